@@ -0,0 +1,189 @@
+// Command bookctl runs catalogue maintenance tasks against the BookManagement
+// database without going through the HTTP API.
+//
+//	bookctl import [-dry-run] [-on-duplicate error|skip|update] [-format csv|xlsx] [-map "Titel=bookname"] [-report report.json] FILE
+//	bookctl export [-format csv|json|ndjson] [-filter "status=Borrowed&personid=3"] [-o FILE] books|users|orders|fines
+//	bookctl prices
+//	bookctl recommendations
+//	bookctl holds
+//	bookctl fines
+//	bookctl idempotency
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/controllers"
+	"net/url"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "import":
+		os.Exit(runImport(os.Args[2:]))
+	case "export":
+		os.Exit(runExport(os.Args[2:]))
+	case "prices":
+		os.Exit(runJob("apply prices", controllers.ApplyScheduledPrices, "book prices changed"))
+	case "recommendations":
+		os.Exit(runJob("rebuild recommendations", controllers.RebuildRecommendations, "similar-book pairs stored"))
+	case "holds":
+		os.Exit(runJob("expire holds", controllers.ExpireHolds, "holds expired"))
+	case "fines":
+		os.Exit(runJob("assess overdue fines", controllers.AssessOverdueFines, "overdue loans updated"))
+	case "idempotency":
+		os.Exit(runJob("purge idempotency keys", controllers.PurgeIdempotencyKeys, "expired idempotency keys deleted"))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bookctl import [flags] FILE")
+	fmt.Fprintln(os.Stderr, "       bookctl export [flags] books|users|orders|fines")
+	fmt.Fprintln(os.Stderr, "       bookctl prices")
+	fmt.Fprintln(os.Stderr, "       bookctl recommendations")
+	fmt.Fprintln(os.Stderr, "       bookctl holds")
+	fmt.Fprintln(os.Stderr, "       bookctl fines")
+	fmt.Fprintln(os.Stderr, "       bookctl idempotency")
+	os.Exit(2)
+}
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "validate every row and roll back instead of saving")
+	onDuplicate := fs.String("on-duplicate", controllers.OnDuplicateError, "what to do with rows matching an existing book: error, skip or update")
+	format := fs.String("format", "", "csv or xlsx (default: from the file extension)")
+	mapping := fs.String("map", "", "column mapping, e.g. \"Titel=bookname,Verfasser=bookauthorname\"")
+	reportPath := fs.String("report", "", "also write the full JSON report to this file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	kind, err := controllers.ImportFormat(*format, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts := controllers.BookImportOptions{DryRun: *dryRun, OnDuplicate: *onDuplicate}
+	if *mapping != "" {
+		if opts.Mapping, err = controllers.ParseImportMapping(*mapping); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	table, err := controllers.ReadImportTable(f, kind)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := database.Migrate(controllers.MigrationBackfills); err != nil {
+		fmt.Fprintf(os.Stderr, "database migration failed: %v\n", err)
+		return 1
+	}
+	report, err := controllers.ImportBookTable(database.Database(), table, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+
+	for _, row := range report.Rows {
+		if row.Status == controllers.ImportFailed {
+			fmt.Printf("row %d: %s (%s)\n", row.Row, row.Error, row.Code)
+		}
+	}
+	mode := "imported"
+	if report.DryRun {
+		mode = "dry run"
+	}
+	fmt.Printf("%s: %d rows, %d created, %d updated, %d skipped, %d failed\n",
+		mode, report.Total, report.Created, report.Updated, report.Skipped, report.Failed)
+
+	if *reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*reportPath, data, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "write report: %v\n", err)
+			return 1
+		}
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", controllers.ExportCSV, "csv, json or ndjson")
+	filter := fs.String("filter", "", "list filters as a query string, e.g. \"status=Borrowed&personid=3\"")
+	outPath := fs.String("o", "", "write to this file instead of stdout")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	params, err := url.ParseQuery(*filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -filter: %v\n", err)
+		return 2
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	count, err := controllers.ExportEntity(database.Database(), out, fs.Arg(0), *format, params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d %s\n", count, fs.Arg(0))
+	return 0
+}
+
+// runJob runs one of the API's scheduled jobs once, for deployments that
+// drive them from cron: prices applies scheduled price changes that have
+// come into effect, recommendations rebuilds the borrowed-together data,
+// holds expires uncollected holds, fines charges overdue loans and
+// idempotency deletes expired idempotency keys.
+func runJob(name string, job func(*sql.DB) (int64, error), done string) int {
+	if err := database.Migrate(controllers.MigrationBackfills); err != nil {
+		fmt.Fprintf(os.Stderr, "database migration failed: %v\n", err)
+		return 1
+	}
+	n, err := job(database.Database())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", name, err)
+		return 1
+	}
+	fmt.Printf("%d %s\n", n, done)
+	return 0
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 
 	_ "github.com/microsoft/go-mssqldb"
 )
@@ -24,7 +25,9 @@ func Database() *sql.DB {
 		connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%s;database=%s;encrypt=true;TrustServerCertificate=true",
 			server, user, password, port, databaseName)
 
-		fmt.Println("Attempting to connect with:", connString)
+		// Logged rather than printed so that bookctl export can write to
+		// stdout; the password stays out of the log
+		log.Println("Attempting to connect with:", strings.Replace(connString, "password="+password, "password=*****", 1))
 
 		// Open the connection
 		var err error
@@ -39,7 +42,7 @@ func Database() *sql.DB {
 			log.Fatalf("Ping failed: %v", err)
 		}
 
-		fmt.Println("Connected to MSSQL Server successfully!")
+		log.Println("Connected to MSSQL Server successfully!")
 	}
 	return db
 }
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"bufio"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrate applies every script in migrations/ that is not yet recorded in
+// SchemaMigrations. Scripts run in file-name order, each inside its own
+// transaction, and may be split into batches with a line containing only GO.
+// $(DEFAULT_CURRENCY) in a script stands for the library's currency, the
+// one the API reads amounts in, so both always agree. backfills holds data
+// steps written in Go, by script name, for data the API's own rules must
+// produce; each runs right after its script, in the same transaction.
+func Migrate(backfills map[string]func(tx *sql.Tx) error) error {
+	db := Database()
+
+	const createTable = `
+		IF OBJECT_ID('SchemaMigrations', 'U') IS NULL
+		CREATE TABLE SchemaMigrations (
+			Name       NVARCHAR(255) NOT NULL PRIMARY KEY,
+			Applied_at DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME()
+		)`
+	if _, err := db.Exec(createTable); err != nil {
+		return fmt.Errorf("create SchemaMigrations: %w", err)
+	}
+
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var applied int
+		if err := db.QueryRow("SELECT COUNT(1) FROM SchemaMigrations WHERE Name = ?", name).Scan(&applied); err != nil {
+			return fmt.Errorf("check migration %s: %w", name, err)
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return fmt.Errorf("read migration %s: %w", name, err)
+		}
+		text := strings.ReplaceAll(string(script), "$(DEFAULT_CURRENCY)", DefaultCurrency())
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin migration %s: %w", name, err)
+		}
+		for _, batch := range splitBatches(text) {
+			if _, err := tx.Exec(batch); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("apply migration %s: %w", name, err)
+			}
+		}
+		if backfill := backfills[name]; backfill != nil {
+			if err := backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("backfill migration %s: %w", name, err)
+			}
+		}
+		if _, err := tx.Exec("INSERT INTO SchemaMigrations (Name) VALUES (?)", name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %s: %w", name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %s: %w", name, err)
+		}
+		log.Printf("applied migration %s", name)
+	}
+	return nil
+}
+
+// DefaultCurrency is the library's currency, from DEFAULT_CURRENCY (USD when
+// unset or not a three-letter code). Amounts stored or given without a
+// currency are in it.
+func DefaultCurrency() string {
+	code := strings.ToUpper(strings.TrimSpace(os.Getenv("DEFAULT_CURRENCY")))
+	if len(code) != 3 || strings.IndexFunc(code, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return "USD"
+	}
+	return code
+}
+
+// splitBatches breaks a script on GO separator lines, dropping empty batches.
+func splitBatches(script string) []string {
+	var batches []string
+	var current strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.EqualFold(strings.TrimSpace(line), "GO") {
+			if s := strings.TrimSpace(current.String()); s != "" {
+				batches = append(batches, s)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+	}
+	if s := strings.TrimSpace(current.String()); s != "" {
+		batches = append(batches, s)
+	}
+	return batches
+}
@@ -0,0 +1,10 @@
+-- ISBN identifiers for Book. Both forms are stored so lookups by either are a
+-- simple equality; ISBN13 is the canonical, unique key.
+ALTER TABLE Book ADD isbn10 VARCHAR(10) NULL, isbn13 VARCHAR(13) NULL;
+GO
+
+CREATE UNIQUE INDEX UX_Book_isbn13 ON Book (isbn13) WHERE isbn13 IS NOT NULL;
+GO
+
+CREATE INDEX IX_Book_isbn10 ON Book (isbn10) WHERE isbn10 IS NOT NULL;
+GO
@@ -0,0 +1,78 @@
+-- Physical copies of a Book. Book.bookQuantity and Book.isAvailable are kept
+-- in sync with the number of Available copies by the application.
+CREATE TABLE BookCopy (
+    CopyID          INT IDENTITY(1,1) PRIMARY KEY,
+    BookID          INT           NOT NULL REFERENCES Book (BookID),
+    Barcode         VARCHAR(32)   NOT NULL,
+    Status          VARCHAR(20)   NOT NULL DEFAULT 'Available',
+    Condition       VARCHAR(20)   NOT NULL DEFAULT 'Good',
+    AcquisitionDate DATE          NULL,
+    Notes           NVARCHAR(500) NULL
+);
+GO
+
+CREATE UNIQUE INDEX UX_BookCopy_Barcode ON BookCopy (Barcode);
+GO
+
+CREATE INDEX IX_BookCopy_BookID_Status ON BookCopy (BookID, Status);
+GO
+
+ALTER TABLE OrderBook ADD CopyID INT NULL REFERENCES BookCopy (CopyID);
+GO
+
+-- bookQuantity was never reduced on checkout, so it counts every copy a
+-- book has, lent or not. Create that many copies per book (more if a book
+-- has more open loans than that), give each open loan one of them, and
+-- from then on count only the copies on the shelf. Barcodes are
+-- B<BookID>-<N>, untruncated so that they cannot collide.
+DECLARE @maxCopies INT;
+
+SELECT b.BookID,
+       CASE WHEN ISNULL(l.Loans, 0) > b.bookQuantity THEN l.Loans ELSE b.bookQuantity END AS Copies
+INTO #BookCopyCount
+FROM Book b
+LEFT JOIN (SELECT BookID, COUNT(*) AS Loans
+           FROM OrderBook
+           WHERE Status IN ('Borrowed', 'Overdue')
+           GROUP BY BookID) l ON l.BookID = b.BookID;
+
+SELECT @maxCopies = ISNULL(MAX(Copies), 0) FROM #BookCopyCount;
+
+WITH Numbers AS (
+    SELECT 1 AS N
+    UNION ALL
+    SELECT N + 1 FROM Numbers WHERE N < @maxCopies
+)
+INSERT INTO BookCopy (BookID, Barcode, Status)
+SELECT c.BookID,
+       'B' + CAST(c.BookID AS VARCHAR(10)) + '-' + CAST(n.N AS VARCHAR(10)),
+       'Available'
+FROM #BookCopyCount c
+JOIN Numbers n ON n.N <= c.Copies
+OPTION (MAXRECURSION 0);
+
+-- The Nth open loan of a book, oldest first, takes copy N
+WITH Loans AS (
+    SELECT OrderID, BookID, ROW_NUMBER() OVER (PARTITION BY BookID ORDER BY OrderID) AS N
+    FROM OrderBook
+    WHERE Status IN ('Borrowed', 'Overdue')
+)
+UPDATE o
+SET CopyID = c.CopyID
+FROM OrderBook o
+JOIN Loans l ON l.OrderID = o.OrderID
+JOIN BookCopy c ON c.Barcode = 'B' + CAST(l.BookID AS VARCHAR(10)) + '-' + CAST(l.N AS VARCHAR(10));
+
+UPDATE c
+SET Status = 'OnLoan'
+FROM BookCopy c
+JOIN OrderBook o ON o.CopyID = c.CopyID
+WHERE o.Status IN ('Borrowed', 'Overdue');
+
+UPDATE b
+SET bookQuantity = (SELECT COUNT(*) FROM BookCopy c WHERE c.BookID = b.BookID AND c.Status = 'Available'),
+    isAvailable = CASE WHEN EXISTS (SELECT 1 FROM BookCopy c WHERE c.BookID = b.BookID AND c.Status = 'Available') THEN 1 ELSE 0 END
+FROM Book b;
+
+DROP TABLE #BookCopyCount;
+GO
@@ -0,0 +1,73 @@
+-- Authors as their own entity, linked to books with a contributor role.
+-- NormalizedName is lower-cased with punctuation folded to single spaces so
+-- "J.K. Rowling" and "J. K. Rowling" compare equal; it must stay in step with
+-- normalizeAuthorName in controllers/authorControllers.go.
+CREATE TABLE Author (
+    AuthorID       INT IDENTITY(1,1) PRIMARY KEY,
+    Name           NVARCHAR(255) NOT NULL,
+    SortName       NVARCHAR(255) NULL,
+    NormalizedName NVARCHAR(255) NOT NULL,
+    Created_at     DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME(),
+    Updated_at     DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+CREATE INDEX IX_Author_NormalizedName ON Author (NormalizedName);
+GO
+
+CREATE TABLE BookAuthor (
+    BookID   INT         NOT NULL REFERENCES Book (BookID),
+    AuthorID INT         NOT NULL REFERENCES Author (AuthorID),
+    Role     VARCHAR(20) NOT NULL DEFAULT 'Author',
+    Position INT         NOT NULL DEFAULT 1,
+    CONSTRAINT PK_BookAuthor PRIMARY KEY (BookID, AuthorID, Role)
+);
+GO
+
+CREATE INDEX IX_BookAuthor_AuthorID ON BookAuthor (AuthorID);
+GO
+
+-- Split the free-text author column on the separators people actually used
+-- and link every book to one Author per distinct normalized name. The split
+-- and the normalization follow splitAuthorNames and normalizeAuthorName:
+-- separators match case-sensitively, hence the binary collation, and every
+-- whitespace character Go's strings.Fields knows is folded to a space before
+-- names are trimmed and runs of spaces collapsed.
+DECLARE @ws NVARCHAR(10) = NCHAR(9) + NCHAR(10) + NCHAR(11) + NCHAR(12) + NCHAR(13) + NCHAR(133) + NCHAR(160) + N' ';
+
+SELECT b.BookID,
+       TRIM(@ws FROM s.value) AS Name,
+       ROW_NUMBER() OVER (PARTITION BY b.BookID ORDER BY CHARINDEX(';' + s.value + ';', ';' + a.Authors + ';')) AS Position
+INTO #SplitAuthors
+FROM Book b
+CROSS APPLY (SELECT REPLACE(REPLACE(b.bookAuthorName COLLATE Latin1_General_BIN2, N' & ', N';'), N' and ', N';') AS Authors) a
+CROSS APPLY STRING_SPLIT(a.Authors, ';') s
+WHERE TRIM(@ws FROM s.value) <> '';
+
+ALTER TABLE #SplitAuthors ADD NormalizedName NVARCHAR(255) COLLATE DATABASE_DEFAULT NULL;
+
+-- Punctuation and whitespace become spaces; runs of spaces are then
+-- collapsed by marking each with CHAR(1) CHAR(2), dropping the CHAR(2)
+-- CHAR(1) pairs between neighbours, and turning what is left back into one
+-- space.
+UPDATE #SplitAuthors
+SET NormalizedName = TRIM(
+    REPLACE(REPLACE(REPLACE(
+        TRANSLATE(LOWER(Name) COLLATE Latin1_General_BIN2,
+            N'.,;:''"-()&' + NCHAR(9) + NCHAR(10) + NCHAR(11) + NCHAR(12) + NCHAR(13) + NCHAR(133) + NCHAR(160),
+            N'                 '),
+    N' ', NCHAR(1) + NCHAR(2)), NCHAR(2) + NCHAR(1), N''), NCHAR(1) + NCHAR(2), N' '));
+
+INSERT INTO Author (Name, NormalizedName)
+SELECT MIN(Name), NormalizedName
+FROM #SplitAuthors
+GROUP BY NormalizedName;
+
+INSERT INTO BookAuthor (BookID, AuthorID, Role, Position)
+SELECT s.BookID, MIN(a.AuthorID), 'Author', MIN(s.Position)
+FROM #SplitAuthors s
+JOIN Author a ON a.NormalizedName = s.NormalizedName
+GROUP BY s.BookID, s.NormalizedName;
+
+DROP TABLE #SplitAuthors;
+GO
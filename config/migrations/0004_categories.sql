@@ -0,0 +1,80 @@
+-- Managed subject/genre tree replacing the free-text typeOfBook. Aliases map
+-- spelling variants ("Sci-Fi", "SciFi", "Science Fiction") to one category.
+-- Alias keys are lower-cased letters and digits only, as categoryKey in
+-- controllers/categoryControllers.go makes them. The books' typeOfBook
+-- values are filed by BackfillBookCategories, in Go, after this script so
+-- that they get exactly the keys and slugs the API would give them.
+CREATE TABLE Category (
+    CategoryID INT IDENTITY(1,1) PRIMARY KEY,
+    ParentID   INT           NULL REFERENCES Category (CategoryID),
+    Name       NVARCHAR(100) NOT NULL,
+    Slug       VARCHAR(120)  NOT NULL,
+    Created_at DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME(),
+    Updated_at DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+CREATE UNIQUE INDEX UX_Category_Slug ON Category (Slug);
+GO
+
+CREATE INDEX IX_Category_ParentID ON Category (ParentID);
+GO
+
+CREATE TABLE CategoryAlias (
+    AliasKey   NVARCHAR(100) NOT NULL PRIMARY KEY,
+    CategoryID INT           NOT NULL REFERENCES Category (CategoryID)
+);
+GO
+
+CREATE TABLE BookCategory (
+    BookID     INT NOT NULL REFERENCES Book (BookID),
+    CategoryID INT NOT NULL REFERENCES Category (CategoryID),
+    Position   INT NOT NULL DEFAULT 1,
+    CONSTRAINT PK_BookCategory PRIMARY KEY (BookID, CategoryID)
+);
+GO
+
+CREATE INDEX IX_BookCategory_CategoryID ON BookCategory (CategoryID);
+GO
+
+-- Starter tree for the genres already in use, with their common variants.
+INSERT INTO Category (ParentID, Name, Slug) VALUES (NULL, 'Fiction', 'fiction'), (NULL, 'Non-Fiction', 'non-fiction');
+
+INSERT INTO Category (ParentID, Name, Slug)
+SELECT p.CategoryID, v.Name, v.Slug
+FROM (VALUES
+    ('fiction', 'Science Fiction', 'science-fiction'),
+    ('fiction', 'Fantasy', 'fantasy'),
+    ('fiction', 'Mystery', 'mystery'),
+    ('fiction', 'Thriller', 'thriller'),
+    ('fiction', 'Romance', 'romance'),
+    ('fiction', 'Horror', 'horror'),
+    ('non-fiction', 'Biography', 'biography'),
+    ('non-fiction', 'History', 'history'),
+    ('non-fiction', 'Science', 'science'),
+    ('non-fiction', 'Self-Help', 'self-help')
+) AS v (ParentSlug, Name, Slug)
+JOIN Category p ON p.Slug = v.ParentSlug;
+
+INSERT INTO Category (ParentID, Name, Slug)
+SELECT p.CategoryID, 'Epic Fantasy', 'epic-fantasy'
+FROM Category p WHERE p.Slug = 'fantasy';
+
+INSERT INTO CategoryAlias (AliasKey, CategoryID)
+SELECT v.AliasKey, c.CategoryID
+FROM (VALUES
+    ('fiction', 'fiction'), ('novel', 'fiction'), ('novels', 'fiction'),
+    ('nonfiction', 'non-fiction'),
+    ('sciencefiction', 'science-fiction'), ('scifi', 'science-fiction'), ('sf', 'science-fiction'),
+    ('fantasy', 'fantasy'), ('epicfantasy', 'epic-fantasy'), ('highfantasy', 'epic-fantasy'),
+    ('mystery', 'mystery'), ('crime', 'mystery'), ('detective', 'mystery'),
+    ('thriller', 'thriller'), ('suspense', 'thriller'),
+    ('romance', 'romance'),
+    ('horror', 'horror'),
+    ('biography', 'biography'), ('autobiography', 'biography'), ('memoir', 'biography'),
+    ('history', 'history'),
+    ('science', 'science'),
+    ('selfhelp', 'self-help')
+) AS v (AliasKey, Slug)
+JOIN Category c ON c.Slug = v.Slug;
+GO
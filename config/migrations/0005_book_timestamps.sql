@@ -0,0 +1,48 @@
+-- Change timestamps on Book for incremental harvesting (OAI-PMH). Updated_at
+-- moves whenever the bibliographic description changes: the Book columns
+-- themselves, author credits, categories or a category's name. Inventory
+-- columns (bookQuantity, isAvailable) change on every loan and are excluded.
+ALTER TABLE Book ADD
+    Created_at DATETIME2 NOT NULL CONSTRAINT DF_Book_Created_at DEFAULT SYSUTCDATETIME(),
+    Updated_at DATETIME2 NOT NULL CONSTRAINT DF_Book_Updated_at DEFAULT SYSUTCDATETIME();
+GO
+
+CREATE INDEX IX_Book_Updated_at ON Book (Updated_at, BookID);
+GO
+
+CREATE TRIGGER TR_Book_Touch ON Book AFTER UPDATE AS
+BEGIN
+    SET NOCOUNT ON;
+    IF UPDATE(typeOfBook) OR UPDATE(bookName) OR UPDATE(bookAuthorName)
+       OR UPDATE(bookPrice) OR UPDATE(isbn10) OR UPDATE(isbn13)
+        UPDATE Book SET Updated_at = SYSUTCDATETIME()
+        WHERE BookID IN (SELECT BookID FROM inserted);
+END
+GO
+
+CREATE TRIGGER TR_BookAuthor_Touch ON BookAuthor AFTER INSERT, UPDATE, DELETE AS
+BEGIN
+    SET NOCOUNT ON;
+    UPDATE Book SET Updated_at = SYSUTCDATETIME()
+    WHERE BookID IN (SELECT BookID FROM inserted UNION SELECT BookID FROM deleted);
+END
+GO
+
+CREATE TRIGGER TR_BookCategory_Touch ON BookCategory AFTER INSERT, UPDATE, DELETE AS
+BEGIN
+    SET NOCOUNT ON;
+    UPDATE Book SET Updated_at = SYSUTCDATETIME()
+    WHERE BookID IN (SELECT BookID FROM inserted UNION SELECT BookID FROM deleted);
+END
+GO
+
+CREATE TRIGGER TR_Category_Touch ON Category AFTER UPDATE AS
+BEGIN
+    SET NOCOUNT ON;
+    IF UPDATE(Name) OR UPDATE(Slug) OR UPDATE(ParentID)
+        UPDATE Book SET Updated_at = SYSUTCDATETIME()
+        WHERE BookID IN (
+            SELECT bc.BookID FROM BookCategory bc
+            JOIN inserted i ON i.CategoryID = bc.CategoryID);
+END
+GO
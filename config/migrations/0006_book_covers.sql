@@ -0,0 +1,14 @@
+-- One cover image per book. The image and its thumbnails live in blob
+-- storage under covers/<BookID>/<Hash>/ (see the storage package); only the
+-- metadata is kept here. Hash is the start of the image's SHA-256, so a new
+-- upload gets new keys and old URLs can be cached forever.
+CREATE TABLE BookCover (
+    BookID      INT          NOT NULL PRIMARY KEY REFERENCES Book (BookID),
+    Hash        VARCHAR(64)  NOT NULL,
+    ContentType VARCHAR(50)  NOT NULL,
+    Width       INT          NOT NULL,
+    Height      INT          NOT NULL,
+    ByteSize    BIGINT       NOT NULL,
+    Uploaded_at DATETIME2    NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
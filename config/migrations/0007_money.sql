@@ -0,0 +1,32 @@
+-- Prices and fines become exact decimals with a currency. DECIMAL(19,4)
+-- matches helper.Money, which keeps four decimal places, so nothing is lost
+-- between Go and the database. Existing rows take the library's currency,
+-- DEFAULT_CURRENCY (USD when unset), which the migration runner fills in.
+UPDATE Book SET bookPrice = 0 WHERE bookPrice IS NULL;
+ALTER TABLE Book ALTER COLUMN bookPrice DECIMAL(19,4) NOT NULL;
+ALTER TABLE Book ADD bookPriceCurrency CHAR(3) NOT NULL
+    CONSTRAINT DF_Book_bookPriceCurrency DEFAULT '$(DEFAULT_CURRENCY)';
+GO
+
+UPDATE FineTable SET FineAmount = 0 WHERE FineAmount IS NULL;
+ALTER TABLE FineTable ALTER COLUMN FineAmount DECIMAL(19,4) NOT NULL;
+ALTER TABLE FineTable ADD Currency CHAR(3) NOT NULL
+    CONSTRAINT DF_FineTable_Currency DEFAULT '$(DEFAULT_CURRENCY)';
+GO
+
+UPDATE FineBookTable SET FineAmount = 0 WHERE FineAmount IS NULL;
+ALTER TABLE FineBookTable ALTER COLUMN FineAmount DECIMAL(19,4) NOT NULL;
+ALTER TABLE FineBookTable ADD Currency CHAR(3) NOT NULL
+    CONSTRAINT DF_FineBookTable_Currency DEFAULT '$(DEFAULT_CURRENCY)';
+GO
+
+-- A price in another currency is a description change like any other
+ALTER TRIGGER TR_Book_Touch ON Book AFTER UPDATE AS
+BEGIN
+    SET NOCOUNT ON;
+    IF UPDATE(typeOfBook) OR UPDATE(bookName) OR UPDATE(bookAuthorName)
+       OR UPDATE(bookPrice) OR UPDATE(bookPriceCurrency) OR UPDATE(isbn10) OR UPDATE(isbn13)
+        UPDATE Book SET Updated_at = SYSUTCDATETIME()
+        WHERE BookID IN (SELECT BookID FROM inserted);
+END
+GO
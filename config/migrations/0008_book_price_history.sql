@@ -0,0 +1,21 @@
+-- Every price a book has had or is scheduled to have. Book.bookPrice stays
+-- the price in effect now: it is updated when an entry is added that is
+-- already effective, and by the scheduled-price job once a future entry's
+-- EffectiveFrom passes.
+CREATE TABLE BookPriceHistory (
+    PriceID       INT IDENTITY(1,1) PRIMARY KEY,
+    BookID        INT            NOT NULL REFERENCES Book (BookID),
+    Price         DECIMAL(19,4)  NOT NULL,
+    Currency      CHAR(3)        NOT NULL,
+    EffectiveFrom DATETIME2      NOT NULL,
+    Note          NVARCHAR(200)  NULL,
+    Created_at    DATETIME2      NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT UQ_BookPriceHistory_Effective UNIQUE (BookID, EffectiveFrom)
+);
+GO
+
+-- The current price of each existing book, effective since it was catalogued
+INSERT INTO BookPriceHistory (BookID, Price, Currency, EffectiveFrom, Note)
+SELECT BookID, bookPrice, bookPriceCurrency, Created_at, 'price when history began'
+FROM Book;
+GO
@@ -0,0 +1,26 @@
+-- Member reviews with a 1-5 star rating. A member reviews a book once and
+-- edits that review afterwards. Only approved reviews are shown publicly and
+-- count towards a book's rating; new and edited reviews wait in pending.
+CREATE TABLE BookReview (
+    ReviewID       INT IDENTITY(1,1) PRIMARY KEY,
+    BookID         INT            NOT NULL REFERENCES Book (BookID),
+    PersonID       INT            NOT NULL,
+    Rating         TINYINT        NOT NULL CONSTRAINT CK_BookReview_Rating CHECK (Rating BETWEEN 1 AND 5),
+    Title          NVARCHAR(200)  NULL,
+    Body           NVARCHAR(4000) NULL,
+    Status         VARCHAR(10)    NOT NULL CONSTRAINT DF_BookReview_Status DEFAULT 'pending'
+                   CONSTRAINT CK_BookReview_Status CHECK (Status IN ('pending', 'approved', 'hidden')),
+    ModerationNote NVARCHAR(400)  NULL,
+    Moderated_at   DATETIME2      NULL,
+    Created_at     DATETIME2      NOT NULL DEFAULT SYSUTCDATETIME(),
+    Updated_at     DATETIME2      NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT UQ_BookReview_Person UNIQUE (BookID, PersonID)
+);
+GO
+
+-- Ratings are aggregated per book over approved reviews
+CREATE INDEX IX_BookReview_Book_Status ON BookReview (BookID, Status) INCLUDE (Rating);
+GO
+
+CREATE INDEX IX_BookReview_Status ON BookReview (Status, Created_at);
+GO
@@ -0,0 +1,21 @@
+-- Item-to-item "borrowed together" similarity, rebuilt offline from the
+-- OrderBook history (see RebuildRecommendations). Score is the cosine of the
+-- two books' borrower sets: CoBorrowers / SQRT(borrowers of A * borrowers of
+-- B). Each book keeps only its best matches.
+CREATE TABLE BookSimilarity (
+    BookID        INT   NOT NULL REFERENCES Book (BookID),
+    SimilarBookID INT   NOT NULL REFERENCES Book (BookID),
+    Score         FLOAT NOT NULL,
+    CoBorrowers   INT   NOT NULL,
+    CONSTRAINT PK_BookSimilarity PRIMARY KEY (BookID, SimilarBookID)
+);
+GO
+
+-- One row per rebuild, so clients can tell how fresh the data is
+CREATE TABLE RecommendationBuild (
+    BuildID     INT IDENTITY(1,1) PRIMARY KEY,
+    Started_at  DATETIME2 NOT NULL,
+    Finished_at DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME(),
+    Pairs       INT       NOT NULL
+);
+GO
@@ -0,0 +1,29 @@
+-- Holds queue for books with no copy on the shelf. Holds are served first
+-- come, first served: a copy that becomes Available goes to the oldest
+-- Waiting hold instead, turning the hold Ready and the copy OnHold until the
+-- patron collects it or PickupBy passes.
+CREATE TABLE BookHold (
+    HoldID     INT IDENTITY(1,1) PRIMARY KEY,
+    BookID     INT         NOT NULL REFERENCES Book (BookID),
+    PersonID   INT         NOT NULL,
+    Status     VARCHAR(10) NOT NULL CONSTRAINT DF_BookHold_Status DEFAULT 'Waiting'
+               CONSTRAINT CK_BookHold_Status CHECK (Status IN ('Waiting', 'Ready', 'Fulfilled', 'Expired', 'Cancelled')),
+    CopyID     INT         NULL REFERENCES BookCopy (CopyID),
+    OrderID    INT         NULL,
+    Placed_at  DATETIME2   NOT NULL DEFAULT SYSUTCDATETIME(),
+    Ready_at   DATETIME2   NULL,
+    PickupBy   DATETIME2   NULL,
+    Closed_at  DATETIME2   NULL
+);
+GO
+
+-- A patron holds a book at most once at a time
+CREATE UNIQUE INDEX UX_BookHold_Active ON BookHold (BookID, PersonID)
+    WHERE Status IN ('Waiting', 'Ready');
+GO
+
+CREATE INDEX IX_BookHold_Queue ON BookHold (BookID, Status, Placed_at);
+GO
+
+CREATE INDEX IX_BookHold_Pickup ON BookHold (Status, PickupBy);
+GO
@@ -0,0 +1,4 @@
+-- Loans can be renewed a limited number of times (see RenewOrderBook);
+-- RenewalCount tracks how often each one has been.
+ALTER TABLE OrderBook ADD RenewalCount INT NOT NULL CONSTRAINT DF_OrderBook_RenewalCount DEFAULT 0;
+GO
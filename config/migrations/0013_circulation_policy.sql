@@ -0,0 +1,35 @@
+-- Circulation rules by patron category and book category. A NULL key matches
+-- everything, and a book category also covers its subcategories; checkout
+-- uses the most specific row (patron category first, then the nearest
+-- category of the book's primary category). The catch-all row below keeps
+-- the behaviour loans had before policies existed.
+ALTER TABLE Person ADD PatronCategory VARCHAR(30) NOT NULL
+    CONSTRAINT DF_Person_PatronCategory DEFAULT 'standard';
+GO
+
+CREATE TABLE CirculationPolicy (
+    PolicyID       INT IDENTITY(1,1) PRIMARY KEY,
+    PatronCategory VARCHAR(30) NULL,
+    CategoryID     INT         NULL REFERENCES Category (CategoryID),
+    LoanPeriodDays INT         NOT NULL CHECK (LoanPeriodDays > 0),
+    MaxItems       INT         NULL CHECK (MaxItems >= 0),
+    MaxRenewals    INT         NOT NULL DEFAULT 2 CHECK (MaxRenewals >= 0),
+    -- Fine rule: FineTypeID's amount is charged per day overdue, once the
+    -- loan is more than FineGraceDays late, for at most MaxFineDays days
+    FineTypeID     INT         NULL REFERENCES FineTable (FineID),
+    FineGraceDays  INT         NOT NULL DEFAULT 0 CHECK (FineGraceDays >= 0),
+    MaxFineDays    INT         NULL CHECK (MaxFineDays > 0),
+    HoldsAllowed   BIT         NOT NULL DEFAULT 1,
+    Created_at     DATETIME2   NOT NULL DEFAULT SYSUTCDATETIME(),
+    Updated_at     DATETIME2   NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+-- One rule per cell of the matrix; NULLs compare equal here, so there is a
+-- single catch-all
+CREATE UNIQUE INDEX UX_CirculationPolicy_Key ON CirculationPolicy (PatronCategory, CategoryID);
+GO
+
+INSERT INTO CirculationPolicy (PatronCategory, CategoryID, LoanPeriodDays, MaxItems, MaxRenewals)
+VALUES (NULL, NULL, 14, NULL, 2);
+GO
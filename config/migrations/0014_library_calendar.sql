@@ -0,0 +1,31 @@
+-- Library calendar. Due dates and hold pickup deadlines that fall on a
+-- closed day move to the next open one, and closed days are not charged as
+-- overdue. A weekday without a row is closed; one-off closures (holidays,
+-- including ones imported from iCal feeds) close single dates.
+CREATE TABLE OpeningHours (
+    Weekday TINYINT NOT NULL PRIMARY KEY CHECK (Weekday BETWEEN 0 AND 6), -- 0 = Sunday
+    Opens   TIME(0) NOT NULL,
+    Closes  TIME(0) NOT NULL,
+    CONSTRAINT CK_OpeningHours_Range CHECK (Closes > Opens)
+);
+GO
+
+-- Open every day until the library sets its hours, as before the calendar
+INSERT INTO OpeningHours (Weekday, Opens, Closes)
+VALUES (0, '09:00', '17:00'), (1, '09:00', '17:00'), (2, '09:00', '17:00'), (3, '09:00', '17:00'),
+       (4, '09:00', '17:00'), (5, '09:00', '17:00'), (6, '09:00', '17:00');
+GO
+
+CREATE TABLE LibraryClosure (
+    ClosureID   INT IDENTITY(1,1) PRIMARY KEY,
+    ClosedOn    DATE          NOT NULL,
+    Reason      NVARCHAR(200) NOT NULL,
+    Source      VARCHAR(10)   NOT NULL CONSTRAINT DF_LibraryClosure_Source DEFAULT 'manual'
+                CONSTRAINT CK_LibraryClosure_Source CHECK (Source IN ('manual', 'ical')),
+    ExternalUID NVARCHAR(255) NULL, -- UID of the imported iCal event
+    Created_at  DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+CREATE UNIQUE INDEX UX_LibraryClosure_ClosedOn ON LibraryClosure (ClosedOn);
+GO
@@ -0,0 +1,29 @@
+-- Fine records can be paid, in part or in full, or waived. What is left
+-- unpaid on Open records is the patron's outstanding balance, and borrowing
+-- is blocked while it is above FINE_BLOCK_THRESHOLD unless a librarian
+-- overrides the block; every override is kept in CirculationOverride.
+ALTER TABLE FineBookTable ADD
+    AmountPaid DECIMAL(19,4) NOT NULL CONSTRAINT DF_FineBookTable_AmountPaid DEFAULT 0,
+    Status     VARCHAR(10)   NOT NULL CONSTRAINT DF_FineBookTable_Status DEFAULT 'Open'
+               CONSTRAINT CK_FineBookTable_Status CHECK (Status IN ('Open', 'Paid', 'Waived')),
+    Closed_at  DATETIME2     NULL;
+GO
+
+CREATE INDEX IX_FineBookTable_Person_Status ON FineBookTable (PersonID, Status);
+GO
+
+CREATE TABLE CirculationOverride (
+    OverrideID   INT IDENTITY(1,1) PRIMARY KEY,
+    PersonID     INT           NOT NULL,
+    OrderID      INT           NULL,
+    Action       VARCHAR(20)   NOT NULL, -- checkout or renewal
+    Code         VARCHAR(30)   NOT NULL, -- the block that was overridden
+    OverriddenBy NVARCHAR(100) NOT NULL,
+    Reason       NVARCHAR(500) NOT NULL,
+    Balance      NVARCHAR(200) NULL,     -- outstanding balance at the time
+    Created_at   DATETIME2     NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+CREATE INDEX IX_CirculationOverride_PersonID ON CirculationOverride (PersonID);
+GO
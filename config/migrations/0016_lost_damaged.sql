@@ -0,0 +1,36 @@
+-- Loans can end Lost or Damaged. Either charges the book's replacement cost
+-- and a processing fee as fine records of the fine types seeded below; the
+-- replacement types are priced from the book, so their own amount is unused.
+-- When a lost book is found the unpaid replacement charge is voided, and
+-- whatever was already paid on it is refunded as a negative Credit record.
+-- Seeded fine types are found by their Code, which only migrations set, so
+-- that staff can rename them.
+ALTER TABLE FineBookTable DROP CONSTRAINT CK_FineBookTable_Status;
+GO
+
+ALTER TABLE FineBookTable ADD CONSTRAINT CK_FineBookTable_Status
+    CHECK (Status IN ('Open', 'Paid', 'Waived', 'Void', 'Credit'));
+GO
+
+ALTER TABLE FineTable ADD Code VARCHAR(30) NULL;
+GO
+
+CREATE UNIQUE INDEX UX_FineTable_Code ON FineTable (Code) WHERE Code IS NOT NULL;
+GO
+
+INSERT INTO FineTable (NameOfFine, FineAmount, Currency)
+SELECT v.NameOfFine, v.FineAmount, '$(DEFAULT_CURRENCY)'
+FROM (VALUES ('Lost item replacement', 0),
+             ('Damaged item replacement', 0),
+             ('Lost or damaged item processing fee', 5)) v (NameOfFine, FineAmount)
+WHERE NOT EXISTS (SELECT 1 FROM FineTable f WHERE f.NameOfFine = v.NameOfFine);
+GO
+
+UPDATE f SET Code = v.Code
+FROM FineTable f
+JOIN (VALUES ('LOST_REPLACEMENT', 'Lost item replacement'),
+             ('DAMAGED_REPLACEMENT', 'Damaged item replacement'),
+             ('LOSS_PROCESSING_FEE', 'Lost or damaged item processing fee')) v (Code, NameOfFine)
+    ON f.NameOfFine = v.NameOfFine
+WHERE f.FineID = (SELECT MIN(FineID) FROM FineTable WHERE NameOfFine = v.NameOfFine);
+GO
@@ -0,0 +1,42 @@
+-- Condition history of each copy: one row per assessment, made when a loan
+-- is returned or when staff change a copy's condition by hand. Photos of an
+-- assessment live in blob storage under conditions/<CopyID>/<AssessmentID>/;
+-- only their metadata is kept here. A return that leaves a copy in worse
+-- condition than DAMAGE_FINE_CONDITION may raise the fine type seeded below,
+-- found by its Code like those of 0016.
+CREATE TABLE CopyCondition (
+    AssessmentID      INT IDENTITY(1,1) PRIMARY KEY,
+    CopyID            INT            NOT NULL REFERENCES BookCopy (CopyID),
+    OrderID           INT            NULL,
+    Condition         VARCHAR(20)    NOT NULL
+                      CONSTRAINT CK_CopyCondition_Condition CHECK (Condition IN ('New', 'Good', 'Worn', 'Damaged')),
+    PreviousCondition VARCHAR(20)    NOT NULL,
+    Notes             NVARCHAR(1000) NULL,
+    AssessedBy        NVARCHAR(100)  NULL,
+    FineID            INT            NULL,
+    Assessed_at       DATETIME2      NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+CREATE INDEX IX_CopyCondition_CopyID ON CopyCondition (CopyID, Assessed_at);
+GO
+
+CREATE TABLE CopyConditionPhoto (
+    PhotoID      INT IDENTITY(1,1) PRIMARY KEY,
+    AssessmentID INT         NOT NULL REFERENCES CopyCondition (AssessmentID),
+    Hash         VARCHAR(64) NOT NULL,
+    ContentType  VARCHAR(50) NOT NULL,
+    ByteSize     BIGINT      NOT NULL,
+    Uploaded_at  DATETIME2   NOT NULL DEFAULT SYSUTCDATETIME()
+);
+GO
+
+INSERT INTO FineTable (NameOfFine, FineAmount, Currency)
+SELECT 'Damage on return', 10, '$(DEFAULT_CURRENCY)'
+WHERE NOT EXISTS (SELECT 1 FROM FineTable WHERE NameOfFine = 'Damage on return');
+GO
+
+UPDATE FineTable SET Code = 'DAMAGE_ON_RETURN'
+WHERE FineID = (SELECT MIN(FineID) FROM FineTable WHERE NameOfFine = 'Damage on return')
+  AND NOT EXISTS (SELECT 1 FROM FineTable WHERE Code = 'DAMAGE_ON_RETURN');
+GO
@@ -0,0 +1,23 @@
+-- Responses to POST requests sent with an Idempotency-Key header, kept until
+-- Expires_at so that a retried request gets the original response instead
+-- of being run again. ResponseStatus is NULL while the first request is
+-- still being handled, and Heartbeat_at is kept fresh for as long as it is.
+-- Keys are scoped to the Caller, the uid of the token the request was sent
+-- with or '' when it had none, so one user's key never replays another's
+-- response.
+CREATE TABLE IdempotencyKey (
+    Caller         NVARCHAR(100)  NOT NULL DEFAULT '',
+    IdempotencyKey NVARCHAR(255)  NOT NULL,
+    RequestHash    CHAR(64)       NOT NULL,
+    ResponseStatus INT            NULL,
+    ContentType    VARCHAR(100)   NULL,
+    ResponseBody   VARBINARY(MAX) NULL,
+    Created_at     DATETIME2      NOT NULL DEFAULT SYSUTCDATETIME(),
+    Heartbeat_at   DATETIME2      NOT NULL DEFAULT SYSUTCDATETIME(),
+    Expires_at     DATETIME2      NOT NULL,
+    CONSTRAINT PK_IdempotencyKey PRIMARY KEY (Caller, IdempotencyKey)
+);
+GO
+
+CREATE INDEX IX_IdempotencyKey_Expires_at ON IdempotencyKey (Expires_at);
+GO
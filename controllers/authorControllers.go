@@ -0,0 +1,783 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Author is a person credited on one or more books
+type Author struct {
+	AuthorID  int       `json:"authorid"`
+	Name      string    `json:"name"`
+	SortName  *string   `json:"sortname"`
+	BookCount int       `json:"bookcount"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BookContributor links an author to a book in a given role
+type BookContributor struct {
+	AuthorID int    `json:"authorid"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Position int    `json:"position"`
+}
+
+// Contributor roles. RoleAuthor credits make up Book.bookAuthorName.
+const (
+	RoleAuthor      = "Author"
+	RoleEditor      = "Editor"
+	RoleTranslator  = "Translator"
+	RoleIllustrator = "Illustrator"
+)
+
+const authorColumns = `a.AuthorID, a.Name, a.SortName, a.Created_at, a.Updated_at,
+	(SELECT COUNT(DISTINCT ba.BookID) FROM BookAuthor ba WHERE ba.AuthorID = a.AuthorID)`
+
+// scanAuthor reads one row selected with authorColumns into an Author.
+func scanAuthor(row rowScanner, author *Author) error {
+	var sortName sql.NullString
+	if err := row.Scan(&author.AuthorID, &author.Name, &sortName, &author.CreatedAt, &author.UpdatedAt, &author.BookCount); err != nil {
+		return err
+	}
+	author.SortName = nil
+	if sortName.Valid {
+		author.SortName = &sortName.String
+	}
+	return nil
+}
+
+// isValidContributorRole checks if the role is one we credit books with
+func isValidContributorRole(role string) bool {
+	validRoles := map[string]bool{
+		RoleAuthor:      true,
+		RoleEditor:      true,
+		RoleTranslator:  true,
+		RoleIllustrator: true,
+	}
+	return validRoles[role]
+}
+
+// normalizeAuthorName folds case and punctuation so spelling variants of the
+// same name compare equal. It mirrors the SQL in migration 0003_authors.sql.
+func normalizeAuthorName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".,;:'\"-()&", r) {
+			return ' '
+		}
+		return r
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// splitAuthorNames breaks a free-text author string on the separators used in
+// bookAuthorName ("&", "and", ";").
+func splitAuthorNames(s string) []string {
+	s = strings.ReplaceAll(s, " & ", ";")
+	s = strings.ReplaceAll(s, " and ", ";")
+	var names []string
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part != "" {
+			names = append(names, part)
+		}
+	}
+	return names
+}
+
+// findOrCreateAuthor returns the author whose normalized name matches name,
+// creating one if none exists, along with the name as stored.
+func findOrCreateAuthor(q queryer, name string) (int, string, error) {
+	name = strings.TrimSpace(name)
+	normalized := normalizeAuthorName(name)
+	if normalized == "" {
+		return 0, "", fmt.Errorf("author name %q is empty", name)
+	}
+
+	var id int
+	var stored string
+	err := q.QueryRow("SELECT TOP (1) AuthorID, Name FROM Author WHERE NormalizedName = ? ORDER BY AuthorID", normalized).
+		Scan(&id, &stored)
+	if err == nil {
+		return id, stored, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", fmt.Errorf("find author %q: %w", name, err)
+	}
+
+	const ins = `
+		INSERT INTO Author (Name, NormalizedName)
+		VALUES (?, ?);
+		SELECT SCOPE_IDENTITY() AS AuthorID;`
+	if err := q.QueryRow(ins, name, normalized).Scan(&id); err != nil {
+		return 0, "", fmt.Errorf("insert author %q: %w", name, err)
+	}
+	return id, name, nil
+}
+
+// resolveContributors validates contributor input (an authorid or a name, plus
+// a role) and fills in author IDs and stored names, creating authors as
+// needed. Positions follow input order within each role.
+func resolveContributors(q queryer, inputs []BookContributor) ([]BookContributor, error) {
+	positions := map[string]int{}
+	out := make([]BookContributor, 0, len(inputs))
+	for _, in := range inputs {
+		role := strings.TrimSpace(in.Role)
+		if role == "" {
+			role = RoleAuthor
+		}
+		if !isValidContributorRole(role) {
+			return nil, newRequestError(http.StatusBadRequest, "INVALID_ROLE", "role must be one of: Author, Editor, Translator, Illustrator")
+		}
+
+		id := in.AuthorID
+		var name string
+		switch {
+		case id > 0:
+			err := q.QueryRow("SELECT Name FROM Author WHERE AuthorID = ?", id).Scan(&name)
+			if err == sql.ErrNoRows {
+				return nil, newRequestError(http.StatusBadRequest, "AUTHOR_NOT_FOUND", "author %d not found", id)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("load author %d: %w", id, err)
+			}
+		case strings.TrimSpace(in.Name) != "":
+			var err error
+			if id, name, err = findOrCreateAuthor(q, in.Name); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, newRequestError(http.StatusBadRequest, "INVALID_AUTHOR", "each author needs an authorid or a name")
+		}
+
+		positions[role]++
+		out = append(out, BookContributor{AuthorID: id, Name: name, Role: role, Position: positions[role]})
+	}
+	return out, nil
+}
+
+// contributorsFromNames credits each name in a free-text author string as an Author.
+func contributorsFromNames(authorNames string) []BookContributor {
+	var out []BookContributor
+	for _, name := range splitAuthorNames(authorNames) {
+		out = append(out, BookContributor{Name: name, Role: RoleAuthor})
+	}
+	return out
+}
+
+// hasAuthorCredit reports whether contributor input credits at least one
+// author in the Author role (a blank role counts as Author).
+func hasAuthorCredit(contributors []BookContributor) bool {
+	for _, bc := range contributors {
+		role := strings.TrimSpace(bc.Role)
+		if (role == "" || role == RoleAuthor) && (bc.AuthorID > 0 || strings.TrimSpace(bc.Name) != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// authorDisplayName joins the Author-role credits the way bookAuthorName stores them.
+func authorDisplayName(contributors []BookContributor) string {
+	var names []string
+	for _, bc := range contributors {
+		if bc.Role == RoleAuthor {
+			names = append(names, bc.Name)
+		}
+	}
+	return strings.Join(names, " & ")
+}
+
+// setBookContributors replaces the credits for the given roles on a book and
+// refreshes bookAuthorName. Roles not listed keep their existing links.
+func setBookContributors(q queryer, bookID int, roles []string, contributors []BookContributor) error {
+	for _, role := range roles {
+		if _, err := q.Exec("DELETE FROM BookAuthor WHERE BookID = ? AND Role = ?", bookID, role); err != nil {
+			return fmt.Errorf("clear %s credits on book %d: %w", role, bookID, err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, bc := range contributors {
+		key := fmt.Sprintf("%d/%s", bc.AuthorID, bc.Role)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, err := q.Exec("INSERT INTO BookAuthor (BookID, AuthorID, Role, Position) VALUES (?, ?, ?, ?)",
+			bookID, bc.AuthorID, bc.Role, bc.Position); err != nil {
+			return fmt.Errorf("credit author %d on book %d: %w", bc.AuthorID, bookID, err)
+		}
+	}
+	return syncBookAuthorName(q, bookID)
+}
+
+// syncBookAuthorName rewrites Book.bookAuthorName from the book's Author
+// credits so name searches and the duplicate check keep working.
+func syncBookAuthorName(q queryer, bookID int) error {
+	rows, err := q.Query(`
+		SELECT a.Name
+		FROM BookAuthor ba
+		JOIN Author a ON a.AuthorID = ba.AuthorID
+		WHERE ba.BookID = ? AND ba.Role = ?
+		ORDER BY ba.Position, a.Name`, bookID, RoleAuthor)
+	if err != nil {
+		return fmt.Errorf("load authors of book %d: %w", bookID, err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan author of book %d: %w", bookID, err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load authors of book %d: %w", bookID, err)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if _, err := q.Exec("UPDATE Book SET bookAuthorName = ? WHERE BookID = ?", strings.Join(names, " & "), bookID); err != nil {
+		return fmt.Errorf("update author name of book %d: %w", bookID, err)
+	}
+	return nil
+}
+
+// attachContributors loads the credits of every book.
+func attachContributors(q queryer, books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(books))
+	for i := range books {
+		index[books[i].BookID] = i
+		books[i].Authors = []BookContributor{}
+	}
+
+	err := queryBookBatches(q, books, `
+		SELECT ba.BookID, a.AuthorID, a.Name, ba.Role, ba.Position
+		FROM BookAuthor ba
+		JOIN Author a ON a.AuthorID = ba.AuthorID
+		WHERE ba.BookID IN (%s)
+		ORDER BY ba.BookID, ba.Role, ba.Position`, nil, func(rows *sql.Rows) error {
+		var bookID int
+		var bc BookContributor
+		if err := rows.Scan(&bookID, &bc.AuthorID, &bc.Name, &bc.Role, &bc.Position); err != nil {
+			return err
+		}
+		if i, ok := index[bookID]; ok {
+			books[i].Authors = append(books[i].Authors, bc)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load contributors: %w", err)
+	}
+	return nil
+}
+
+// CreateAuthor handles the creation of a new author
+func CreateAuthor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		var newAuthor Author
+		if err := c.ShouldBindJSON(&newAuthor); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+
+		newAuthor.Name = strings.TrimSpace(newAuthor.Name)
+		normalized := normalizeAuthorName(newAuthor.Name)
+		if normalized == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		// Spelling variants of an existing name should be linked, not duplicated
+		var existingID int
+		err := db.QueryRow("SELECT TOP (1) AuthorID FROM Author WHERE NormalizedName = ? ORDER BY AuthorID", normalized).Scan(&existingID)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "an author with this name already exists", "existingID": existingID})
+			return
+		} else if err != sql.ErrNoRows {
+			log.Printf("check author existence for %s: %v", newAuthor.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check author existence"})
+			return
+		}
+
+		const ins = `
+			INSERT INTO Author (Name, SortName, NormalizedName)
+			VALUES (?, ?, ?);
+			SELECT SCOPE_IDENTITY() AS AuthorID;`
+		var id int
+		if err := db.QueryRow(ins, newAuthor.Name, newAuthor.SortName, normalized).Scan(&id); err != nil {
+			log.Printf("insert author %s: %v", newAuthor.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create author"})
+			return
+		}
+
+		var created Author
+		if err := scanAuthor(db.QueryRow("SELECT "+authorColumns+" FROM Author a WHERE a.AuthorID = ?", id), &created); err != nil {
+			log.Printf("fetch author %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch created author"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, created)
+	}
+}
+
+// GetAuthors lists authors, optionally filtered by a name fragment (?q=)
+func GetAuthors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		query := "SELECT " + authorColumns + " FROM Author a"
+		var args []interface{}
+		if q := normalizeAuthorName(c.Query("q")); q != "" {
+			query += " WHERE a.NormalizedName LIKE ?"
+			args = append(args, "%"+q+"%")
+		}
+		query += " ORDER BY COALESCE(a.SortName, a.Name)"
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Printf("get authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get authors"})
+			return
+		}
+		defer rows.Close()
+
+		authors := []Author{}
+		for rows.Next() {
+			var author Author
+			if err := scanAuthor(rows, &author); err != nil {
+				log.Printf("scan author: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scan author"})
+				return
+			}
+			authors = append(authors, author)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get authors"})
+			return
+		}
+
+		c.JSON(http.StatusOK, authors)
+	}
+}
+
+// GetAuthorByID retrieves a specific author by ID
+func GetAuthorByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		authorID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || authorID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author_id"})
+			return
+		}
+
+		var author Author
+		err = scanAuthor(db.QueryRow("SELECT "+authorColumns+" FROM Author a WHERE a.AuthorID = ?", authorID), &author)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "author not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("query author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve author"})
+			return
+		}
+
+		c.JSON(http.StatusOK, author)
+	}
+}
+
+// UpdateAuthor renames an author or changes their sort name
+func UpdateAuthor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		authorID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || authorID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author_id"})
+			return
+		}
+
+		var input struct {
+			Name     *string `json:"name"`
+			SortName *string `json:"sortname"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+
+		// Build dynamic query
+		var setClauses []string
+		var args []interface{}
+		var normalized string
+		if input.Name != nil {
+			name := strings.TrimSpace(*input.Name)
+			normalized = normalizeAuthorName(name)
+			if normalized == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name cannot be empty"})
+				return
+			}
+			setClauses = append(setClauses, "Name = ?", "NormalizedName = ?")
+			args = append(args, name, normalized)
+		}
+		if input.SortName != nil {
+			setClauses = append(setClauses, "SortName = ?")
+			args = append(args, strings.TrimSpace(*input.SortName))
+		}
+		if len(setClauses) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no valid fields provided for update"})
+			return
+		}
+		setClauses = append(setClauses, "Updated_at = ?")
+		args = append(args, time.Now())
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+			return
+		}
+		defer tx.Rollback()
+
+		// A rename must not make the author a spelling variant of another;
+		// those are merged instead
+		if input.Name != nil {
+			var existingID int
+			err := tx.QueryRow("SELECT TOP (1) AuthorID FROM Author WITH (UPDLOCK, HOLDLOCK) WHERE NormalizedName = ? AND AuthorID <> ? ORDER BY AuthorID",
+				normalized, authorID).Scan(&existingID)
+			if err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "an author with this name already exists", "existingID": existingID})
+				return
+			}
+			if err != sql.ErrNoRows {
+				log.Printf("check author name: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+				return
+			}
+		}
+
+		result, err := tx.Exec("UPDATE Author SET "+strings.Join(setClauses, ", ")+" WHERE AuthorID = ?", append(args, authorID)...)
+		if err != nil {
+			log.Printf("update author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+			return
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			log.Printf("update author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+			return
+		}
+		if n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "author not found"})
+			return
+		}
+
+		// A rename changes the display name on every credited book
+		if input.Name != nil {
+			bookIDs, err := authorBookIDs(tx, authorID)
+			if err == nil {
+				for _, bookID := range bookIDs {
+					if err = syncBookAuthorName(tx, bookID); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				log.Printf("update author %d: %v", authorID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+				return
+			}
+		}
+
+		var author Author
+		if err := scanAuthor(tx.QueryRow("SELECT "+authorColumns+" FROM Author a WHERE a.AuthorID = ?", authorID), &author); err != nil {
+			log.Printf("fetch author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve updated author"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+			return
+		}
+
+		c.JSON(http.StatusOK, author)
+	}
+}
+
+// DeleteAuthor removes an author who is no longer credited on any book
+func DeleteAuthor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		authorID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || authorID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author_id"})
+			return
+		}
+
+		var credits int
+		if err := db.QueryRow("SELECT COUNT(*) FROM BookAuthor WHERE AuthorID = ?", authorID).Scan(&credits); err != nil {
+			log.Printf("count credits of author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete author"})
+			return
+		}
+		if credits > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "author is credited on books; merge or re-credit them first"})
+			return
+		}
+
+		result, err := db.Exec("DELETE FROM Author WHERE AuthorID = ?", authorID)
+		if err != nil {
+			log.Printf("delete author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete author"})
+			return
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "author not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "author deleted"})
+	}
+}
+
+// MergeAuthors folds duplicate authors into the author in the URL. Credits
+// move to the target and the duplicates are deleted.
+func MergeAuthors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		targetID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || targetID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author_id"})
+			return
+		}
+
+		var input struct {
+			AuthorIDs []int `json:"authorids"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if len(input.AuthorIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "authorids must list the authors to merge"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+			return
+		}
+		defer tx.Rollback()
+
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM Author WHERE AuthorID = ?", targetID).Scan(&exists); err != nil || exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "author not found"})
+			return
+		}
+
+		affected := map[int]bool{}
+		for _, sourceID := range input.AuthorIDs {
+			if sourceID == targetID {
+				continue
+			}
+			bookIDs, err := authorBookIDs(tx, sourceID)
+			if err != nil {
+				log.Printf("merge author %d: %v", sourceID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+				return
+			}
+			for _, id := range bookIDs {
+				affected[id] = true
+			}
+
+			// Move credits the target does not already have, then drop the rest
+			const move = `
+				UPDATE ba SET AuthorID = ?
+				FROM BookAuthor ba
+				WHERE ba.AuthorID = ?
+				  AND NOT EXISTS (SELECT 1 FROM BookAuthor t WHERE t.BookID = ba.BookID AND t.AuthorID = ? AND t.Role = ba.Role)`
+			if _, err := tx.Exec(move, targetID, sourceID, targetID); err != nil {
+				log.Printf("merge author %d: %v", sourceID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+				return
+			}
+			if _, err := tx.Exec("DELETE FROM BookAuthor WHERE AuthorID = ?", sourceID); err != nil {
+				log.Printf("merge author %d: %v", sourceID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+				return
+			}
+			result, err := tx.Exec("DELETE FROM Author WHERE AuthorID = ?", sourceID)
+			if err != nil {
+				log.Printf("merge author %d: %v", sourceID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+				return
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("author %d not found", sourceID)})
+				return
+			}
+		}
+
+		for bookID := range affected {
+			if err := syncBookAuthorName(tx, bookID); err != nil {
+				log.Printf("merge authors into %d: %v", targetID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+				return
+			}
+		}
+
+		var author Author
+		if err := scanAuthor(tx.QueryRow("SELECT "+authorColumns+" FROM Author a WHERE a.AuthorID = ?", targetID), &author); err != nil {
+			log.Printf("fetch author %d: %v", targetID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit merge into %d: %v", targetID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to merge authors"})
+			return
+		}
+
+		c.JSON(http.StatusOK, author)
+	}
+}
+
+// GetAuthorBooks lists the books an author is credited on, in any role
+func GetAuthorBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		authorID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || authorID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author_id"})
+			return
+		}
+
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(1) FROM Author WHERE AuthorID = ?", authorID).Scan(&exists); err != nil {
+			log.Printf("query author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve author"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "author not found"})
+			return
+		}
+
+		query := "SELECT " + bookColumns + ` FROM Book
+			WHERE BookID IN (SELECT BookID FROM BookAuthor WHERE AuthorID = ?)
+			ORDER BY bookName`
+		rows, err := db.Query(query, authorID)
+		if err != nil {
+			log.Printf("get books of author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get books"})
+			return
+		}
+		defer rows.Close()
+
+		books := []Book{}
+		for rows.Next() {
+			var book Book
+			if err := scanBook(rows, &book); err != nil {
+				log.Printf("scan book: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scan book"})
+				return
+			}
+			books = append(books, book)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get books of author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get books"})
+			return
+		}
+		if err := attachBookDetails(db, books); err != nil {
+			log.Printf("get books of author %d: %v", authorID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get books"})
+			return
+		}
+
+		c.JSON(http.StatusOK, books)
+	}
+}
+
+// authorBookIDs lists the books an author is credited on.
+func authorBookIDs(q queryer, authorID int) ([]int, error) {
+	rows, err := q.Query("SELECT DISTINCT BookID FROM BookAuthor WHERE AuthorID = ?", authorID)
+	if err != nil {
+		return nil, fmt.Errorf("load books of author %d: %w", authorID, err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan book of author %d: %w", authorID, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// MigrationBackfills are the data steps of migrations that must follow the
+// API's own rules, by the migration script they run after.
+var MigrationBackfills = map[string]func(tx *sql.Tx) error{
+	"0004_categories.sql": BackfillBookCategories,
+}
+
+// BackfillBookCategories files every book under the category its typeOfBook
+// names, creating top-level categories for values no alias, slug or name
+// matches, exactly as a new book's type would be filed.
+func BackfillBookCategories(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT DISTINCT typeOfBook FROM Book WHERE typeOfBook IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("load book types: %w", err)
+	}
+	var labels []string
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan book type: %w", err)
+		}
+		labels = append(labels, label)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load book types: %w", err)
+	}
+
+	for _, label := range labels {
+		if categoryKey(label) == "" {
+			continue
+		}
+		id, err := findOrCreateCategory(tx, label)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO BookCategory (BookID, CategoryID, Position)
+			SELECT b.BookID, ?, 1 FROM Book b
+			WHERE b.typeOfBook = ?
+			  AND NOT EXISTS (SELECT 1 FROM BookCategory bc WHERE bc.BookID = b.BookID)`, id, label)
+		if err != nil {
+			return fmt.Errorf("file books of type %q: %w", label, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestBackfillBookCategories(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.rows("SELECT DISTINCT typeOfBook", []string{"typeOfBook"},
+		[]driver.Value{"Sci-Fi"}, []driver.Value{"Cosy Crime!"}, []driver.Value{" -- "})
+	f.on("FROM CategoryAlias WHERE AliasKey", func(args []driver.Value) fakeResult {
+		r := fakeResult{cols: []string{"CategoryID"}}
+		if args[0] == "scifi" {
+			r.rows = [][]driver.Value{{int64(3)}}
+		}
+		return r
+	})
+	f.exec("INSERT INTO CategoryAlias")
+	f.on("INSERT INTO Category (", func(args []driver.Value) fakeResult {
+		if args[0] != "Cosy Crime!" || args[1] != "cosy-crime" {
+			return fakeResult{err: errUnexpectedArgs(args)}
+		}
+		return fakeResult{cols: []string{"CategoryID"}, rows: [][]driver.Value{{int64(20)}}}
+	})
+	f.exec("INSERT INTO BookCategory")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := BackfillBookCategories(tx); err != nil {
+		t.Fatalf("BackfillBookCategories: %v", err)
+	}
+
+	if aliases := f.executed("INSERT INTO CategoryAlias"); len(aliases) != 1 || aliases[0].args[0] != "cosycrime" {
+		t.Errorf("aliases = %+v, want one for cosycrime", aliases)
+	}
+	filed := f.executed("INSERT INTO BookCategory")
+	if len(filed) != 2 {
+		t.Fatalf("filed %d book types, want 2", len(filed))
+	}
+	for i, want := range []struct {
+		id    int64
+		label string
+	}{{3, "Sci-Fi"}, {20, "Cosy Crime!"}} {
+		if args := filed[i].args; args[0] != want.id || args[1] != want.label {
+			t.Errorf("filing %d = %v, want %s under %d", i, args, want.label, want.id)
+		}
+	}
+}
@@ -2,33 +2,350 @@ package controllers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	database "go-crud-api/config"
+	"go-crud-api/helper"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Book struct {
-	BookID         int     `json:"bookid"`
-	TypeOfBook     string  `json:"typeofbook"`
-	BookName       string  `json:"bookname"`
-	BookAuthorName string  `json:"bookauthorname"`
-	IsAvailable    bool    `json:"isavailable"`
-	BookQuantity   int     `json:"bookquantity"`
-	BookPrice      float64 `json:"bookprice"`
+	BookID         int          `json:"bookid"`
+	TypeOfBook     string       `json:"typeofbook"`
+	BookName       string       `json:"bookname"`
+	BookAuthorName string       `json:"bookauthorname"`
+	IsAvailable    bool         `json:"isavailable"`  // true while any copy is Available
+	BookQuantity   int          `json:"bookquantity"` // copies currently Available
+	BookPrice      helper.Money `json:"bookprice"`
+	ISBN10         *string      `json:"isbn10"`
+	ISBN13         *string      `json:"isbn13"`
+
+	// Authors lists every contributor credit; on create, entries may give a
+	// name instead of an authorid
+	Authors []BookContributor `json:"authors"`
+
+	// Categories the book is filed under, primary first; typeofbook mirrors
+	// the primary category's name
+	Categories []CategoryRef `json:"categories"`
+
+	// Cover is null until an image is uploaded
+	Cover *BookCover `json:"cover"`
+
+	// Rating summarizes the approved reviews
+	Rating BookRating `json:"rating"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"` // last change to the description, not to inventory
+}
+
+// maxCopiesPerRequest caps how many copies CreateBook generates in one call.
+const maxCopiesPerRequest = 500
+
+// bookColumns is the column list every Book query selects, in scanBook order.
+const bookColumns = "BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice, bookPriceCurrency, isbn10, isbn13, Created_at, Updated_at"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBook reads one row selected with bookColumns into book.
+func scanBook(row rowScanner, book *Book) error {
+	var isbn10, isbn13 sql.NullString
+	if err := row.Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName,
+		&book.IsAvailable, &book.BookQuantity, &book.BookPrice, book.BookPrice.CurrencyScanner(), &isbn10, &isbn13,
+		&book.CreatedAt, &book.UpdatedAt); err != nil {
+		return err
+	}
+	book.ISBN10, book.ISBN13 = nil, nil
+	if isbn10.Valid {
+		book.ISBN10 = &isbn10.String
+	}
+	if isbn13.Valid {
+		book.ISBN13 = &isbn13.String
+	}
+	return nil
+}
+
+// normalizeBookISBN validates whichever ISBN fields were supplied and fills in
+// the other representation. When both are given they must identify the same
+// book. Returns nil pointers when neither field carries a value.
+func normalizeBookISBN(isbn10, isbn13 *string) (*string, *string, error) {
+	var raw10, raw13 string
+	if isbn10 != nil {
+		raw10 = strings.TrimSpace(*isbn10)
+	}
+	if isbn13 != nil {
+		raw13 = strings.TrimSpace(*isbn13)
+	}
+	if raw10 == "" && raw13 == "" {
+		return nil, nil, nil
+	}
+
+	var parsed helper.ISBN
+	if raw13 != "" {
+		p, err := helper.ParseISBN(raw13)
+		if err != nil {
+			return nil, nil, fmt.Errorf("isbn13 %q: %w", raw13, err)
+		}
+		parsed = p
+	}
+	if raw10 != "" {
+		p, err := helper.ParseISBN(raw10)
+		if err != nil {
+			return nil, nil, fmt.Errorf("isbn10 %q: %w", raw10, err)
+		}
+		if parsed.ISBN13 != "" && parsed.ISBN13 != p.ISBN13 {
+			return nil, nil, errors.New("isbn10 and isbn13 refer to different books")
+		}
+		parsed = p
+	}
+
+	out13 := parsed.ISBN13
+	if parsed.ISBN10 == "" {
+		return nil, &out13, nil
+	}
+	out10 := parsed.ISBN10
+	return &out10, &out13, nil
 }
 
 // UpdateBookInput represents the input for updating a book
 type UpdateBookInput struct {
-	TypeOfBook     *string  `json:"typeofbook"`
-	BookName       *string  `json:"bookname"`
-	BookAuthorName *string  `json:"bookauthorname"`
-	IsAvailable    *bool    `json:"isavailable"`
-	BookQuantity   *int     `json:"bookquantity"`
-	BookPrice      *float64 `json:"bookprice"`
+	TypeOfBook     *string       `json:"typeofbook"`
+	BookName       *string       `json:"bookname"`
+	BookAuthorName *string       `json:"bookauthorname"`
+	IsAvailable    *bool         `json:"isavailable"`
+	BookQuantity   *int          `json:"bookquantity"`
+	BookPrice      *helper.Money `json:"bookprice"`
+	ISBN10         *string       `json:"isbn10"`
+	ISBN13         *string       `json:"isbn13"`
+
+	// Authors replaces every contributor credit when present
+	Authors *[]BookContributor `json:"authors"`
+}
+
+// attachBookDetails loads author credits, categories, covers and ratings for
+// every book.
+func attachBookDetails(q queryer, books []Book) error {
+	if err := attachContributors(q, books); err != nil {
+		return err
+	}
+	if err := attachCategories(q, books); err != nil {
+		return err
+	}
+	if err := attachCovers(q, books); err != nil {
+		return err
+	}
+	return attachRatings(q, books)
+}
+
+// attachBookDetail loads author credits, categories, cover and rating for a
+// single book.
+func attachBookDetail(q queryer, book *Book) error {
+	books := []Book{*book}
+	if err := attachBookDetails(q, books); err != nil {
+		return err
+	}
+	*book = books[0]
+	return nil
+}
+
+// maxQueryBookIDs keeps the IN lists of queryBookBatches well under the
+// 2100 parameters SQL Server allows in one query.
+const maxQueryBookIDs = 1000
+
+// queryBookBatches runs query for the IDs of books, at most maxQueryBookIDs
+// at a time, and hands every row to scan. The %s in query is replaced by
+// one batch's placeholders; args come before the IDs.
+func queryBookBatches(q queryer, books []Book, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	for start := 0; start < len(books); start += maxQueryBookIDs {
+		batch := books[start:min(start+maxQueryBookIDs, len(books))]
+		placeholders := make([]string, len(batch))
+		batchArgs := append([]interface{}{}, args...)
+		for i := range batch {
+			placeholders[i] = "?"
+			batchArgs = append(batchArgs, batch[i].BookID)
+		}
+		if err := queryRows(q, fmt.Sprintf(query, strings.Join(placeholders, ", ")), batchArgs, scan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryRows runs one query and hands every row to scan.
+func queryRows(q queryer, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// validateNewBook applies the required-field rules for a new book and
+// normalizes its ISBNs in place.
+func validateNewBook(book *Book) error {
+	if book.BookName == "" || (book.TypeOfBook == "" && len(book.Categories) == 0) ||
+		(book.BookAuthorName == "" && !hasAuthorCredit(book.Authors)) {
+		return newRequestError(http.StatusBadRequest, "INVALID_BOOK", "Book name, type of book, and author name are required fields")
+	}
+	if book.BookQuantity < 0 || book.BookQuantity > maxCopiesPerRequest {
+		return newRequestError(http.StatusBadRequest, "INVALID_BOOK", "Book quantity must be between 0 and %d", maxCopiesPerRequest)
+	}
+	price, err := normalizePrice(book.BookPrice)
+	if err != nil {
+		return newRequestError(http.StatusBadRequest, "INVALID_BOOK", "%s", err.Error())
+	}
+	book.BookPrice = price
+
+	isbn10, isbn13, err := normalizeBookISBN(book.ISBN10, book.ISBN13)
+	if err != nil {
+		return newRequestError(http.StatusBadRequest, "INVALID_ISBN", "%s", err.Error())
+	}
+	book.ISBN10, book.ISBN13 = isbn10, isbn13
+	return nil
+}
+
+// normalizePrice rounds a price to its currency's smallest unit and rejects
+// negative prices. An absent price is zero in the default currency.
+func normalizePrice(price helper.Money) (helper.Money, error) {
+	if price.Sign() < 0 {
+		return helper.Money{}, errors.New("bookprice must not be negative")
+	}
+	if price.Currency() == "" {
+		return helper.ZeroMoney(""), nil
+	}
+	return price.Round(), nil
+}
+
+// findDuplicateBook returns a DUPLICATE_BOOK error naming the existing book
+// when one has the same name and author, or the same ISBN.
+func findDuplicateBook(q queryer, bookName, bookAuthorName string, isbn13 *string) error {
+	var existingID int
+	err := q.QueryRow("SELECT BookID FROM Book WHERE bookName = ? AND bookAuthorName = ?", bookName, bookAuthorName).Scan(&existingID)
+	if err == nil {
+		e := newRequestError(http.StatusConflict, "DUPLICATE_BOOK", "A book with this name and author already exists")
+		e.Details = gin.H{"existingID": existingID}
+		return e
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("check for existing book: %w", err)
+	}
+
+	// An ISBN identifies a single edition, so it must not already be catalogued
+	if isbn13 != nil {
+		err = q.QueryRow("SELECT BookID FROM Book WHERE isbn13 = ?", *isbn13).Scan(&existingID)
+		if err == nil {
+			e := newRequestError(http.StatusConflict, "DUPLICATE_BOOK", "A book with this ISBN already exists")
+			e.Details = gin.H{"existingID": existingID}
+			return e
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("check for existing ISBN: %w", err)
+		}
+	}
+	return nil
+}
+
+// insertBook creates a validated book together with its copies and author
+// credits, rejecting duplicates. bookAuthorName is derived from the credits.
+func insertBook(q queryer, book *Book) (int, error) {
+	contributors := book.Authors
+	if !hasAuthorCredit(contributors) {
+		contributors = append(contributorsFromNames(book.BookAuthorName), contributors...)
+	}
+	contributors, err := resolveContributors(q, contributors)
+	if err != nil {
+		return 0, err
+	}
+	if name := authorDisplayName(contributors); name != "" {
+		book.BookAuthorName = name
+	}
+
+	if err := findDuplicateBook(q, book.BookName, book.BookAuthorName, book.ISBN13); err != nil {
+		return 0, err
+	}
+
+	// Prepare the SQL query to insert a new book and get the inserted ID using MSSQL syntax
+	query := `
+		INSERT INTO Book (typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice, bookPriceCurrency, isbn10, isbn13)
+		VALUES (?, ?, ?, 0, 0, ?, ?, ?, ?);
+		SELECT SCOPE_IDENTITY() AS ID;
+	`
+	var id int
+	err = q.QueryRow(query,
+		book.TypeOfBook,
+		book.BookName,
+		book.BookAuthorName,
+		book.BookPrice,
+		book.BookPrice.Currency(),
+		book.ISBN10,
+		book.ISBN13).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("insert book: %w", err)
+	}
+	if _, err := recordBookPrice(q, id, book.BookPrice, time.Now().UTC(), ""); err != nil {
+		return 0, err
+	}
+
+	// bookquantity on create is the number of copies to add, each with a generated barcode
+	for n := 1; n <= book.BookQuantity; n++ {
+		item := BookCopy{BookID: id, Barcode: generatedBarcode(id, n)}
+		if err := insertCopy(q, &item); err != nil {
+			return 0, err
+		}
+	}
+	if err := syncBookInventory(q, id); err != nil {
+		return 0, err
+	}
+
+	roles := []string{RoleAuthor, RoleEditor, RoleTranslator, RoleIllustrator}
+	if err := setBookContributors(q, id, roles, contributors); err != nil {
+		return 0, err
+	}
+
+	categoryIDs, err := resolveBookCategories(q, book)
+	if err != nil {
+		return 0, err
+	}
+	if err := setBookCategories(q, id, categoryIDs); err != nil {
+		return 0, err
+	}
+	book.BookID = id
+	return id, nil
+}
+
+// resolveBookCategories turns the categories on a new book into IDs. Entries
+// may name a category instead of giving its ID; with none, typeofbook is
+// mapped through the category aliases.
+func resolveBookCategories(q queryer, book *Book) ([]int, error) {
+	refs := book.Categories
+	if len(refs) == 0 {
+		refs = []CategoryRef{{Name: book.TypeOfBook}}
+	}
+	ids := make([]int, 0, len(refs))
+	for _, ref := range refs {
+		if ref.CategoryID > 0 {
+			ids = append(ids, ref.CategoryID)
+			continue
+		}
+		id, err := findOrCreateCategory(q, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
 func CreateBook() gin.HandlerFunc {
@@ -44,63 +361,36 @@ func CreateBook() gin.HandlerFunc {
 			return
 		}
 
-		// Validate required fields
-		if newBook.BookName == "" || newBook.TypeOfBook == "" || newBook.BookAuthorName == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Book name, type of book, and author name are required fields"})
+		// Validate required fields and normalize ISBNs
+		if err := validateNewBook(&newBook); err != nil {
+			respondRequestError(c, err, "Invalid book")
 			return
 		}
 
-		// Check if a book with the same name and author already exists
-		var existingID int
-		checkQuery := "SELECT BookID FROM Book WHERE bookName = ? AND bookAuthorName = ?"
-		err := db.QueryRow(checkQuery, newBook.BookName, newBook.BookAuthorName).Scan(&existingID)
-
-		if err == nil {
-			// Book already exists, return conflict status
-			c.JSON(http.StatusConflict, gin.H{"error": "A book with this name and author already exists", "existingID": existingID})
+		// The book, its copies and its author credits are created together
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create book. Please try again later."})
 			return
-		} else if err != sql.ErrNoRows {
-			// Unexpected database error
-			log.Printf("Error checking for existing book: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to verify book uniqueness. Please try again later."})
-			return
-		}
-
-		// If we reach here, the book does not exist, so we can proceed with insertion
-		// Prepare the SQL query to insert a new book and get the inserted ID using MSSQL syntax
-		query := `
-			INSERT INTO Book (typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice) 
-			VALUES (?, ?, ?, ?, ?, ?);
-			SELECT SCOPE_IDENTITY() AS ID;
-		`
-
-		// Execute the query with parameterized values using standard placeholders
-		var id int
-		err = db.QueryRow(query,
-			newBook.TypeOfBook,
-			newBook.BookName,
-			newBook.BookAuthorName,
-			newBook.IsAvailable,
-			newBook.BookQuantity,
-			newBook.BookPrice).Scan(&id)
+		}
+		defer tx.Rollback()
 
+		id, err := insertBook(tx, &newBook)
 		if err != nil {
-			log.Printf("Failed to execute insert query: %v", err)
+			log.Printf("Failed to create book: %v", err)
+			respondRequestError(c, err, "Unable to create book. Please try again later.")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Failed to commit book: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create book. Please try again later."})
 			return
 		}
 
 		// Fetch the newly created book data
 		var bookDetails Book
-		err = db.QueryRow("SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice FROM Book WHERE BookID = ?", id).Scan(
-			&bookDetails.BookID,
-			&bookDetails.TypeOfBook,
-			&bookDetails.BookName,
-			&bookDetails.BookAuthorName,
-			&bookDetails.IsAvailable,
-			&bookDetails.BookQuantity,
-			&bookDetails.BookPrice,
-		)
+		err = scanBook(db.QueryRow("SELECT "+bookColumns+" FROM Book WHERE BookID = ?", id), &bookDetails)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				log.Printf("No record found for BookID %d", id)
@@ -111,6 +401,11 @@ func CreateBook() gin.HandlerFunc {
 			}
 			return
 		}
+		if err := attachBookDetail(db, &bookDetails); err != nil {
+			log.Printf("Failed to fetch created book authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch created book data. Please try again later."})
+			return
+		}
 
 		// Send the response with the created book data
 		c.JSON(http.StatusCreated, gin.H{"data": bookDetails})
@@ -122,11 +417,29 @@ func GetBooks() gin.HandlerFunc {
 		// Get the database connection
 		db := database.Database()
 
-		// Prepare the SQL query to fetch all books
-		query := "SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice FROM Book"
+		// Optional filters: name, author, type, isAvailable, isbn
+		filter, err := bookFilter(db, c.Request.URL.Query())
+		if err != nil {
+			log.Printf("Invalid book filter: %v", err)
+			respondRequestError(c, err, "Unable to fetch books. Please try again later.")
+			return
+		}
+
+		// Prepare the SQL query to fetch all matching books
+		query := "SELECT " + bookColumns + " FROM Book" + filter.where()
+
+		// sort=rating puts the best rated books first
+		switch v := c.Query("sort"); v {
+		case "":
+		case "rating":
+			query = "SELECT " + bookColumns + " FROM Book" + bookRatingApply + filter.where() + " ORDER BY " + bookRatingOrder
+		default:
+			respondRequestError(c, invalidFilter("sort", v), "Unable to fetch books. Please try again later.")
+			return
+		}
 
 		// Execute the query and scan the results into a slice of Book structs
-		rows, err := db.Query(query)
+		rows, err := db.Query(query, filter.args...)
 		if err != nil {
 			log.Printf("Failed to execute query: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch books. Please try again later."})
@@ -138,7 +451,7 @@ func GetBooks() gin.HandlerFunc {
 		var books []Book
 		for rows.Next() {
 			var book Book
-			err := rows.Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName, &book.IsAvailable, &book.BookQuantity, &book.BookPrice)
+			err := scanBook(rows, &book)
 			if err != nil {
 				log.Printf("Failed to scan row: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process book data. Please try again later."})
@@ -154,6 +467,13 @@ func GetBooks() gin.HandlerFunc {
 			return
 		}
 
+		// Load author credits for all books at once
+		if err := attachBookDetails(db, books); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to process book data. Please try again later."})
+			return
+		}
+
 		// Send the response with the fetched books
 		c.JSON(http.StatusOK, gin.H{"data": books})
 	}
@@ -168,11 +488,11 @@ func GetBookByID() gin.HandlerFunc {
 		bookID := c.Param("id")
 
 		// Prepare the SQL query to fetch a single book by ID
-		query := "SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice FROM Book WHERE BookID = ?"
+		query := "SELECT " + bookColumns + " FROM Book WHERE BookID = ?"
 
 		// Execute the query with a parameterized statement
 		var book Book
-		err := db.QueryRow(query, bookID).Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName, &book.IsAvailable, &book.BookQuantity, &book.BookPrice)
+		err := scanBook(db.QueryRow(query, bookID), &book)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				// No rows found for the given ID
@@ -184,6 +504,31 @@ func GetBookByID() gin.HandlerFunc {
 			}
 			return
 		}
+		if err := attachBookDetail(db, &book); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch book data. Please try again later."})
+			return
+		}
+
+		// ?asof=2024-03-01 reports the price the book had then
+		if v := c.Query("asof"); v != "" {
+			at, err := parsePriceTime(v, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "asof: " + err.Error()})
+				return
+			}
+			p, err := bookPriceAsOf(db, book.BookID, at)
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "The book had no recorded price at that time"})
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to fetch price as of %s: %v", at, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch book data. Please try again later."})
+				return
+			}
+			book.BookPrice = p.Price
+		}
 
 		// Send the response with the book data
 		c.JSON(http.StatusOK, gin.H{"data": book})
@@ -207,8 +552,7 @@ func GetBookByName() gin.HandlerFunc {
 
 		// Add wildcards for partial matching and handle case-insensitive search
 		searchName := "%" + bookName + "%"
-		query := `
-            SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice
+		query := "SELECT " + bookColumns + `
             FROM Book
             WHERE LOWER(bookName) LIKE LOWER(?)`
 
@@ -228,7 +572,7 @@ func GetBookByName() gin.HandlerFunc {
 		var books []Book
 		for rows.Next() {
 			var book Book
-			err := rows.Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName, &book.IsAvailable, &book.BookQuantity, &book.BookPrice)
+			err := scanBook(rows, &book)
 			if err != nil {
 				log.Printf("Error scanning row: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{
@@ -250,6 +594,16 @@ func GetBookByName() gin.HandlerFunc {
 			return
 		}
 
+		// Load author credits for all books at once
+		if err := attachBookDetails(db, books); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Error processing book data",
+				"data":  nil,
+			})
+			return
+		}
+
 		// Handle no results
 		if len(books) == 0 {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -282,11 +636,18 @@ func GetBookByAuthor() gin.HandlerFunc {
 			return
 		}
 
-		// Add wildcards for partial matching and handle case-insensitive search
-		searchAuthor := "%" + bookAuthorName + "%"
-		query := `SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice
+		// Match the whole normalized author name, so spelling variants such as
+		// "J.K. Rowling" and "J. K. Rowling" find the same books but "Row"
+		// finds none; GET /author?q= searches names and GET
+		// /author/:id/books lists an author's books
+		searchAuthor := normalizeAuthorName(bookAuthorName)
+		query := "SELECT " + bookColumns + `
             FROM Book
-            WHERE LOWER(bookAuthorName) LIKE LOWER(?)`
+            WHERE BookID IN (
+                SELECT ba.BookID
+                FROM BookAuthor ba
+                JOIN Author a ON a.AuthorID = ba.AuthorID
+                WHERE a.NormalizedName = ?)`
 
 		// Execute the query (using ? for MSSQL compatibility)
 		rows, err := db.Query(query, searchAuthor)
@@ -304,7 +665,7 @@ func GetBookByAuthor() gin.HandlerFunc {
 		var books []Book
 		for rows.Next() {
 			var book Book
-			err := rows.Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName, &book.IsAvailable, &book.BookQuantity, &book.BookPrice)
+			err := scanBook(rows, &book)
 			if err != nil {
 				log.Printf("Error scanning row: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{
@@ -326,6 +687,16 @@ func GetBookByAuthor() gin.HandlerFunc {
 			return
 		}
 
+		// Load author credits for all books at once
+		if err := attachBookDetails(db, books); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Error processing book data",
+				"data":  nil,
+			})
+			return
+		}
+
 		// Handle no results
 		if len(books) == 0 {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -358,14 +729,31 @@ func GetBookByType() gin.HandlerFunc {
 			return
 		}
 
-		// Add wildcards for partial matching and handle case-insensitive search
-		searchType := "%" + typeOfBook + "%"
-		query := `SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice
-					     FROM Book
-            WHERE LOWER(typeOfBook) LIKE LOWER(?)`
+		// Resolve the type through the category aliases; browsing a category
+		// includes every category below it
+		categoryID, found, err := resolveCategory(db, typeOfBook)
+		if err != nil {
+			log.Printf("Failed to resolve category: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Unable to fetch book data",
+				"data":  nil,
+			})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "No books found with the given type",
+				"data":  nil,
+			})
+			return
+		}
+		query := categorySubtreeCTE + `
+            SELECT ` + bookColumns + `
+            FROM Book
+            WHERE BookID IN (SELECT bc.BookID FROM BookCategory bc JOIN Tree t ON t.CategoryID = bc.CategoryID)`
 
 		// Execute the query (using ? for MSSQL compatibility)
-		rows, err := db.Query(query, searchType)
+		rows, err := db.Query(query, categoryID)
 		if err != nil {
 			log.Printf("Failed to execute query: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -380,7 +768,7 @@ func GetBookByType() gin.HandlerFunc {
 		var books []Book
 		for rows.Next() {
 			var book Book
-			err := rows.Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName, &book.IsAvailable, &book.BookQuantity, &book.BookPrice)
+			err := scanBook(rows, &book)
 			if err != nil {
 				log.Printf("Error scanning row: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{
@@ -402,6 +790,16 @@ func GetBookByType() gin.HandlerFunc {
 			return
 		}
 
+		// Load author credits for all books at once
+		if err := attachBookDetails(db, books); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Error processing book data",
+				"data":  nil,
+			})
+			return
+		}
+
 		// Handle no results
 		if len(books) == 0 {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -419,6 +817,55 @@ func GetBookByType() gin.HandlerFunc {
 	}
 }
 
+// GetBookByISBN looks a book up by ISBN-10, ISBN-13 or a scanned EAN-13 barcode
+func GetBookByISBN() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the database connection
+		db := database.Database()
+
+		// Normalize the ISBN from the URL parameters; everything is matched on ISBN-13
+		isbn, err := helper.ParseISBN(c.Param("isbn"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid ISBN. Use a valid ISBN-10, ISBN-13 or EAN-13 barcode",
+				"data":  nil,
+			})
+			return
+		}
+
+		var book Book
+		err = scanBook(db.QueryRow("SELECT "+bookColumns+" FROM Book WHERE isbn13 = ?", isbn.ISBN13), &book)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "No book found with the given ISBN",
+					"data":  nil,
+				})
+			} else {
+				log.Printf("Failed to execute query: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Unable to fetch book data",
+					"data":  nil,
+				})
+			}
+			return
+		}
+		if err := attachBookDetail(db, &book); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Unable to fetch book data",
+				"data":  nil,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"error": nil,
+			"data":  book,
+		})
+	}
+}
+
 // GetBookByAvailability handles fetching books by availability status
 func GetBookByAvailability() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -458,8 +905,7 @@ func GetBookByAvailability() gin.HandlerFunc {
 		}
 
 		// Query for books with the specified availability
-		query := `
-            SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable, bookQuantity, bookPrice
+		query := "SELECT " + bookColumns + `
             FROM Book
             WHERE isAvailable = ?`
 
@@ -479,7 +925,7 @@ func GetBookByAvailability() gin.HandlerFunc {
 		var books []Book
 		for rows.Next() {
 			var book Book
-			err := rows.Scan(&book.BookID, &book.TypeOfBook, &book.BookName, &book.BookAuthorName, &book.IsAvailable, &book.BookQuantity, &book.BookPrice)
+			err := scanBook(rows, &book)
 			if err != nil {
 				log.Printf("Error scanning row: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{
@@ -501,6 +947,16 @@ func GetBookByAvailability() gin.HandlerFunc {
 			return
 		}
 
+		// Load author credits for all books at once
+		if err := attachBookDetails(db, books); err != nil {
+			log.Printf("Error loading authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Error processing book data",
+				"data":  nil,
+			})
+			return
+		}
+
 		// Handle no results
 		if len(books) == 0 {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -548,33 +1004,80 @@ func UpdateBook() gin.HandlerFunc {
 		var setClauses []string
 		var args []interface{}
 
-		if input.TypeOfBook != nil {
-			setClauses = append(setClauses, "typeOfBook = ?")
-			args = append(args, *input.TypeOfBook)
-		}
 		if input.BookName != nil {
 			setClauses = append(setClauses, "bookName = ?")
 			args = append(args, *input.BookName)
 		}
-		if input.BookAuthorName != nil {
-			setClauses = append(setClauses, "bookAuthorName = ?")
-			args = append(args, *input.BookAuthorName)
+
+		// Author changes re-credit the book; bookAuthorName follows the credits.
+		// A plain bookauthorname only replaces the Author role.
+		var contributors []BookContributor
+		var replaceRoles []string
+		if input.Authors != nil {
+			contributors = *input.Authors
+			replaceRoles = []string{RoleAuthor, RoleEditor, RoleTranslator, RoleIllustrator}
+		} else if input.BookAuthorName != nil {
+			contributors = contributorsFromNames(*input.BookAuthorName)
+			replaceRoles = []string{RoleAuthor}
 		}
-		if input.IsAvailable != nil {
-			setClauses = append(setClauses, "isAvailable = ?")
-			args = append(args, *input.IsAvailable)
+		if replaceRoles != nil && !hasAuthorCredit(contributors) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "A book needs at least one author",
+				"data":  nil,
+			})
+			return
 		}
-		if input.BookQuantity != nil {
-			setClauses = append(setClauses, "bookQuantity = ?")
-			args = append(args, *input.BookQuantity)
+		if input.IsAvailable != nil || input.BookQuantity != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "isavailable and bookquantity are derived from the book's copies; manage them via /book/:id/copies",
+				"data":  nil,
+			})
+			return
 		}
 		if input.BookPrice != nil {
-			setClauses = append(setClauses, "bookPrice = ?")
-			args = append(args, *input.BookPrice)
+			// Prices change through the history so the old one is kept
+			if _, err := normalizePrice(*input.BookPrice); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+					"data":  nil,
+				})
+				return
+			}
+		}
+		if input.ISBN10 != nil || input.ISBN13 != nil {
+			isbn10, isbn13, err := normalizeBookISBN(input.ISBN10, input.ISBN13)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+					"data":  nil,
+				})
+				return
+			}
+			if isbn13 != nil {
+				var existingID int
+				err = db.QueryRow("SELECT BookID FROM Book WHERE isbn13 = ? AND BookID <> ?", *isbn13, bookID).Scan(&existingID)
+				if err == nil {
+					c.JSON(http.StatusConflict, gin.H{
+						"error": "A book with this ISBN already exists",
+						"data":  gin.H{"existingID": existingID},
+					})
+					return
+				} else if err != sql.ErrNoRows {
+					log.Printf("Error checking for existing ISBN: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"error": "Unable to verify book uniqueness",
+						"data":  nil,
+					})
+					return
+				}
+			}
+			// Both columns are rewritten together so they never describe different editions
+			setClauses = append(setClauses, "isbn10 = ?", "isbn13 = ?")
+			args = append(args, isbn10, isbn13)
 		}
 
 		// Check if any fields were provided for update
-		if len(setClauses) == 0 {
+		if len(setClauses) == 0 && replaceRoles == nil && input.TypeOfBook == nil && input.BookPrice == nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "No fields provided for update",
 				"data":  nil,
@@ -582,39 +1085,36 @@ func UpdateBook() gin.HandlerFunc {
 			return
 		}
 
-		// Construct the SQL query
-		query := fmt.Sprintf(
-			"UPDATE Book SET %s WHERE BookID = ?",
-			strings.Join(setClauses, ", "),
-		)
-		args = append(args, bookID)
-
-		// Log the query for debugging (avoid logging args to prevent sensitive data exposure)
-		log.Printf("Executing query: %s", query)
+		id, err := strconv.Atoi(bookID)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid book ID",
+				"data":  nil,
+			})
+			return
+		}
 
-		// Execute the update
-		result, err := db.Exec(query, args...)
+		tx, err := db.Begin()
 		if err != nil {
-			log.Printf("Failed to execute update query: %v", err)
+			log.Printf("Failed to begin transaction: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to update book",
 				"data":  nil,
 			})
 			return
 		}
+		defer tx.Rollback()
 
-		// Check if any rows were affected
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
-			log.Printf("Failed to check rows affected: %v", err)
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM Book WITH (UPDLOCK) WHERE BookID = ?", id).Scan(&exists); err != nil {
+			log.Printf("Failed to check book: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to verify update",
+				"error": "Failed to update book",
 				"data":  nil,
 			})
 			return
 		}
-
-		if rowsAffected == 0 {
+		if exists == 0 {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "Book not found",
 				"data":  nil,
@@ -622,6 +1122,90 @@ func UpdateBook() gin.HandlerFunc {
 			return
 		}
 
+		if len(setClauses) > 0 {
+			// Construct the SQL query
+			query := fmt.Sprintf(
+				"UPDATE Book SET %s WHERE BookID = ?",
+				strings.Join(setClauses, ", "),
+			)
+			args = append(args, id)
+
+			// Log the query for debugging (avoid logging args to prevent sensitive data exposure)
+			log.Printf("Executing query: %s", query)
+
+			// Execute the update
+			if _, err := tx.Exec(query, args...); err != nil {
+				log.Printf("Failed to execute update query: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to update book",
+					"data":  nil,
+				})
+				return
+			}
+		}
+
+		if input.BookPrice != nil {
+			if _, err := recordBookPrice(tx, id, *input.BookPrice, time.Now().UTC(), ""); err != nil {
+				log.Printf("Failed to update book price: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to update book",
+					"data":  nil,
+				})
+				return
+			}
+		}
+
+		// A new type makes its category the primary one; other categories stay
+		if input.TypeOfBook != nil {
+			err := setPrimaryBookCategory(tx, id, *input.TypeOfBook)
+			if err != nil {
+				log.Printf("Failed to update book type: %v", err)
+				if re, ok := err.(*requestError); ok {
+					c.JSON(re.Status, gin.H{
+						"error": re.Message,
+						"data":  nil,
+					})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to update book",
+					"data":  nil,
+				})
+				return
+			}
+		}
+
+		if replaceRoles != nil {
+			resolved, err := resolveContributors(tx, contributors)
+			if err == nil {
+				err = setBookContributors(tx, id, replaceRoles, resolved)
+			}
+			if err != nil {
+				log.Printf("Failed to update book authors: %v", err)
+				if re, ok := err.(*requestError); ok {
+					c.JSON(re.Status, gin.H{
+						"error": re.Message,
+						"data":  nil,
+					})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to update book",
+					"data":  nil,
+				})
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Failed to commit book update: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update book",
+				"data":  nil,
+			})
+			return
+		}
+
 		// Return success response
 		c.JSON(http.StatusOK, gin.H{
 			"error": nil,
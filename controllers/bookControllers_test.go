@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestAttachContributorsBatchesIDs(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.on("FROM BookAuthor ba", func(args []driver.Value) fakeResult {
+		// Credit the first book of every batch
+		return fakeResult{
+			cols: []string{"BookID", "AuthorID", "Name", "Role", "Position"},
+			rows: [][]driver.Value{{args[0], int64(7), "A. Writer", RoleAuthor, int64(1)}},
+		}
+	})
+
+	books := make([]Book, 2*maxQueryBookIDs+1)
+	for i := range books {
+		books[i].BookID = i + 1
+	}
+	if err := attachContributors(db, books); err != nil {
+		t.Fatalf("attachContributors: %v", err)
+	}
+
+	calls := f.executed("FROM BookAuthor ba")
+	if len(calls) != 3 {
+		t.Fatalf("ran %d queries, want 3", len(calls))
+	}
+	for i, call := range calls {
+		if len(call.args) > maxQueryBookIDs || strings.Count(call.query, "?") != len(call.args) {
+			t.Errorf("query %d has %d placeholders for %d args", i, strings.Count(call.query, "?"), len(call.args))
+		}
+	}
+	for _, i := range []int{0, maxQueryBookIDs, 2 * maxQueryBookIDs} {
+		if len(books[i].Authors) != 1 || books[i].Authors[0].Name != "A. Writer" {
+			t.Errorf("book %d authors = %+v", books[i].BookID, books[i].Authors)
+		}
+	}
+	if books[1].Authors == nil || len(books[1].Authors) != 0 {
+		t.Errorf("uncredited book authors = %#v, want empty", books[1].Authors)
+	}
+}
+
+func TestCountShelvedCopiesKeepsLeadingArgs(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.on("FROM BookCopy", func(args []driver.Value) fakeResult {
+		if args[0] != CopyLost || args[1] != CopyWithdrawn {
+			return fakeResult{err: errUnexpectedArgs(args)}
+		}
+		return fakeResult{cols: []string{"BookID", "n"}, rows: [][]driver.Value{{args[2], int64(3)}}}
+	})
+	books := make([]Book, maxQueryBookIDs+1)
+	for i := range books {
+		books[i].BookID = i + 1
+	}
+	counts, err := countShelvedCopies(db, books)
+	if err != nil {
+		t.Fatalf("countShelvedCopies: %v", err)
+	}
+	if counts[1] != 3 || counts[maxQueryBookIDs+1] != 3 || len(counts) != 2 {
+		t.Errorf("counts = %v", counts)
+	}
+}
@@ -0,0 +1,460 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BookCopy is one physical item of a Book, identified by its barcode
+type BookCopy struct {
+	CopyID          int     `json:"copyid"`
+	BookID          int     `json:"bookid"`
+	Barcode         string  `json:"barcode"`
+	Status          string  `json:"status"`
+	Condition       string  `json:"condition"`
+	AcquisitionDate *string `json:"acquisitiondate"` // YYYY-MM-DD, nullable
+	Notes           *string `json:"notes"`
+}
+
+// UpdateBookCopyInput represents the input for updating a copy
+type UpdateBookCopyInput struct {
+	Barcode         *string `json:"barcode"`
+	Status          *string `json:"status"`
+	Condition       *string `json:"condition"`
+	AcquisitionDate *string `json:"acquisitiondate"`
+	Notes           *string `json:"notes"`
+}
+
+const copyColumns = "CopyID, BookID, Barcode, Status, Condition, AcquisitionDate, Notes"
+
+// scanCopy reads one row selected with copyColumns into a BookCopy.
+func scanCopy(row rowScanner, item *BookCopy) error {
+	var acquired sql.NullTime
+	var notes sql.NullString
+	if err := row.Scan(&item.CopyID, &item.BookID, &item.Barcode, &item.Status, &item.Condition, &acquired, &notes); err != nil {
+		return err
+	}
+	item.AcquisitionDate, item.Notes = nil, nil
+	if acquired.Valid {
+		d := acquired.Time.Format("2006-01-02")
+		item.AcquisitionDate = &d
+	}
+	if notes.Valid {
+		item.Notes = &notes.String
+	}
+	return nil
+}
+
+// isValidCopyCondition checks if the condition is one we grade copies by
+func isValidCopyCondition(condition string) bool {
+	validConditions := map[string]bool{
+		"New":     true,
+		"Good":    true,
+		"Worn":    true,
+		"Damaged": true,
+	}
+	return validConditions[condition]
+}
+
+// isManualCopyStatus reports whether staff may set status directly; OnLoan
+// is only ever set by checkout and OnHold by the holds queue.
+func isManualCopyStatus(status string) bool {
+	switch status {
+	case CopyAvailable, CopyDamaged, CopyLost, CopyWithdrawn:
+		return true
+	}
+	return false
+}
+
+// generatedBarcode builds the default barcode for the n-th copy of a book.
+func generatedBarcode(bookID, n int) string {
+	return fmt.Sprintf("B%06d-%03d", bookID, n)
+}
+
+// insertCopy adds a copy to a book, generating a barcode when none is given.
+func insertCopy(q queryer, item *BookCopy) error {
+	if item.Barcode == "" {
+		var count int
+		if err := q.QueryRow("SELECT COUNT(*) FROM BookCopy WHERE BookID = ?", item.BookID).Scan(&count); err != nil {
+			return fmt.Errorf("count copies of book %d: %w", item.BookID, err)
+		}
+		item.Barcode = generatedBarcode(item.BookID, count+1)
+	}
+	if item.Status == "" {
+		item.Status = CopyAvailable
+	}
+	if item.Condition == "" {
+		item.Condition = "Good"
+	}
+
+	const ins = `
+		INSERT INTO BookCopy (BookID, Barcode, Status, Condition, AcquisitionDate, Notes)
+		VALUES (?, ?, ?, ?, ?, ?);
+		SELECT SCOPE_IDENTITY() AS CopyID;`
+	if err := q.QueryRow(ins, item.BookID, item.Barcode, item.Status, item.Condition,
+		item.AcquisitionDate, item.Notes).Scan(&item.CopyID); err != nil {
+		return fmt.Errorf("insert copy for book %d: %w", item.BookID, err)
+	}
+	return nil
+}
+
+// CreateBookCopy adds a physical copy to an existing book
+func CreateBookCopy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book_id"})
+			return
+		}
+
+		var newCopy BookCopy
+		if err := c.ShouldBindJSON(&newCopy); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		newCopy.BookID = bookID
+		newCopy.Barcode = strings.TrimSpace(newCopy.Barcode)
+		newCopy.Status = strings.TrimSpace(newCopy.Status)
+		newCopy.Condition = strings.TrimSpace(newCopy.Condition)
+
+		// Validate input
+		if newCopy.Status != "" && !isManualCopyStatus(newCopy.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of: Available, Damaged, Lost, Withdrawn"})
+			return
+		}
+		if newCopy.Condition != "" && !isValidCopyCondition(newCopy.Condition) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "condition must be one of: New, Good, Worn, Damaged"})
+			return
+		}
+		if newCopy.AcquisitionDate != nil {
+			if _, err := time.Parse("2006-01-02", *newCopy.AcquisitionDate); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "acquisitiondate must be in YYYY-MM-DD format"})
+				return
+			}
+		}
+
+		// Check the barcode is not already in use
+		if newCopy.Barcode != "" {
+			var existingID int
+			err := db.QueryRow("SELECT CopyID FROM BookCopy WHERE Barcode = ?", newCopy.Barcode).Scan(&existingID)
+			if err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "a copy with this barcode already exists", "existingID": existingID})
+				return
+			} else if err != sql.ErrNoRows {
+				log.Printf("check barcode %s: %v", newCopy.Barcode, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check barcode"})
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create copy"})
+			return
+		}
+		defer tx.Rollback()
+
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM Book WHERE BookID = ?", bookID).Scan(&exists); err != nil {
+			log.Printf("check book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create copy"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+			return
+		}
+
+		if err := insertCopy(tx, &newCopy); err != nil {
+			log.Printf("create copy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create copy"})
+			return
+		}
+		// A new copy of a book with a queue goes straight to the first hold
+		hold, err := shelveCopy(tx, newCopy.CopyID)
+		if err != nil {
+			log.Printf("create copy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create copy"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit copy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create copy"})
+			return
+		}
+		if hold != nil {
+			newCopy.Status = CopyOnHold
+		}
+
+		c.JSON(http.StatusCreated, newCopy)
+	}
+}
+
+// GetBookCopies lists every copy of a book
+func GetBookCopies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book_id"})
+			return
+		}
+
+		rows, err := db.Query("SELECT "+copyColumns+" FROM BookCopy WHERE BookID = ? ORDER BY CopyID", bookID)
+		if err != nil {
+			log.Printf("get copies of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get copies"})
+			return
+		}
+		defer rows.Close()
+
+		copies := []BookCopy{}
+		for rows.Next() {
+			var item BookCopy
+			if err := scanCopy(rows, &item); err != nil {
+				log.Printf("scan copy: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scan copy"})
+				return
+			}
+			copies = append(copies, item)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get copies of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get copies"})
+			return
+		}
+
+		c.JSON(http.StatusOK, copies)
+	}
+}
+
+// GetCopyByID retrieves a copy by its ID
+func GetCopyByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		copyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || copyID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy_id"})
+			return
+		}
+
+		var item BookCopy
+		err = scanCopy(db.QueryRow("SELECT "+copyColumns+" FROM BookCopy WHERE CopyID = ?", copyID), &item)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("query copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve copy"})
+			return
+		}
+
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// GetCopyByBarcode retrieves a copy by the barcode printed on it
+func GetCopyByBarcode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		barcode := strings.TrimSpace(c.Param("barcode"))
+		if barcode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "barcode cannot be empty"})
+			return
+		}
+
+		var item BookCopy
+		err := scanCopy(db.QueryRow("SELECT "+copyColumns+" FROM BookCopy WHERE Barcode = ?", barcode), &item)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("query copy %s: %v", barcode, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve copy"})
+			return
+		}
+
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// UpdateBookCopy updates a copy's barcode, status, condition or notes
+func UpdateBookCopy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		copyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || copyID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy_id"})
+			return
+		}
+
+		var input UpdateBookCopyInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+
+		// Build dynamic query
+		var setClauses []string
+		var args []interface{}
+
+		if input.Barcode != nil {
+			barcode := strings.TrimSpace(*input.Barcode)
+			if barcode == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "barcode cannot be empty"})
+				return
+			}
+			setClauses = append(setClauses, "Barcode = ?")
+			args = append(args, barcode)
+		}
+		if input.Status != nil {
+			status := strings.TrimSpace(*input.Status)
+			if !isManualCopyStatus(status) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of: Available, Damaged, Lost, Withdrawn"})
+				return
+			}
+			setClauses = append(setClauses, "Status = ?")
+			args = append(args, status)
+		}
+		// A condition change is recorded in the copy's condition history
+		var condition string
+		if input.Condition != nil {
+			condition = strings.TrimSpace(*input.Condition)
+			if !isValidCopyCondition(condition) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "condition must be one of: New, Good, Worn, Damaged"})
+				return
+			}
+		}
+		if input.AcquisitionDate != nil {
+			if _, err := time.Parse("2006-01-02", *input.AcquisitionDate); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "acquisitiondate must be in YYYY-MM-DD format"})
+				return
+			}
+			setClauses = append(setClauses, "AcquisitionDate = ?")
+			args = append(args, *input.AcquisitionDate)
+		}
+		if input.Notes != nil {
+			setClauses = append(setClauses, "Notes = ?")
+			args = append(args, *input.Notes)
+		}
+
+		if len(setClauses) == 0 && condition == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no valid fields provided for update"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update copy"})
+			return
+		}
+		defer tx.Rollback()
+
+		// A copy out on loan or set aside for a hold changes status through
+		// the return and hold flows, not here
+		var current BookCopy
+		err = scanCopy(tx.QueryRow("SELECT "+copyColumns+" FROM BookCopy WITH (UPDLOCK) WHERE CopyID = ?", copyID), &current)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("query copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update copy"})
+			return
+		}
+		if input.Status != nil && current.Status == CopyOnLoan {
+			c.JSON(http.StatusConflict, gin.H{"error": "copy is on loan; return it before changing its status"})
+			return
+		}
+		if input.Status != nil && current.Status == CopyOnHold {
+			c.JSON(http.StatusConflict, gin.H{"error": "copy is set aside for a hold; cancel the hold before changing its status"})
+			return
+		}
+
+		if len(setClauses) > 0 {
+			updateQuery := "UPDATE BookCopy SET " + strings.Join(setClauses, ", ") + " WHERE CopyID = ?"
+			args = append(args, copyID)
+			if _, err := tx.Exec(updateQuery, args...); err != nil {
+				log.Printf("update copy %d: %v", copyID, err)
+				if strings.Contains(err.Error(), "UX_BookCopy_Barcode") {
+					c.JSON(http.StatusConflict, gin.H{"error": "a copy with this barcode already exists"})
+				} else {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update copy"})
+				}
+				return
+			}
+		}
+		if condition != "" && condition != current.Condition {
+			if _, err := assessCondition(tx, copyID, nil, ConditionReport{Condition: condition}); err != nil {
+				log.Printf("update copy %d: %v", copyID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update copy"})
+				return
+			}
+		}
+		if _, err := shelveCopy(tx, copyID); err != nil {
+			log.Printf("update copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update copy"})
+			return
+		}
+
+		var updated BookCopy
+		if err := scanCopy(tx.QueryRow("SELECT "+copyColumns+" FROM BookCopy WHERE CopyID = ?", copyID), &updated); err != nil {
+			log.Printf("query copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve updated copy"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update copy"})
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
@@ -0,0 +1,622 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// Limits for a single import, over HTTP or from the CLI.
+const (
+	maxImportBytes = 20 << 20
+	maxImportRows  = 10000
+)
+
+// Duplicate strategies for an import row matching an existing book by name
+// and author or by ISBN.
+const (
+	OnDuplicateError  = "error"  // report the row as failed
+	OnDuplicateSkip   = "skip"   // leave the existing book untouched
+	OnDuplicateUpdate = "update" // apply the row's price, ISBN, type and quantity to it
+)
+
+// Row outcomes in a BookImportReport.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// importFields maps a folded column header (see categoryKey) to the Book
+// field it fills. A custom mapping may name any of the values.
+var importFields = map[string]string{
+	"bookname":          "bookname",
+	"name":              "bookname",
+	"title":             "bookname",
+	"bookauthorname":    "bookauthorname",
+	"author":            "bookauthorname",
+	"authors":           "bookauthorname",
+	"typeofbook":        "typeofbook",
+	"type":              "typeofbook",
+	"genre":             "typeofbook",
+	"category":          "typeofbook",
+	"categories":        "categories",
+	"bookprice":         "bookprice",
+	"price":             "bookprice",
+	"bookpricecurrency": "bookpricecurrency",
+	"currency":          "bookpricecurrency",
+	"bookquantity":      "bookquantity",
+	"quantity":          "bookquantity",
+	"qty":               "bookquantity",
+	"copies":            "bookquantity",
+	"isbn":              "isbn",
+	"isbn10":            "isbn10",
+	"isbn13":            "isbn13",
+}
+
+// BookImportOptions controls how ImportBookTable treats the rows it reads.
+type BookImportOptions struct {
+	DryRun      bool
+	OnDuplicate string
+
+	// Mapping overrides header recognition: column header -> Book field
+	// (bookname, bookauthorname, typeofbook, categories, bookprice,
+	// bookpricecurrency, bookquantity, isbn, isbn10 or isbn13)
+	Mapping map[string]string
+}
+
+// BookImportRowResult is the outcome of one data row. Row is the line number
+// in the file, counting the header as line 1.
+type BookImportRowResult struct {
+	Row      int    `json:"row"`
+	Status   string `json:"status"`
+	BookID   int    `json:"bookid,omitempty"`
+	BookName string `json:"bookname,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// Unmapped lists source fields that had no Book equivalent (MARC imports)
+	Unmapped []string `json:"unmapped,omitempty"`
+}
+
+// BookImportReport summarises an import. In a dry run nothing is written and
+// created rows carry no bookid.
+type BookImportReport struct {
+	DryRun         bool                  `json:"dryRun"`
+	OnDuplicate    string                `json:"onDuplicate"`
+	Total          int                   `json:"total"`
+	Created        int                   `json:"created"`
+	Updated        int                   `json:"updated"`
+	Skipped        int                   `json:"skipped"`
+	Failed         int                   `json:"failed"`
+	IgnoredColumns []string              `json:"ignoredColumns,omitempty"`
+	UnmappedFields map[string]int        `json:"unmappedFields,omitempty"` // field -> records containing it
+	Rows           []BookImportRowResult `json:"rows"`
+}
+
+// importRow is a data row or record mapped onto a Book. Columns the row
+// leaves blank are not applied when it updates an existing book.
+type importRow struct {
+	line     int
+	book     Book
+	set      map[string]bool
+	fault    error
+	unmapped []string
+}
+
+// ParseImportMapping reads a mapping of the form "Titel=bookname,Verfasser=bookauthorname".
+func ParseImportMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		header, field, ok := strings.Cut(pair, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || strings.TrimSpace(header) == "" || !isImportField(field) {
+			return nil, fmt.Errorf("invalid column mapping %q", pair)
+		}
+		mapping[strings.TrimSpace(header)] = field
+	}
+	return mapping, nil
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportFormat picks csv or xlsx from an explicit format or a file name.
+func ImportFormat(format, filename string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch format {
+	case "csv", "xlsx":
+		return format, nil
+	case "":
+		return "", errors.New("file format could not be determined; pass format=csv or format=xlsx")
+	}
+	return "", fmt.Errorf("unsupported file format %q; use csv or xlsx", format)
+}
+
+// ReadImportTable reads every row of a CSV file or of the first sheet of an
+// XLSX workbook. The first row is the header.
+func ReadImportTable(r io.Reader, format string) ([][]string, error) {
+	r = io.LimitReader(r, maxImportBytes+1)
+	switch format {
+	case "csv":
+		return readCSVTable(r)
+	case "xlsx":
+		return readXLSXTable(r)
+	}
+	return nil, fmt.Errorf("unsupported file format %q", format)
+}
+
+func readCSVTable(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportBytes {
+		return nil, fmt.Errorf("file is larger than %d MB", maxImportBytes>>20)
+	}
+	// Spreadsheet exports often start with a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = csvDelimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV: %w", err)
+	}
+	return rows, nil
+}
+
+// csvDelimiter guesses the separator from the header line, since exports from
+// European locales use semicolons and some tools write tabs.
+func csvDelimiter(data []byte) rune {
+	line, _ := bufio.NewReader(bytes.NewReader(data)).ReadString('\n')
+	best, count := ',', strings.Count(line, ",")
+	for _, d := range []rune{';', '\t'} {
+		if n := strings.Count(line, string(d)); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+func readXLSXTable(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportBytes {
+		return nil, fmt.Errorf("file is larger than %d MB", maxImportBytes>>20)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("read XLSX: %w", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("workbook has no sheets")
+	}
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, fmt.Errorf("read sheet %q: %w", sheets[0], err)
+	}
+	return rows, nil
+}
+
+// mapImportRows matches the header to Book fields and converts each data row.
+// Blank rows are dropped; rows whose cells cannot be converted carry a fault.
+func mapImportRows(table [][]string, mapping map[string]string) ([]importRow, []string, error) {
+	if len(table) == 0 {
+		return nil, nil, newRequestError(http.StatusBadRequest, "EMPTY_IMPORT", "the file is empty")
+	}
+
+	custom := map[string]string{}
+	for header, field := range mapping {
+		custom[categoryKey(header)] = field
+	}
+	columns := make([]string, len(table[0]))
+	used := map[string]bool{}
+	var ignored []string
+	for i, header := range table[0] {
+		key := categoryKey(header)
+		field, ok := custom[key]
+		if !ok {
+			field, ok = importFields[key]
+		}
+		if !ok || used[field] {
+			if strings.TrimSpace(header) != "" {
+				ignored = append(ignored, header)
+			}
+			continue
+		}
+		columns[i] = field
+		used[field] = true
+	}
+	if !used["bookname"] {
+		return nil, ignored, newRequestError(http.StatusBadRequest, "INVALID_IMPORT", "no column maps to bookname")
+	}
+	if len(table)-1 > maxImportRows {
+		return nil, ignored, newRequestError(http.StatusBadRequest, "INVALID_IMPORT", "an import may hold at most %d rows", maxImportRows)
+	}
+
+	var rows []importRow
+	for n, cells := range table[1:] {
+		row := importRow{line: n + 2, set: map[string]bool{}}
+		blank := true
+		for i, cell := range cells {
+			cell = strings.TrimSpace(cell)
+			if i >= len(columns) || columns[i] == "" || cell == "" {
+				continue
+			}
+			blank = false
+			if err := setImportField(&row.book, columns[i], cell); err != nil && row.fault == nil {
+				row.fault = err
+			}
+			row.set[columns[i]] = true
+		}
+		if !blank {
+			rows = append(rows, row)
+		}
+	}
+	return rows, ignored, nil
+}
+
+func setImportField(book *Book, field, value string) error {
+	switch field {
+	case "bookname":
+		book.BookName = value
+	case "bookauthorname":
+		book.BookAuthorName = value
+	case "typeofbook":
+		book.TypeOfBook = value
+	case "categories":
+		// Several categories are separated by ';' or '|', primary first
+		for _, name := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' }) {
+			if name = strings.TrimSpace(name); name != "" {
+				book.Categories = append(book.Categories, CategoryRef{Name: name})
+			}
+		}
+	case "bookprice":
+		// "12.50", "12.50 EUR" or "EUR 12.50"; a currency column, in
+		// either order, supplies the currency of a bare number
+		price, err := helper.ParseMoney(value, book.BookPrice.Currency())
+		if err != nil || price.Sign() < 0 {
+			return newRequestError(http.StatusBadRequest, "INVALID_BOOK", "bookprice %q is not a valid price", value)
+		}
+		book.BookPrice = price
+	case "bookpricecurrency":
+		price, err := helper.ParseMoney(book.BookPrice.Amount(), value)
+		if err != nil {
+			return newRequestError(http.StatusBadRequest, "INVALID_BOOK", "bookpricecurrency %q is not a currency code", value)
+		}
+		book.BookPrice = price
+	case "bookquantity":
+		quantity, err := strconv.Atoi(value)
+		if err != nil {
+			return newRequestError(http.StatusBadRequest, "INVALID_BOOK", "bookquantity %q is not a whole number", value)
+		}
+		book.BookQuantity = quantity
+	case "isbn", "isbn13":
+		book.ISBN13 = &value
+	case "isbn10":
+		book.ISBN10 = &value
+	}
+	return nil
+}
+
+// ImportBookTable creates the books in table (header first) with the same
+// rules as CreateBook. The error is non-nil only when the file as a whole is
+// unusable; problems with single rows are reported per row.
+func ImportBookTable(db *sql.DB, table [][]string, opts BookImportOptions) (*BookImportReport, error) {
+	if err := checkImportOptions(&opts); err != nil {
+		return nil, err
+	}
+	rows, ignored, err := mapImportRows(table, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	report, err := importBookRows(db, rows, opts)
+	if err != nil {
+		return nil, err
+	}
+	report.IgnoredColumns = ignored
+	return report, nil
+}
+
+func checkImportOptions(opts *BookImportOptions) error {
+	switch opts.OnDuplicate {
+	case "":
+		opts.OnDuplicate = OnDuplicateError
+	case OnDuplicateError, OnDuplicateSkip, OnDuplicateUpdate:
+	default:
+		return newRequestError(http.StatusBadRequest, "INVALID_IMPORT", "onDuplicate must be error, skip or update")
+	}
+	return nil
+}
+
+// importBookRows imports mapped rows from any source format. Each row is
+// committed on its own so one bad row does not undo the rest; a dry run runs
+// every row in one transaction and rolls it back.
+func importBookRows(db *sql.DB, rows []importRow, opts BookImportOptions) (*BookImportReport, error) {
+	report := &BookImportReport{
+		DryRun:      opts.DryRun,
+		OnDuplicate: opts.OnDuplicate,
+		Rows:        make([]BookImportRowResult, 0, len(rows)),
+	}
+
+	var dryRunTx *sql.Tx
+	var err error
+	if opts.DryRun {
+		if dryRunTx, err = db.Begin(); err != nil {
+			return nil, fmt.Errorf("begin dry run: %w", err)
+		}
+		defer dryRunTx.Rollback()
+	}
+
+	for _, row := range rows {
+		var result BookImportRowResult
+		if opts.DryRun {
+			result, err = importBookRowSavepoint(dryRunTx, row, opts.OnDuplicate)
+		} else {
+			result, err = importBookRowTx(db, row, opts.OnDuplicate)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opts.DryRun && result.Status == ImportCreated {
+			result.BookID = 0
+		}
+		result.Unmapped = row.unmapped
+
+		report.Total++
+		switch result.Status {
+		case ImportCreated:
+			report.Created++
+		case ImportUpdated:
+			report.Updated++
+		case ImportSkipped:
+			report.Skipped++
+		case ImportFailed:
+			report.Failed++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	return report, nil
+}
+
+// importBookRowTx imports one row in its own transaction, committing unless
+// the row failed.
+func importBookRowTx(db *sql.DB, row importRow, onDuplicate string) (BookImportRowResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return BookImportRowResult{}, fmt.Errorf("begin row %d: %w", row.line, err)
+	}
+	defer tx.Rollback()
+
+	result, err := importBookRow(tx, row, onDuplicate)
+	if err != nil || result.Status == ImportFailed {
+		return result, err
+	}
+	if err := tx.Commit(); err != nil {
+		return BookImportRowResult{}, fmt.Errorf("commit row %d: %w", row.line, err)
+	}
+	return result, nil
+}
+
+// importBookRowSavepoint imports one row inside the dry-run transaction,
+// undoing a failed row's partial work so later rows see a consistent state.
+func importBookRowSavepoint(tx *sql.Tx, row importRow, onDuplicate string) (BookImportRowResult, error) {
+	if _, err := tx.Exec("SAVE TRANSACTION import_row"); err != nil {
+		return BookImportRowResult{}, fmt.Errorf("savepoint for row %d: %w", row.line, err)
+	}
+	result, err := importBookRow(tx, row, onDuplicate)
+	if err != nil || result.Status != ImportFailed {
+		return result, err
+	}
+	if _, err := tx.Exec("ROLLBACK TRANSACTION import_row"); err != nil {
+		return BookImportRowResult{}, fmt.Errorf("undo row %d: %w", row.line, err)
+	}
+	return result, nil
+}
+
+// importBookRow validates and creates one book, applying the duplicate
+// strategy when it already exists. Failures of the row itself are reported
+// in the result; the error is reserved for database faults.
+func importBookRow(tx *sql.Tx, row importRow, onDuplicate string) (BookImportRowResult, error) {
+	book := row.book
+	result := BookImportRowResult{Row: row.line, BookName: book.BookName}
+	fail := func(err error) (BookImportRowResult, error) {
+		re, ok := err.(*requestError)
+		if !ok {
+			return result, err
+		}
+		result.Status, result.Code, result.Error = ImportFailed, re.Code, re.Message
+		return result, nil
+	}
+
+	if row.fault != nil {
+		return fail(row.fault)
+	}
+	if err := validateNewBook(&book); err != nil {
+		return fail(err)
+	}
+
+	// A duplicate is only found once insertBook has resolved the authors, so
+	// roll back to here before handling it
+	if _, err := tx.Exec("SAVE TRANSACTION import_insert"); err != nil {
+		return result, fmt.Errorf("savepoint for row %d: %w", row.line, err)
+	}
+	id, err := insertBook(tx, &book)
+	if err == nil {
+		result.Status, result.BookID = ImportCreated, id
+		return result, nil
+	}
+	re, ok := err.(*requestError)
+	if !ok || re.Code != "DUPLICATE_BOOK" {
+		return fail(err)
+	}
+	if _, err := tx.Exec("ROLLBACK TRANSACTION import_insert"); err != nil {
+		return result, fmt.Errorf("undo row %d: %w", row.line, err)
+	}
+
+	existingID, _ := re.Details["existingID"].(int)
+	switch onDuplicate {
+	case OnDuplicateSkip:
+		result.Status, result.BookID = ImportSkipped, existingID
+		return result, nil
+	case OnDuplicateUpdate:
+		if err := updateImportedBook(tx, existingID, &book, row.set); err != nil {
+			return fail(err)
+		}
+		result.Status, result.BookID = ImportUpdated, existingID
+		return result, nil
+	}
+	result.BookID = existingID
+	return fail(re)
+}
+
+// updateImportedBook applies an import row to the book it duplicates. Only
+// columns present in the row are applied; bookquantity adds copies until the
+// book holds that many (withdrawn copies aside), so re-running a file does
+// not multiply its copies.
+func updateImportedBook(q queryer, bookID int, book *Book, set map[string]bool) error {
+	if set["bookprice"] {
+		if _, err := recordBookPrice(q, bookID, book.BookPrice, time.Now().UTC(), "import"); err != nil {
+			return fmt.Errorf("update price of book %d: %w", bookID, err)
+		}
+	}
+
+	if book.ISBN13 != nil {
+		var otherID int
+		err := q.QueryRow("SELECT BookID FROM Book WHERE isbn13 = ? AND BookID <> ?", *book.ISBN13, bookID).Scan(&otherID)
+		if err == nil {
+			e := newRequestError(http.StatusConflict, "DUPLICATE_BOOK", "ISBN %s belongs to book %d", *book.ISBN13, otherID)
+			e.Details = gin.H{"existingID": otherID}
+			return e
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("check for existing ISBN: %w", err)
+		}
+		if _, err := q.Exec("UPDATE Book SET isbn10 = ?, isbn13 = ? WHERE BookID = ?", book.ISBN10, book.ISBN13, bookID); err != nil {
+			return fmt.Errorf("update ISBN of book %d: %w", bookID, err)
+		}
+	}
+
+	if set["categories"] {
+		ids, err := resolveBookCategories(q, book)
+		if err != nil {
+			return err
+		}
+		if err := setBookCategories(q, bookID, ids); err != nil {
+			return err
+		}
+	} else if set["typeofbook"] {
+		if err := setPrimaryBookCategory(q, bookID, book.TypeOfBook); err != nil {
+			return err
+		}
+	}
+
+	if set["bookquantity"] {
+		var held, numbered int
+		err := q.QueryRow("SELECT COUNT(CASE WHEN Status <> ? THEN 1 END), COUNT(*) FROM BookCopy WHERE BookID = ?",
+			CopyWithdrawn, bookID).Scan(&held, &numbered)
+		if err != nil {
+			return fmt.Errorf("count copies of book %d: %w", bookID, err)
+		}
+		for n := 1; n <= book.BookQuantity-held; n++ {
+			item := BookCopy{BookID: bookID, Barcode: generatedBarcode(bookID, numbered+n)}
+			if err := insertCopy(q, &item); err != nil {
+				return err
+			}
+		}
+		if err := syncBookInventory(q, bookID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportBooks accepts a CSV or XLSX file in the multipart field "file" and
+// imports it as books. Query or form parameters: format (csv|xlsx, default
+// from the file name), dryRun, onDuplicate (error|skip|update) and map
+// ("Header=field,...").
+func ImportBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the database connection
+		db := database.Database()
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes+1<<20)
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			log.Printf("Invalid import upload: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the file to import in the multipart field \"file\""})
+			return
+		}
+		defer file.Close()
+
+		format, err := ImportFormat(c.Request.FormValue("format"), header.Filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := BookImportOptions{OnDuplicate: strings.ToLower(c.Request.FormValue("onDuplicate"))}
+		if v := c.Request.FormValue("dryRun"); v != "" {
+			if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dryRun must be true or false"})
+				return
+			}
+		}
+		if v := c.Request.FormValue("map"); v != "" {
+			if opts.Mapping, err = ParseImportMapping(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		table, err := ReadImportTable(file, format)
+		if err != nil {
+			log.Printf("Failed to read import file: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		report, err := ImportBookTable(db, table, opts)
+		if err != nil {
+			log.Printf("Failed to import books: %v", err)
+			respondRequestError(c, err, "Unable to import books. Please try again later.")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": report})
+	}
+}
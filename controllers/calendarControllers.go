@@ -0,0 +1,478 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Calendar limits. A date is rolled forward at most calendarScanDays days;
+// a calendar with no open day in that window leaves dates where they are.
+// Recurring iCal events are expanded icalRecurrenceYears years ahead.
+const (
+	calendarScanDays    = 366
+	maxClosureDays      = 366
+	maxICalBytes        = 1 << 20
+	icalRecurrenceYears = 2
+	closureSourceICal   = "ical"
+	closureSourceStaff  = "manual"
+)
+
+// OpeningHours are a weekday's hours, HH:MM in library local time.
+// Weekday 0 is Sunday.
+type OpeningHours struct {
+	Weekday int    `json:"Weekday"`
+	Opens   string `json:"Opens"`
+	Closes  string `json:"Closes"`
+}
+
+// Closure is one date the library is closed.
+type Closure struct {
+	ClosureID   int     `json:"ClosureID"`
+	ClosedOn    string  `json:"ClosedOn"` // YYYY-MM-DD
+	Reason      string  `json:"Reason"`
+	Source      string  `json:"Source"` // manual or ical
+	ExternalUID *string `json:"ExternalUID"`
+}
+
+// ClosureInput is the body of POST /calendar/closures: a date, or a range
+// of dates when To is set.
+type ClosureInput struct {
+	From   string `json:"From"`
+	To     string `json:"To"`
+	Reason string `json:"Reason"`
+}
+
+// libraryCalendar is the weekly schedule and the closures within a window.
+type libraryCalendar struct {
+	open   [7]bool
+	closed map[string]bool
+}
+
+// loadCalendar reads the opening days and the closures between from and to.
+func loadCalendar(q queryer, from, to time.Time) (*libraryCalendar, error) {
+	cal := &libraryCalendar{closed: map[string]bool{}}
+	rows, err := q.Query("SELECT Weekday FROM OpeningHours")
+	if err != nil {
+		return nil, fmt.Errorf("load opening hours: %w", err)
+	}
+	for rows.Next() {
+		var day int
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan opening hours: %w", err)
+		}
+		if day >= 0 && day < 7 {
+			cal.open[day] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load opening hours: %w", err)
+	}
+
+	rows, err = q.Query("SELECT ClosedOn FROM LibraryClosure WHERE ClosedOn BETWEEN ? AND ?", from, to)
+	if err != nil {
+		return nil, fmt.Errorf("load closures: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return nil, fmt.Errorf("scan closure: %w", err)
+		}
+		cal.closed[day.Format("2006-01-02")] = true
+	}
+	return cal, rows.Err()
+}
+
+func (cal *libraryCalendar) isOpen(day time.Time) bool {
+	return cal.open[day.Weekday()] && !cal.closed[day.Format("2006-01-02")]
+}
+
+// truncateDay drops the time of day.
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// nextOpenDay returns day if the library is open then, or else the first
+// open day after it.
+func nextOpenDay(q queryer, day time.Time) (time.Time, error) {
+	day = truncateDay(day)
+	cal, err := loadCalendar(q, day, day.AddDate(0, 0, calendarScanDays))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for d := day; d.Before(day.AddDate(0, 0, calendarScanDays)); d = d.AddDate(0, 0, 1) {
+		if cal.isOpen(d) {
+			return d, nil
+		}
+	}
+	return day, nil
+}
+
+// closedDaysBetween counts the closed days after from, up to and including
+// to.
+func closedDaysBetween(q queryer, from, to time.Time) (int, error) {
+	from, to = truncateDay(from), truncateDay(to)
+	if !to.After(from) {
+		return 0, nil
+	}
+	cal, err := loadCalendar(q, from, to)
+	if err != nil {
+		return 0, err
+	}
+	closed := 0
+	for d := from.AddDate(0, 0, 1); !d.After(to); d = d.AddDate(0, 0, 1) {
+		if !cal.isOpen(d) {
+			closed++
+		}
+	}
+	return closed, nil
+}
+
+// parseClock reads an HH:MM time of day.
+func parseClock(v string) (time.Time, error) {
+	return time.Parse("15:04", strings.TrimSpace(v))
+}
+
+// GetOpeningHours lists the weekly schedule, Sunday first.
+func GetOpeningHours() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		rows, err := db.Query("SELECT Weekday, CONVERT(VARCHAR(5), Opens, 108), CONVERT(VARCHAR(5), Closes, 108) FROM OpeningHours ORDER BY Weekday")
+		if err != nil {
+			log.Printf("get opening hours: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get opening hours"})
+			return
+		}
+		defer rows.Close()
+		hours := []OpeningHours{}
+		for rows.Next() {
+			var h OpeningHours
+			if err := rows.Scan(&h.Weekday, &h.Opens, &h.Closes); err != nil {
+				log.Printf("scan opening hours: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get opening hours"})
+				return
+			}
+			hours = append(hours, h)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get opening hours: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get opening hours"})
+			return
+		}
+		c.JSON(http.StatusOK, hours)
+	}
+}
+
+// SetOpeningHours replaces the weekly schedule. Weekdays left out are closed;
+// at least one day must be open.
+func SetOpeningHours() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		var hours []OpeningHours
+		if err := c.ShouldBindJSON(&hours); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if len(hours) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one weekday must be open"})
+			return
+		}
+		seen := map[int]bool{}
+		for i, h := range hours {
+			if h.Weekday < 0 || h.Weekday > 6 || seen[h.Weekday] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("entry %d: Weekday must be 0 (Sunday) to 6 and appear once", i)})
+				return
+			}
+			seen[h.Weekday] = true
+			opens, err1 := parseClock(h.Opens)
+			closes, err2 := parseClock(h.Closes)
+			if err1 != nil || err2 != nil || !closes.After(opens) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("entry %d: Opens and Closes must be HH:MM with Closes later", i)})
+				return
+			}
+			hours[i].Opens, hours[i].Closes = opens.Format("15:04"), closes.Format("15:04")
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin opening hours: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set opening hours"})
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("DELETE FROM OpeningHours"); err != nil {
+			log.Printf("clear opening hours: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set opening hours"})
+			return
+		}
+		for _, h := range hours {
+			if _, err := tx.Exec("INSERT INTO OpeningHours (Weekday, Opens, Closes) VALUES (?, ?, ?)", h.Weekday, h.Opens, h.Closes); err != nil {
+				log.Printf("insert opening hours: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set opening hours"})
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit opening hours: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set opening hours"})
+			return
+		}
+		c.JSON(http.StatusOK, hours)
+	}
+}
+
+// GetClosures lists closures, optionally between ?from= and ?to= (YYYY-MM-DD).
+func GetClosures() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		f := &sqlFilter{}
+		params := c.Request.URL.Query()
+		if err := dateFilter(f, params, "from", "ClosedOn", ">="); err != nil {
+			respondRequestError(c, err, "failed to get closures")
+			return
+		}
+		if err := dateFilter(f, params, "to", "ClosedOn", "<="); err != nil {
+			respondRequestError(c, err, "failed to get closures")
+			return
+		}
+		rows, err := db.Query("SELECT ClosureID, ClosedOn, Reason, Source, ExternalUID FROM LibraryClosure"+f.where()+" ORDER BY ClosedOn", f.args...)
+		if err != nil {
+			log.Printf("get closures: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get closures"})
+			return
+		}
+		defer rows.Close()
+		closures := []Closure{}
+		for rows.Next() {
+			var cl Closure
+			var day time.Time
+			var uid sql.NullString
+			if err := rows.Scan(&cl.ClosureID, &day, &cl.Reason, &cl.Source, &uid); err != nil {
+				log.Printf("scan closure: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get closures"})
+				return
+			}
+			cl.ClosedOn = day.Format("2006-01-02")
+			if uid.Valid {
+				cl.ExternalUID = &uid.String
+			}
+			closures = append(closures, cl)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get closures: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get closures"})
+			return
+		}
+		c.JSON(http.StatusOK, closures)
+	}
+}
+
+// addClosures closes every day in [from, to), skipping days that are
+// already closed, and returns the closures added.
+func addClosures(q queryer, from, to time.Time, reason, source string, uid *string) ([]Closure, error) {
+	var added []Closure
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		cl := Closure{ClosedOn: d.Format("2006-01-02"), Reason: reason, Source: source, ExternalUID: uid}
+		err := q.QueryRow(`
+			INSERT INTO LibraryClosure (ClosedOn, Reason, Source, ExternalUID)
+			OUTPUT inserted.ClosureID
+			SELECT ?, ?, ?, ?
+			WHERE NOT EXISTS (SELECT 1 FROM LibraryClosure WHERE ClosedOn = ?)`, d, reason, source, uid, d).Scan(&cl.ClosureID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("add closure on %s: %w", cl.ClosedOn, err)
+		}
+		added = append(added, cl)
+	}
+	return added, nil
+}
+
+// CreateClosure closes the library on a date or, with To, on every date from
+// From to To inclusive. Dates already closed are left as they are.
+func CreateClosure() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		var input ClosureInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		input.Reason = strings.TrimSpace(input.Reason)
+		if input.Reason == "" || len(input.Reason) > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required (max 200 characters)"})
+			return
+		}
+		from, err := time.Parse("2006-01-02", input.From)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "From must be in YYYY-MM-DD format"})
+			return
+		}
+		to := from
+		if input.To != "" {
+			if to, err = time.Parse("2006-01-02", input.To); err != nil || to.Before(from) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "To must be a YYYY-MM-DD date on or after From"})
+				return
+			}
+		}
+		if days := int(to.Sub(from).Hours()/24) + 1; days > maxClosureDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a closure can span at most %d days", maxClosureDays)})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin closure: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add closure"})
+			return
+		}
+		defer tx.Rollback()
+		added, err := addClosures(tx, from, to.AddDate(0, 0, 1), input.Reason, closureSourceStaff, nil)
+		if err != nil {
+			log.Printf("add closure: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add closure"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit closure: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add closure"})
+			return
+		}
+		if added == nil {
+			added = []Closure{}
+		}
+		c.JSON(http.StatusCreated, added)
+	}
+}
+
+// DeleteClosure reopens the library on a closure's date. Due dates already
+// rolled past it stay as they are.
+func DeleteClosure() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		closureID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || closureID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid closure_id"})
+			return
+		}
+		res, err := db.Exec("DELETE FROM LibraryClosure WHERE ClosureID = ?", closureID)
+		if err != nil {
+			log.Printf("delete closure %d: %v", closureID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete closure"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "closure not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "closure deleted"})
+	}
+}
+
+// ImportClosures reads public holidays from an iCalendar file in the
+// multipart field "file" and closes the library on every day they cover.
+// With replace=true the closures of earlier imports are dropped first.
+// Recurring events are expanded into their occurrences from today up to
+// icalRecurrenceYears ahead and are listed under "recurring"; a file with a
+// recurrence rule that cannot be expanded is rejected.
+func ImportClosures() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxICalBytes+1<<16)
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the calendar in the multipart field \"file\""})
+			return
+		}
+		defer file.Close()
+		replace := false
+		if v := c.Request.FormValue("replace"); v != "" {
+			if replace, err = strconv.ParseBool(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "replace must be true or false"})
+				return
+			}
+		}
+		events, err := helper.ParseICal(io.LimitReader(file, maxICalBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		horizon := today.AddDate(icalRecurrenceYears, 0, 0)
+		var occurrences []helper.ICalEvent
+		recurring := []string{}
+		for _, ev := range events {
+			occs, err := ev.Occurrences(today, horizon)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("event %q: %v", ev.Summary, err)})
+				return
+			}
+			if ev.RRule != "" {
+				recurring = append(recurring, ev.Summary)
+			}
+			occurrences = append(occurrences, occs...)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin closure import: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import closures"})
+			return
+		}
+		defer tx.Rollback()
+		if replace {
+			if _, err := tx.Exec("DELETE FROM LibraryClosure WHERE Source = ?", closureSourceICal); err != nil {
+				log.Printf("clear imported closures: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import closures"})
+				return
+			}
+		}
+
+		added := []Closure{}
+		skipped := 0
+		for _, ev := range occurrences {
+			end := ev.End
+			if days := int(end.Sub(ev.Start).Hours() / 24); days > maxClosureDays {
+				end = ev.Start.AddDate(0, 0, maxClosureDays)
+			}
+			reason := ev.Summary
+			if reason == "" {
+				reason = "Holiday"
+			} else if r := []rune(reason); len(r) > 200 {
+				reason = string(r[:200])
+			}
+			var uid *string
+			if ev.UID != "" && len(ev.UID) <= 255 {
+				uid = &ev.UID
+			}
+			closures, err := addClosures(tx, ev.Start, end, reason, closureSourceICal, uid)
+			if err != nil {
+				log.Printf("import closure %q: %v", ev.Summary, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import closures"})
+				return
+			}
+			skipped += int(end.Sub(ev.Start).Hours()/24) - len(closures)
+			added = append(added, closures...)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit closure import: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import closures"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"events": len(events), "added": added, "skipped": skipped, "recurring": recurring})
+	}
+}
@@ -0,0 +1,870 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Category is a node in the subject/genre tree
+type Category struct {
+	CategoryID int         `json:"categoryid"`
+	ParentID   *int        `json:"parentid"`
+	Name       string      `json:"name"`
+	Slug       string      `json:"slug"`
+	Path       string      `json:"path"` // e.g. "Fiction > Fantasy > Epic Fantasy"
+	Aliases    []string    `json:"aliases,omitempty"`
+	Children   []*Category `json:"children,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// CategoryRef is the short form of a category embedded in book responses
+type CategoryRef struct {
+	CategoryID int    `json:"categoryid"`
+	Name       string `json:"name"`
+}
+
+// categoryKey folds a genre label to the form stored in CategoryAlias:
+// lower-case letters and digits only, so "Sci-Fi" and "SciFi" match.
+func categoryKey(label string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(label) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// slugify builds a URL-safe slug from a category name.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// categorySubtreeCTE selects the category bound to its parameter and all of
+// its descendants as Tree(CategoryID). Callers append the final SELECT.
+const categorySubtreeCTE = `
+	WITH Tree AS (
+		SELECT CategoryID FROM Category WHERE CategoryID = ?
+		UNION ALL
+		SELECT c.CategoryID FROM Category c JOIN Tree t ON c.ParentID = t.CategoryID
+	)`
+
+// loadCategories reads the whole tree, returning every node by ID and the
+// top-level nodes sorted by name. Paths and children are filled in.
+func loadCategories(q queryer) (map[int]*Category, []*Category, error) {
+	rows, err := q.Query("SELECT CategoryID, ParentID, Name, Slug, Created_at, Updated_at FROM Category")
+	if err != nil {
+		return nil, nil, fmt.Errorf("load categories: %w", err)
+	}
+	defer rows.Close()
+
+	byID := map[int]*Category{}
+	for rows.Next() {
+		var cat Category
+		var parentID sql.NullInt64
+		if err := rows.Scan(&cat.CategoryID, &parentID, &cat.Name, &cat.Slug, &cat.CreatedAt, &cat.UpdatedAt); err != nil {
+			return nil, nil, fmt.Errorf("scan category: %w", err)
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			cat.ParentID = &id
+		}
+		byID[cat.CategoryID] = &cat
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("load categories: %w", err)
+	}
+
+	var roots []*Category
+	for _, cat := range byID {
+		if cat.ParentID == nil {
+			roots = append(roots, cat)
+		} else if parent, ok := byID[*cat.ParentID]; ok {
+			parent.Children = append(parent.Children, cat)
+		}
+	}
+	var walk func(nodes []*Category, prefix string)
+	walk = func(nodes []*Category, prefix string) {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		for _, n := range nodes {
+			n.Path = prefix + n.Name
+			walk(n.Children, n.Path+" > ")
+		}
+	}
+	walk(roots, "")
+	return byID, roots, nil
+}
+
+// resolveCategory finds the category a free-text label refers to by alias,
+// slug or exact name. ok is false when nothing matches.
+func resolveCategory(q queryer, label string) (id int, ok bool, err error) {
+	key := categoryKey(label)
+	if key == "" {
+		return 0, false, nil
+	}
+	err = q.QueryRow(`
+		SELECT TOP (1) CategoryID FROM (
+			SELECT CategoryID, 1 AS Rank FROM CategoryAlias WHERE AliasKey = ?
+			UNION ALL
+			SELECT CategoryID, 2 FROM Category WHERE Slug = ?
+			UNION ALL
+			SELECT CategoryID, 3 FROM Category WHERE Name = ?
+		) m ORDER BY Rank, CategoryID`, key, slugify(label), strings.TrimSpace(label)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("resolve category %q: %w", label, err)
+	}
+	return id, true, nil
+}
+
+// findOrCreateCategory resolves label, creating a top-level category (and its
+// alias) when it is new.
+func findOrCreateCategory(q queryer, label string) (int, error) {
+	id, ok, err := resolveCategory(q, label)
+	if err != nil || ok {
+		return id, err
+	}
+	key := categoryKey(label)
+	if key == "" {
+		return 0, newRequestError(http.StatusBadRequest, "INVALID_CATEGORY", "category name cannot be empty")
+	}
+
+	const ins = `
+		INSERT INTO Category (ParentID, Name, Slug)
+		VALUES (NULL, ?, ?);
+		SELECT SCOPE_IDENTITY() AS CategoryID;`
+	if err := q.QueryRow(ins, strings.TrimSpace(label), slugify(label)).Scan(&id); err != nil {
+		return 0, fmt.Errorf("insert category %q: %w", label, err)
+	}
+	if _, err := q.Exec("INSERT INTO CategoryAlias (AliasKey, CategoryID) VALUES (?, ?)", key, id); err != nil {
+		return 0, fmt.Errorf("insert alias for category %q: %w", label, err)
+	}
+	return id, nil
+}
+
+// setBookCategories replaces a book's category assignments. The first
+// category is primary and its name is mirrored into Book.typeOfBook.
+func setBookCategories(q queryer, bookID int, categoryIDs []int) error {
+	if _, err := q.Exec("DELETE FROM BookCategory WHERE BookID = ?", bookID); err != nil {
+		return fmt.Errorf("clear categories of book %d: %w", bookID, err)
+	}
+	seen := map[int]bool{}
+	position := 0
+	for _, id := range categoryIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		var exists int
+		if err := q.QueryRow("SELECT COUNT(1) FROM Category WHERE CategoryID = ?", id).Scan(&exists); err != nil {
+			return fmt.Errorf("check category %d: %w", id, err)
+		}
+		if exists == 0 {
+			return newRequestError(http.StatusBadRequest, "CATEGORY_NOT_FOUND", "category %d not found", id)
+		}
+		position++
+		if _, err := q.Exec("INSERT INTO BookCategory (BookID, CategoryID, Position) VALUES (?, ?, ?)", bookID, id, position); err != nil {
+			return fmt.Errorf("assign category %d to book %d: %w", id, bookID, err)
+		}
+	}
+	return syncBookType(q, bookID)
+}
+
+// syncBookType mirrors the primary category's name into Book.typeOfBook.
+func syncBookType(q queryer, bookID int) error {
+	const stmt = `
+		UPDATE b SET typeOfBook = c.Name
+		FROM Book b
+		JOIN BookCategory bc ON bc.BookID = b.BookID
+		JOIN Category c ON c.CategoryID = bc.CategoryID
+		WHERE b.BookID = ?
+		  AND bc.Position = (SELECT MIN(Position) FROM BookCategory WHERE BookID = ?)`
+	if _, err := q.Exec(stmt, bookID, bookID); err != nil {
+		return fmt.Errorf("sync type of book %d: %w", bookID, err)
+	}
+	return nil
+}
+
+// setPrimaryBookCategory files a book first under the category label resolves
+// to (creating it if new), keeping its other categories after it.
+func setPrimaryBookCategory(q queryer, bookID int, label string) error {
+	primary, err := findOrCreateCategory(q, label)
+	if err != nil {
+		return err
+	}
+	rows, err := q.Query("SELECT CategoryID FROM BookCategory WHERE BookID = ? ORDER BY Position", bookID)
+	if err != nil {
+		return fmt.Errorf("load categories of book %d: %w", bookID, err)
+	}
+	ids := []int{primary}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan category of book %d: %w", bookID, err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load categories of book %d: %w", bookID, err)
+	}
+	return setBookCategories(q, bookID, ids)
+}
+
+// attachCategories loads the categories of every book.
+func attachCategories(q queryer, books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(books))
+	for i := range books {
+		index[books[i].BookID] = i
+		books[i].Categories = []CategoryRef{}
+	}
+
+	err := queryBookBatches(q, books, `
+		SELECT bc.BookID, c.CategoryID, c.Name
+		FROM BookCategory bc
+		JOIN Category c ON c.CategoryID = bc.CategoryID
+		WHERE bc.BookID IN (%s)
+		ORDER BY bc.BookID, bc.Position`, nil, func(rows *sql.Rows) error {
+		var bookID int
+		var ref CategoryRef
+		if err := rows.Scan(&bookID, &ref.CategoryID, &ref.Name); err != nil {
+			return err
+		}
+		if i, ok := index[bookID]; ok {
+			books[i].Categories = append(books[i].Categories, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load book categories: %w", err)
+	}
+	return nil
+}
+
+// CreateCategory adds a category, optionally under a parent
+func CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		var input struct {
+			Name     string   `json:"name"`
+			ParentID *int     `json:"parentid"`
+			Slug     string   `json:"slug"`
+			Aliases  []string `json:"aliases"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+
+		input.Name = strings.TrimSpace(input.Name)
+		if input.Name == "" || len(input.Name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required (max 100 characters)"})
+			return
+		}
+		slug := slugify(input.Slug)
+		if slug == "" {
+			slug = slugify(input.Name)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create category"})
+			return
+		}
+		defer tx.Rollback()
+
+		if input.ParentID != nil {
+			var exists int
+			if err := tx.QueryRow("SELECT COUNT(1) FROM Category WHERE CategoryID = ?", *input.ParentID).Scan(&exists); err != nil || exists == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "parent category not found"})
+				return
+			}
+		}
+
+		// The slug and every alias must be free; otherwise this is a duplicate
+		var existingID int
+		err = tx.QueryRow("SELECT CategoryID FROM Category WHERE Slug = ?", slug).Scan(&existingID)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "a category with this slug already exists", "existingID": existingID})
+			return
+		} else if err != sql.ErrNoRows {
+			log.Printf("check category slug %s: %v", slug, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create category"})
+			return
+		}
+
+		const ins = `
+			INSERT INTO Category (ParentID, Name, Slug)
+			VALUES (?, ?, ?);
+			SELECT SCOPE_IDENTITY() AS CategoryID;`
+		var id int
+		if err := tx.QueryRow(ins, input.ParentID, input.Name, slug).Scan(&id); err != nil {
+			log.Printf("insert category %s: %v", input.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create category"})
+			return
+		}
+
+		for _, alias := range append([]string{input.Name}, input.Aliases...) {
+			if err := addCategoryAlias(tx, id, alias); err != nil {
+				log.Printf("add alias %s: %v", alias, err)
+				respondRequestError(c, err, "failed to create category")
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit category: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create category"})
+			return
+		}
+
+		respondCategory(c, db, id, http.StatusCreated)
+	}
+}
+
+// addCategoryAlias records another label for a category. Re-adding an alias
+// the category already has is a no-op; taking one from another category is not.
+func addCategoryAlias(q queryer, categoryID int, alias string) error {
+	key := categoryKey(alias)
+	if key == "" {
+		return nil
+	}
+	var owner int
+	err := q.QueryRow("SELECT CategoryID FROM CategoryAlias WHERE AliasKey = ?", key).Scan(&owner)
+	if err == nil {
+		if owner == categoryID {
+			return nil
+		}
+		e := newRequestError(http.StatusConflict, "ALIAS_IN_USE", "alias %q already belongs to another category", alias)
+		e.Details = gin.H{"existingID": owner}
+		return e
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("check alias %q: %w", alias, err)
+	}
+	if _, err := q.Exec("INSERT INTO CategoryAlias (AliasKey, CategoryID) VALUES (?, ?)", key, categoryID); err != nil {
+		return fmt.Errorf("insert alias %q: %w", alias, err)
+	}
+	return nil
+}
+
+// respondCategory writes one category with its path, aliases and children.
+func respondCategory(c *gin.Context, q queryer, id int, status int) {
+	byID, _, err := loadCategories(q)
+	if err != nil {
+		log.Printf("load category %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve category"})
+		return
+	}
+	cat, ok := byID[id]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+		return
+	}
+
+	rows, err := q.Query("SELECT AliasKey FROM CategoryAlias WHERE CategoryID = ? ORDER BY AliasKey", id)
+	if err != nil {
+		log.Printf("load aliases of category %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve category"})
+		return
+	}
+	defer rows.Close()
+	cat.Aliases = []string{}
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			log.Printf("scan alias: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve category"})
+			return
+		}
+		cat.Aliases = append(cat.Aliases, alias)
+	}
+
+	c.JSON(status, cat)
+}
+
+// GetCategories returns the category tree, or a flat list with ?flat=true
+func GetCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		byID, roots, err := loadCategories(db)
+		if err != nil {
+			log.Printf("get categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get categories"})
+			return
+		}
+
+		if flat, _ := strconv.ParseBool(c.Query("flat")); flat {
+			list := make([]Category, 0, len(byID))
+			for _, cat := range byID {
+				entry := *cat
+				entry.Children = nil
+				list = append(list, entry)
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+			c.JSON(http.StatusOK, list)
+			return
+		}
+
+		if roots == nil {
+			roots = []*Category{}
+		}
+		c.JSON(http.StatusOK, roots)
+	}
+}
+
+// GetCategoryByID retrieves a category with its path, aliases and children
+func GetCategoryByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		categoryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || categoryID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+
+		respondCategory(c, db, categoryID, http.StatusOK)
+	}
+}
+
+// UpdateCategory renames a category or moves it under another parent
+func UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		categoryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || categoryID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+
+		var input struct {
+			Name     *string `json:"name"`
+			ParentID *int    `json:"parentid"` // 0 moves the category to the top level
+			Slug     *string `json:"slug"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update category"})
+			return
+		}
+		defer tx.Rollback()
+
+		byID, _, err := loadCategories(tx)
+		if err != nil {
+			log.Printf("update category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update category"})
+			return
+		}
+		if _, ok := byID[categoryID]; !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+			return
+		}
+
+		// Build dynamic query
+		var setClauses []string
+		var args []interface{}
+		if input.Name != nil {
+			name := strings.TrimSpace(*input.Name)
+			if name == "" || len(name) > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "name is required (max 100 characters)"})
+				return
+			}
+			setClauses = append(setClauses, "Name = ?")
+			args = append(args, name)
+		}
+		if input.Slug != nil {
+			slug := slugify(*input.Slug)
+			if slug == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "slug cannot be empty"})
+				return
+			}
+			setClauses = append(setClauses, "Slug = ?")
+			args = append(args, slug)
+		}
+		if input.ParentID != nil {
+			if *input.ParentID == 0 {
+				setClauses = append(setClauses, "ParentID = NULL")
+			} else {
+				parent, ok := byID[*input.ParentID]
+				if !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "parent category not found"})
+					return
+				}
+				// Walking up from the new parent must never reach this category
+				for p := parent; p != nil; {
+					if p.CategoryID == categoryID {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "a category cannot be moved under itself or its descendants"})
+						return
+					}
+					if p.ParentID == nil {
+						break
+					}
+					p = byID[*p.ParentID]
+				}
+				setClauses = append(setClauses, "ParentID = ?")
+				args = append(args, *input.ParentID)
+			}
+		}
+		if len(setClauses) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no valid fields provided for update"})
+			return
+		}
+		setClauses = append(setClauses, "Updated_at = ?")
+		args = append(args, time.Now())
+
+		query := "UPDATE Category SET " + strings.Join(setClauses, ", ") + " WHERE CategoryID = ?"
+		if _, err := tx.Exec(query, append(args, categoryID)...); err != nil {
+			log.Printf("update category %d: %v", categoryID, err)
+			if strings.Contains(err.Error(), "UX_Category_Slug") {
+				c.JSON(http.StatusConflict, gin.H{"error": "a category with this slug already exists"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update category"})
+			}
+			return
+		}
+
+		// Books whose primary category was renamed carry the new name
+		if input.Name != nil {
+			if _, err := tx.Exec(`
+				UPDATE b SET typeOfBook = ?
+				FROM Book b
+				JOIN BookCategory bc ON bc.BookID = b.BookID AND bc.CategoryID = ?
+				WHERE bc.Position = (SELECT MIN(Position) FROM BookCategory WHERE BookID = b.BookID)`,
+				strings.TrimSpace(*input.Name), categoryID); err != nil {
+				log.Printf("update category %d: %v", categoryID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update category"})
+				return
+			}
+			if err := addCategoryAlias(tx, categoryID, *input.Name); err != nil {
+				log.Printf("update category %d: %v", categoryID, err)
+				respondRequestError(c, err, "failed to update category")
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update category"})
+			return
+		}
+
+		respondCategory(c, db, categoryID, http.StatusOK)
+	}
+}
+
+// DeleteCategory removes a category that has no children and no books
+func DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		categoryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || categoryID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+
+		var children, books int
+		err = db.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM Category WHERE ParentID = ?),
+			       (SELECT COUNT(*) FROM BookCategory WHERE CategoryID = ?)`, categoryID, categoryID).Scan(&children, &books)
+		if err != nil {
+			log.Printf("check category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete category"})
+			return
+		}
+		if children > 0 || books > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "category still has subcategories or books"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete category"})
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("DELETE FROM CategoryAlias WHERE CategoryID = ?", categoryID); err != nil {
+			log.Printf("delete category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete category"})
+			return
+		}
+		result, err := tx.Exec("DELETE FROM Category WHERE CategoryID = ?", categoryID)
+		if err != nil {
+			log.Printf("delete category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete category"})
+			return
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit delete category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete category"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "category deleted"})
+	}
+}
+
+// AddCategoryAlias maps another spelling to an existing category
+func AddCategoryAlias() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		categoryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || categoryID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+
+		var input struct {
+			Alias string `json:"alias"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if categoryKey(input.Alias) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "alias is required"})
+			return
+		}
+
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(1) FROM Category WHERE CategoryID = ?", categoryID).Scan(&exists); err != nil {
+			log.Printf("check category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add alias"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+			return
+		}
+
+		if err := addCategoryAlias(db, categoryID, input.Alias); err != nil {
+			log.Printf("add alias to category %d: %v", categoryID, err)
+			respondRequestError(c, err, "failed to add alias")
+			return
+		}
+
+		respondCategory(c, db, categoryID, http.StatusCreated)
+	}
+}
+
+// GetCategoryBooks lists books in a category and all of its descendants
+func GetCategoryBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		categoryID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || categoryID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+
+		var exists int
+		if err := db.QueryRow("SELECT COUNT(1) FROM Category WHERE CategoryID = ?", categoryID).Scan(&exists); err != nil {
+			log.Printf("check category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get books"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+			return
+		}
+
+		books, err := queryCategoryBooks(db, categoryID)
+		if err != nil {
+			log.Printf("get books of category %d: %v", categoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get books"})
+			return
+		}
+
+		c.JSON(http.StatusOK, books)
+	}
+}
+
+// queryCategoryBooks returns the books filed under a category or any of its
+// descendants, with author and category details attached.
+func queryCategoryBooks(q queryer, categoryID int) ([]Book, error) {
+	query := categorySubtreeCTE + `
+		SELECT ` + bookColumns + ` FROM Book
+		WHERE BookID IN (SELECT bc.BookID FROM BookCategory bc JOIN Tree t ON t.CategoryID = bc.CategoryID)
+		ORDER BY bookName`
+	rows, err := q.Query(query, categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("query books of category %d: %w", categoryID, err)
+	}
+	defer rows.Close()
+
+	books := []Book{}
+	for rows.Next() {
+		var book Book
+		if err := scanBook(rows, &book); err != nil {
+			return nil, fmt.Errorf("scan book: %w", err)
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query books of category %d: %w", categoryID, err)
+	}
+	if err := attachBookDetails(q, books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// SetBookCategories replaces the categories a book is filed under
+func SetBookCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book_id"})
+			return
+		}
+
+		var input struct {
+			CategoryIDs []int `json:"categoryids"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			log.Printf("invalid request body: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if len(input.CategoryIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "categoryids must list at least one category"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set categories"})
+			return
+		}
+		defer tx.Rollback()
+
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM Book WITH (UPDLOCK) WHERE BookID = ?", bookID).Scan(&exists); err != nil {
+			log.Printf("check book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set categories"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+			return
+		}
+
+		if err := setBookCategories(tx, bookID, input.CategoryIDs); err != nil {
+			log.Printf("set categories of book %d: %v", bookID, err)
+			respondRequestError(c, err, "failed to set categories")
+			return
+		}
+
+		var book Book
+		if err := scanBook(tx.QueryRow("SELECT "+bookColumns+" FROM Book WHERE BookID = ?", bookID), &book); err != nil {
+			log.Printf("fetch book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set categories"})
+			return
+		}
+		if err := attachBookDetail(tx, &book); err != nil {
+			log.Printf("fetch book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set categories"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit categories of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set categories"})
+			return
+		}
+
+		c.JSON(http.StatusOK, book)
+	}
+}
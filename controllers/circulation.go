@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Copy statuses. Only Available copies can be checked out; OnLoan and OnHold
+// (set aside for a Ready hold) are managed by the circulation functions and
+// cannot be set by hand.
+const (
+	CopyAvailable = "Available"
+	CopyOnLoan    = "OnLoan"
+	CopyOnHold    = "OnHold"
+	CopyDamaged   = "Damaged"
+	CopyLost      = "Lost"
+	CopyWithdrawn = "Withdrawn"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// requestError is a failure inside a multi-step operation (checkout, return,
+// crediting authors) that maps to an HTTP status and a stable code clients
+// can branch on.
+type requestError struct {
+	Status  int
+	Code    string
+	Message string
+	Details gin.H // extra fields merged into the JSON response
+}
+
+func (e *requestError) Error() string { return e.Message }
+
+func newRequestError(status int, code, format string, args ...interface{}) *requestError {
+	return &requestError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// respondRequestError writes err as JSON, using its status and code when it
+// is a requestError and a generic 500 otherwise.
+func respondRequestError(c *gin.Context, err error, fallback string) {
+	if ce, ok := err.(*requestError); ok {
+		body := gin.H{"error": ce.Message, "code": ce.Code}
+		for k, v := range ce.Details {
+			body[k] = v
+		}
+		c.JSON(ce.Status, body)
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
+
+// reserveCopy picks the copy a new loan will use and marks it OnLoan. When
+// copyID is nil the lowest-numbered Available copy of bookID is taken; when
+// it is set the copy must belong to bookID and be Available.
+func reserveCopy(q queryer, bookID int, copyID *int) (int, error) {
+	var chosen int
+	if copyID != nil {
+		var copyBookID int
+		var status string
+		err := q.QueryRow("SELECT BookID, Status FROM BookCopy WITH (UPDLOCK, ROWLOCK) WHERE CopyID = ?", *copyID).
+			Scan(&copyBookID, &status)
+		if err == sql.ErrNoRows {
+			return 0, newRequestError(http.StatusNotFound, "COPY_NOT_FOUND", "copy %d not found", *copyID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("load copy %d: %w", *copyID, err)
+		}
+		if copyBookID != bookID {
+			return 0, newRequestError(http.StatusBadRequest, "COPY_BOOK_MISMATCH", "copy %d does not belong to book %d", *copyID, bookID)
+		}
+		if status != CopyAvailable {
+			return 0, newRequestError(http.StatusConflict, "COPY_UNAVAILABLE", "copy %d is %s", *copyID, status)
+		}
+		chosen = *copyID
+	} else {
+		err := q.QueryRow(`
+			SELECT TOP (1) CopyID
+			FROM BookCopy WITH (UPDLOCK, READPAST)
+			WHERE BookID = ? AND Status = ?
+			ORDER BY CopyID`, bookID, CopyAvailable).Scan(&chosen)
+		if err == sql.ErrNoRows {
+			return 0, newRequestError(http.StatusConflict, "NO_COPY_AVAILABLE", "no copy of book %d is available", bookID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("find available copy of book %d: %w", bookID, err)
+		}
+	}
+
+	if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ?", CopyOnLoan, chosen); err != nil {
+		return 0, fmt.Errorf("mark copy %d on loan: %w", chosen, err)
+	}
+	if err := syncBookInventory(q, bookID); err != nil {
+		return 0, err
+	}
+	return chosen, nil
+}
+
+// releaseCopy puts a returned copy back on the shelf, or sets it aside for
+// the next hold on its book, which it returns.
+func releaseCopy(q queryer, copyID int) (*Hold, error) {
+	if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ? AND Status = ?", CopyAvailable, copyID, CopyOnLoan); err != nil {
+		return nil, fmt.Errorf("release copy %d: %w", copyID, err)
+	}
+	return shelveCopy(q, copyID)
+}
+
+// syncBookInventory recomputes Book.bookQuantity (copies on the shelf) and
+// Book.isAvailable from the book's copies.
+func syncBookInventory(q queryer, bookID int) error {
+	const stmt = `
+		UPDATE Book
+		SET bookQuantity = (SELECT COUNT(*) FROM BookCopy WHERE BookID = ? AND Status = ?),
+		    isAvailable = CASE WHEN EXISTS (SELECT 1 FROM BookCopy WHERE BookID = ? AND Status = ?) THEN 1 ELSE 0 END
+		WHERE BookID = ?`
+	if _, err := q.Exec(stmt, bookID, CopyAvailable, bookID, CopyAvailable, bookID); err != nil {
+		return fmt.Errorf("sync inventory for book %d: %w", bookID, err)
+	}
+	return nil
+}
+
+// defaultFineBlockThreshold is the outstanding balance above which a patron
+// may not borrow or renew.
+const defaultFineBlockThreshold = "10.00"
+
+// fineBlockThreshold reads FINE_BLOCK_THRESHOLD, a plain amount such as
+// "25.00". It applies to each currency a patron owes in separately, so an
+// amount that names a currency, such as "25 EUR", is ignored.
+func fineBlockThreshold() string {
+	if v := strings.TrimSpace(os.Getenv("FINE_BLOCK_THRESHOLD")); v != "" {
+		m, err := helper.ParseMoney(v, helper.DefaultCurrency())
+		if err == nil && m.Sign() >= 0 && strings.IndexFunc(v, unicode.IsLetter) < 0 {
+			return v
+		}
+		log.Printf("ignoring invalid FINE_BLOCK_THRESHOLD %q", v)
+	}
+	return defaultFineBlockThreshold
+}
+
+// BlockOverride lets a librarian lend to a blocked patron. By is who
+// overrode, always the authenticated user, and Reason why.
+type BlockOverride struct {
+	By     string `json:"By"`
+	Reason string `json:"Reason"`
+}
+
+// outstandingBalance totals what a patron still owes on open fines, one
+// amount per currency.
+func outstandingBalance(q queryer, personID int) ([]helper.Money, error) {
+	rows, err := q.Query(`
+		SELECT Currency, SUM(FineAmount - AmountPaid) FROM FineBookTable
+		WHERE PersonID = ? AND Status = 'Open'
+		GROUP BY Currency HAVING SUM(FineAmount - AmountPaid) > 0
+		ORDER BY Currency`, personID)
+	if err != nil {
+		return nil, fmt.Errorf("load balance of person %d: %w", personID, err)
+	}
+	defer rows.Close()
+	balance := []helper.Money{}
+	for rows.Next() {
+		var m helper.Money
+		if err := rows.Scan(m.CurrencyScanner(), &m); err != nil {
+			return nil, fmt.Errorf("scan balance of person %d: %w", personID, err)
+		}
+		balance = append(balance, m)
+	}
+	return balance, rows.Err()
+}
+
+// fineBlock returns a FINES_OUTSTANDING error, without failing, when the
+// patron owes more than the threshold in any currency.
+func fineBlock(q queryer, personID int) (*requestError, error) {
+	balance, err := outstandingBalance(q, personID)
+	if err != nil {
+		return nil, err
+	}
+	threshold := fineBlockThreshold()
+	for _, owed := range balance {
+		limit, err := helper.ParseMoney(threshold, owed.Currency())
+		if err != nil {
+			return nil, err
+		}
+		over, err := owed.Cmp(limit)
+		if err != nil {
+			return nil, err
+		}
+		if over > 0 {
+			e := newRequestError(http.StatusConflict, "FINES_OUTSTANDING", "person %d owes %s in fines; pay them or have a librarian override the block", personID, owed)
+			e.Details = gin.H{"balance": balance, "threshold": threshold}
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkOverride validates a librarian override. Only an authenticated user
+// can override, and is recorded as the one who did, whatever the body says.
+func checkOverride(c *gin.Context, o *BlockOverride) error {
+	if o == nil {
+		return nil
+	}
+	o.By = strings.TrimSpace(c.GetString("uid"))
+	if o.By == "" {
+		return newRequestError(http.StatusUnauthorized, "OVERRIDE_UNAUTHENTICATED", "an override must be made with the token header of a signed-in librarian")
+	}
+	o.Reason = strings.TrimSpace(o.Reason)
+	if len(o.By) > 100 || o.Reason == "" || len(o.Reason) > 500 {
+		return newRequestError(http.StatusBadRequest, "INVALID_OVERRIDE", "an override needs a Reason (max 500 characters)")
+	}
+	return nil
+}
+
+// recordOverride logs that block was overridden for an order. A patron
+// cannot override a block on their own loans, so it refuses when o.By is the
+// borrower's user.
+func recordOverride(q queryer, personID, orderID int, action string, block *requestError, o *BlockOverride) error {
+	var self int
+	err := q.QueryRow("SELECT COUNT(*) FROM Person WHERE ID = ? AND User_id = ?", personID, o.By).Scan(&self)
+	if err != nil {
+		return fmt.Errorf("check overrider of order %d: %w", orderID, err)
+	}
+	if self > 0 {
+		return newRequestError(http.StatusForbidden, "OVERRIDE_BY_BORROWER", "a patron cannot override a block on their own account; ask a librarian")
+	}
+	var balance string
+	if owed, ok := block.Details["balance"].([]helper.Money); ok {
+		parts := make([]string, len(owed))
+		for i, m := range owed {
+			parts[i] = m.String()
+		}
+		balance = strings.Join(parts, ", ")
+	}
+	_, err = q.Exec(`
+		INSERT INTO CirculationOverride (PersonID, OrderID, Action, Code, OverriddenBy, Reason, Balance)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, personID, orderID, action, block.Code, o.By, o.Reason, balance)
+	if err != nil {
+		return fmt.Errorf("record override for order %d: %w", orderID, err)
+	}
+	return nil
+}
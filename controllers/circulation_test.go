@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFineBlockThreshold(t *testing.T) {
+	tests := []struct {
+		env, want string
+	}{
+		{"", defaultFineBlockThreshold},
+		{"25.00", "25.00"},
+		{" 0 ", "0"},
+		{"25 EUR", defaultFineBlockThreshold},
+		{"USD 25", defaultFineBlockThreshold},
+		{"-1", defaultFineBlockThreshold},
+		{"lots", defaultFineBlockThreshold},
+	}
+	for _, tt := range tests {
+		t.Setenv("FINE_BLOCK_THRESHOLD", tt.env)
+		if got := fineBlockThreshold(); got != tt.want {
+			t.Errorf("FINE_BLOCK_THRESHOLD=%q: threshold = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOverride(t *testing.T) {
+	tests := []struct {
+		name   string
+		uid    string
+		o      *BlockOverride
+		status int // 0 when the override is accepted
+	}{
+		{"no override", "", nil, 0},
+		{"signed in", "u-1", &BlockOverride{By: "someone else", Reason: " paid at the desk "}, 0},
+		{"anonymous", "", &BlockOverride{By: "librarian", Reason: "paid at the desk"}, http.StatusUnauthorized},
+		{"no reason", "u-1", &BlockOverride{Reason: "  "}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.uid != "" {
+				c.Set("uid", tt.uid)
+			}
+			err := checkOverride(c, tt.o)
+			var reqErr *requestError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Fatalf("checkOverride: %v", err)
+			case tt.status != 0 && (!errors.As(err, &reqErr) || reqErr.Status != tt.status):
+				t.Fatalf("checkOverride = %v, want status %d", err, tt.status)
+			}
+			if tt.status == 0 && tt.o != nil && (tt.o.By != tt.uid || tt.o.Reason != "paid at the desk") {
+				t.Errorf("override = %+v", tt.o)
+			}
+		})
+	}
+}
+
+func TestRecordOverrideRefusesBorrower(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.on("FROM Person", func(args []driver.Value) fakeResult {
+		self := int64(0)
+		if args[0] == int64(7) && args[1] == "u-7" {
+			self = 1
+		}
+		return fakeResult{cols: []string{"n"}, rows: [][]driver.Value{{self}}}
+	})
+	f.exec("INSERT INTO CirculationOverride")
+	block := newRequestError(http.StatusConflict, "FINES_OUTSTANDING", "owes fines")
+
+	err := recordOverride(db, 7, 1, "checkout", block, &BlockOverride{By: "u-7", Reason: "please"})
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) || reqErr.Code != "OVERRIDE_BY_BORROWER" {
+		t.Fatalf("override by the borrower: err = %v, want OVERRIDE_BY_BORROWER", err)
+	}
+	if n := len(f.executed("INSERT INTO CirculationOverride")); n != 0 {
+		t.Errorf("refused override was recorded %d times", n)
+	}
+
+	if err := recordOverride(db, 7, 1, "checkout", block, &BlockOverride{By: "u-1", Reason: "paid at the desk"}); err != nil {
+		t.Fatalf("override by a librarian: %v", err)
+	}
+	if calls := f.executed("INSERT INTO CirculationOverride"); len(calls) != 1 || calls[0].args[4] != "u-1" {
+		t.Errorf("recorded overrides = %+v", calls)
+	}
+}
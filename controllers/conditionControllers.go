@@ -0,0 +1,486 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"go-crud-api/storage"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// copyConditions are the grades copies are kept in, best first.
+var copyConditions = []string{"New", "Good", "Worn", "Damaged"}
+
+// damageFine is the code of the fine type a return can raise for damage.
+const damageFine = "DAMAGE_ON_RETURN"
+
+// maxConditionNotes caps the notes of one assessment.
+const maxConditionNotes = 1000
+
+// ConditionReport is the condition staff record for a copy, for example
+// when its loan is returned.
+type ConditionReport struct {
+	Condition  string `json:"Condition"` // New, Good, Worn or Damaged
+	Notes      string `json:"Notes"`
+	AssessedBy string `json:"AssessedBy"` // the authenticated user when there is one
+}
+
+// ConditionAssessment is one entry of a copy's condition history.
+type ConditionAssessment struct {
+	AssessmentID      int              `json:"AssessmentID"`
+	CopyID            int              `json:"CopyID"`
+	OrderID           *int             `json:"OrderID"` // the loan returned, if any
+	Condition         string           `json:"Condition"`
+	PreviousCondition string           `json:"PreviousCondition"`
+	Notes             *string          `json:"Notes"`
+	AssessedBy        *string          `json:"AssessedBy"`
+	FineID            *int             `json:"FineID"` // the damage fine raised, if any
+	AssessedAt        time.Time        `json:"AssessedAt"`
+	Photos            []ConditionPhoto `json:"Photos"`
+}
+
+// ConditionPhoto is a photo attached to an assessment.
+type ConditionPhoto struct {
+	PhotoID     int       `json:"PhotoID"`
+	URL         string    `json:"URL"`
+	ContentType string    `json:"ContentType"`
+	Bytes       int64     `json:"Bytes"`
+	UploadedAt  time.Time `json:"UploadedAt"`
+}
+
+const conditionColumns = "AssessmentID, CopyID, OrderID, Condition, PreviousCondition, Notes, AssessedBy, FineID, Assessed_at"
+
+func scanCondition(row rowScanner, a *ConditionAssessment) error {
+	var orderID, fineID sql.NullInt64
+	var notes, by sql.NullString
+	if err := row.Scan(&a.AssessmentID, &a.CopyID, &orderID, &a.Condition, &a.PreviousCondition,
+		&notes, &by, &fineID, &a.AssessedAt); err != nil {
+		return err
+	}
+	a.OrderID, a.FineID = nullIntPtr(orderID), nullIntPtr(fineID)
+	a.Notes, a.AssessedBy = nil, nil
+	if notes.Valid {
+		a.Notes = &notes.String
+	}
+	if by.Valid {
+		a.AssessedBy = &by.String
+	}
+	a.Photos = []ConditionPhoto{}
+	return nil
+}
+
+// conditionRank orders conditions from New (0) to Damaged; it is -1 for
+// anything else.
+func conditionRank(condition string) int {
+	for i, c := range copyConditions {
+		if c == condition {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeCondition accepts a condition in any case, returning its
+// canonical spelling, or "" when it is not one.
+func normalizeCondition(condition string) string {
+	for _, c := range copyConditions {
+		if strings.EqualFold(c, strings.TrimSpace(condition)) {
+			return c
+		}
+	}
+	return ""
+}
+
+// damageFineCondition reads DAMAGE_FINE_CONDITION: a return that leaves a
+// copy worse off than before, and at least this bad, raises the damage
+// fine. Unset, returns never do.
+func damageFineCondition() string {
+	v := os.Getenv("DAMAGE_FINE_CONDITION")
+	if v == "" {
+		return ""
+	}
+	if c := normalizeCondition(v); c != "" {
+		return c
+	}
+	log.Printf("ignoring invalid DAMAGE_FINE_CONDITION %q", v)
+	return ""
+}
+
+// checkConditionReport validates a report and normalizes its condition. An
+// authenticated user is recorded as the assessor, whatever the body says.
+func checkConditionReport(c *gin.Context, r *ConditionReport) error {
+	if r == nil {
+		return nil
+	}
+	if uid := c.GetString("uid"); uid != "" {
+		r.AssessedBy = uid
+	}
+	r.Condition = normalizeCondition(r.Condition)
+	r.Notes, r.AssessedBy = strings.TrimSpace(r.Notes), strings.TrimSpace(r.AssessedBy)
+	switch {
+	case r.Condition == "":
+		return newRequestError(http.StatusBadRequest, "INVALID_CONDITION", "Condition must be one of: New, Good, Worn, Damaged")
+	case len([]rune(r.Notes)) > maxConditionNotes:
+		return newRequestError(http.StatusBadRequest, "INVALID_CONDITION", "Notes are limited to %d characters", maxConditionNotes)
+	case len([]rune(r.AssessedBy)) > 100:
+		return newRequestError(http.StatusBadRequest, "INVALID_CONDITION", "AssessedBy is limited to 100 characters")
+	}
+	return nil
+}
+
+// nullString is nil for "" so empty text is stored as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// assessCondition records a copy's condition and makes it the copy's
+// current one. For a returned loan (orderID set), a copy that came back in
+// worse condition, and at least as bad as DAMAGE_FINE_CONDITION, costs the
+// borrower the damage fine.
+func assessCondition(q queryer, copyID int, orderID *int, r ConditionReport) (ConditionAssessment, error) {
+	var previous string
+	err := q.QueryRow("SELECT Condition FROM BookCopy WITH (UPDLOCK) WHERE CopyID = ?", copyID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return ConditionAssessment{}, newRequestError(http.StatusNotFound, "COPY_NOT_FOUND", "copy %d not found", copyID)
+	}
+	if err != nil {
+		return ConditionAssessment{}, fmt.Errorf("load copy %d: %w", copyID, err)
+	}
+	if previous != r.Condition {
+		if _, err := q.Exec("UPDATE BookCopy SET Condition = ? WHERE CopyID = ?", r.Condition, copyID); err != nil {
+			return ConditionAssessment{}, fmt.Errorf("update condition of copy %d: %w", copyID, err)
+		}
+	}
+
+	var fineID *int
+	level := damageFineCondition()
+	if orderID != nil && level != "" && conditionRank(r.Condition) > conditionRank(previous) &&
+		conditionRank(r.Condition) >= conditionRank(level) {
+		typeID, amount, err := seededFineType(q, damageFine)
+		if err != nil {
+			return ConditionAssessment{}, err
+		}
+		if amount.Sign() > 0 {
+			fine := FineBook{OrderID: *orderID, FineTypeID: typeID, FineAmount: amount.Round()}
+			if err := q.QueryRow("SELECT PersonID FROM OrderBook WHERE OrderID = ?", *orderID).Scan(&fine.PersonID); err != nil {
+				return ConditionAssessment{}, fmt.Errorf("load order %d: %w", *orderID, err)
+			}
+			if err := insertFine(q, &fine); err != nil {
+				return ConditionAssessment{}, err
+			}
+			fineID = &fine.FineID
+		}
+	}
+
+	var a ConditionAssessment
+	err = scanCondition(q.QueryRow(`
+		INSERT INTO CopyCondition (CopyID, OrderID, Condition, PreviousCondition, Notes, AssessedBy, FineID)
+		OUTPUT `+qualify("inserted", conditionColumns)+`
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		copyID, orderID, r.Condition, previous, nullString(r.Notes), nullString(r.AssessedBy), fineID), &a)
+	if err != nil {
+		return a, fmt.Errorf("record condition of copy %d: %w", copyID, err)
+	}
+	return a, nil
+}
+
+// conditionPhotoKey is the storage key of an assessment photo.
+func conditionPhotoKey(copyID, assessmentID int, hash, contentType string) string {
+	return fmt.Sprintf("conditions/%d/%d/%s%s", copyID, assessmentID, hash, helper.CoverTypes[contentType])
+}
+
+// conditionPhotoURL is where a photo can be fetched: the store's public URL,
+// or the API path that serves it.
+func conditionPhotoURL(copyID, assessmentID, photoID int, key string) string {
+	if store, err := coverStore(); err == nil {
+		if u := store.URL(key); u != "" {
+			return u
+		}
+	}
+	return fmt.Sprintf("/copy/%d/conditions/%d/photos/%d", copyID, assessmentID, photoID)
+}
+
+// copyIDParam reads the :id of a /copy/:id route, answering 400 or 404 and
+// returning false when it does not name a copy.
+func copyIDParam(c *gin.Context, q queryer) (int, bool) {
+	copyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || copyID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy_id"})
+		return 0, false
+	}
+	var exists int
+	if err := q.QueryRow("SELECT COUNT(1) FROM BookCopy WHERE CopyID = ?", copyID).Scan(&exists); err != nil {
+		log.Printf("check copy %d: %v", copyID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load copy"})
+		return 0, false
+	}
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+		return 0, false
+	}
+	return copyID, true
+}
+
+// GetCopyConditions answers GET /copy/:id/conditions with the copy's
+// condition history, newest first, including photos.
+func GetCopyConditions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+		copyID, ok := copyIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query("SELECT "+conditionColumns+" FROM CopyCondition WHERE CopyID = ? ORDER BY Assessed_at DESC, AssessmentID DESC", copyID)
+		if err != nil {
+			log.Printf("get conditions of copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get condition history"})
+			return
+		}
+		history := []ConditionAssessment{}
+		index := map[int]int{}
+		for rows.Next() {
+			var a ConditionAssessment
+			if err := scanCondition(rows, &a); err != nil {
+				rows.Close()
+				log.Printf("scan condition: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get condition history"})
+				return
+			}
+			index[a.AssessmentID] = len(history)
+			history = append(history, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			log.Printf("get conditions of copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get condition history"})
+			return
+		}
+
+		rows, err = db.Query(`
+			SELECT p.PhotoID, p.AssessmentID, p.Hash, p.ContentType, p.ByteSize, p.Uploaded_at
+			FROM CopyConditionPhoto p JOIN CopyCondition a ON a.AssessmentID = p.AssessmentID
+			WHERE a.CopyID = ? ORDER BY p.PhotoID`, copyID)
+		if err != nil {
+			log.Printf("get condition photos of copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get condition history"})
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p ConditionPhoto
+			var assessmentID int
+			var hash string
+			if err := rows.Scan(&p.PhotoID, &assessmentID, &hash, &p.ContentType, &p.Bytes, &p.UploadedAt); err != nil {
+				log.Printf("scan condition photo: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get condition history"})
+				return
+			}
+			p.URL = conditionPhotoURL(copyID, assessmentID, p.PhotoID, conditionPhotoKey(copyID, assessmentID, hash, p.ContentType))
+			if i, ok := index[assessmentID]; ok {
+				history[i].Photos = append(history[i].Photos, p)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get condition photos of copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get condition history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+// CreateCopyCondition answers POST /copy/:id/conditions, recording an
+// assessment made outside a return, such as at a stock check.
+func CreateCopyCondition() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+		copyID, ok := copyIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		var report ConditionReport
+		if err := c.ShouldBindJSON(&report); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if err := checkConditionReport(c, &report); err != nil {
+			respondRequestError(c, err, "failed to record condition")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record condition"})
+			return
+		}
+		defer tx.Rollback()
+
+		assessment, err := assessCondition(tx, copyID, nil, report)
+		if err != nil {
+			log.Printf("record condition of copy %d: %v", copyID, err)
+			respondRequestError(c, err, "failed to record condition")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit condition of copy %d: %v", copyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record condition"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, assessment)
+	}
+}
+
+// UploadConditionPhoto answers POST /copy/:id/conditions/:assessmentid/photos,
+// storing a multipart "file" image with the assessment.
+func UploadConditionPhoto() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+		copyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || copyID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy_id"})
+			return
+		}
+		assessmentID, err := strconv.Atoi(c.Param("assessmentid"))
+		if err != nil || assessmentID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid assessment_id"})
+			return
+		}
+		store, err := coverStore()
+		if err != nil {
+			log.Printf("open photo storage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "photo storage is not available"})
+			return
+		}
+
+		var exists int
+		err = db.QueryRow("SELECT COUNT(1) FROM CopyCondition WHERE AssessmentID = ? AND CopyID = ?", assessmentID, copyID).Scan(&exists)
+		if err != nil {
+			log.Printf("check assessment %d: %v", assessmentID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload photo"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "assessment not found"})
+			return
+		}
+
+		data, contentType, _, err := readImageUpload(c, "photo", coverMaxBytes())
+		if err != nil {
+			respondRequestError(c, err, "failed to upload photo")
+			return
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:16]
+
+		// Write the blob first; rows only ever point at stored photos
+		ctx := c.Request.Context()
+		key := conditionPhotoKey(copyID, assessmentID, hash, contentType)
+		if err := store.Put(ctx, key, data, contentType); err != nil {
+			log.Printf("store photo of assessment %d: %v", assessmentID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store photo"})
+			return
+		}
+		photo := ConditionPhoto{ContentType: contentType, Bytes: int64(len(data))}
+		err = db.QueryRow(`
+			INSERT INTO CopyConditionPhoto (AssessmentID, Hash, ContentType, ByteSize)
+			OUTPUT inserted.PhotoID, inserted.Uploaded_at
+			VALUES (?, ?, ?, ?)`, assessmentID, hash, contentType, len(data)).Scan(&photo.PhotoID, &photo.UploadedAt)
+		if err != nil {
+			log.Printf("save photo of assessment %d: %v", assessmentID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload photo"})
+			return
+		}
+
+		photo.URL = conditionPhotoURL(copyID, assessmentID, photo.PhotoID, key)
+		c.JSON(http.StatusCreated, photo)
+	}
+}
+
+// GetConditionPhoto streams an assessment photo from storage for stores
+// without a public URL.
+func GetConditionPhoto() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+		copyID, err1 := strconv.Atoi(c.Param("id"))
+		assessmentID, err2 := strconv.Atoi(c.Param("assessmentid"))
+		photoID, err3 := strconv.Atoi(c.Param("photoid"))
+		if err1 != nil || err2 != nil || err3 != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+			return
+		}
+
+		var hash, contentType string
+		err := db.QueryRow(`
+			SELECT p.Hash, p.ContentType
+			FROM CopyConditionPhoto p JOIN CopyCondition a ON a.AssessmentID = p.AssessmentID
+			WHERE p.PhotoID = ? AND p.AssessmentID = ? AND a.CopyID = ?`, photoID, assessmentID, copyID).Scan(&hash, &contentType)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("get photo %d: %v", photoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get photo"})
+			return
+		}
+
+		store, err := coverStore()
+		if err != nil {
+			log.Printf("open photo storage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "photo storage is not available"})
+			return
+		}
+		body, obj, err := store.Get(c.Request.Context(), conditionPhotoKey(copyID, assessmentID, hash, contentType))
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("serve photo %d: %v", photoID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get photo"})
+			return
+		}
+		defer body.Close()
+		c.DataFromReader(http.StatusOK, obj.Size, obj.ContentType, body, map[string]string{
+			"Cache-Control": coverCacheControl,
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestAssessConditionDamageFine(t *testing.T) {
+	orderID := 2
+	tests := []struct {
+		name      string
+		level     string // DAMAGE_FINE_CONDITION
+		previous  string
+		condition string
+		order     *int
+		fined     bool
+	}{
+		{"fines disabled", "", "Good", "Damaged", &orderID, false},
+		{"worse and at the level", "Worn", "Good", "Worn", &orderID, true},
+		{"worse and past the level", "worn", "New", "Damaged", &orderID, true},
+		{"worse but not bad enough", "Worn", "New", "Good", &orderID, false},
+		{"already that bad", "Worn", "Damaged", "Damaged", &orderID, false},
+		{"better", "Worn", "Damaged", "Worn", &orderID, false},
+		{"not a return", "Worn", "Good", "Damaged", nil, false},
+		{"invalid level", "Broken", "Good", "Damaged", &orderID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DAMAGE_FINE_CONDITION", tt.level)
+			db, f := newFakeDB(t)
+			f.rows("FROM BookCopy WITH (UPDLOCK)", []string{"Condition"}, []driver.Value{tt.previous})
+			f.exec("UPDATE BookCopy SET Condition")
+			f.rows("FROM FineTable WHERE Code", []string{"FineID", "FineAmount", "Currency"},
+				[]driver.Value{int64(9), "3.5000", "USD"})
+			f.rows("SELECT PersonID FROM OrderBook", []string{"PersonID"}, []driver.Value{int64(7)})
+			f.rows("INSERT INTO FineBookTable", []string{"FineID"}, []driver.Value{int64(40)})
+			f.on("INSERT INTO CopyCondition", func(args []driver.Value) fakeResult {
+				return fakeResult{
+					cols: []string{"AssessmentID", "CopyID", "OrderID", "Condition", "PreviousCondition", "Notes", "AssessedBy", "FineID", "Assessed_at"},
+					rows: [][]driver.Value{{int64(1), args[0], args[1], args[2], args[3], args[4], args[5], args[6], time.Now()}},
+				}
+			})
+
+			a, err := assessCondition(db, 4, tt.order, ConditionReport{Condition: tt.condition})
+			if err != nil {
+				t.Fatalf("assessCondition: %v", err)
+			}
+			if a.Condition != tt.condition || a.PreviousCondition != tt.previous {
+				t.Errorf("assessment = %+v", a)
+			}
+			fines := f.executed("INSERT INTO FineBookTable")
+			if !tt.fined {
+				if len(fines) != 0 || a.FineID != nil {
+					t.Errorf("fined %d times, FineID %v; want no fine", len(fines), a.FineID)
+				}
+				return
+			}
+			if len(fines) != 1 || a.FineID == nil || *a.FineID != 40 {
+				t.Fatalf("fines = %+v, FineID %v; want fine 40", fines, a.FineID)
+			}
+			if args := fines[0].args; args[0] != int64(7) || args[1] != int64(2) || args[2] != int64(9) || args[3] != "3.5000" || args[5] != FineOpen {
+				t.Errorf("fine = %v", args)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"go-crud-api/storage"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// coverSizes are the thumbnails generated for every cover. Each fits a box
+// of width x 1.5*width, the usual proportions of a book.
+var coverSizes = []struct {
+	name  string
+	width int
+}{
+	{"small", 120},
+	{"medium", 300},
+	{"large", 600},
+}
+
+// defaultCoverMaxBytes caps an upload unless COVER_MAX_BYTES says otherwise.
+const defaultCoverMaxBytes = 5 << 20
+
+// coverCacheControl is sent with cover images. Keys include the image hash,
+// so a given URL never changes content.
+const coverCacheControl = "public, max-age=31536000, immutable"
+
+// coverStore is the blob store covers are kept in, opened on first use from
+// the STORAGE_* environment.
+var coverStore = sync.OnceValues(storage.FromEnv)
+
+// BookCover is the cover of a book as returned in the book JSON. URLs are
+// relative to the API unless the store serves objects publicly.
+type BookCover struct {
+	URL         string            `json:"url"`
+	ContentType string            `json:"contenttype"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Bytes       int64             `json:"bytes"`
+	Thumbnails  map[string]string `json:"thumbnails"` // size name -> URL
+	UploadedAt  time.Time         `json:"uploaded_at"`
+}
+
+func coverMaxBytes() int64 {
+	if v := os.Getenv("COVER_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("ignoring invalid COVER_MAX_BYTES %q", v)
+	}
+	return defaultCoverMaxBytes
+}
+
+// coverKeys lists the storage keys of a cover: the original first, then one
+// JPEG per entry of coverSizes.
+func coverKeys(bookID int, hash, contentType string) []string {
+	prefix := fmt.Sprintf("covers/%d/%s/", bookID, hash)
+	keys := []string{prefix + "original" + helper.CoverTypes[contentType]}
+	for _, size := range coverSizes {
+		keys = append(keys, prefix+size.name+".jpg")
+	}
+	return keys
+}
+
+// coverURL is the store's public URL for key, or the API path that serves it.
+func coverURL(key string) string {
+	if store, err := coverStore(); err == nil {
+		if u := store.URL(key); u != "" {
+			return u
+		}
+	}
+	return "/" + key
+}
+
+func newBookCover(bookID int, hash, contentType string, width, height int, size int64, uploadedAt time.Time) *BookCover {
+	keys := coverKeys(bookID, hash, contentType)
+	cover := &BookCover{
+		URL:         coverURL(keys[0]),
+		ContentType: contentType,
+		Width:       width,
+		Height:      height,
+		Bytes:       size,
+		Thumbnails:  map[string]string{},
+		UploadedAt:  uploadedAt,
+	}
+	for i, s := range coverSizes {
+		cover.Thumbnails[s.name] = coverURL(keys[i+1])
+	}
+	return cover
+}
+
+// attachCovers loads the cover of every book.
+func attachCovers(q queryer, books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(books))
+	for i := range books {
+		index[books[i].BookID] = i
+	}
+
+	err := queryBookBatches(q, books, `
+		SELECT BookID, Hash, ContentType, Width, Height, ByteSize, Uploaded_at
+		FROM BookCover WHERE BookID IN (%s)`, nil, func(rows *sql.Rows) error {
+		var bookID, width, height int
+		var hash, contentType string
+		var size int64
+		var uploadedAt time.Time
+		if err := rows.Scan(&bookID, &hash, &contentType, &width, &height, &size, &uploadedAt); err != nil {
+			return err
+		}
+		if i, ok := index[bookID]; ok {
+			books[i].Cover = newBookCover(bookID, hash, contentType, width, height, size, uploadedAt)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load book covers: %w", err)
+	}
+	return nil
+}
+
+// readCoverUpload reads the "file" part of a multipart upload, enforcing the
+// size limit and checking the bytes really are a supported image.
+func readCoverUpload(c *gin.Context) ([]byte, string, *helper.ImageInfo, error) {
+	return readImageUpload(c, "cover", coverMaxBytes())
+}
+
+// readImageUpload does the work of readCoverUpload for any kind of image;
+// what names it in errors ("cover" gives COVER_TOO_LARGE).
+func readImageUpload(c *gin.Context, what string, maxBytes int64) ([]byte, string, *helper.ImageInfo, error) {
+	tooLarge := newRequestError(http.StatusRequestEntityTooLarge, strings.ToUpper(what)+"_TOO_LARGE", "%s images are limited to %d bytes", what, maxBytes)
+
+	// Leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+64<<10)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, "", nil, tooLarge
+		}
+		return nil, "", nil, newRequestError(http.StatusBadRequest, "FILE_REQUIRED", "a multipart file field named file is required")
+	}
+	defer file.Close()
+	if header.Size > maxBytes {
+		return nil, "", nil, tooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return nil, "", nil, newRequestError(http.StatusBadRequest, "FILE_REQUIRED", "failed to read the uploaded file")
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", nil, tooLarge
+	}
+
+	contentType, err := helper.SniffImage(data)
+	if err != nil {
+		return nil, "", nil, newRequestError(http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE",
+			"%s must be a JPEG, PNG, GIF or WebP image", what)
+	}
+	info, err := helper.DecodeImage(data)
+	if errors.Is(err, helper.ErrImageTooLarge) {
+		return nil, "", nil, newRequestError(http.StatusUnprocessableEntity, "IMAGE_TOO_LARGE",
+			"%s images are limited to %d pixels", what, helper.MaxImagePixels)
+	}
+	if err != nil {
+		return nil, "", nil, newRequestError(http.StatusUnprocessableEntity, "INVALID_IMAGE", "the uploaded file is not a readable image")
+	}
+	return data, contentType, info, nil
+}
+
+// deleteCoverBlobs removes a cover's original and thumbnails. Failures are
+// only logged: the row is already gone and stray blobs are harmless.
+func deleteCoverBlobs(ctx context.Context, store storage.Store, bookID int, hash, contentType string) {
+	for _, key := range coverKeys(bookID, hash, contentType) {
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("delete cover blob %s: %v", key, err)
+		}
+	}
+}
+
+// UploadBookCover stores a multipart "file" as the book's cover, replacing
+// any previous one, and generates its thumbnails.
+func UploadBookCover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+			return
+		}
+		store, err := coverStore()
+		if err != nil {
+			log.Printf("open cover storage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cover storage is not available"})
+			return
+		}
+
+		var exists int
+		err = db.QueryRow("SELECT COUNT(*) FROM Book WHERE BookID = ?", bookID).Scan(&exists)
+		if err != nil {
+			log.Printf("check book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload cover"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+			return
+		}
+
+		data, contentType, info, err := readCoverUpload(c)
+		if err != nil {
+			respondRequestError(c, err, "failed to upload cover")
+			return
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:16]
+
+		// Write the blobs first; the row only ever points at complete covers
+		ctx := c.Request.Context()
+		keys := coverKeys(bookID, hash, contentType)
+		if err := store.Put(ctx, keys[0], data, contentType); err != nil {
+			log.Printf("store cover of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store cover"})
+			return
+		}
+		for i, size := range coverSizes {
+			thumb, err := helper.Thumbnail(info.Image, size.width, size.width*3/2)
+			if err == nil {
+				err = store.Put(ctx, keys[i+1], thumb, "image/jpeg")
+			}
+			if err != nil {
+				log.Printf("store %s thumbnail of book %d: %v", size.name, bookID, err)
+				deleteCoverBlobs(ctx, store, bookID, hash, contentType)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store cover"})
+				return
+			}
+		}
+
+		// oldRead tells whether the current cover row was read; until it is,
+		// the new blobs may be the live cover's and must not be deleted
+		var oldHash, oldType string
+		var oldRead bool
+		uploadedAt := time.Now().UTC()
+		err = func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			err = tx.QueryRow("SELECT Hash, ContentType FROM BookCover WITH (UPDLOCK) WHERE BookID = ?", bookID).Scan(&oldHash, &oldType)
+			oldRead = err == nil || err == sql.ErrNoRows
+			switch {
+			case err == sql.ErrNoRows:
+				_, err = tx.Exec(`INSERT INTO BookCover (BookID, Hash, ContentType, Width, Height, ByteSize, Uploaded_at)
+					VALUES (?, ?, ?, ?, ?, ?, ?)`, bookID, hash, contentType, info.Width, info.Height, len(data), uploadedAt)
+			case err == nil:
+				_, err = tx.Exec(`UPDATE BookCover SET Hash = ?, ContentType = ?, Width = ?, Height = ?, ByteSize = ?, Uploaded_at = ?
+					WHERE BookID = ?`, hash, contentType, info.Width, info.Height, len(data), uploadedAt, bookID)
+			}
+			if err != nil {
+				return err
+			}
+			return tx.Commit()
+		}()
+		if err != nil {
+			log.Printf("save cover of book %d: %v", bookID, err)
+			if oldRead && (oldHash != hash || oldType != contentType) {
+				deleteCoverBlobs(ctx, store, bookID, hash, contentType)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload cover"})
+			return
+		}
+		if oldHash != "" && (oldHash != hash || oldType != contentType) {
+			deleteCoverBlobs(ctx, store, bookID, oldHash, oldType)
+		}
+
+		c.JSON(http.StatusCreated, newBookCover(bookID, hash, contentType, info.Width, info.Height, int64(len(data)), uploadedAt))
+	}
+}
+
+// GetBookCover redirects to the book's current cover, or to one of its
+// thumbnails with ?size=small|medium|large. The redirect itself is not
+// cached, so a new upload shows up at once.
+func GetBookCover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+			return
+		}
+		size := c.DefaultQuery("size", "original")
+
+		var hash, contentType string
+		err = db.QueryRow("SELECT Hash, ContentType FROM BookCover WHERE BookID = ?", bookID).Scan(&hash, &contentType)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "book has no cover"})
+			return
+		}
+		if err != nil {
+			log.Printf("get cover of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cover"})
+			return
+		}
+
+		keys := coverKeys(bookID, hash, contentType)
+		key := ""
+		if size == "original" {
+			key = keys[0]
+		}
+		for i, s := range coverSizes {
+			if s.name == size {
+				key = keys[i+1]
+			}
+		}
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "size must be original, small, medium or large"})
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Redirect(http.StatusFound, coverURL(key))
+	}
+}
+
+// DeleteBookCover removes a book's cover and its thumbnails.
+func DeleteBookCover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+			return
+		}
+		store, err := coverStore()
+		if err != nil {
+			log.Printf("open cover storage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cover storage is not available"})
+			return
+		}
+
+		var hash, contentType string
+		err = db.QueryRow("DELETE FROM BookCover OUTPUT deleted.Hash, deleted.ContentType WHERE BookID = ?", bookID).Scan(&hash, &contentType)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "book has no cover"})
+			return
+		}
+		if err != nil {
+			log.Printf("delete cover of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete cover"})
+			return
+		}
+		deleteCoverBlobs(c.Request.Context(), store, bookID, hash, contentType)
+		c.JSON(http.StatusOK, gin.H{"message": "cover deleted"})
+	}
+}
+
+// ServeCover streams a cover blob from storage for stores without a public
+// URL. Blobs are immutable, so they are cached for a year and revalidated by
+// key alone.
+func ServeCover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "covers" + c.Param("key")
+		if storage.CheckKey(key) != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cover not found"})
+			return
+		}
+		etag := `"` + strings.ReplaceAll(strings.TrimPrefix(key, "covers/"), "/", "-") + `"`
+		if c.GetHeader("If-None-Match") == etag {
+			c.Header("ETag", etag)
+			c.Header("Cache-Control", coverCacheControl)
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		store, err := coverStore()
+		if err != nil {
+			log.Printf("open cover storage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cover storage is not available"})
+			return
+		}
+		body, obj, err := store.Get(c.Request.Context(), key)
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cover not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("serve cover %s: %v", key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cover"})
+			return
+		}
+		defer body.Close()
+		c.DataFromReader(http.StatusOK, obj.Size, obj.ContentType, body, map[string]string{
+			"Cache-Control": coverCacheControl,
+			"ETag":          etag,
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDeskItems caps how many books one desk transaction handles.
+const maxDeskItems = 100
+
+// Desk item outcomes. Skipped items were valid but undone because another
+// item of an all-or-nothing transaction failed.
+const (
+	DeskDone    = "Done"
+	DeskFailed  = "Failed"
+	DeskSkipped = "Skipped"
+)
+
+// DeskItem identifies one book at the desk: a copy by CopyID or Barcode, or
+// a book by BookID to take any copy. A return may name the OrderID instead,
+// and may record the copy's Condition.
+type DeskItem struct {
+	BookID    int              `json:"BookID"`
+	CopyID    *int             `json:"CopyID"`
+	Barcode   string           `json:"Barcode"`
+	OrderID   int              `json:"OrderID"`
+	Condition *ConditionReport `json:"Condition"`
+}
+
+// DeskRequest is the body of POST /orderbook/checkout and /orderbook/return.
+// With AllOrNothing set one failed item undoes the whole transaction;
+// otherwise every item that can be processed is.
+type DeskRequest struct {
+	PersonID     int            `json:"PersonID"`
+	Items        []DeskItem     `json:"Items"`
+	AllOrNothing bool           `json:"AllOrNothing"`
+	Override     *BlockOverride `json:"Override"` // checkout only
+}
+
+// DeskItemResult reports what happened to Items[Item].
+type DeskItemResult struct {
+	Item       int                  `json:"Item"`
+	Status     string               `json:"Status"` // Done, Failed or Skipped
+	Code       string               `json:"Code,omitempty"`
+	Error      string               `json:"Error,omitempty"`
+	Order      *OrderBook           `json:"Order,omitempty"`
+	Assessment *ConditionAssessment `json:"Assessment,omitempty"` // returns with a Condition
+}
+
+// ReceiptLine is one book on a desk receipt.
+type ReceiptLine struct {
+	OrderID    int     `json:"OrderID"`
+	BookID     int     `json:"BookID"`
+	BookName   string  `json:"BookName"`
+	Barcode    *string `json:"Barcode"`
+	DueDate    *string `json:"DueDate,omitempty"`    // checkouts
+	ReturnedOn *string `json:"ReturnedOn,omitempty"` // returns
+}
+
+// DeskReceipt is the patron's receipt for a desk transaction: the books
+// actually checked out or returned and what they owe afterwards.
+type DeskReceipt struct {
+	Action      string         `json:"Action"` // checkout or return
+	PersonID    int            `json:"PersonID"`
+	PatronName  string         `json:"PatronName"`
+	IssuedAt    time.Time      `json:"IssuedAt"`
+	Lines       []ReceiptLine  `json:"Lines"`
+	NextDue     *string        `json:"NextDue,omitempty"` // earliest due date of the books lent
+	Outstanding []helper.Money `json:"Outstanding"`       // unpaid fines, per currency
+}
+
+// deskItemCopy resolves a Barcode to its copy, filling in CopyID and BookID,
+// or completes BookID from CopyID.
+func deskItemCopy(q queryer, item *DeskItem) error {
+	item.Barcode = strings.TrimSpace(item.Barcode)
+	var bookID, copyID int
+	var err error
+	switch {
+	case item.Barcode != "":
+		err = q.QueryRow("SELECT CopyID, BookID FROM BookCopy WHERE Barcode = ?", item.Barcode).Scan(&copyID, &bookID)
+		if err == sql.ErrNoRows {
+			return newRequestError(http.StatusNotFound, "COPY_NOT_FOUND", "no copy has barcode %q", item.Barcode)
+		}
+	case item.CopyID != nil:
+		copyID = *item.CopyID
+		err = q.QueryRow("SELECT BookID FROM BookCopy WHERE CopyID = ?", copyID).Scan(&bookID)
+		if err == sql.ErrNoRows {
+			return newRequestError(http.StatusNotFound, "COPY_NOT_FOUND", "copy %d not found", copyID)
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load copy: %w", err)
+	}
+	if item.CopyID != nil && *item.CopyID != copyID {
+		return newRequestError(http.StatusBadRequest, "COPY_BOOK_MISMATCH", "barcode %q is not copy %d", item.Barcode, *item.CopyID)
+	}
+	if item.BookID != 0 && item.BookID != bookID {
+		return newRequestError(http.StatusBadRequest, "COPY_BOOK_MISMATCH", "copy %d does not belong to book %d", copyID, item.BookID)
+	}
+	item.CopyID, item.BookID = &copyID, bookID
+	return nil
+}
+
+// deskCheckout lends one item to the patron.
+func deskCheckout(q queryer, req *DeskRequest, item DeskItem, today time.Time) (int, *ConditionAssessment, error) {
+	if err := deskItemCopy(q, &item); err != nil {
+		return 0, nil, err
+	}
+	if item.BookID <= 0 {
+		return 0, nil, newRequestError(http.StatusBadRequest, "INVALID_ITEM", "give a BookID, CopyID or Barcode")
+	}
+	order := OrderBook{
+		PersonID:   req.PersonID,
+		BookID:     item.BookID,
+		CopyID:     item.CopyID,
+		BorrowDate: today.Format("2006-01-02"),
+		Status:     "Borrowed",
+		Override:   req.Override,
+	}
+	if err := lendBook(q, &order, today); err != nil {
+		return 0, nil, err
+	}
+	return order.OrderID, nil, nil
+}
+
+// deskReturn finds the patron's open loan of one item and returns it.
+func deskReturn(q queryer, req *DeskRequest, item DeskItem, today time.Time) (int, *ConditionAssessment, error) {
+	orderID := item.OrderID
+	if orderID == 0 {
+		if err := deskItemCopy(q, &item); err != nil {
+			return 0, nil, err
+		}
+		f := &sqlFilter{}
+		f.add("PersonID = ?", req.PersonID)
+		f.add("Status IN ('Borrowed', 'Overdue')")
+		switch {
+		case item.CopyID != nil:
+			f.add("CopyID = ?", *item.CopyID)
+		case item.BookID > 0:
+			f.add("BookID = ?", item.BookID)
+		default:
+			return 0, nil, newRequestError(http.StatusBadRequest, "INVALID_ITEM", "give an OrderID, BookID, CopyID or Barcode")
+		}
+		err := q.QueryRow("SELECT TOP (1) OrderID FROM OrderBook"+f.where()+" ORDER BY BorrowDate, OrderID", f.args...).Scan(&orderID)
+		if err == sql.ErrNoRows {
+			return 0, nil, newRequestError(http.StatusNotFound, "LOAN_NOT_FOUND", "person %d has no open loan of this item", req.PersonID)
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("find loan: %w", err)
+		}
+	} else {
+		var personID int
+		err := q.QueryRow("SELECT PersonID FROM OrderBook WHERE OrderID = ?", orderID).Scan(&personID)
+		if err == sql.ErrNoRows || (err == nil && personID != req.PersonID) {
+			return 0, nil, newRequestError(http.StatusNotFound, "LOAN_NOT_FOUND", "person %d has no order %d", req.PersonID, orderID)
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("load order %d: %w", orderID, err)
+		}
+	}
+	assessment, err := returnLoan(q, orderID, today, item.Condition)
+	return orderID, assessment, err
+}
+
+// runDeskItem processes one item behind a savepoint, so that a failed item
+// leaves no partial changes. Item failures are reported in the result; the
+// error is reserved for database faults.
+func runDeskItem(tx *sql.Tx, i int, do func() (int, *ConditionAssessment, error)) (DeskItemResult, error) {
+	result := DeskItemResult{Item: i}
+	if _, err := tx.Exec("SAVE TRANSACTION desk_item"); err != nil {
+		return result, fmt.Errorf("savepoint for item %d: %w", i, err)
+	}
+	orderID, assessment, err := do()
+	if err == nil {
+		var order OrderBook
+		if err := scanOrder(tx.QueryRow("SELECT "+orderColumns+" FROM OrderBook WHERE OrderID = ?", orderID), &order); err != nil {
+			return result, fmt.Errorf("reload order %d: %w", orderID, err)
+		}
+		result.Status, result.Order, result.Assessment = DeskDone, &order, assessment
+		return result, nil
+	}
+	re, ok := err.(*requestError)
+	if !ok {
+		return result, err
+	}
+	if _, err := tx.Exec("ROLLBACK TRANSACTION desk_item"); err != nil {
+		return result, fmt.Errorf("undo item %d: %w", i, err)
+	}
+	result.Status, result.Code, result.Error = DeskFailed, re.Code, re.Message
+	return result, nil
+}
+
+// runDeskItems processes every item of req in order and counts the ones
+// that failed. When an all-or-nothing request has failures, the items done
+// are reported Skipped: the caller must then roll the transaction back.
+func runDeskItems(tx *sql.Tx, req *DeskRequest, today time.Time, process func(queryer, *DeskRequest, DeskItem, time.Time) (int, *ConditionAssessment, error)) ([]DeskItemResult, int, error) {
+	results := make([]DeskItemResult, len(req.Items))
+	failed := 0
+	for i, item := range req.Items {
+		var err error
+		results[i], err = runDeskItem(tx, i, func() (int, *ConditionAssessment, error) { return process(tx, req, item, today) })
+		if err != nil {
+			return nil, 0, fmt.Errorf("item %d: %w", i, err)
+		}
+		if results[i].Status == DeskFailed {
+			failed++
+		}
+	}
+	if req.AllOrNothing && failed > 0 {
+		for i := range results {
+			if results[i].Status == DeskDone {
+				results[i].Status, results[i].Order, results[i].Assessment = DeskSkipped, nil, nil
+			}
+		}
+	}
+	return results, failed, nil
+}
+
+// deskReceipt lists the orders of the items done, with their books and
+// copies, and the patron's balance once the transaction is applied.
+func deskReceipt(q queryer, action string, personID int, results []DeskItemResult) (DeskReceipt, error) {
+	receipt := DeskReceipt{Action: action, PersonID: personID, IssuedAt: time.Now().UTC(), Lines: []ReceiptLine{}}
+	var first, last sql.NullString
+	if err := q.QueryRow("SELECT First_name, Last_name FROM Person WHERE ID = ?", personID).Scan(&first, &last); err != nil {
+		return receipt, fmt.Errorf("load person %d: %w", personID, err)
+	}
+	receipt.PatronName = strings.TrimSpace(first.String + " " + last.String)
+
+	for _, r := range results {
+		if r.Status != DeskDone {
+			continue
+		}
+		line := ReceiptLine{OrderID: r.Order.OrderID, BookID: r.Order.BookID}
+		var barcode sql.NullString
+		err := q.QueryRow(`
+			SELECT b.bookName, c.Barcode
+			FROM Book b LEFT JOIN BookCopy c ON c.CopyID = ?
+			WHERE b.BookID = ?`, r.Order.CopyID, r.Order.BookID).Scan(&line.BookName, &barcode)
+		if err != nil {
+			return receipt, fmt.Errorf("load book %d: %w", r.Order.BookID, err)
+		}
+		if barcode.Valid {
+			line.Barcode = &barcode.String
+		}
+		if action == "checkout" {
+			line.DueDate = r.Order.ReturnDate
+			if line.DueDate != nil && (receipt.NextDue == nil || *line.DueDate < *receipt.NextDue) {
+				receipt.NextDue = line.DueDate
+			}
+		} else {
+			line.ReturnedOn = r.Order.ActualReturnDate
+		}
+		receipt.Lines = append(receipt.Lines, line)
+	}
+
+	balance, err := outstandingBalance(q, personID)
+	if err != nil {
+		return receipt, err
+	}
+	receipt.Outstanding = balance
+	return receipt, nil
+}
+
+// deskTransaction answers a batch checkout or return: every item is tried
+// in order inside one transaction, then either everything is committed,
+// only the items that succeeded are, or (all-or-nothing with a failure)
+// nothing is. The response is 200 with per-item results and a receipt,
+// or 409 when an all-or-nothing transaction was undone.
+func deskTransaction(action string, process func(queryer, *DeskRequest, DeskItem, time.Time) (int, *ConditionAssessment, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+		fail := "failed to " + action + " books"
+
+		var req DeskRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if req.PersonID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "PersonID must be positive"})
+			return
+		}
+		if len(req.Items) == 0 || len(req.Items) > maxDeskItems {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Items must list 1 to %d books", maxDeskItems)})
+			return
+		}
+		if action != "checkout" {
+			req.Override = nil
+		}
+		if err := checkOverride(c, req.Override); err != nil {
+			respondRequestError(c, err, fail)
+			return
+		}
+		for i := range req.Items {
+			if action != "return" {
+				req.Items[i].Condition = nil
+			}
+			if err := checkConditionReport(c, req.Items[i].Condition); err != nil {
+				respondRequestError(c, err, fail)
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin %s: %v", action, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fail})
+			return
+		}
+		defer tx.Rollback()
+
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM Person WHERE ID = ?", req.PersonID).Scan(&exists); err != nil {
+			log.Printf("check person %d: %v", req.PersonID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fail})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "person not found", "code": "PERSON_NOT_FOUND"})
+			return
+		}
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		results, failed, err := runDeskItems(tx, &req, today, process)
+		if err != nil {
+			log.Printf("%s for person %d: %v", action, req.PersonID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fail})
+			return
+		}
+		if req.AllOrNothing && failed > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   fmt.Sprintf("%d of %d items failed; nothing was applied", failed, len(results)),
+				"code":    "BATCH_FAILED",
+				"results": results,
+			})
+			return
+		}
+
+		receipt, err := deskReceipt(tx, action, req.PersonID, results)
+		if err != nil {
+			log.Printf("%s receipt for person %d: %v", action, req.PersonID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fail})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit %s for person %d: %v", action, req.PersonID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fail})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"done":    len(results) - failed,
+			"failed":  failed,
+			"results": results,
+			"receipt": receipt,
+		})
+	}
+}
+
+// CheckoutBooks answers POST /orderbook/checkout, lending several books to
+// one patron under the same rules as POST /orderbook.
+func CheckoutBooks() gin.HandlerFunc {
+	return deskTransaction("checkout", deskCheckout)
+}
+
+// ReturnBooks answers POST /orderbook/return, closing several of one
+// patron's loans.
+func ReturnBooks() gin.HandlerFunc {
+	return deskTransaction("return", deskReturn)
+}
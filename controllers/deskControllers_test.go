@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// deskOrders scripts the savepoints of desk items and the reload of their
+// orders: order N is person 7's loan of book 10*N.
+func deskOrders(f *fakeDB) {
+	f.on("SELECT "+orderColumns, func(args []driver.Value) fakeResult {
+		id := args[0].(int64)
+		borrowed := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		return fakeResult{
+			cols: []string{"OrderID", "PersonID", "BookID", "CopyID", "BorrowDate", "ReturnDate", "ActualReturnDate", "Status", "RenewalCount"},
+			rows: [][]driver.Value{{id, int64(7), id * 10, nil, borrowed, borrowed.AddDate(0, 0, 14+int(id)), nil, "Borrowed", int64(0)}},
+		}
+	})
+	f.exec("SAVE TRANSACTION desk_item")
+	f.exec("ROLLBACK TRANSACTION desk_item")
+}
+
+func deskTx(t *testing.T, db *sql.DB) *sql.Tx {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+func TestRunDeskItem(t *testing.T) {
+	db, f := newFakeDB(t)
+	deskOrders(f)
+	tx := deskTx(t, db)
+
+	done, err := runDeskItem(tx, 0, func() (int, *ConditionAssessment, error) { return 3, nil, nil })
+	if err != nil {
+		t.Fatalf("runDeskItem: %v", err)
+	}
+	if done.Status != DeskDone || done.Order == nil || done.Order.OrderID != 3 {
+		t.Errorf("done item = %+v", done)
+	}
+	if n := len(f.executed("ROLLBACK TRANSACTION desk_item")); n != 0 {
+		t.Errorf("a done item was rolled back %d times", n)
+	}
+
+	failed, err := runDeskItem(tx, 1, func() (int, *ConditionAssessment, error) {
+		return 0, nil, newRequestError(http.StatusConflict, "NO_COPY_AVAILABLE", "no copy is available")
+	})
+	if err != nil {
+		t.Fatalf("runDeskItem: %v", err)
+	}
+	if failed.Item != 1 || failed.Status != DeskFailed || failed.Code != "NO_COPY_AVAILABLE" || failed.Order != nil {
+		t.Errorf("failed item = %+v", failed)
+	}
+	if n := len(f.executed("ROLLBACK TRANSACTION desk_item")); n != 1 {
+		t.Errorf("failed item rolled back to its savepoint %d times, want 1", n)
+	}
+	if n := len(f.executed("SAVE TRANSACTION desk_item")); n != 2 {
+		t.Errorf("%d savepoints, want one per item", n)
+	}
+
+	fault := errors.New("connection reset")
+	if _, err := runDeskItem(tx, 2, func() (int, *ConditionAssessment, error) { return 0, nil, fault }); !errors.Is(err, fault) {
+		t.Errorf("database fault: err = %v, want it returned", err)
+	}
+}
+
+func TestRunDeskItems(t *testing.T) {
+	// Item 1 fails; the others are lent as orders 1 and 3.
+	process := func(q queryer, req *DeskRequest, item DeskItem, today time.Time) (int, *ConditionAssessment, error) {
+		if item.BookID == 2 {
+			return 0, nil, newRequestError(http.StatusNotFound, "BOOK_NOT_FOUND", "book not found")
+		}
+		return item.BookID, nil, nil
+	}
+	items := []DeskItem{{BookID: 1}, {BookID: 2}, {BookID: 3}}
+	tests := []struct {
+		name         string
+		allOrNothing bool
+		want         []string
+	}{
+		{"best effort", false, []string{DeskDone, DeskFailed, DeskDone}},
+		{"all or nothing", true, []string{DeskSkipped, DeskFailed, DeskSkipped}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, f := newFakeDB(t)
+			deskOrders(f)
+			req := &DeskRequest{PersonID: 7, Items: items, AllOrNothing: tt.allOrNothing}
+			results, failed, err := runDeskItems(deskTx(t, db), req, time.Now(), process)
+			if err != nil {
+				t.Fatalf("runDeskItems: %v", err)
+			}
+			if failed != 1 || len(results) != len(items) {
+				t.Fatalf("failed = %d, results = %+v", failed, results)
+			}
+			for i, r := range results {
+				if r.Item != i || r.Status != tt.want[i] || (r.Order != nil) != (r.Status == DeskDone) {
+					t.Errorf("result %d = %+v, want %s", i, r, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeskReceipt(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.rows("FROM Person", []string{"First_name", "Last_name"}, []driver.Value{"Ada", nil})
+	f.on("SELECT b.bookName", func(args []driver.Value) fakeResult {
+		return fakeResult{cols: []string{"bookName", "Barcode"}, rows: [][]driver.Value{{fmt.Sprintf("Book %d", args[1]), nil}}}
+	})
+	f.rows("FROM FineBookTable", []string{"Currency", "Owed"}, []driver.Value{"USD", "4.5000"})
+
+	due := func(d string) *string { return &d }
+	results := []DeskItemResult{
+		{Item: 0, Status: DeskDone, Order: &OrderBook{OrderID: 1, BookID: 1, ReturnDate: due("2026-03-20")}},
+		{Item: 1, Status: DeskFailed, Code: "NO_COPY_AVAILABLE"},
+		{Item: 2, Status: DeskDone, Order: &OrderBook{OrderID: 2, BookID: 2, ReturnDate: due("2026-03-15")}},
+	}
+	receipt, err := deskReceipt(db, "checkout", 7, results)
+	if err != nil {
+		t.Fatalf("deskReceipt: %v", err)
+	}
+	if receipt.PatronName != "Ada" || len(receipt.Lines) != 2 {
+		t.Fatalf("receipt = %+v", receipt)
+	}
+	if l := receipt.Lines[1]; l.OrderID != 2 || l.BookName != "Book 2" || l.DueDate == nil || *l.DueDate != "2026-03-15" || l.ReturnedOn != nil {
+		t.Errorf("line = %+v", l)
+	}
+	if receipt.NextDue == nil || *receipt.NextDue != "2026-03-15" {
+		t.Errorf("NextDue = %v, want the earliest due date", receipt.NextDue)
+	}
+	if len(receipt.Outstanding) != 1 || receipt.Outstanding[0].String() != "4.50 USD" {
+		t.Errorf("Outstanding = %v", receipt.Outstanding)
+	}
+}
@@ -0,0 +1,369 @@
+package controllers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	database "go-crud-api/config"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Export formats. JSON is a single array; NDJSON is one object per line.
+const (
+	ExportCSV    = "csv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// exportFlushEvery is how many rows are written between flushes to the client.
+const exportFlushEvery = 500
+
+// exportSpec describes one exportable entity: the output column names and a
+// query selecting them in that order. Filters use the list endpoint's
+// parameters and are applied through filter.where().
+type exportSpec struct {
+	columns []string
+	query   func(filter *sqlFilter) string
+	filter  func(q queryer, params url.Values) (*sqlFilter, error)
+}
+
+// exportSpecs are keyed by the name used in /export/:entity. Column names
+// match the JSON of the list endpoints, and book columns are the ones
+// accepted by the book import so an export can be re-imported elsewhere.
+var exportSpecs = map[string]exportSpec{
+	"books": {
+		columns: []string{"bookid", "typeofbook", "bookname", "bookauthorname", "isavailable",
+			"bookquantity", "bookprice", "bookpricecurrency", "isbn10", "isbn13", "categories"},
+		query: func(f *sqlFilter) string {
+			return `
+				SELECT BookID, typeOfBook, bookName, bookAuthorName, isAvailable,
+				       bookQuantity, bookPrice, bookPriceCurrency, isbn10, isbn13,
+				       (SELECT STRING_AGG(c.Name, '; ') WITHIN GROUP (ORDER BY bc.Position)
+				        FROM BookCategory bc JOIN Category c ON c.CategoryID = bc.CategoryID
+				        WHERE bc.BookID = Book.BookID)
+				FROM Book` + f.where() + " ORDER BY BookID"
+		},
+		filter: bookFilter,
+	},
+	// Password, Token and Refresh_Token are never exported
+	"users": {
+		columns: []string{"id", "username", "email", "phonenumber", "first_name", "last_name",
+			"created_at", "updated_at", "user_id"},
+		query: func(f *sqlFilter) string {
+			return `
+				SELECT ID, Username, Email, PhoneNumber, First_name, Last_name,
+				       Created_at, Updated_at, User_id
+				FROM Person` + f.where() + " ORDER BY ID"
+		},
+		filter: func(q queryer, params url.Values) (*sqlFilter, error) { return userFilter(params) },
+	},
+	"orders": {
+		columns: []string{"OrderID", "PersonID", "BookID", "CopyID", "BorrowDate", "ReturnDate",
+			"ActualReturnDate", "Status", "RenewalCount"},
+		query: func(f *sqlFilter) string {
+			return `
+				SELECT ` + orderColumns + `
+				FROM OrderBook` + f.where() + " ORDER BY OrderID"
+		},
+		filter: func(q queryer, params url.Values) (*sqlFilter, error) { return orderFilter(params) },
+	},
+	"fines": {
+		columns: []string{"FineID", "PersonID", "OrderID", "FineTypeID", "FineAmount", "Currency", "AmountPaid", "Status"},
+		query: func(f *sqlFilter) string {
+			return `
+				SELECT FineID, PersonID, OrderID, FineTypeID, FineAmount, Currency, AmountPaid, Status
+				FROM FineBookTable` + f.where() + " ORDER BY FineID"
+		},
+		filter: func(q queryer, params url.Values) (*sqlFilter, error) { return fineBookFilter(params) },
+	},
+}
+
+// exportCursor is an open export query.
+type exportCursor struct {
+	rows    *sql.Rows
+	columns []string
+	dbTypes []string
+}
+
+// openExport validates the entity, format and filters and runs the query, so
+// every client error is known before any output is written.
+func openExport(db *sql.DB, entity, format string, params url.Values) (*exportCursor, error) {
+	spec, ok := exportSpecs[entity]
+	if !ok {
+		return nil, newRequestError(http.StatusNotFound, "UNKNOWN_EXPORT", "cannot export %q; use books, users, orders or fines", entity)
+	}
+	switch format {
+	case ExportCSV, ExportJSON, ExportNDJSON:
+	default:
+		return nil, newRequestError(http.StatusBadRequest, "INVALID_FORMAT", "format must be csv, json or ndjson")
+	}
+	filter, err := spec.filter(db, params)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(spec.query(filter), filter.args...)
+	if err != nil {
+		return nil, fmt.Errorf("export %s: %w", entity, err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("export %s: %w", entity, err)
+	}
+	cursor := &exportCursor{rows: rows, columns: spec.columns, dbTypes: make([]string, len(types))}
+	for i, t := range types {
+		cursor.dbTypes[i] = strings.ToUpper(t.DatabaseTypeName())
+	}
+	return cursor, nil
+}
+
+// stream writes every row to w one at a time, calling flush every
+// exportFlushEvery rows, and returns the number of rows written.
+func (e *exportCursor) stream(w io.Writer, format string, flush func()) (int, error) {
+	defer e.rows.Close()
+
+	out := bufio.NewWriter(w)
+	writer := newExportWriter(out, format, e.columns)
+	if err := writer.begin(); err != nil {
+		return 0, err
+	}
+
+	values := make([]interface{}, len(e.columns))
+	ptrs := make([]interface{}, len(e.columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	count := 0
+	for e.rows.Next() {
+		if err := e.rows.Scan(ptrs...); err != nil {
+			return count, fmt.Errorf("scan export row: %w", err)
+		}
+		for i := range values {
+			values[i] = exportValue(values[i], e.dbTypes[i])
+		}
+		if err := writer.row(values); err != nil {
+			return count, err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			if err := out.Flush(); err != nil {
+				return count, err
+			}
+			if flush != nil {
+				flush()
+			}
+		}
+	}
+	if err := e.rows.Err(); err != nil {
+		return count, fmt.Errorf("read export rows: %w", err)
+	}
+	if err := writer.end(); err != nil {
+		return count, err
+	}
+	return count, out.Flush()
+}
+
+// exportValue converts a driver value into one that marshals sensibly:
+// DATE columns become YYYY-MM-DD, decimals keep their exact digits and
+// uniqueidentifiers their canonical text form.
+func exportValue(v interface{}, dbType string) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if dbType == "DATE" {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	case []byte:
+		switch dbType {
+		case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+			return json.Number(string(v))
+		case "UNIQUEIDENTIFIER":
+			var id mssql.UniqueIdentifier
+			if err := id.Scan(v); err == nil {
+				return id.String()
+			}
+		}
+		return string(v)
+	}
+	return v
+}
+
+// exportWriter renders rows in one export format.
+type exportWriter interface {
+	begin() error
+	row(values []interface{}) error
+	end() error
+}
+
+func newExportWriter(w *bufio.Writer, format string, columns []string) exportWriter {
+	switch format {
+	case ExportCSV:
+		return &csvExportWriter{w: csv.NewWriter(w), columns: columns}
+	case ExportNDJSON:
+		return &jsonExportWriter{w: w, keys: jsonKeys(columns)}
+	}
+	return &jsonExportWriter{w: w, keys: jsonKeys(columns), array: true}
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func (cw *csvExportWriter) begin() error {
+	cw.record = make([]string, len(cw.columns))
+	return cw.w.Write(cw.columns)
+}
+
+func (cw *csvExportWriter) row(values []interface{}) error {
+	for i, v := range values {
+		cw.record[i] = csvValue(v)
+	}
+	// csv.NewWriter reuses the bufio.Writer it is given, so records are
+	// flushed along with it
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvExportWriter) end() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonExportWriter writes objects with keys in column order, either one per
+// line (NDJSON) or as the elements of a single array.
+type jsonExportWriter struct {
+	w     *bufio.Writer
+	keys  [][]byte
+	array bool
+	n     int
+}
+
+func jsonKeys(columns []string) [][]byte {
+	keys := make([][]byte, len(columns))
+	for i, col := range columns {
+		key, _ := json.Marshal(col)
+		keys[i] = append(key, ':')
+	}
+	return keys
+}
+
+func (jw *jsonExportWriter) begin() error {
+	if jw.array {
+		_, err := jw.w.WriteString("[")
+		return err
+	}
+	return nil
+}
+
+func (jw *jsonExportWriter) row(values []interface{}) error {
+	if jw.array && jw.n > 0 {
+		jw.w.WriteByte(',')
+	}
+	if jw.array {
+		jw.w.WriteByte('\n')
+	}
+	jw.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			jw.w.WriteByte(',')
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encode export value: %w", err)
+		}
+		jw.w.Write(jw.keys[i])
+		jw.w.Write(data)
+	}
+	jw.w.WriteByte('}')
+	if !jw.array {
+		jw.w.WriteByte('\n')
+	}
+	jw.n++
+	return nil
+}
+
+func (jw *jsonExportWriter) end() error {
+	if !jw.array {
+		return nil
+	}
+	if jw.n > 0 {
+		jw.w.WriteByte('\n')
+	}
+	_, err := jw.w.WriteString("]\n")
+	return err
+}
+
+// ExportEntity writes every row of entity (books, users, orders or fines)
+// matching params to w in format and returns the number of rows written.
+func ExportEntity(db *sql.DB, w io.Writer, entity, format string, params url.Values) (int, error) {
+	cursor, err := openExport(db, entity, format, params)
+	if err != nil {
+		return 0, err
+	}
+	return cursor.stream(w, format, nil)
+}
+
+// ExportData streams /export/:entity as an attachment. The format query
+// parameter picks csv, json (default) or ndjson; the remaining parameters are
+// the filters of the entity's list endpoint. Rows are written as they are
+// read, so a failure part-way through leaves the output truncated.
+func ExportData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+
+		entity := c.Param("entity")
+		format := strings.ToLower(c.DefaultQuery("format", ExportJSON))
+		cursor, err := openExport(db, entity, format, c.Request.URL.Query())
+		if err != nil {
+			log.Printf("export %s: %v", entity, err)
+			respondRequestError(c, err, "failed to export data")
+			return
+		}
+
+		contentTypes := map[string]string{
+			ExportCSV:    "text/csv; charset=utf-8",
+			ExportJSON:   "application/json; charset=utf-8",
+			ExportNDJSON: "application/x-ndjson",
+		}
+		filename := fmt.Sprintf("%s-%s.%s", entity, time.Now().Format("20060102"), format)
+		c.Header("Content-Type", contentTypes[format])
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Status(http.StatusOK)
+
+		count, err := cursor.stream(c.Writer, format, c.Writer.Flush)
+		if err != nil {
+			log.Printf("export %s stopped after %d rows: %v", entity, count, err)
+		}
+	}
+}
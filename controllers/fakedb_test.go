@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a scripted database/sql driver for tests of the SQL-driven
+// controller logic. Each statement goes to the first handler whose match is
+// contained in it; a statement no handler matches fails the query, so a
+// test notices SQL it did not expect.
+type fakeDB struct {
+	mu       sync.Mutex
+	handlers []fakeHandler
+	calls    []fakeCall
+}
+
+// fakeResult is what a handler answers: rows for a query, RowsAffected for
+// an Exec, or an error.
+type fakeResult struct {
+	cols     []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+type fakeHandler struct {
+	match string
+	fn    func(args []driver.Value) fakeResult
+}
+
+type fakeCall struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB opens a *sql.DB backed by a new fakeDB.
+func newFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+	f := &fakeDB{}
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = f
+	fakeDBsMu.Unlock()
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, t.Name())
+		fakeDBsMu.Unlock()
+	})
+	return db, f
+}
+
+// on answers statements containing match with fn.
+func (f *fakeDB) on(match string, fn func(args []driver.Value) fakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, fakeHandler{match, fn})
+}
+
+// rows answers statements containing match with fixed rows.
+func (f *fakeDB) rows(match string, cols []string, rows ...[]driver.Value) {
+	f.on(match, func([]driver.Value) fakeResult { return fakeResult{cols: cols, rows: rows} })
+}
+
+// exec answers statements containing match as an Exec touching one row.
+func (f *fakeDB) exec(match string) {
+	f.on(match, func([]driver.Value) fakeResult { return fakeResult{affected: 1} })
+}
+
+// executed returns the statements run that contain match, in order.
+func (f *fakeDB) executed(match string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeCall
+	for _, c := range f.calls {
+		if strings.Contains(c.query, match) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (f *fakeDB) run(query string, args []driver.Value) fakeResult {
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{query, args})
+	var fn func([]driver.Value) fakeResult
+	for _, h := range f.handlers {
+		if strings.Contains(query, h.match) {
+			fn = h.fn
+			break
+		}
+	}
+	f.mu.Unlock()
+	if fn == nil {
+		return fakeResult{err: fmt.Errorf("fakedb: unexpected statement %q", strings.Join(strings.Fields(query), " "))}
+	}
+	return fn(args)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("fakedb: no database %q", name)
+	}
+	return &fakeConn{f}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c.db, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	r := s.db.run(s.query, args)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return driver.RowsAffected(r.affected), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	r := s.db.run(s.query, args)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeRows{cols: r.cols, rows: r.rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+func errUnexpectedArgs(args []driver.Value) error {
+	return fmt.Errorf("fakedb: unexpected arguments %v", args)
+}
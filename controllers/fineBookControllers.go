@@ -2,21 +2,73 @@ package controllers
 
 import (
 	"database/sql"
+	"fmt"
 	database "go-crud-api/config"
+	"go-crud-api/helper"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 // FineBook represents the structure of a fine record in the FineBookTable
 type FineBook struct {
-	FineID     int     `json:"FineID"`
-	PersonID   int     `json:"PersonID"`
-	OrderID    int     `json:"OrderID"`
-	FineTypeID int     `json:"FineTypeID"`
-	FineAmount float64 `json:"FineAmount"`
+	FineID     int          `json:"FineID"`
+	PersonID   int          `json:"PersonID"`
+	OrderID    int          `json:"OrderID"`
+	FineTypeID int          `json:"FineTypeID"`
+	FineAmount helper.Money `json:"FineAmount"` // defaults to the fine type's amount
+	AmountPaid helper.Money `json:"AmountPaid"` // Set by POST /finebook/:id/payments
+	Status     string       `json:"Status"`     // Open, Paid, Waived, Void or Credit
+}
+
+// Fine record statuses. Only what is unpaid on Open fines is owed; a Void
+// fine was charged in error, such as for a lost book that was found, and a
+// Credit record, with a negative amount, refunds what was paid on one.
+const (
+	FineOpen   = "Open"
+	FinePaid   = "Paid"
+	FineWaived = "Waived"
+	FineVoid   = "Void"
+	FineCredit = "Credit"
+)
+
+func isFineStatus(s string) bool {
+	return s == FineOpen || s == FinePaid || s == FineWaived || s == FineVoid || s == FineCredit
+}
+
+// fineBookColumns is the column list FineBook queries select, in scanFineBook order.
+const fineBookColumns = "FineID, PersonID, OrderID, FineTypeID, FineAmount, Currency, AmountPaid, Currency, Status"
+
+func scanFineBook(row rowScanner, fine *FineBook) error {
+	return row.Scan(&fine.FineID, &fine.PersonID, &fine.OrderID, &fine.FineTypeID,
+		&fine.FineAmount, fine.FineAmount.CurrencyScanner(),
+		&fine.AmountPaid, fine.AmountPaid.CurrencyScanner(), &fine.Status)
+}
+
+// fineBookAmount settles the amount of a fine record: an omitted amount is
+// the fine type's, and every amount is rounded to its currency.
+func fineBookAmount(q queryer, fine *FineBook) error {
+	if fine.FineAmount.IsZero() && fine.FineTypeID > 0 {
+		var typeAmount helper.Money
+		err := q.QueryRow("SELECT FineAmount, Currency FROM FineTable WHERE FineID = ?", fine.FineTypeID).
+			Scan(&typeAmount, typeAmount.CurrencyScanner())
+		if err == sql.ErrNoRows {
+			return newRequestError(http.StatusBadRequest, "INVALID_FINE", "fine type %d does not exist", fine.FineTypeID)
+		}
+		if err != nil {
+			return fmt.Errorf("load fine type %d: %w", fine.FineTypeID, err)
+		}
+		fine.FineAmount = typeAmount
+	}
+	fine.FineAmount = fine.FineAmount.Round()
+	if fine.PersonID <= 0 || fine.OrderID <= 0 || fine.FineTypeID <= 0 || fine.FineAmount.Sign() <= 0 {
+		return newRequestError(http.StatusBadRequest, "INVALID_FINE", "all fields must be positive")
+	}
+	return nil
 }
 
 // CreateFineBook handles the creation of a new fine record
@@ -37,21 +89,22 @@ func CreateFineBook() gin.HandlerFunc {
 		}
 
 		// Validate input
-		if newFine.PersonID <= 0 || newFine.OrderID <= 0 || newFine.FineTypeID <= 0 || newFine.FineAmount <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "all fields must be positive"})
+		if err := fineBookAmount(db, &newFine); err != nil {
+			respondRequestError(c, err, "failed to create fine record")
 			return
 		}
 
 		// Insert into database
 		insertQuery := `
-            INSERT INTO FineBookTable (PersonID, OrderID, FineTypeID, FineAmount)
-            VALUES (?, ?, ?, ?);
+            INSERT INTO FineBookTable (PersonID, OrderID, FineTypeID, FineAmount, Currency)
+            VALUES (?, ?, ?, ?, ?);
             SELECT SCOPE_IDENTITY() AS FineID;`
 		err := db.QueryRow(insertQuery,
 			newFine.PersonID,
 			newFine.OrderID,
 			newFine.FineTypeID,
 			newFine.FineAmount,
+			newFine.FineAmount.Currency(),
 		).Scan(&newFine.FineID)
 		if err != nil {
 			log.Printf("insert fine: %v", err)
@@ -59,11 +112,14 @@ func CreateFineBook() gin.HandlerFunc {
 			return
 		}
 
+		newFine.AmountPaid = helper.ZeroMoney(newFine.FineAmount.Currency())
+		newFine.Status = FineOpen
 		c.JSON(http.StatusCreated, newFine)
 	}
 }
 
-// GetAllFineBooks retrieves all fine records (top 1000)
+// GetAllFineBooks retrieves fine records (top 1000), optionally filtered by
+// personid, orderid, finetypeid or status
 func GetAllFineBooks() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db := database.Database()
@@ -73,8 +129,14 @@ func GetAllFineBooks() gin.HandlerFunc {
 			return
 		}
 
-		query := `SELECT TOP (1000) FineID, PersonID, OrderID, FineTypeID, FineAmount FROM FineBookTable`
-		rows, err := db.Query(query)
+		filter, err := fineBookFilter(c.Request.URL.Query())
+		if err != nil {
+			respondRequestError(c, err, "failed to get fine records")
+			return
+		}
+
+		query := `SELECT TOP (1000) ` + fineBookColumns + ` FROM FineBookTable` + filter.where()
+		rows, err := db.Query(query, filter.args...)
 		if err != nil {
 			log.Printf("get all fines: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine records"})
@@ -85,14 +147,7 @@ func GetAllFineBooks() gin.HandlerFunc {
 		var fines []FineBook
 		for rows.Next() {
 			var fine FineBook
-			err := rows.Scan(
-				&fine.FineID,
-				&fine.PersonID,
-				&fine.OrderID,
-				&fine.FineTypeID,
-				&fine.FineAmount,
-			)
-			if err != nil {
+			if err := scanFineBook(rows, &fine); err != nil {
 				log.Printf("scan fine: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scan fine"})
 				return
@@ -126,15 +181,9 @@ func GetFineBookByID() gin.HandlerFunc {
 			return
 		}
 
-		query := `SELECT FineID, PersonID, OrderID, FineTypeID, FineAmount FROM FineBookTable WHERE FineID = ?`
+		query := `SELECT ` + fineBookColumns + ` FROM FineBookTable WHERE FineID = ?`
 		var fine FineBook
-		err = db.QueryRow(query, fineID).Scan(
-			&fine.FineID,
-			&fine.PersonID,
-			&fine.OrderID,
-			&fine.FineTypeID,
-			&fine.FineAmount,
-		)
+		err = scanFineBook(db.QueryRow(query, fineID), &fine)
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "fine record not found"})
 			return
@@ -149,7 +198,30 @@ func GetFineBookByID() gin.HandlerFunc {
 	}
 }
 
-// UpdateFineBook handles updating an existing fine record
+// checkFineCurrencyChange locks a fine record and refuses to move it to
+// another currency once anything has been paid on it, since AmountPaid
+// shares the fine's Currency column.
+func checkFineCurrencyChange(q queryer, fineID int, currency string) error {
+	var paid helper.Money
+	err := q.QueryRow("SELECT AmountPaid, Currency FROM FineBookTable WITH (UPDLOCK) WHERE FineID = ?", fineID).
+		Scan(&paid, paid.CurrencyScanner())
+	if err == sql.ErrNoRows {
+		return newRequestError(http.StatusNotFound, "FINE_NOT_FOUND", "fine record not found")
+	}
+	if err != nil {
+		return fmt.Errorf("load fine %d: %w", fineID, err)
+	}
+	if paid.Currency() != currency && !paid.IsZero() {
+		e := newRequestError(http.StatusConflict, "FINE_CURRENCY_LOCKED", "%s has already been paid on fine %d; its currency cannot change", paid, fineID)
+		e.Details = gin.H{"AmountPaid": paid}
+		return e
+	}
+	return nil
+}
+
+// UpdateFineBook handles updating an existing fine record. Payments are kept,
+// and an open or paid fine is reopened or closed to match its new amount. A
+// fine's currency can only change while nothing has been paid on it.
 func UpdateFineBook() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db := database.Database()
@@ -173,21 +245,39 @@ func UpdateFineBook() gin.HandlerFunc {
 		}
 
 		// Validate input
-		if updateFine.PersonID <= 0 || updateFine.OrderID <= 0 || updateFine.FineTypeID <= 0 || updateFine.FineAmount <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "all fields must be positive"})
+		if err := fineBookAmount(db, &updateFine); err != nil {
+			respondRequestError(c, err, "failed to update fine record")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fine record"})
+			return
+		}
+		defer tx.Rollback()
+		if err := checkFineCurrencyChange(tx, fineID, updateFine.FineAmount.Currency()); err != nil {
+			log.Printf("update fine %d: %v", fineID, err)
+			respondRequestError(c, err, "failed to update fine record")
 			return
 		}
 
 		// Update in database
 		updateQuery := `
             UPDATE FineBookTable
-            SET PersonID = ?, OrderID = ?, FineTypeID = ?, FineAmount = ?
+            SET PersonID = ?, OrderID = ?, FineTypeID = ?, FineAmount = ?, Currency = ?,
+                Status = CASE WHEN Status IN ('Waived', 'Void', 'Credit') THEN Status WHEN AmountPaid >= ? THEN 'Paid' ELSE 'Open' END,
+                Closed_at = CASE WHEN Status IN ('Waived', 'Void', 'Credit') THEN Closed_at WHEN AmountPaid >= ? THEN COALESCE(Closed_at, SYSUTCDATETIME()) END
             WHERE FineID = ?`
-		result, err := db.Exec(updateQuery,
+		_, err = tx.Exec(updateQuery,
 			updateFine.PersonID,
 			updateFine.OrderID,
 			updateFine.FineTypeID,
 			updateFine.FineAmount,
+			updateFine.FineAmount.Currency(),
+			updateFine.FineAmount,
+			updateFine.FineAmount,
 			fineID,
 		)
 		if err != nil {
@@ -196,18 +286,330 @@ func UpdateFineBook() gin.HandlerFunc {
 			return
 		}
 
-		rowsAffected, err := result.RowsAffected()
+		if err := scanFineBook(tx.QueryRow(`SELECT `+fineBookColumns+` FROM FineBookTable WHERE FineID = ?`, fineID), &updateFine); err != nil {
+			log.Printf("reload fine %d: %v", fineID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fine record"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit fine %d: %v", fineID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fine record"})
+			return
+		}
+		c.JSON(http.StatusOK, updateFine)
+	}
+}
+
+// FineSummary totals one person's fines, with one amount per currency.
+// Totals are what was charged and Credits what was refunded, as a positive
+// amount, on Credit records.
+type FineSummary struct {
+	PersonID int            `json:"PersonID"`
+	Fines    int            `json:"Fines"`
+	Totals   []helper.Money `json:"Totals"`
+	Credits  []helper.Money `json:"Credits"`
+}
+
+// addMoney adds m to the entry of its currency in totals.
+func addMoney(totals map[string]helper.Money, m helper.Money) error {
+	sum, err := totals[m.Currency()].Add(m)
+	if err != nil {
+		return err
+	}
+	totals[m.Currency()] = sum
+	return nil
+}
+
+// sortedMoney lists the amounts of totals by currency.
+func sortedMoney(totals map[string]helper.Money) []helper.Money {
+	out := make([]helper.Money, 0, len(totals))
+	for _, m := range totals {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Currency() < out[j].Currency() })
+	return out
+}
+
+// summarizeFines totals the fine records filter selects per person, and
+// overall. Void records were charged in error and are left out unless the
+// filter asks for them by status; Credit records are totalled apart.
+func summarizeFines(q queryer, filter *sqlFilter, status string) ([]FineSummary, gin.H, error) {
+	if status == "" {
+		filter.add("Status <> ?", FineVoid)
+	}
+	query := `SELECT PersonID, Currency,
+			COUNT(CASE WHEN Status <> 'Credit' THEN 1 END),
+			SUM(CASE WHEN Status <> 'Credit' THEN FineAmount ELSE 0 END),
+			SUM(CASE WHEN Status = 'Credit' THEN -FineAmount ELSE 0 END)
+		FROM FineBookTable` + filter.where() + `
+		GROUP BY PersonID, Currency ORDER BY PersonID, Currency`
+	rows, err := q.Query(query, filter.args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("summarize fines: %w", err)
+	}
+	defer rows.Close()
+
+	people := []FineSummary{}
+	charged, credited := map[string]helper.Money{}, map[string]helper.Money{}
+	for rows.Next() {
+		var personID, count int
+		var total, credit helper.Money
+		if err := rows.Scan(&personID, total.CurrencyScanner(), &count, &total, &credit); err != nil {
+			return nil, nil, fmt.Errorf("scan fine summary: %w", err)
+		}
+		if err := credit.CurrencyScanner().Scan(total.Currency()); err != nil {
+			return nil, nil, fmt.Errorf("scan fine summary: %w", err)
+		}
+		if len(people) == 0 || people[len(people)-1].PersonID != personID {
+			people = append(people, FineSummary{PersonID: personID, Totals: []helper.Money{}, Credits: []helper.Money{}})
+		}
+		p := &people[len(people)-1]
+		p.Fines += count
+		if count > 0 {
+			p.Totals = append(p.Totals, total)
+			if err := addMoney(charged, total); err != nil {
+				return nil, nil, fmt.Errorf("summarize fines: %w", err)
+			}
+		}
+		if !credit.IsZero() {
+			p.Credits = append(p.Credits, credit)
+			if err := addMoney(credited, credit); err != nil {
+				return nil, nil, fmt.Errorf("summarize fines: %w", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("summarize fines: %w", err)
+	}
+	return people, gin.H{"totals": sortedMoney(charged), "credits": sortedMoney(credited)}, nil
+}
+
+// GetFineBookSummary totals fine records per person, taking the same
+// personid, orderid, finetypeid and status filters as GetAllFineBooks.
+// Amounts in different currencies are never added together: each person,
+// and the overall total, has one entry per currency. Void charges are left
+// out unless asked for with status=Void, and refunds on Credit records are
+// reported under Credits rather than as negative charges.
+func GetFineBookSummary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		params := c.Request.URL.Query()
+		filter, err := fineBookFilter(params)
 		if err != nil {
-			log.Printf("check rows affected: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify update"})
+			respondRequestError(c, err, "failed to summarize fines")
 			return
 		}
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "fine record not found"})
+		people, totals, err := summarizeFines(db, filter, strings.TrimSpace(params.Get("status")))
+		if err != nil {
+			log.Print(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to summarize fines"})
 			return
 		}
+		totals["people"] = people
+		c.JSON(http.StatusOK, totals)
+	}
+}
 
-		updateFine.FineID = fineID
-		c.JSON(http.StatusOK, updateFine)
+// FinePayment is the body of POST /finebook/:id/payments.
+type FinePayment struct {
+	Amount helper.Money `json:"Amount"` // in the fine's currency
+}
+
+// loadOpenFine locks a fine record that can still be paid or waived.
+func loadOpenFine(q queryer, fineID int) (FineBook, error) {
+	var fine FineBook
+	err := scanFineBook(q.QueryRow(`SELECT `+fineBookColumns+` FROM FineBookTable WITH (UPDLOCK) WHERE FineID = ?`, fineID), &fine)
+	if err == sql.ErrNoRows {
+		return fine, newRequestError(http.StatusNotFound, "FINE_NOT_FOUND", "fine record not found")
+	}
+	if err != nil {
+		return fine, fmt.Errorf("load fine %d: %w", fineID, err)
+	}
+	if fine.Status == FineCredit {
+		return fine, newRequestError(http.StatusConflict, "FINE_IS_CREDIT", "fine %d is a credit refunded to the patron, not a charge", fineID)
+	}
+	if fine.Status != FineOpen {
+		return fine, newRequestError(http.StatusConflict, "FINE_CLOSED", "fine %d is already %s", fineID, fine.Status)
+	}
+	return fine, nil
+}
+
+// payFine takes a payment of at most what is still owed on an open fine,
+// closing the fine once it is paid in full.
+func payFine(q queryer, fineID int, amount helper.Money) (FineBook, error) {
+	fine, err := loadOpenFine(q, fineID)
+	if err != nil {
+		return fine, err
+	}
+	amount = amount.Round()
+	if amount.Sign() <= 0 || amount.Currency() != fine.FineAmount.Currency() {
+		return fine, newRequestError(http.StatusBadRequest, "INVALID_PAYMENT", "Amount must be a positive amount in %s", fine.FineAmount.Currency())
+	}
+	owed, err := fine.FineAmount.Sub(fine.AmountPaid)
+	if err != nil {
+		return fine, err
+	}
+	cmp, err := amount.Cmp(owed)
+	if err != nil {
+		return fine, err
+	}
+	if cmp > 0 {
+		e := newRequestError(http.StatusConflict, "OVERPAYMENT", "only %s is owed on fine %d", owed, fineID)
+		e.Details = gin.H{"owed": owed}
+		return fine, e
+	}
+
+	if fine.AmountPaid, err = fine.AmountPaid.Add(amount); err != nil {
+		return fine, err
+	}
+	if cmp == 0 {
+		fine.Status = FinePaid
+	}
+	_, err = q.Exec(`
+		UPDATE FineBookTable
+		SET AmountPaid = ?, Status = ?, Closed_at = CASE WHEN ? = 'Paid' THEN SYSUTCDATETIME() END
+		WHERE FineID = ?`, fine.AmountPaid, fine.Status, fine.Status, fineID)
+	if err != nil {
+		return fine, fmt.Errorf("pay fine %d: %w", fineID, err)
+	}
+	return fine, nil
+}
+
+// PayFineBook answers POST /finebook/:id/payments, returning the fine with
+// its new AmountPaid and Status.
+func PayFineBook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		fineID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || fineID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fine_id"})
+			return
+		}
+
+		var payment FinePayment
+		if err := c.ShouldBindJSON(&payment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pay fine"})
+			return
+		}
+		defer tx.Rollback()
+
+		fine, err := payFine(tx, fineID, payment.Amount)
+		if err != nil {
+			log.Printf("pay fine %d: %v", fineID, err)
+			respondRequestError(c, err, "failed to pay fine")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit payment of fine %d: %v", fineID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pay fine"})
+			return
+		}
+
+		c.JSON(http.StatusOK, fine)
+	}
+}
+
+// WaiveFineBook answers POST /finebook/:id/waive: whatever is still unpaid
+// on an open fine is no longer owed.
+func WaiveFineBook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		fineID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || fineID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid fine_id"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to waive fine"})
+			return
+		}
+		defer tx.Rollback()
+
+		fine, err := loadOpenFine(tx, fineID)
+		if err != nil {
+			log.Printf("waive fine %d: %v", fineID, err)
+			respondRequestError(c, err, "failed to waive fine")
+			return
+		}
+		if _, err := tx.Exec("UPDATE FineBookTable SET Status = 'Waived', Closed_at = SYSUTCDATETIME() WHERE FineID = ?", fineID); err != nil {
+			log.Printf("waive fine %d: %v", fineID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to waive fine"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit waiver of fine %d: %v", fineID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to waive fine"})
+			return
+		}
+
+		fine.Status = FineWaived
+		c.JSON(http.StatusOK, fine)
+	}
+}
+
+// GetFineBalance answers GET /finebook/balance/:personid with what the
+// person owes on open fines and whether that blocks them from borrowing.
+func GetFineBalance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		personID, err := strconv.Atoi(c.Param("personid"))
+		if err != nil || personID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid person_id"})
+			return
+		}
+
+		balance, err := outstandingBalance(db, personID)
+		if err != nil {
+			log.Printf("fine balance of person %d: %v", personID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine balance"})
+			return
+		}
+		block, err := fineBlock(db, personID)
+		if err != nil {
+			log.Printf("fine balance of person %d: %v", personID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fine balance"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"PersonID":  personID,
+			"Balance":   balance,
+			"Threshold": fineBlockThreshold(),
+			"Blocked":   block != nil,
+		})
 	}
 }
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"go-crud-api/helper"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckFineCurrencyChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		row      []driver.Value // AmountPaid, Currency; nil when there is no fine
+		currency string
+		status   int // 0 when the change is allowed
+	}{
+		{"same currency, paid", []driver.Value{"5.0000", "USD"}, "USD", 0},
+		{"new currency, unpaid", []driver.Value{"0.0000", "USD"}, "EUR", 0},
+		{"new currency, paid", []driver.Value{"5.0000", "USD"}, "EUR", http.StatusConflict},
+		{"missing fine", nil, "USD", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, f := newFakeDB(t)
+			var rows [][]driver.Value
+			if tt.row != nil {
+				rows = append(rows, tt.row)
+			}
+			f.rows("FROM FineBookTable WITH (UPDLOCK)", []string{"AmountPaid", "Currency"}, rows...)
+
+			err := checkFineCurrencyChange(db, 1, tt.currency)
+			var reqErr *requestError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Errorf("err = %v, want nil", err)
+			case tt.status != 0 && (!errors.As(err, &reqErr) || reqErr.Status != tt.status):
+				t.Errorf("err = %v, want status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestSummarizeFines(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.on("FROM FineBookTable", func(args []driver.Value) fakeResult {
+		if len(args) != 1 || args[0] != FineVoid {
+			return fakeResult{err: errUnexpectedArgs(args)}
+		}
+		return fakeResult{
+			cols: []string{"PersonID", "Currency", "Fines", "Charged", "Credited"},
+			rows: [][]driver.Value{
+				{int64(1), "USD", int64(2), "25.0000", "0.0000"},
+				{int64(1), "EUR", int64(0), "0.0000", "12.5000"}, // only a refund
+				{int64(2), "USD", int64(1), "5.0000", "3.0000"},
+			},
+		}
+	})
+
+	people, totals, err := summarizeFines(db, &sqlFilter{}, "")
+	if err != nil {
+		t.Fatalf("summarizeFines: %v", err)
+	}
+	if q := f.executed("FROM FineBookTable")[0].query; !strings.Contains(q, "Status <> ?") {
+		t.Errorf("Void records are not excluded: %s", q)
+	}
+	if len(people) != 2 {
+		t.Fatalf("people = %+v", people)
+	}
+	if p := people[0]; p.Fines != 2 || moneyList(p.Totals) != "25.00 USD" || moneyList(p.Credits) != "12.50 EUR" {
+		t.Errorf("person 1 = %+v", p)
+	}
+	if p := people[1]; p.Fines != 1 || moneyList(p.Totals) != "5.00 USD" || moneyList(p.Credits) != "3.00 USD" {
+		t.Errorf("person 2 = %+v", p)
+	}
+	if got := moneyList(totals["totals"].([]helper.Money)); got != "30.00 USD" {
+		t.Errorf("totals = %s", got)
+	}
+	if got := moneyList(totals["credits"].([]helper.Money)); got != "12.50 EUR, 3.00 USD" {
+		t.Errorf("credits = %s", got)
+	}
+}
+
+func TestSummarizeFinesVoidOnRequest(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.rows("FROM FineBookTable", []string{"PersonID", "Currency", "Fines", "Charged", "Credited"})
+	filter := &sqlFilter{}
+	filter.add("Status = ?", FineVoid)
+	if _, _, err := summarizeFines(db, filter, FineVoid); err != nil {
+		t.Fatalf("summarizeFines: %v", err)
+	}
+	if q := f.executed("FROM FineBookTable")[0].query; strings.Contains(q, "Status <> ?") {
+		t.Errorf("status=Void summary still excludes Void records: %s", q)
+	}
+}
+
+func moneyList(ms []helper.Money) string {
+	parts := make([]string, len(ms))
+	for i, m := range ms {
+		parts[i] = m.String()
+	}
+	return strings.Join(parts, ", ")
+}
@@ -2,7 +2,10 @@ package controllers
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	database "go-crud-api/config"
+	"go-crud-api/helper"
 	"log"
 	"net/http"
 	"strconv"
@@ -14,7 +17,34 @@ import (
 type Fine struct {
 	FineID     int
 	NameOfFine string
-	FineAmount float64
+	FineAmount helper.Money
+}
+
+// maxFineAmount caps a fine type's amount, in whatever currency it is set.
+const maxFineAmount = 1000000
+
+// normalizeFineAmount rounds a fine type's amount to its currency and checks
+// it is within 0..maxFineAmount; an absent amount is zero.
+func normalizeFineAmount(amount helper.Money) (helper.Money, error) {
+	if amount.Currency() == "" {
+		return helper.ZeroMoney(""), nil
+	}
+	amount = amount.Round()
+	if amount.Sign() < 0 {
+		return helper.Money{}, errors.New("fine_amount must be non-negative")
+	}
+	limit, err := helper.ParseMoney(strconv.Itoa(maxFineAmount), amount.Currency())
+	if err != nil {
+		return helper.Money{}, err
+	}
+	over, err := amount.Cmp(limit)
+	if err != nil {
+		return helper.Money{}, err
+	}
+	if over > 0 {
+		return helper.Money{}, fmt.Errorf("fine_amount is too large (max %d)", maxFineAmount)
+	}
+	return amount, nil
 }
 
 // CreateFine handles the creation of a new fine
@@ -45,19 +75,17 @@ func CreateFine() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "name_of_fine is too long (max 100 characters)"})
 			return
 		}
-		if newFine.FineAmount < 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "fine_amount must be non-negative"})
-			return
-		}
-		if newFine.FineAmount > 1000000 { // Example upper limit to prevent overflow
-			c.JSON(http.StatusBadRequest, gin.H{"error": "fine_amount is too large (max 1000000)"})
+		amount, err := normalizeFineAmount(newFine.FineAmount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		newFine.FineAmount = amount
 
 		// Check if a fine with the same name already exists
 		var existingID int
 		checkQuery := "SELECT FineID FROM FineTable WHERE NameOfFine = ?"
-		err := db.QueryRow(checkQuery, newFine.NameOfFine).Scan(&existingID)
+		err = db.QueryRow(checkQuery, newFine.NameOfFine).Scan(&existingID)
 		if err == nil {
 			log.Printf("fine with name %s already exists, ID: %d", newFine.NameOfFine, existingID)
 			c.JSON(http.StatusConflict, gin.H{
@@ -73,10 +101,10 @@ func CreateFine() gin.HandlerFunc {
 
 		// Insert the new fine into the database
 		insertQuery := `
-            INSERT INTO FineTable (NameOfFine, FineAmount)
-            VALUES (?, ?);
+            INSERT INTO FineTable (NameOfFine, FineAmount, Currency)
+            VALUES (?, ?, ?);
             SELECT SCOPE_IDENTITY() AS FineID;`
-		err = db.QueryRow(insertQuery, newFine.NameOfFine, newFine.FineAmount).Scan(&newFine.FineID)
+		err = db.QueryRow(insertQuery, newFine.NameOfFine, newFine.FineAmount, newFine.FineAmount.Currency()).Scan(&newFine.FineID)
 		if err != nil {
 			log.Printf("insert fine %s: %v", newFine.NameOfFine, err)
 			if strings.Contains(strings.ToLower(err.Error()), "unique") {
@@ -97,7 +125,7 @@ func GetFines() gin.HandlerFunc {
 		db := database.Database()
 
 		// Fetch all fines from the database
-		query := "SELECT FineID, NameOfFine, FineAmount FROM FineTable"
+		query := "SELECT FineID, NameOfFine, FineAmount, Currency FROM FineTable"
 		rows, err := db.Query(query)
 		if err != nil {
 			log.Printf("fetch fines: %v", err)
@@ -112,7 +140,7 @@ func GetFines() gin.HandlerFunc {
 		// Iterate over the rows and populate the fines slice
 		for rows.Next() {
 			var fine Fine
-			err := rows.Scan(&fine.FineID, &fine.NameOfFine, &fine.FineAmount)
+			err := rows.Scan(&fine.FineID, &fine.NameOfFine, &fine.FineAmount, fine.FineAmount.CurrencyScanner())
 			if err != nil {
 				log.Printf("scan fine: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scan fine"})
@@ -140,9 +168,9 @@ func GetFineById() gin.HandlerFunc {
 		}
 
 		// Fetch the fine from the database
-		query := "SELECT FineID, NameOfFine, FineAmount FROM FineTable WHERE FineID = ?"
+		query := "SELECT FineID, NameOfFine, FineAmount, Currency FROM FineTable WHERE FineID = ?"
 		var fine Fine
-		err = db.QueryRow(query, id).Scan(&fine.FineID, &fine.NameOfFine, &fine.FineAmount)
+		err = db.QueryRow(query, id).Scan(&fine.FineID, &fine.NameOfFine, &fine.FineAmount, fine.FineAmount.CurrencyScanner())
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
@@ -179,9 +207,15 @@ func UpdateFineById() gin.HandlerFunc {
 			return
 		}
 
+		amount, err := normalizeFineAmount(updatedFine.FineAmount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Update the fine in the database
-		query := "UPDATE FineTable SET NameOfFine = ?, FineAmount = ? WHERE FineID = ?"
-		_, err = db.Exec(query, updatedFine.NameOfFine, updatedFine.FineAmount, id)
+		query := "UPDATE FineTable SET NameOfFine = ?, FineAmount = ?, Currency = ? WHERE FineID = ?"
+		_, err = db.Exec(query, updatedFine.NameOfFine, amount, amount.Currency(), id)
 		if err != nil {
 			log.Printf("update fine %d: %v", id, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fine"})
@@ -189,9 +223,9 @@ func UpdateFineById() gin.HandlerFunc {
 		}
 
 		// Return the updated fine
-		query = "SELECT FineID, NameOfFine, FineAmount FROM FineTable WHERE FineID = ?"
+		query = "SELECT FineID, NameOfFine, FineAmount, Currency FROM FineTable WHERE FineID = ?"
 		var fine Fine
-		err = db.QueryRow(query, id).Scan(&fine.FineID, &fine.NameOfFine, &fine.FineAmount)
+		err = db.QueryRow(query, id).Scan(&fine.FineID, &fine.NameOfFine, &fine.FineAmount, fine.FineAmount.CurrencyScanner())
 		if err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "fine not found"})
@@ -0,0 +1,551 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hold statuses. Waiting and Ready holds are active; the rest are closed.
+const (
+	HoldWaiting   = "Waiting"
+	HoldReady     = "Ready"
+	HoldFulfilled = "Fulfilled"
+	HoldExpired   = "Expired"
+	HoldCancelled = "Cancelled"
+)
+
+// defaultHoldPickupDays is how long a Ready hold keeps its copy.
+const defaultHoldPickupDays = 7
+
+// Hold is a patron's place in a book's queue. Position is 1 for the next
+// patron to be served and only set while the hold is Waiting.
+type Hold struct {
+	HoldID   int        `json:"HoldID"`
+	BookID   int        `json:"BookID"`
+	PersonID int        `json:"PersonID"`
+	Status   string     `json:"Status"`
+	CopyID   *int       `json:"CopyID"`  // the copy set aside once Ready
+	OrderID  *int       `json:"OrderID"` // the loan that fulfilled it
+	PlacedAt time.Time  `json:"PlacedAt"`
+	ReadyAt  *time.Time `json:"ReadyAt"`
+	PickupBy *time.Time `json:"PickupBy"`
+	ClosedAt *time.Time `json:"ClosedAt"`
+	Position *int       `json:"Position"`
+}
+
+// HoldInput is the body of POST /book/:id/holds.
+type HoldInput struct {
+	PersonID int `json:"PersonID"`
+}
+
+// holdPositionColumn computes a Waiting hold's place in its queue.
+const holdPositionColumn = `CASE WHEN h.Status = 'Waiting' THEN (
+		SELECT COUNT(*) FROM BookHold w
+		WHERE w.BookID = h.BookID AND w.Status = 'Waiting'
+		  AND (w.Placed_at < h.Placed_at OR (w.Placed_at = h.Placed_at AND w.HoldID <= h.HoldID))) END`
+
+func scanHold(row rowScanner, h *Hold) error {
+	var copyID, orderID, position sql.NullInt64
+	var readyAt, pickupBy, closedAt sql.NullTime
+	if err := row.Scan(&h.HoldID, &h.BookID, &h.PersonID, &h.Status, &copyID, &orderID,
+		&h.PlacedAt, &readyAt, &pickupBy, &closedAt, &position); err != nil {
+		return err
+	}
+	h.CopyID, h.OrderID, h.Position = nullIntPtr(copyID), nullIntPtr(orderID), nullIntPtr(position)
+	h.ReadyAt, h.PickupBy, h.ClosedAt = nullTimePtr(readyAt), nullTimePtr(pickupBy), nullTimePtr(closedAt)
+	return nil
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+func nullTimePtr(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
+
+// holdColumns is the column list hold queries select from BookHold h, in
+// scanHold order.
+const holdColumns = "h.HoldID, h.BookID, h.PersonID, h.Status, h.CopyID, h.OrderID, h.Placed_at, h.Ready_at, h.PickupBy, h.Closed_at, " +
+	holdPositionColumn
+
+func loadHold(q queryer, holdID int) (Hold, error) {
+	var h Hold
+	err := scanHold(q.QueryRow("SELECT "+holdColumns+" FROM BookHold h WHERE h.HoldID = ?", holdID), &h)
+	return h, err
+}
+
+// holdPickupDays reads HOLD_PICKUP_DAYS.
+func holdPickupDays() int {
+	if v := os.Getenv("HOLD_PICKUP_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("ignoring invalid HOLD_PICKUP_DAYS %q", v)
+	}
+	return defaultHoldPickupDays
+}
+
+// shelveCopy is called whenever a copy becomes Available. The copy goes to
+// the oldest Waiting hold on its book, which becomes Ready; with nobody
+// waiting it stays on the shelf. It returns the hold served, if any.
+func shelveCopy(q queryer, copyID int) (*Hold, error) {
+	var bookID int
+	var status string
+	if err := q.QueryRow("SELECT BookID, Status FROM BookCopy WITH (UPDLOCK) WHERE CopyID = ?", copyID).Scan(&bookID, &status); err != nil {
+		return nil, fmt.Errorf("load copy %d: %w", copyID, err)
+	}
+	if status != CopyAvailable {
+		return nil, syncBookInventory(q, bookID)
+	}
+
+	var holdID int
+	err := q.QueryRow(`
+		SELECT TOP (1) HoldID FROM BookHold WITH (UPDLOCK)
+		WHERE BookID = ? AND Status = ?
+		ORDER BY Placed_at, HoldID`, bookID, HoldWaiting).Scan(&holdID)
+	if err == sql.ErrNoRows {
+		return nil, syncBookInventory(q, bookID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find next hold on book %d: %w", bookID, err)
+	}
+
+	// A deadline on a closed day moves to the same time on the next open one
+	now := time.Now().UTC()
+	pickupBy := now.AddDate(0, 0, holdPickupDays())
+	pickupDay, err := nextOpenDay(q, pickupBy)
+	if err != nil {
+		return nil, err
+	}
+	pickupBy = pickupDay.Add(pickupBy.Sub(truncateDay(pickupBy)))
+	if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ?", CopyOnHold, copyID); err != nil {
+		return nil, fmt.Errorf("set copy %d aside: %w", copyID, err)
+	}
+	if _, err := q.Exec("UPDATE BookHold SET Status = ?, CopyID = ?, Ready_at = ?, PickupBy = ? WHERE HoldID = ?",
+		HoldReady, copyID, now, pickupBy, holdID); err != nil {
+		return nil, fmt.Errorf("make hold %d ready: %w", holdID, err)
+	}
+	if err := syncBookInventory(q, bookID); err != nil {
+		return nil, err
+	}
+	hold, err := loadHold(q, holdID)
+	if err != nil {
+		return nil, fmt.Errorf("reload hold %d: %w", holdID, err)
+	}
+	return &hold, nil
+}
+
+// closeHold ends an active hold. A Ready hold's copy is passed on to the
+// next patron in the queue, or goes back on the shelf.
+func closeHold(q queryer, holdID int, status string) (*Hold, error) {
+	var copyID sql.NullInt64
+	var current string
+	err := q.QueryRow("SELECT Status, CopyID FROM BookHold WITH (UPDLOCK) WHERE HoldID = ?", holdID).Scan(&current, &copyID)
+	if err != nil {
+		return nil, fmt.Errorf("load hold %d: %w", holdID, err)
+	}
+	if current != HoldWaiting && current != HoldReady {
+		return nil, newRequestError(http.StatusConflict, "HOLD_CLOSED", "hold %d is already %s", holdID, current)
+	}
+	if _, err := q.Exec("UPDATE BookHold SET Status = ?, Closed_at = SYSUTCDATETIME() WHERE HoldID = ?", status, holdID); err != nil {
+		return nil, fmt.Errorf("close hold %d: %w", holdID, err)
+	}
+	if current != HoldReady || !copyID.Valid {
+		return nil, nil
+	}
+	if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ? AND Status = ?", CopyAvailable, copyID.Int64, CopyOnHold); err != nil {
+		return nil, fmt.Errorf("release held copy %d: %w", copyID.Int64, err)
+	}
+	return shelveCopy(q, int(copyID.Int64))
+}
+
+// checkoutCopy picks the copy for a new loan, honoring holds: a patron
+// whose hold is Ready gets the copy set aside for them (or, if they bring a
+// different Available copy to the desk, that one, and the set-aside copy
+// moves on down the queue). It returns the patron's active hold on the
+// book, 0 if none, to be marked Fulfilled once the loan exists.
+func checkoutCopy(q queryer, personID, bookID int, copyID *int) (int, int, error) {
+	var holdID int
+	var status string
+	var heldCopy sql.NullInt64
+	err := q.QueryRow(`
+		SELECT HoldID, Status, CopyID FROM BookHold WITH (UPDLOCK)
+		WHERE PersonID = ? AND BookID = ? AND Status IN (?, ?)`,
+		personID, bookID, HoldWaiting, HoldReady).Scan(&holdID, &status, &heldCopy)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("load hold of person %d on book %d: %w", personID, bookID, err)
+	}
+
+	if status == HoldReady && heldCopy.Valid && (copyID == nil || *copyID == int(heldCopy.Int64)) {
+		chosen := int(heldCopy.Int64)
+		if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ?", CopyOnLoan, chosen); err != nil {
+			return 0, 0, fmt.Errorf("lend held copy %d: %w", chosen, err)
+		}
+		return chosen, holdID, syncBookInventory(q, bookID)
+	}
+
+	chosen, err := reserveCopy(q, bookID, copyID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if status == HoldReady && heldCopy.Valid {
+		if _, err := q.Exec("UPDATE BookHold SET CopyID = ? WHERE HoldID = ?", chosen, holdID); err != nil {
+			return 0, 0, fmt.Errorf("move hold %d to copy %d: %w", holdID, chosen, err)
+		}
+		if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ?", CopyAvailable, heldCopy.Int64); err != nil {
+			return 0, 0, fmt.Errorf("release held copy %d: %w", heldCopy.Int64, err)
+		}
+		// Fulfil first so shelveCopy does not hand the copy back to this patron
+		if _, err := q.Exec("UPDATE BookHold SET Status = ?, Closed_at = SYSUTCDATETIME() WHERE HoldID = ?", HoldFulfilled, holdID); err != nil {
+			return 0, 0, fmt.Errorf("fulfil hold %d: %w", holdID, err)
+		}
+		if _, err := shelveCopy(q, int(heldCopy.Int64)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return chosen, holdID, nil
+}
+
+// fulfillHold records the loan that satisfied a hold.
+func fulfillHold(q queryer, holdID, orderID int) error {
+	_, err := q.Exec("UPDATE BookHold SET Status = ?, OrderID = ?, Closed_at = COALESCE(Closed_at, SYSUTCDATETIME()) WHERE HoldID = ?",
+		HoldFulfilled, orderID, holdID)
+	if err != nil {
+		return fmt.Errorf("fulfil hold %d: %w", holdID, err)
+	}
+	return nil
+}
+
+// ExpireHolds closes Ready holds whose pickup deadline has passed and hands
+// each copy to the next patron in line. It returns the number expired.
+func ExpireHolds(db *sql.DB) (int64, error) {
+	rows, err := db.Query("SELECT HoldID FROM BookHold WHERE Status = ? AND PickupBy < SYSUTCDATETIME()", HoldReady)
+	if err != nil {
+		return 0, fmt.Errorf("find expired holds: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan expired hold: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("find expired holds: %w", err)
+	}
+
+	var expired int64
+	for _, id := range ids {
+		if err := expireHold(db, id); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// expireHold expires one hold in its own transaction, re-checking the
+// deadline in case the patron collected the copy meanwhile.
+func expireHold(db *sql.DB, holdID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin hold expiry: %w", err)
+	}
+	defer tx.Rollback()
+	var due int
+	err = tx.QueryRow("SELECT COUNT(1) FROM BookHold WITH (UPDLOCK) WHERE HoldID = ? AND Status = ? AND PickupBy < SYSUTCDATETIME()",
+		holdID, HoldReady).Scan(&due)
+	if err != nil {
+		return fmt.Errorf("load hold %d: %w", holdID, err)
+	}
+	if due == 0 {
+		return nil
+	}
+	if _, err := closeHold(tx, holdID, HoldExpired); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PlaceHold answers POST /book/:id/holds, adding the patron to the end of
+// the book's queue. Holds are for books with no copy on the shelf.
+func PlaceHold() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+		var input HoldInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if input.PersonID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "PersonID must be positive"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin hold: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to place hold"})
+			return
+		}
+		defer tx.Rollback()
+
+		holdID, err := placeHold(tx, bookID, input.PersonID)
+		if err != nil {
+			log.Printf("place hold on book %d: %v", bookID, err)
+			respondRequestError(c, err, "failed to place hold")
+			return
+		}
+		hold, err := loadHold(tx, holdID)
+		if err != nil {
+			log.Printf("reload hold %d: %v", holdID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to place hold"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit hold: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to place hold"})
+			return
+		}
+		c.JSON(http.StatusCreated, hold)
+	}
+}
+
+func placeHold(q queryer, bookID, personID int) (int, error) {
+	var exists int
+	if err := q.QueryRow("SELECT COUNT(1) FROM Person WHERE ID = ?", personID).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("check person %d: %w", personID, err)
+	}
+	if exists == 0 {
+		return 0, newRequestError(http.StatusBadRequest, "PERSON_NOT_FOUND", "Person %d does not exist", personID)
+	}
+
+	// Lock the book so two patrons cannot both see it unavailable while a
+	// copy is being shelved
+	var available bool
+	if err := q.QueryRow("SELECT isAvailable FROM Book WITH (UPDLOCK) WHERE BookID = ?", bookID).Scan(&available); err != nil {
+		return 0, fmt.Errorf("load book %d: %w", bookID, err)
+	}
+	if available {
+		return 0, newRequestError(http.StatusConflict, "COPY_AVAILABLE", "a copy of book %d is on the shelf; borrow it instead", bookID)
+	}
+
+	var open int
+	err := q.QueryRow("SELECT COUNT(1) FROM OrderBook WHERE PersonID = ? AND BookID = ? AND Status IN ('Borrowed', 'Overdue')",
+		personID, bookID).Scan(&open)
+	if err != nil {
+		return 0, fmt.Errorf("check loans of person %d: %w", personID, err)
+	}
+	if open > 0 {
+		return 0, newRequestError(http.StatusConflict, "ALREADY_ON_LOAN", "person %d already has book %d on loan", personID, bookID)
+	}
+
+	policy, err := policyFor(q, personID, bookID)
+	if err != nil {
+		return 0, err
+	}
+	if !policy.HoldsAllowed {
+		return 0, newRequestError(http.StatusConflict, "HOLDS_NOT_ALLOWED", "person %d may not place holds on book %d", personID, bookID)
+	}
+
+	var existingID int
+	err = q.QueryRow("SELECT HoldID FROM BookHold WHERE BookID = ? AND PersonID = ? AND Status IN (?, ?)",
+		bookID, personID, HoldWaiting, HoldReady).Scan(&existingID)
+	if err == nil {
+		e := newRequestError(http.StatusConflict, "DUPLICATE_HOLD", "person %d already holds book %d", personID, bookID)
+		e.Details = gin.H{"existingID": existingID}
+		return 0, e
+	} else if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("check holds of person %d: %w", personID, err)
+	}
+
+	var holdID int
+	err = q.QueryRow(`
+		INSERT INTO BookHold (BookID, PersonID) VALUES (?, ?);
+		SELECT SCOPE_IDENTITY();`, bookID, personID).Scan(&holdID)
+	if err != nil {
+		return 0, fmt.Errorf("insert hold: %w", err)
+	}
+	return holdID, nil
+}
+
+// holdFilter reads the hold list filters: bookid, personid and status
+// (a hold status, or active for Waiting and Ready).
+func holdFilter(params url.Values) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	for _, col := range [][2]string{{"bookid", "h.BookID"}, {"personid", "h.PersonID"}} {
+		if err := intFilter(f, params, col[0], col[1]); err != nil {
+			return nil, err
+		}
+	}
+	switch v := strings.TrimSpace(params.Get("status")); v {
+	case "":
+	case "active":
+		f.add("h.Status IN (?, ?)", HoldWaiting, HoldReady)
+	case HoldWaiting, HoldReady, HoldFulfilled, HoldExpired, HoldCancelled:
+		f.add("h.Status = ?", v)
+	default:
+		return nil, invalidFilter("status", v)
+	}
+	return f, nil
+}
+
+func listHolds(c *gin.Context, q queryer, filter *sqlFilter) {
+	rows, err := q.Query("SELECT TOP (1000) "+holdColumns+" FROM BookHold h"+filter.where()+" ORDER BY h.Placed_at, h.HoldID", filter.args...)
+	if err != nil {
+		log.Printf("list holds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get holds"})
+		return
+	}
+	defer rows.Close()
+	holds := []Hold{}
+	for rows.Next() {
+		var h Hold
+		if err := scanHold(rows, &h); err != nil {
+			log.Printf("scan hold: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get holds"})
+			return
+		}
+		holds = append(holds, h)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("list holds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get holds"})
+		return
+	}
+	c.JSON(http.StatusOK, holds)
+}
+
+// GetBookHolds lists a book's queue: its active holds in the order they will
+// be served, or other holds with ?status=.
+func GetBookHolds() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+		params := c.Request.URL.Query()
+		params.Set("bookid", strconv.Itoa(bookID))
+		if params.Get("status") == "" {
+			params.Set("status", "active")
+		}
+		filter, err := holdFilter(params)
+		if err != nil {
+			respondRequestError(c, err, "failed to get holds")
+			return
+		}
+		listHolds(c, db, filter)
+	}
+}
+
+// GetHolds lists holds filtered by bookid, personid and status.
+func GetHolds() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		filter, err := holdFilter(c.Request.URL.Query())
+		if err != nil {
+			respondRequestError(c, err, "failed to get holds")
+			return
+		}
+		listHolds(c, db, filter)
+	}
+}
+
+// GetHoldByID returns one hold.
+func GetHoldByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		holdID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || holdID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hold_id"})
+			return
+		}
+		hold, err := loadHold(db, holdID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "hold not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("get hold %d: %v", holdID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve hold"})
+			return
+		}
+		c.JSON(http.StatusOK, hold)
+	}
+}
+
+// CancelHold cancels an active hold. A copy set aside for it goes to the
+// next patron in the queue.
+func CancelHold() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		holdID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || holdID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hold_id"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin cancel hold: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel hold"})
+			return
+		}
+		defer tx.Rollback()
+
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM BookHold WHERE HoldID = ?", holdID).Scan(&exists); err != nil {
+			log.Printf("check hold %d: %v", holdID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel hold"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "hold not found"})
+			return
+		}
+		next, err := closeHold(tx, holdID, HoldCancelled)
+		if err != nil {
+			log.Printf("cancel hold %d: %v", holdID, err)
+			respondRequestError(c, err, "failed to cancel hold")
+			return
+		}
+		hold, err := loadHold(tx, holdID)
+		if err != nil {
+			log.Printf("reload hold %d: %v", holdID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel hold"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit cancel hold %d: %v", holdID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel hold"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"hold": hold, "next": next})
+	}
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// IdempotencyHeader names the client's key for a POST that must not run twice.
+const IdempotencyHeader = "Idempotency-Key"
+
+// Limits on idempotency keys. While its first request runs, a key's
+// heartbeat is refreshed every idempotencyHeartbeat; a key whose heartbeat
+// stopped, because the server went down mid-request, is abandoned after
+// idempotencyAbandonAfter so that the client can retry it. A request that
+// is merely slow keeps its key however long it runs.
+const (
+	maxIdempotencyKeyLen    = 255
+	defaultIdempotencyTTL   = 24 * time.Hour
+	idempotencyHeartbeat    = time.Minute
+	idempotencyAbandonAfter = 5 * time.Minute
+)
+
+// defaultIdempotentMaxBody caps the body of a request with an idempotency
+// key, which is read into memory to fingerprint it. It leaves room for the
+// largest upload a handler accepts.
+const defaultIdempotentMaxBody = maxImportBytes + 1<<20
+
+// idempotencyExempt lists the routes whose responses carry credentials and
+// are never stored: sign-up and login.
+var idempotencyExempt = map[string]bool{
+	"/user":       true,
+	"/user/login": true,
+}
+
+// idempotencyTTL reads IDEMPOTENCY_TTL, such as "24h".
+func idempotencyTTL() time.Duration {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("ignoring invalid IDEMPOTENCY_TTL %q", v)
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotentMaxBody reads IDEMPOTENCY_MAX_BODY_BYTES.
+func idempotentMaxBody() int64 {
+	if v := os.Getenv("IDEMPOTENCY_MAX_BODY_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("ignoring invalid IDEMPOTENCY_MAX_BODY_BYTES %q", v)
+	}
+	return defaultIdempotentMaxBody
+}
+
+// idempotencyRecorder keeps a copy of the response body as it is written.
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyHash fingerprints a request: its method, path, query and body.
+func idempotencyHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyCaller returns who a request is from, the uid of its token
+// header, so that keys are scoped to the caller. Requests without a valid
+// token share the anonymous caller "".
+func idempotencyCaller(c *gin.Context) string {
+	token := c.GetHeader("token")
+	if token == "" {
+		return ""
+	}
+	claims, msg := helper.ValidateToken(token)
+	if msg != "" {
+		return ""
+	}
+	return claims.Uid
+}
+
+// isDuplicateKey reports a primary key or unique index violation.
+func isDuplicateKey(err error) bool {
+	var me mssql.Error
+	return errors.As(err, &me) && (me.Number == 2627 || me.Number == 2601)
+}
+
+// storedRequest is what is kept under an idempotency key. Status is nil
+// while the first request is still running.
+type storedRequest struct {
+	Hash        string
+	Status      *int
+	ContentType string
+	Body        []byte
+}
+
+// claimIdempotencyKey records caller's key as in progress for a request with
+// hash. If the key is already taken, it returns what is stored under it
+// instead.
+func claimIdempotencyKey(db *sql.DB, caller, key, hash string, ttl time.Duration) (*storedRequest, error) {
+	now := time.Now().UTC()
+	_, err := db.Exec(`DELETE FROM IdempotencyKey WHERE Caller = ? AND IdempotencyKey = ?
+		AND (Expires_at < ? OR (ResponseStatus IS NULL AND Heartbeat_at < ?))`,
+		caller, key, now, now.Add(-idempotencyAbandonAfter))
+	if err != nil {
+		return nil, fmt.Errorf("clear stale key: %w", err)
+	}
+	_, err = db.Exec("INSERT INTO IdempotencyKey (Caller, IdempotencyKey, RequestHash, Heartbeat_at, Expires_at) VALUES (?, ?, ?, ?, ?)",
+		caller, key, hash, now, now.Add(ttl))
+	if err == nil {
+		return nil, nil
+	}
+	if !isDuplicateKey(err) {
+		return nil, fmt.Errorf("store key: %w", err)
+	}
+
+	var stored storedRequest
+	var status sql.NullInt64
+	var contentType sql.NullString
+	err = db.QueryRow("SELECT RequestHash, ResponseStatus, ContentType, ResponseBody FROM IdempotencyKey WHERE Caller = ? AND IdempotencyKey = ?", caller, key).
+		Scan(&stored.Hash, &status, &contentType, &stored.Body)
+	if err == sql.ErrNoRows {
+		// The first request failed and released the key in between
+		return nil, newRequestError(http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "a request with this idempotency key has just finished; retry")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load key: %w", err)
+	}
+	if status.Valid {
+		n := int(status.Int64)
+		stored.Status = &n
+	}
+	stored.ContentType = contentType.String
+	return &stored, nil
+}
+
+// keepIdempotencyKeyAlive refreshes the heartbeat of caller's key until done
+// is closed.
+func keepIdempotencyKeyAlive(db *sql.DB, caller, key string, done <-chan struct{}) {
+	ticker := time.NewTicker(idempotencyHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := db.Exec("UPDATE IdempotencyKey SET Heartbeat_at = ? WHERE Caller = ? AND IdempotencyKey = ? AND ResponseStatus IS NULL",
+				time.Now().UTC(), caller, key)
+			if err != nil {
+				log.Printf("refresh idempotency key %q: %v", key, err)
+			}
+		}
+	}
+}
+
+// Idempotent makes POST requests that carry an Idempotency-Key header safe
+// to retry. The first request with a key runs normally and its response is
+// kept for IDEMPOTENCY_TTL; a retry with the same key and the same request
+// gets that response again, marked with an Idempotent-Replayed header,
+// without running the handler. Reusing a key for a different request is
+// answered 422, and a retry while the first request is still running 409.
+// Server errors are not kept, so a request that failed can be retried.
+// Keys belong to the user of the token header, so two users can use the
+// same key. Bodies are limited to IDEMPOTENCY_MAX_BODY_BYTES, and sign-up
+// and login ignore the header.
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(IdempotencyHeader))
+		if c.Request.Method != http.MethodPost || key == "" || idempotencyExempt[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyHeader, maxIdempotencyKeyLen),
+				"code":  "INVALID_IDEMPOTENCY_KEY",
+			})
+			return
+		}
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		maxBody := idempotentMaxBody()
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+		if err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+					"error": fmt.Sprintf("requests with an %s are limited to %d bytes", IdempotencyHeader, maxBody),
+					"code":  "IDEMPOTENT_BODY_TOO_LARGE",
+				})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := idempotencyHash(c.Request, body)
+
+		caller := idempotencyCaller(c)
+		stored, err := claimIdempotencyKey(db, caller, key, hash, idempotencyTTL())
+		if err != nil {
+			log.Printf("idempotency key %q: %v", key, err)
+			respondRequestError(c, err, "failed to check idempotency key")
+			c.Abort()
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.Hash != hash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "idempotency key was already used for a different request",
+					"code":  "IDEMPOTENCY_KEY_REUSED",
+				})
+			case stored.Status == nil:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+					"error": "a request with this idempotency key is still in progress",
+					"code":  "IDEMPOTENCY_KEY_IN_USE",
+				})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(*stored.Status, stored.ContentType, stored.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+		go keepIdempotencyKeyAlive(db, caller, key, done)
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			if _, err := db.Exec("DELETE FROM IdempotencyKey WHERE Caller = ? AND IdempotencyKey = ?", caller, key); err != nil {
+				log.Printf("release idempotency key %q: %v", key, err)
+			}
+			return
+		}
+		_, err = db.Exec("UPDATE IdempotencyKey SET ResponseStatus = ?, ContentType = ?, ResponseBody = ? WHERE Caller = ? AND IdempotencyKey = ?",
+			recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes(), caller, key)
+		if err != nil {
+			log.Printf("store response for idempotency key %q: %v", key, err)
+		}
+	}
+}
+
+// PurgeIdempotencyKeys deletes stored responses whose TTL has passed.
+func PurgeIdempotencyKeys(db *sql.DB) (int64, error) {
+	res, err := db.Exec("DELETE FROM IdempotencyKey WHERE Expires_at < SYSUTCDATETIME()")
+	if err != nil {
+		return 0, fmt.Errorf("purge idempotency keys: %w", err)
+	}
+	return res.RowsAffected()
+}
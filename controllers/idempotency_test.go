@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"go-crud-api/helper"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Sign-up and login never reach the key store, so this runs without a
+// database.
+func TestIdempotentExemptRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Idempotent())
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"token": "secret"}) }
+	router.POST("/user", ok)
+	router.POST("/user/login", ok)
+
+	for _, path := range []string{"/user", "/user/login"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"email":"a@example.org"}`))
+		req.Header.Set(IdempotencyHeader, "key-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("POST %s: status %d, body %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestIdempotentMaxBody(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int64
+	}{
+		{"", defaultIdempotentMaxBody},
+		{"1048576", 1 << 20},
+		{"0", defaultIdempotentMaxBody},
+		{"-5", defaultIdempotentMaxBody},
+		{"20MB", defaultIdempotentMaxBody},
+	}
+	for _, tt := range tests {
+		t.Setenv("IDEMPOTENCY_MAX_BODY_BYTES", tt.env)
+		if got := idempotentMaxBody(); got != tt.want {
+			t.Errorf("IDEMPOTENCY_MAX_BODY_BYTES=%q: got %d, want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestIdempotencyCaller(t *testing.T) {
+	token, _, err := helper.GenerateAllTokens("a@example.org", "Ada", "Lovelace", "u-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{"": "", token: "u-1", "not-a-token": ""} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/orderbook", nil)
+		if header != "" {
+			c.Request.Header.Set("token", header)
+		}
+		if got := idempotencyCaller(c); got != want {
+			t.Errorf("token %q: caller = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.exec("DELETE FROM IdempotencyKey")
+	f.on("INSERT INTO IdempotencyKey", func(args []driver.Value) fakeResult {
+		switch args[1] {
+		case "taken":
+			return fakeResult{err: mssql.Error{Number: 2627, Message: "Violation of PRIMARY KEY constraint"}}
+		case "broken":
+			return fakeResult{err: mssql.Error{Number: 8152, Message: "String or binary data would be truncated"}}
+		}
+		return fakeResult{affected: 1}
+	})
+	f.on("SELECT RequestHash", func(args []driver.Value) fakeResult {
+		if args[0] != "u-1" || args[1] != "taken" {
+			return fakeResult{err: errUnexpectedArgs(args)}
+		}
+		return fakeResult{
+			cols: []string{"RequestHash", "ResponseStatus", "ContentType", "ResponseBody"},
+			rows: [][]driver.Value{{"h", int64(201), "application/json", []byte(`{}`)}},
+		}
+	})
+
+	stored, err := claimIdempotencyKey(db, "u-1", "new", "h", time.Hour)
+	if err != nil || stored != nil {
+		t.Fatalf("new key: stored %+v, err %v", stored, err)
+	}
+	if args := f.executed("INSERT INTO IdempotencyKey")[0].args; args[0] != "u-1" {
+		t.Errorf("key stored for caller %v, want u-1", args[0])
+	}
+
+	stored, err = claimIdempotencyKey(db, "u-1", "taken", "h", time.Hour)
+	if err != nil || stored == nil || stored.Status == nil || *stored.Status != http.StatusCreated {
+		t.Fatalf("taken key: stored %+v, err %v", stored, err)
+	}
+
+	if _, err := claimIdempotencyKey(db, "u-1", "broken", "h", time.Hour); err == nil {
+		t.Error("another insert error was taken for a duplicate key")
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"fmt"
+	"go-crud-api/helper"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlFilter collects the WHERE conditions of a list or export query built
+// from query-string parameters.
+type sqlFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+func (f *sqlFilter) add(cond string, args ...interface{}) {
+	f.conds = append(f.conds, cond)
+	f.args = append(f.args, args...)
+}
+
+// where returns the WHERE clause, or "" when no filter was given.
+func (f *sqlFilter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+func invalidFilter(name, value string) error {
+	return newRequestError(http.StatusBadRequest, "INVALID_FILTER", "invalid value %q for filter %s", value, name)
+}
+
+// intFilter adds "column = ?" when params carries a positive integer for name.
+func intFilter(f *sqlFilter, params url.Values, name, column string) error {
+	v := strings.TrimSpace(params.Get(name))
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return invalidFilter(name, v)
+	}
+	f.add(column+" = ?", n)
+	return nil
+}
+
+// dateFilter adds "column op ?" when params carries a YYYY-MM-DD date for name.
+func dateFilter(f *sqlFilter, params url.Values, name, column, op string) error {
+	v := strings.TrimSpace(params.Get(name))
+	if v == "" {
+		return nil
+	}
+	d, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return invalidFilter(name, v)
+	}
+	f.add(column+" "+op+" ?", d)
+	return nil
+}
+
+// bookFilter reads the GET /book filters: name (substring), author (matched
+// on the normalized name), type (a category and everything below it),
+// isAvailable and isbn. They mirror the /book/name, /book/author, /book/type,
+// /book/isAvailable and /book/isbn lookups.
+func bookFilter(q queryer, params url.Values) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	if v := strings.TrimSpace(params.Get("name")); v != "" {
+		f.add("LOWER(bookName) LIKE LOWER(?)", "%"+v+"%")
+	}
+	if v := strings.TrimSpace(params.Get("author")); v != "" {
+		f.add(`BookID IN (
+			SELECT ba.BookID FROM BookAuthor ba JOIN Author a ON a.AuthorID = ba.AuthorID
+			WHERE a.NormalizedName LIKE ?)`, "%"+normalizeAuthorName(v)+"%")
+	}
+	if v := strings.TrimSpace(params.Get("type")); v != "" {
+		categoryID, found, err := resolveCategory(q, v)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			f.add("1 = 0")
+		} else {
+			ids, err := categorySubtreeIDs(q, categoryID)
+			if err != nil {
+				return nil, err
+			}
+			placeholders := make([]string, len(ids))
+			args := make([]interface{}, len(ids))
+			for i, id := range ids {
+				placeholders[i] = "?"
+				args[i] = id
+			}
+			f.add("BookID IN (SELECT BookID FROM BookCategory WHERE CategoryID IN ("+strings.Join(placeholders, ", ")+"))", args...)
+		}
+	}
+	if v := strings.TrimSpace(params.Get("isAvailable")); v != "" {
+		switch strings.ToLower(v) {
+		case "true", "1":
+			f.add("isAvailable = 1")
+		case "false", "0":
+			f.add("isAvailable = 0")
+		default:
+			return nil, invalidFilter("isAvailable", v)
+		}
+	}
+	if v := strings.TrimSpace(params.Get("isbn")); v != "" {
+		parsed, err := helper.ParseISBN(v)
+		if err != nil {
+			return nil, invalidFilter("isbn", v)
+		}
+		f.add("isbn13 = ?", parsed.ISBN13)
+	}
+	return f, nil
+}
+
+// categorySubtreeIDs returns categoryID and the IDs of all its descendants.
+func categorySubtreeIDs(q queryer, categoryID int) ([]int, error) {
+	rows, err := q.Query(categorySubtreeCTE+" SELECT CategoryID FROM Tree", categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("load subtree of category %d: %w", categoryID, err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan subtree of category %d: %w", categoryID, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// userFilter reads the GET /user filters: username and email (exact) and q,
+// a substring of the username, email or name.
+func userFilter(params url.Values) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	if v := strings.TrimSpace(params.Get("username")); v != "" {
+		f.add("Username = ?", v)
+	}
+	if v := strings.TrimSpace(params.Get("email")); v != "" {
+		f.add("Email = ?", v)
+	}
+	if v := strings.TrimSpace(params.Get("q")); v != "" {
+		like := "%" + strings.ToLower(v) + "%"
+		f.add(`(LOWER(Username) LIKE ? OR LOWER(Email) LIKE ?
+			OR LOWER(First_name) LIKE ? OR LOWER(Last_name) LIKE ?)`, like, like, like, like)
+	}
+	return f, nil
+}
+
+// orderFilter reads the GET /orderbook filters: personid, bookid, copyid,
+// status and a borrowFrom/borrowTo date range.
+func orderFilter(params url.Values) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	for _, col := range [][2]string{{"personid", "PersonID"}, {"bookid", "BookID"}, {"copyid", "CopyID"}} {
+		if err := intFilter(f, params, col[0], col[1]); err != nil {
+			return nil, err
+		}
+	}
+	if v := strings.TrimSpace(params.Get("status")); v != "" {
+		if !isValidStatus(v) {
+			return nil, invalidFilter("status", v)
+		}
+		f.add("Status = ?", v)
+	}
+	if err := dateFilter(f, params, "borrowFrom", "BorrowDate", ">="); err != nil {
+		return nil, err
+	}
+	if err := dateFilter(f, params, "borrowTo", "BorrowDate", "<="); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// fineBookFilter reads the GET /finebook filters: personid, orderid,
+// finetypeid and status.
+func fineBookFilter(params url.Values) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	for _, col := range [][2]string{{"personid", "PersonID"}, {"orderid", "OrderID"}, {"finetypeid", "FineTypeID"}} {
+		if err := intFilter(f, params, col[0], col[1]); err != nil {
+			return nil, err
+		}
+	}
+	if v := strings.TrimSpace(params.Get("status")); v != "" {
+		if !isFineStatus(v) {
+			return nil, invalidFilter("status", v)
+		}
+		f.add("Status = ?", v)
+	}
+	return f, nil
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Loan outcomes besides a normal return. Both close the loan and charge the
+// patron; only a Lost loan can later be returned as found.
+const (
+	OrderLost    = "Lost"
+	OrderDamaged = "Damaged"
+)
+
+func isLossStatus(status string) bool {
+	return status == OrderLost || status == OrderDamaged
+}
+
+// Codes of the fine types seeded for lost and damaged loans. The
+// replacement types are priced from the book; the processing fee is its
+// FineTable amount.
+const (
+	lostReplacementFine    = "LOST_REPLACEMENT"
+	damagedReplacementFine = "DAMAGED_REPLACEMENT"
+	processingFeeFine      = "LOSS_PROCESSING_FEE"
+)
+
+// seededFineType returns the ID and amount of the fine type a migration
+// seeded with code. Codes are not editable, unlike names.
+func seededFineType(q queryer, code string) (int, helper.Money, error) {
+	var id int
+	var amount helper.Money
+	err := q.QueryRow("SELECT FineID, FineAmount, Currency FROM FineTable WHERE Code = ?", code).
+		Scan(&id, &amount, amount.CurrencyScanner())
+	if err == sql.ErrNoRows {
+		return 0, amount, fmt.Errorf("fine type %s is missing", code)
+	}
+	if err != nil {
+		return 0, amount, fmt.Errorf("load fine type %s: %w", code, err)
+	}
+	return id, amount, nil
+}
+
+// insertFine adds an open fine record or, when fine.Status is Credit, a
+// refund, which is closed as soon as it is made.
+func insertFine(q queryer, fine *FineBook) error {
+	fine.AmountPaid = helper.ZeroMoney(fine.FineAmount.Currency())
+	if fine.Status != FineCredit {
+		fine.Status = FineOpen
+	}
+	err := q.QueryRow(`
+		INSERT INTO FineBookTable (PersonID, OrderID, FineTypeID, FineAmount, Currency, Status, Closed_at)
+		VALUES (?, ?, ?, ?, ?, ?, CASE WHEN ? = 'Credit' THEN SYSUTCDATETIME() END);
+		SELECT SCOPE_IDENTITY() AS FineID;`,
+		fine.PersonID, fine.OrderID, fine.FineTypeID, fine.FineAmount, fine.FineAmount.Currency(),
+		fine.Status, fine.Status).Scan(&fine.FineID)
+	if err != nil {
+		return fmt.Errorf("insert fine for order %d: %w", fine.OrderID, err)
+	}
+	return nil
+}
+
+// declareLoss closes an open loan as Lost or Damaged. The copy is flagged
+// with the same status so it is no longer counted as stock, the overdue
+// fine is settled up to today, and the patron is charged the book's
+// current price plus the processing fee. It returns the new charges.
+func declareLoss(q queryer, orderID int, outcome string, today time.Time) ([]FineBook, error) {
+	var personID, bookID int
+	var status string
+	var copyID sql.NullInt64
+	err := q.QueryRow("SELECT PersonID, BookID, Status, CopyID FROM OrderBook WITH (UPDLOCK) WHERE OrderID = ?", orderID).
+		Scan(&personID, &bookID, &status, &copyID)
+	if err == sql.ErrNoRows {
+		return nil, newRequestError(http.StatusNotFound, "ORDER_NOT_FOUND", "order %d not found", orderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load order %d: %w", orderID, err)
+	}
+	if !isOpenLoanStatus(status) {
+		return nil, newRequestError(http.StatusConflict, "LOAN_CLOSED", "order %d is %s and cannot be declared %s", orderID, status, outcome)
+	}
+
+	if _, err := q.Exec("UPDATE OrderBook SET Status = ?, ActualReturnDate = ? WHERE OrderID = ?", outcome, today, orderID); err != nil {
+		return nil, fmt.Errorf("close order %d as %s: %w", orderID, outcome, err)
+	}
+	if copyID.Valid {
+		copyStatus := CopyLost
+		if outcome == OrderDamaged {
+			copyStatus = CopyDamaged
+		}
+		if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ? AND Status = ?", copyStatus, copyID.Int64, CopyOnLoan); err != nil {
+			return nil, fmt.Errorf("flag copy %d %s: %w", copyID.Int64, copyStatus, err)
+		}
+		if err := syncBookInventory(q, bookID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := assessOverdueFine(q, orderID, today); err != nil {
+		return nil, err
+	}
+
+	replacementType := lostReplacementFine
+	if outcome == OrderDamaged {
+		replacementType = damagedReplacementFine
+	}
+	typeID, _, err := seededFineType(q, replacementType)
+	if err != nil {
+		return nil, err
+	}
+	var price helper.Money
+	if err := q.QueryRow("SELECT bookPrice, bookPriceCurrency FROM Book WHERE BookID = ?", bookID).Scan(&price, price.CurrencyScanner()); err != nil {
+		return nil, fmt.Errorf("load price of book %d: %w", bookID, err)
+	}
+	feeTypeID, fee, err := seededFineType(q, processingFeeFine)
+	if err != nil {
+		return nil, err
+	}
+
+	fines := []FineBook{}
+	for _, charge := range []FineBook{
+		{PersonID: personID, OrderID: orderID, FineTypeID: typeID, FineAmount: price.Round()},
+		{PersonID: personID, OrderID: orderID, FineTypeID: feeTypeID, FineAmount: fee.Round()},
+	} {
+		if charge.FineAmount.Sign() <= 0 {
+			continue
+		}
+		if err := insertFine(q, &charge); err != nil {
+			return nil, err
+		}
+		fines = append(fines, charge)
+	}
+	return fines, nil
+}
+
+// returnFound closes a Lost loan whose book turned up. The copy goes back
+// on the shelf (or to the next hold) and the replacement charge is
+// reversed: voided, with anything already paid on it refunded as a
+// negative Credit record, which is never owed or payable. The processing
+// fee stands, and the overdue fine runs to today. It returns the voided
+// charges and credits.
+func returnFound(q queryer, orderID int, today time.Time) ([]FineBook, error) {
+	var personID int
+	var status string
+	var copyID sql.NullInt64
+	err := q.QueryRow("SELECT PersonID, Status, CopyID FROM OrderBook WITH (UPDLOCK) WHERE OrderID = ?", orderID).
+		Scan(&personID, &status, &copyID)
+	if err == sql.ErrNoRows {
+		return nil, newRequestError(http.StatusNotFound, "ORDER_NOT_FOUND", "order %d not found", orderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load order %d: %w", orderID, err)
+	}
+	if status != OrderLost {
+		return nil, newRequestError(http.StatusConflict, "NOT_LOST", "order %d is %s, not Lost", orderID, status)
+	}
+
+	if _, err := q.Exec("UPDATE OrderBook SET Status = 'Returned', ActualReturnDate = ? WHERE OrderID = ?", today, orderID); err != nil {
+		return nil, fmt.Errorf("return order %d: %w", orderID, err)
+	}
+	if copyID.Valid {
+		if _, err := q.Exec("UPDATE BookCopy SET Status = ? WHERE CopyID = ? AND Status = ?", CopyAvailable, copyID.Int64, CopyLost); err != nil {
+			return nil, fmt.Errorf("restore copy %d: %w", copyID.Int64, err)
+		}
+		if _, err := shelveCopy(q, int(copyID.Int64)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := assessOverdueFine(q, orderID, today); err != nil {
+		return nil, err
+	}
+
+	typeID, _, err := seededFineType(q, lostReplacementFine)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`SELECT `+fineBookColumns+` FROM FineBookTable WITH (UPDLOCK)
+		WHERE OrderID = ? AND FineTypeID = ? AND Status IN ('Open', 'Paid') AND FineAmount > 0`, orderID, typeID)
+	if err != nil {
+		return nil, fmt.Errorf("load lost charges of order %d: %w", orderID, err)
+	}
+	var charges []FineBook
+	for rows.Next() {
+		var fine FineBook
+		if err := scanFineBook(rows, &fine); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan lost charge: %w", err)
+		}
+		charges = append(charges, fine)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load lost charges of order %d: %w", orderID, err)
+	}
+
+	reversed := []FineBook{}
+	for _, fine := range charges {
+		if _, err := q.Exec("UPDATE FineBookTable SET Status = 'Void', Closed_at = SYSUTCDATETIME() WHERE FineID = ?", fine.FineID); err != nil {
+			return nil, fmt.Errorf("void fine %d: %w", fine.FineID, err)
+		}
+		fine.Status = FineVoid
+		reversed = append(reversed, fine)
+		if fine.AmountPaid.Sign() <= 0 {
+			continue
+		}
+		credit := FineBook{PersonID: personID, OrderID: orderID, FineTypeID: typeID, FineAmount: fine.AmountPaid.Neg(), Status: FineCredit}
+		if err := insertFine(q, &credit); err != nil {
+			return nil, err
+		}
+		reversed = append(reversed, credit)
+	}
+	return reversed, nil
+}
+
+// lossHandler answers the POST /orderbook/:id actions that close or reopen
+// a loss, responding with the order and the fine records they touched.
+func lossHandler(action string, apply func(q queryer, orderID int, today time.Time) ([]FineBook, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || orderID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order_id"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to " + action})
+			return
+		}
+		defer tx.Rollback()
+
+		fines, err := apply(tx, orderID, time.Now().UTC().Truncate(24*time.Hour))
+		if err != nil {
+			log.Printf("%s order %d: %v", action, orderID, err)
+			respondRequestError(c, err, "failed to "+action)
+			return
+		}
+		var order OrderBook
+		if err := scanOrder(tx.QueryRow("SELECT "+orderColumns+" FROM OrderBook WHERE OrderID = ?", orderID), &order); err != nil {
+			log.Printf("reload order %d: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to " + action})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit %s of order %d: %v", action, orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to " + action})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"order": order, "fines": fines})
+	}
+}
+
+// DeclareOrderLost answers POST /orderbook/:id/lost.
+func DeclareOrderLost() gin.HandlerFunc {
+	return lossHandler("declare order lost", func(q queryer, orderID int, today time.Time) ([]FineBook, error) {
+		return declareLoss(q, orderID, OrderLost, today)
+	})
+}
+
+// DeclareOrderDamaged answers POST /orderbook/:id/damaged, for a book
+// returned damaged beyond use.
+func DeclareOrderDamaged() gin.HandlerFunc {
+	return lossHandler("declare order damaged", func(q queryer, orderID int, today time.Time) ([]FineBook, error) {
+		return declareLoss(q, orderID, OrderDamaged, today)
+	})
+}
+
+// ReturnFoundOrder answers POST /orderbook/:id/found.
+func ReturnFoundOrder() gin.HandlerFunc {
+	return lossHandler("return found order", returnFound)
+}
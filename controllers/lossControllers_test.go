@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// A found book voids its replacement charges and refunds what was paid on
+// them as Credit records; nothing else is charged or closed.
+func TestReturnFound(t *testing.T) {
+	db, f := newFakeDB(t)
+	today := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	f.rows("SELECT PersonID, Status, CopyID FROM OrderBook", []string{"PersonID", "Status", "CopyID"},
+		[]driver.Value{int64(7), OrderLost, nil})
+	f.exec("UPDATE OrderBook SET Status = 'Returned'")
+	f.rows("ReturnDate, ActualReturnDate FROM OrderBook", []string{"PersonID", "BookID", "Status", "ReturnDate", "ActualReturnDate"},
+		[]driver.Value{int64(7), int64(3), "Returned", nil, today})
+	f.rows("FROM FineTable WHERE Code", []string{"FineID", "FineAmount", "Currency"},
+		[]driver.Value{int64(5), "0.0000", "USD"})
+	f.rows("FROM FineBookTable WITH (UPDLOCK)", []string{"FineID", "PersonID", "OrderID", "FineTypeID", "FineAmount", "Currency", "AmountPaid", "Currency", "Status"},
+		[]driver.Value{int64(11), int64(7), int64(2), int64(5), "25.0000", "USD", "0.0000", "USD", FineOpen},
+		[]driver.Value{int64(12), int64(7), int64(2), int64(5), "25.0000", "USD", "10.0000", "USD", FineOpen},
+	)
+	f.exec("SET Status = 'Void'")
+	f.on("INSERT INTO FineBookTable", func(args []driver.Value) fakeResult {
+		return fakeResult{cols: []string{"FineID"}, rows: [][]driver.Value{{int64(30)}}}
+	})
+
+	reversed, err := returnFound(db, 2, today)
+	if err != nil {
+		t.Fatalf("returnFound: %v", err)
+	}
+	want := []struct {
+		id             int
+		status, amount string
+	}{{11, FineVoid, "25.00 USD"}, {12, FineVoid, "25.00 USD"}, {30, FineCredit, "-10.00 USD"}}
+	if len(reversed) != len(want) {
+		t.Fatalf("reversed = %+v", reversed)
+	}
+	for i, w := range want {
+		if r := reversed[i]; r.FineID != w.id || r.Status != w.status || r.FineAmount.String() != w.amount {
+			t.Errorf("record %d = %d %s %s, want %d %s %s", i, r.FineID, r.Status, r.FineAmount, w.id, w.status, w.amount)
+		}
+	}
+
+	if voided := f.executed("SET Status = 'Void'"); len(voided) != 2 || voided[0].args[0] != int64(11) || voided[1].args[0] != int64(12) {
+		t.Errorf("voided = %+v", voided)
+	}
+	credits := f.executed("INSERT INTO FineBookTable")
+	if len(credits) != 1 {
+		t.Fatalf("inserted %d records, want one credit", len(credits))
+	}
+	if args := credits[0].args; args[0] != int64(7) || args[3] != "-10.0000" || args[5] != FineCredit {
+		t.Errorf("credit = %v", args)
+	}
+}
+
+func TestReturnFoundNotLost(t *testing.T) {
+	db, f := newFakeDB(t)
+	f.rows("FROM OrderBook", []string{"PersonID", "Status", "CopyID"}, []driver.Value{int64(7), "Borrowed", nil})
+	_, err := returnFound(db, 2, time.Now())
+	if re, ok := err.(*requestError); !ok || re.Code != "NOT_LOST" {
+		t.Fatalf("err = %v, want NOT_LOST", err)
+	}
+	if n := len(f.executed("UPDATE")); n != 0 {
+		t.Errorf("%d updates on a loan that is not lost", n)
+	}
+}
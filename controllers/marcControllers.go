@@ -0,0 +1,557 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MARC formats accepted by the import and produced by the export.
+const (
+	MARCBinary = "marc"    // MARC21 in ISO 2709
+	MARCXML    = "marcxml" // MARC21 slim XML
+)
+
+// marcExportBatch is how many books are loaded at a time while exporting.
+const marcExportBatch = 200
+
+// marcLeader is the leader written for exported records: a new (n) record
+// for language material (a), monograph (m), UTF-8 (a), minimal level (7),
+// ISBD punctuation (i). Lengths and addresses are filled in when encoding.
+const marcLeader = "00000nam a22000007i 4500"
+
+// marcRoles maps relator terms ($e) and codes ($4) to contributor roles.
+var marcRoles = map[string]string{
+	"author":      RoleAuthor,
+	"aut":         RoleAuthor,
+	"editor":      RoleEditor,
+	"edt":         RoleEditor,
+	"translator":  RoleTranslator,
+	"trl":         RoleTranslator,
+	"illustrator": RoleIllustrator,
+	"ill":         RoleIllustrator,
+}
+
+// marcRelators are the $e terms written for each role on export.
+var marcRelators = map[string]string{
+	RoleEditor:      "editor",
+	RoleTranslator:  "translator",
+	RoleIllustrator: "illustrator",
+}
+
+// marcRecordReader is satisfied by helper.MARCReader and helper.MARCXMLReader.
+type marcRecordReader interface {
+	Read() (*helper.MARCRecord, error)
+}
+
+// marcRecordWriter is satisfied by helper.MARCWriter and helper.MARCXMLWriter.
+type marcRecordWriter interface {
+	Write(rec *helper.MARCRecord) error
+	Close() error
+}
+
+// marcFormat picks marc or marcxml from an explicit format, the file name or,
+// failing both, whether the content starts like XML.
+func marcFormat(format, filename string, peek []byte) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case MARCBinary, "mrc", "iso2709":
+		return MARCBinary, nil
+	case MARCXML, "xml":
+		return MARCXML, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported MARC format %q; use marc or marcxml", format)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mrc", ".marc":
+		return MARCBinary, nil
+	case ".xml":
+		return MARCXML, nil
+	}
+	if trimmed := strings.TrimSpace(string(peek)); strings.HasPrefix(trimmed, "<") {
+		return MARCXML, nil
+	}
+	return MARCBinary, nil
+}
+
+func newMARCReader(r io.Reader, format string) marcRecordReader {
+	if format == MARCXML {
+		return helper.NewMARCXMLReader(r)
+	}
+	return helper.NewMARCReader(r)
+}
+
+func newMARCWriter(w io.Writer, format string) marcRecordWriter {
+	if format == MARCXML {
+		return helper.NewMARCXMLWriter(w)
+	}
+	return helper.NewMARCWriter(w)
+}
+
+// trimISBD removes the trailing punctuation cataloguers put between MARC
+// subfields (" /", " :", ",", a closing period).
+func trimISBD(s string) string {
+	s = strings.TrimRight(strings.TrimSpace(s), " /:;,=")
+	if strings.HasSuffix(s, ".") && !strings.HasSuffix(s, "..") {
+		s = strings.TrimSuffix(s, ".")
+	}
+	return strings.TrimSpace(s)
+}
+
+// marcPersonalName turns an inverted heading ("Herbert, Frank,") into the
+// direct form stored on Author.
+func marcPersonalName(f helper.MARCDataField) string {
+	name := trimISBD(f.Subfield('a'))
+	if f.Ind1 == '1' {
+		if surname, forename, ok := strings.Cut(name, ", "); ok && forename != "" {
+			name = forename + " " + surname
+		}
+	}
+	return name
+}
+
+// invertedName writes "Frank Herbert" as "Herbert, Frank" for a 100/700
+// heading, taking the last word as the surname.
+func invertedName(name string) (string, byte) {
+	parts := strings.Fields(name)
+	if len(parts) < 2 {
+		return name, '0'
+	}
+	return parts[len(parts)-1] + ", " + strings.Join(parts[:len(parts)-1], " "), '1'
+}
+
+// marcCurrencySymbols maps the symbols seen in 020 $c to ISO 4217 codes.
+var marcCurrencySymbols = map[string]string{"$": "USD", "£": "GBP", "€": "EUR", "¥": "JPY", "₹": "INR"}
+
+// marcPrice reads a price such as "$12.99", "GBP 9.50 (pbk.)" or "12.00 EUR"
+// from 020 $c. A price naming no currency is in the default currency.
+func marcPrice(s string) (helper.Money, bool) {
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return helper.Money{}, false
+	}
+	end := start
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.') {
+		end++
+	}
+	// The currency is a symbol or code right before the number, or a code
+	// right after it; other words ("pbk.") are qualifiers
+	currency := ""
+	if before := strings.Fields(s[:start]); len(before) > 0 {
+		word := before[len(before)-1]
+		for symbol, code := range marcCurrencySymbols {
+			if strings.HasSuffix(word, symbol) {
+				currency = code
+			}
+		}
+		if isCurrencyCode(word) {
+			currency = word
+		}
+	}
+	if after := strings.Fields(s[end:]); currency == "" && len(after) > 0 && isCurrencyCode(after[0]) {
+		currency = after[0]
+	}
+	price, err := helper.ParseMoney(strings.TrimSuffix(s[start:end], "."), currency)
+	if err != nil {
+		return helper.Money{}, false
+	}
+	return price.Round(), true
+}
+
+func isCurrencyCode(word string) bool {
+	_, err := helper.LookupCurrency(word)
+	return err == nil && word == strings.ToUpper(word)
+}
+
+// marcToImportRow maps 245 $a$b to the title, 100/700 to contributors, 020 to
+// the ISBN and price and 650 $a to categories. Every other field, and every
+// subfield of those fields that was not used, is listed in unmapped.
+func marcToImportRow(rec *helper.MARCRecord, n int, defaultType string, copies int) importRow {
+	row := importRow{line: n, set: map[string]bool{}}
+	book := &row.book
+	unmapped := map[string]bool{}
+	note := func(f helper.MARCDataField, used string) {
+		for _, s := range f.Subfields {
+			if !strings.ContainsRune(used, rune(s.Code)) {
+				unmapped[f.Tag+"$"+string(s.Code)] = true
+			}
+		}
+	}
+	for _, cf := range rec.ControlFields {
+		unmapped[cf.Tag] = true
+	}
+
+	var rawISBN string
+	for _, f := range rec.DataFields {
+		switch f.Tag {
+		case "245":
+			title := trimISBD(f.Subfield('a'))
+			if sub := trimISBD(f.Subfield('b')); sub != "" {
+				title += ": " + sub
+			}
+			if book.BookName == "" {
+				book.BookName = title
+				note(f, "ab")
+			} else {
+				note(f, "")
+			}
+		case "100", "700":
+			name := marcPersonalName(f)
+			role := RoleAuthor
+			for _, rel := range []string{f.Subfield('4'), trimISBD(f.Subfield('e'))} {
+				if r, ok := marcRoles[strings.ToLower(rel)]; ok {
+					role = r
+					break
+				}
+			}
+			if name == "" {
+				note(f, "")
+				continue
+			}
+			book.Authors = append(book.Authors, BookContributor{Name: name, Role: role})
+			note(f, "ae4")
+		case "020":
+			// The first valid ISBN identifies the edition; later ones (other
+			// bindings) are reported as unmapped
+			value, _, _ := strings.Cut(strings.TrimSpace(f.Subfield('a')), " ")
+			used := ""
+			if parsed, err := helper.ParseISBN(value); value != "" && err == nil {
+				switch {
+				case book.ISBN13 == nil:
+					book.ISBN13 = &parsed.ISBN13
+					row.set["isbn"] = true
+					used += "a"
+				case *book.ISBN13 == parsed.ISBN13:
+					// the ISBN-10 form of the same edition
+					used += "a"
+				}
+			} else if value != "" && rawISBN == "" {
+				rawISBN = value
+			}
+			if price, ok := marcPrice(f.Subfield('c')); ok && !row.set["bookprice"] {
+				book.BookPrice = price
+				row.set["bookprice"] = true
+				used += "c"
+			}
+			note(f, used)
+		case "650":
+			if subject := trimISBD(f.Subfield('a')); subject != "" {
+				book.Categories = append(book.Categories, CategoryRef{Name: subject})
+				row.set["categories"] = true
+				note(f, "a")
+			} else {
+				note(f, "")
+			}
+		default:
+			unmapped[f.Tag] = true
+		}
+	}
+
+	// An ISBN that is present but invalid fails validation rather than being dropped
+	if book.ISBN13 == nil && rawISBN != "" {
+		book.ISBN13 = &rawISBN
+		row.set["isbn"] = true
+	}
+	if len(book.Categories) == 0 && defaultType != "" {
+		book.TypeOfBook = defaultType
+		row.set["typeofbook"] = true
+	}
+	if copies > 0 {
+		book.BookQuantity = copies
+		row.set["bookquantity"] = true
+	}
+	if book.BookName != "" {
+		row.set["bookname"] = true
+	}
+
+	for field := range unmapped {
+		row.unmapped = append(row.unmapped, field)
+	}
+	sort.Strings(row.unmapped)
+	return row
+}
+
+// bookToMARC builds a record for a book with its contributors and categories
+// attached: 001 control number, 020 ISBNs and price, 100/700 names, 245
+// title and 650 subjects.
+func bookToMARC(book *Book) *helper.MARCRecord {
+	rec := &helper.MARCRecord{Leader: marcLeader}
+	rec.ControlFields = append(rec.ControlFields, helper.MARCControlField{Tag: "001", Value: strconv.Itoa(book.BookID)})
+
+	price := ""
+	if book.BookPrice.Sign() > 0 {
+		price = book.BookPrice.Currency() + " " + book.BookPrice.Round().Amount()
+	}
+	switch {
+	case book.ISBN13 != nil:
+		rec.DataFields = append(rec.DataFields, helper.NewMARCDataField("020", ' ', ' ', "a", *book.ISBN13, "c", price))
+		if book.ISBN10 != nil {
+			rec.DataFields = append(rec.DataFields, helper.NewMARCDataField("020", ' ', ' ', "a", *book.ISBN10))
+		}
+	case price != "":
+		rec.DataFields = append(rec.DataFields, helper.NewMARCDataField("020", ' ', ' ', "c", price))
+	}
+
+	mainEntry := false
+	var added []helper.MARCDataField
+	for _, bc := range book.Authors {
+		heading, ind1 := invertedName(bc.Name)
+		if bc.Role == RoleAuthor && !mainEntry {
+			rec.DataFields = append(rec.DataFields, helper.NewMARCDataField("100", ind1, ' ', "a", heading))
+			mainEntry = true
+			continue
+		}
+		added = append(added, helper.NewMARCDataField("700", ind1, ' ', "a", heading, "e", marcRelators[bc.Role]))
+	}
+
+	// Indicator 1 says whether the title is an added entry (it is when there is no 100)
+	titleInd1 := byte('0')
+	if mainEntry {
+		titleInd1 = '1'
+	}
+	rec.DataFields = append(rec.DataFields, helper.NewMARCDataField("245", titleInd1, '0', "a", book.BookName))
+
+	for _, cat := range book.Categories {
+		rec.DataFields = append(rec.DataFields, helper.NewMARCDataField("650", ' ', '4', "a", cat.Name))
+	}
+	rec.DataFields = append(rec.DataFields, added...)
+	return rec
+}
+
+// ImportMARC accepts MARC21 or MARCXML records in the multipart field "file"
+// and imports them as books with the same rules and options as ImportBooks.
+// Extra parameters: format (marc|marcxml, default from the file), type (the
+// category for records without 650 subjects) and copies (copies to create
+// per new book). The report lists, per record, the fields that could not be
+// mapped.
+func ImportMARC() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the database connection
+		db := database.Database()
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes+1<<20)
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			log.Printf("Invalid MARC upload: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the records in the multipart field \"file\""})
+			return
+		}
+		defer file.Close()
+
+		opts := BookImportOptions{OnDuplicate: strings.ToLower(c.Request.FormValue("onDuplicate"))}
+		if v := c.Request.FormValue("dryRun"); v != "" {
+			if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dryRun must be true or false"})
+				return
+			}
+		}
+		if err := checkImportOptions(&opts); err != nil {
+			respondRequestError(c, err, "Invalid import options")
+			return
+		}
+		copies := 0
+		if v := c.Request.FormValue("copies"); v != "" {
+			if copies, err = strconv.Atoi(v); err != nil || copies < 0 || copies > maxCopiesPerRequest {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("copies must be between 0 and %d", maxCopiesPerRequest)})
+				return
+			}
+		}
+
+		peek := make([]byte, 64)
+		n, _ := io.ReadFull(file, peek)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Failed to rewind MARC upload: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to read the uploaded file"})
+			return
+		}
+		format, err := marcFormat(c.Request.FormValue("format"), header.Filename, peek[:n])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		reader := newMARCReader(io.LimitReader(file, maxImportBytes), format)
+		defaultType := strings.TrimSpace(c.Request.FormValue("type"))
+		var rows []importRow
+		for {
+			rec, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Printf("Failed to read MARC record %d: %v", len(rows)+1, err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("record %d: %v", len(rows)+1, err)})
+				return
+			}
+			if len(rows) == maxImportRows {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("an import may hold at most %d records", maxImportRows)})
+				return
+			}
+			rows = append(rows, marcToImportRow(rec, len(rows)+1, defaultType, copies))
+		}
+		if len(rows) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the file holds no MARC records", "code": "EMPTY_IMPORT"})
+			return
+		}
+
+		report, err := importBookRows(db, rows, opts)
+		if err != nil {
+			log.Printf("Failed to import MARC records: %v", err)
+			respondRequestError(c, err, "Unable to import records. Please try again later.")
+			return
+		}
+		report.UnmappedFields = map[string]int{}
+		for _, row := range rows {
+			for _, field := range row.unmapped {
+				report.UnmappedFields[field]++
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"data": report})
+	}
+}
+
+// ExportMARC streams the books matching the GET /book filters as MARC21
+// (format=marc, the default) or MARCXML (format=marcxml).
+func ExportMARC() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the database connection
+		db := database.Database()
+
+		format, err := marcFormat(c.DefaultQuery("format", MARCBinary), "", nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter, err := bookFilter(db, c.Request.URL.Query())
+		if err != nil {
+			log.Printf("Invalid book filter: %v", err)
+			respondRequestError(c, err, "Unable to export books. Please try again later.")
+			return
+		}
+
+		// Load the first batch before committing to a 200 response
+		batch, err := marcExportBatchAfter(db, filter, 0)
+		if err != nil {
+			log.Printf("Failed to load books for MARC export: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to export books. Please try again later."})
+			return
+		}
+		setMARCHeaders(c, format, "books")
+
+		writer := newMARCWriter(c.Writer, format)
+		for len(batch) > 0 {
+			for i := range batch {
+				if err := writer.Write(bookToMARC(&batch[i])); err != nil {
+					log.Printf("MARC export stopped at book %d: %v", batch[i].BookID, err)
+					return
+				}
+			}
+			c.Writer.Flush()
+			if batch, err = marcExportBatchAfter(db, filter, batch[len(batch)-1].BookID); err != nil {
+				log.Printf("MARC export stopped: %v", err)
+				return
+			}
+		}
+		if err := writer.Close(); err != nil {
+			log.Printf("MARC export failed to finish: %v", err)
+		}
+	}
+}
+
+// marcExportBatchAfter loads the next marcExportBatch books after afterID,
+// with their contributors and categories.
+func marcExportBatchAfter(db *sql.DB, filter *sqlFilter, afterID int) ([]Book, error) {
+	where := filter.where()
+	if where == "" {
+		where = " WHERE BookID > ?"
+	} else {
+		where += " AND BookID > ?"
+	}
+	args := append(append([]interface{}{}, filter.args...), afterID)
+	query := fmt.Sprintf("SELECT TOP (%d) %s FROM Book%s ORDER BY BookID", marcExportBatch, bookColumns, where)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := scanBook(rows, &book); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachBookDetails(db, books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// GetBookMARC returns one book as a MARC21 or MARCXML record.
+func GetBookMARC() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the database connection
+		db := database.Database()
+
+		format, err := marcFormat(c.DefaultQuery("format", MARCBinary), "", nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+			return
+		}
+
+		var book Book
+		err = scanBook(db.QueryRow("SELECT "+bookColumns+" FROM Book WHERE BookID = ?", id), &book)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+			return
+		}
+		if err == nil {
+			err = attachBookDetail(db, &book)
+		}
+		if err != nil {
+			log.Printf("Failed to load book %d for MARC: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch book data"})
+			return
+		}
+
+		setMARCHeaders(c, format, fmt.Sprintf("book-%d", id))
+		writer := newMARCWriter(c.Writer, format)
+		if err := writer.Write(bookToMARC(&book)); err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			log.Printf("Failed to write MARC for book %d: %v", id, err)
+		}
+	}
+}
+
+func setMARCHeaders(c *gin.Context, format, name string) {
+	if format == MARCXML {
+		c.Header("Content-Type", "application/marcxml+xml; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".xml"))
+	} else {
+		c.Header("Content-Type", "application/marc")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".mrc"))
+	}
+	c.Status(http.StatusOK)
+}
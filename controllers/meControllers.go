@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of the /me lists.
+const (
+	defaultMePageSize = 20
+	maxMePageSize     = 100
+)
+
+// MePage is one page of a /me list. Total counts every matching row.
+type MePage struct {
+	Items    []interface{} `json:"Items"`
+	Page     int           `json:"Page"`
+	PageSize int           `json:"PageSize"`
+	Total    int           `json:"Total"`
+}
+
+// LoanBook is the part of a book shown alongside a patron's loans and holds.
+type LoanBook struct {
+	BookID         int     `json:"bookid"`
+	BookName       string  `json:"bookname"`
+	BookAuthorName string  `json:"bookauthorname"`
+	TypeOfBook     string  `json:"typeofbook"`
+	ISBN13         *string `json:"isbn13"`
+}
+
+// loanBookColumns selects a LoanBook from Book b, after its BookID.
+const loanBookColumns = "b.bookName, b.bookAuthorName, b.typeOfBook, b.isbn13"
+
+// MyLoan is a loan as its borrower sees it; ReturnDate is the due date.
+type MyLoan struct {
+	OrderBook
+	Book LoanBook `json:"Book"`
+}
+
+// MyFine is a fine as the patron sees it, with what is left to pay.
+type MyFine struct {
+	FineBook
+	NameOfFine  string       `json:"NameOfFine"`
+	Outstanding helper.Money `json:"Outstanding"` // zero once Paid or Waived
+}
+
+// MyFines is a page of fines with the patron's whole outstanding balance,
+// one amount per currency.
+type MyFines struct {
+	MePage
+	OutstandingTotal []helper.Money `json:"OutstandingTotal"`
+}
+
+// MyHold is a hold on a book, as the patron sees it.
+type MyHold struct {
+	Hold
+	Book LoanBook `json:"Book"`
+}
+
+// withTail scans a row's leading columns with a scan function written for
+// them alone, and the remaining columns into tail.
+type withTail struct {
+	row  rowScanner
+	tail []interface{}
+}
+
+func (w withTail) Scan(dest ...interface{}) error {
+	return w.row.Scan(append(dest, w.tail...)...)
+}
+
+// qualify prefixes every column of a column list with a table alias.
+func qualify(alias, columns string) string {
+	return alias + "." + strings.ReplaceAll(columns, ", ", ", "+alias+".")
+}
+
+// mePaging reads the 1-based ?page= and ?page_size= parameters.
+func mePaging(c *gin.Context) (page, size int, err error) {
+	page, size = 1, defaultMePageSize
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, invalidFilter("page", v)
+		}
+	}
+	if v := c.Query("page_size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > maxMePageSize {
+			return 0, 0, newRequestError(http.StatusBadRequest, "INVALID_FILTER", "page_size must be between 1 and %d", maxMePageSize)
+		}
+	}
+	return page, size, nil
+}
+
+// mePerson resolves the authenticated uid claim to the patron's Person ID.
+func mePerson(c *gin.Context, q queryer) (int, error) {
+	uid := c.GetString("uid")
+	if uid == "" {
+		return 0, newRequestError(http.StatusUnauthorized, "UNAUTHENTICATED", "an access token is required")
+	}
+	var personID int
+	err := q.QueryRow("SELECT ID FROM Person WHERE User_id = ?", uid).Scan(&personID)
+	if err == sql.ErrNoRows {
+		return 0, newRequestError(http.StatusNotFound, "USER_NOT_FOUND", "user not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("look up user %s: %w", uid, err)
+	}
+	return personID, nil
+}
+
+// mePageQuery runs one page of "SELECT columns FROM from WHERE filter
+// ORDER BY orderBy", with the total row count.
+func mePageQuery(q queryer, columns, from string, f *sqlFilter, orderBy string, page, size int,
+	scan func(*sql.Rows) (interface{}, error)) (MePage, error) {
+	result := MePage{Items: []interface{}{}, Page: page, PageSize: size}
+	if err := q.QueryRow("SELECT COUNT(*) FROM "+from+f.where(), f.args...).Scan(&result.Total); err != nil {
+		return result, err
+	}
+	args := append(append([]interface{}{}, f.args...), (page-1)*size, size)
+	rows, err := q.Query("SELECT "+columns+" FROM "+from+f.where()+" ORDER BY "+orderBy+" OFFSET ? ROWS FETCH NEXT ? ROWS ONLY", args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result, rows.Err()
+}
+
+// meRequest is the common start of a /me handler: the patron and the page.
+// On failure it has already responded.
+func meRequest(c *gin.Context, db *sql.DB, what string) (personID, page, size int, ok bool) {
+	if db == nil {
+		log.Println("database connection is nil")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+		return 0, 0, 0, false
+	}
+	page, size, err := mePaging(c)
+	if err != nil {
+		respondRequestError(c, err, "failed to get "+what)
+		return 0, 0, 0, false
+	}
+	personID, err = mePerson(c, db)
+	if err != nil {
+		log.Printf("get my %s: %v", what, err)
+		respondRequestError(c, err, "failed to get "+what)
+		return 0, 0, 0, false
+	}
+	return personID, page, size, true
+}
+
+// GetMyLoans answers GET /me/loans with the patron's loans, newest first,
+// each with its book. ?status= is current, past, or an order status.
+func GetMyLoans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		personID, page, size, ok := meRequest(c, db, "loans")
+		if !ok {
+			return
+		}
+
+		f := &sqlFilter{}
+		f.add("o.PersonID = ?", personID)
+		switch v := strings.TrimSpace(c.Query("status")); {
+		case v == "":
+		case v == "current":
+			f.add("o.Status IN ('Borrowed', 'Overdue')")
+		case v == "past":
+			f.add("o.Status NOT IN ('Borrowed', 'Overdue')")
+		case isValidStatus(v):
+			f.add("o.Status = ?", v)
+		default:
+			respondRequestError(c, invalidFilter("status", v), "failed to get loans")
+			return
+		}
+
+		result, err := mePageQuery(db, qualify("o", orderColumns)+", "+loanBookColumns,
+			"OrderBook o JOIN Book b ON b.BookID = o.BookID", f, "o.BorrowDate DESC, o.OrderID DESC", page, size,
+			func(rows *sql.Rows) (interface{}, error) {
+				var loan MyLoan
+				b := &loan.Book
+				err := scanOrder(withTail{rows, []interface{}{&b.BookName, &b.BookAuthorName, &b.TypeOfBook, &b.ISBN13}}, &loan.OrderBook)
+				b.BookID = loan.BookID
+				return loan, err
+			})
+		if err != nil {
+			log.Printf("get loans of person %d: %v", personID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get loans"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetMyFines answers GET /me/fines with the patron's fines, newest first,
+// filtered by ?status= (Open, Paid, Waived, Void or Credit), and the total still owed.
+func GetMyFines() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		personID, page, size, ok := meRequest(c, db, "fines")
+		if !ok {
+			return
+		}
+
+		f := &sqlFilter{}
+		f.add("f.PersonID = ?", personID)
+		if v := strings.TrimSpace(c.Query("status")); v != "" {
+			if !isFineStatus(v) {
+				respondRequestError(c, invalidFilter("status", v), "failed to get fines")
+				return
+			}
+			f.add("f.Status = ?", v)
+		}
+
+		result, err := mePageQuery(db, qualify("f", fineBookColumns)+", t.NameOfFine",
+			"FineBookTable f JOIN FineTable t ON t.FineID = f.FineTypeID", f, "f.FineID DESC", page, size,
+			func(rows *sql.Rows) (interface{}, error) {
+				var fine MyFine
+				var name sql.NullString
+				if err := scanFineBook(withTail{rows, []interface{}{&name}}, &fine.FineBook); err != nil {
+					return nil, err
+				}
+				fine.NameOfFine = name.String
+				fine.Outstanding = helper.ZeroMoney(fine.FineAmount.Currency())
+				if fine.Status == FineOpen {
+					owed, err := fine.FineAmount.Sub(fine.AmountPaid)
+					if err != nil {
+						return nil, err
+					}
+					fine.Outstanding = owed
+				}
+				return fine, nil
+			})
+		if err != nil {
+			log.Printf("get fines of person %d: %v", personID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fines"})
+			return
+		}
+		total, err := outstandingBalance(db, personID)
+		if err != nil {
+			log.Printf("get fines of person %d: %v", personID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get fines"})
+			return
+		}
+		c.JSON(http.StatusOK, MyFines{MePage: result, OutstandingTotal: total})
+	}
+}
+
+// GetMyHolds answers GET /me/holds with the patron's holds, newest first,
+// each with its book. ?status= takes the same values as GET /hold.
+func GetMyHolds() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		personID, page, size, ok := meRequest(c, db, "holds")
+		if !ok {
+			return
+		}
+
+		params := c.Request.URL.Query()
+		params.Set("personid", strconv.Itoa(personID))
+		f, err := holdFilter(params)
+		if err != nil {
+			respondRequestError(c, err, "failed to get holds")
+			return
+		}
+
+		result, err := mePageQuery(db, holdColumns+", "+loanBookColumns,
+			"BookHold h JOIN Book b ON b.BookID = h.BookID", f, "h.Placed_at DESC, h.HoldID DESC", page, size,
+			func(rows *sql.Rows) (interface{}, error) {
+				var hold MyHold
+				b := &hold.Book
+				err := scanHold(withTail{rows, []interface{}{&b.BookName, &b.BookAuthorName, &b.TypeOfBook, &b.ISBN13}}, &hold.Hold)
+				b.BookID = hold.BookID
+				return hold, err
+			})
+		if err != nil {
+			log.Printf("get holds of person %d: %v", personID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get holds"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,636 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oaiPageSize is how many records or headers a list response carries before
+// handing out a resumptionToken.
+const oaiPageSize = 100
+
+const (
+	oaiNamespace   = "http://www.openarchives.org/OAI/2.0/"
+	oaiDCNamespace = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	dcNamespace    = "http://purl.org/dc/elements/1.1/"
+	xsiNamespace   = "http://www.w3.org/2001/XMLSchema-instance"
+	oaiGranularity = "YYYY-MM-DDThh:mm:ssZ"
+	oaiSecondsForm = "2006-01-02T15:04:05Z"
+	oaiDayForm     = "2006-01-02"
+)
+
+// oaiFormats are the metadata formats every book can be disseminated in.
+var oaiFormats = []struct{ prefix, schema, namespace string }{
+	{"oai_dc", "http://www.openarchives.org/OAI/2.0/oai_dc.xsd", oaiDCNamespace},
+	{"marcxml", "http://www.loc.gov/standards/marcxml/schema/MARC21slim.xsd", helper.MARCXMLNamespace},
+}
+
+// oaiVerbArgs lists the arguments each verb accepts; required ones are
+// marked true. resumptionToken is exclusive and handled separately.
+var oaiVerbArgs = map[string]map[string]bool{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier": false},
+	"ListSets":            {"resumptionToken": false},
+	"GetRecord":           {"identifier": true, "metadataPrefix": true},
+	"ListIdentifiers":     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"ListRecords":         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+}
+
+// oaiError is an OAI-PMH protocol error, reported inside a 200 response.
+type oaiError struct {
+	code    string
+	message string
+}
+
+func (e *oaiError) Error() string { return e.message }
+
+func newOAIError(code, format string, args ...interface{}) *oaiError {
+	return &oaiError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// oaiRepository holds the values Identify reports. They come from the
+// environment: OAI_REPOSITORY_NAME, OAI_REPOSITORY_ID, OAI_ADMIN_EMAIL and
+// OAI_BASE_URL (derived from the request when unset).
+type oaiRepository struct {
+	name, id, adminEmail, baseURL string
+}
+
+func loadOAIRepository(c *gin.Context) oaiRepository {
+	repo := oaiRepository{
+		name:       os.Getenv("OAI_REPOSITORY_NAME"),
+		id:         os.Getenv("OAI_REPOSITORY_ID"),
+		adminEmail: os.Getenv("OAI_ADMIN_EMAIL"),
+		baseURL:    os.Getenv("OAI_BASE_URL"),
+	}
+	if repo.name == "" {
+		repo.name = "BookManagement Library Catalogue"
+	}
+	if repo.id == "" {
+		repo.id = "bookmanagement.local"
+	}
+	if repo.adminEmail == "" {
+		repo.adminEmail = "admin@bookmanagement.local"
+	}
+	if repo.baseURL == "" {
+		repo.baseURL = requestBaseURL(c) + c.Request.URL.Path
+	}
+	return repo
+}
+
+// requestBaseURL is the scheme and host the client used, honouring a proxy's
+// X-Forwarded-Proto.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func (r oaiRepository) identifier(bookID int) string {
+	return fmt.Sprintf("oai:%s:book/%d", r.id, bookID)
+}
+
+// bookID extracts the book ID from an identifier issued by this repository.
+func (r oaiRepository) bookID(identifier string) (int, bool) {
+	rest, ok := strings.CutPrefix(identifier, "oai:"+r.id+":book/")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(rest)
+	return id, err == nil && id > 0
+}
+
+// oaiToken is the state of a list request carried between pages. Tokens are
+// self-contained, so they survive restarts and never expire.
+type oaiToken struct {
+	Verb   string `json:"v"`
+	Prefix string `json:"p"`
+	Set    string `json:"s,omitempty"`
+	From   string `json:"f,omitempty"`
+	Until  string `json:"u"`
+	After  int    `json:"a"`
+	Cursor int    `json:"c"`
+	Total  int    `json:"n"`
+}
+
+func (t oaiToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOAIToken(s, verb string) (oaiToken, error) {
+	var t oaiToken
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &t)
+	}
+	if err != nil || t.Verb != verb || t.Until == "" {
+		return t, newOAIError("badResumptionToken", "the resumptionToken is invalid")
+	}
+	return t, nil
+}
+
+// parseOAIDate reads a from/until argument in either supported granularity.
+func parseOAIDate(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(oaiSecondsForm, s); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(oaiDayForm, s)
+	return t, true, err
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// OAIPMH serves the OAI-PMH 2.0 verbs Identify, ListMetadataFormats, ListSets,
+// GetRecord, ListIdentifiers and ListRecords over the Book table, in oai_dc
+// and marcxml. Sets are the category tree (setSpec is the slug path, e.g.
+// "fiction:fantasy") and a set includes the sets below it.
+func OAIPMH() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		repo := loadOAIRepository(c)
+
+		if err := c.Request.ParseForm(); err != nil {
+			writeOAI(c, repo, "", nil, "", newOAIError("badArgument", "the request could not be parsed"))
+			return
+		}
+		args := map[string]string{}
+		for name, values := range c.Request.Form {
+			if len(values) > 1 {
+				writeOAI(c, repo, "", nil, "", newOAIError("badArgument", "argument %s is repeated", name))
+				return
+			}
+			args[name] = values[0]
+		}
+
+		verb := args["verb"]
+		allowed, ok := oaiVerbArgs[verb]
+		if !ok {
+			writeOAI(c, repo, "", nil, "", newOAIError("badVerb", "illegal or missing verb"))
+			return
+		}
+		delete(args, "verb")
+		if err := checkOAIArgs(allowed, args); err != nil {
+			writeOAI(c, repo, "", nil, "", err)
+			return
+		}
+
+		var body string
+		var err error
+		switch verb {
+		case "Identify":
+			body, err = oaiIdentify(db, repo)
+		case "ListMetadataFormats":
+			body, err = oaiListMetadataFormats(db, repo, args)
+		case "ListSets":
+			body, err = oaiListSets(db, args)
+		case "GetRecord":
+			body, err = oaiGetRecord(db, repo, args)
+		case "ListIdentifiers", "ListRecords":
+			body, err = oaiList(db, repo, verb, args)
+		}
+		if _, ok := err.(*oaiError); err != nil && !ok {
+			log.Printf("OAI-PMH %s: %v", verb, err)
+			c.String(http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeOAI(c, repo, verb, args, body, err)
+	}
+}
+
+func checkOAIArgs(allowed map[string]bool, args map[string]string) error {
+	for name := range args {
+		if _, ok := allowed[name]; !ok {
+			return newOAIError("badArgument", "illegal argument %s", name)
+		}
+	}
+	if _, ok := args["resumptionToken"]; ok {
+		if len(args) > 1 {
+			return newOAIError("badArgument", "resumptionToken is an exclusive argument")
+		}
+		return nil
+	}
+	for name, required := range allowed {
+		if _, ok := args[name]; required && !ok {
+			return newOAIError("badArgument", "missing required argument %s", name)
+		}
+	}
+	return nil
+}
+
+// writeOAI wraps a verb's body (or a protocol error) in the OAI-PMH envelope.
+func writeOAI(c *gin.Context, repo oaiRepository, verb string, args map[string]string, body string, err error) {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<OAI-PMH xmlns="%s" xmlns:xsi="%s" xsi:schemaLocation="%s http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd">`,
+		oaiNamespace, xsiNamespace, oaiNamespace)
+	fmt.Fprintf(&b, "\n<responseDate>%s</responseDate>\n", time.Now().UTC().Format(oaiSecondsForm))
+
+	// The request element echoes the arguments unless they were illegal
+	oe, _ := err.(*oaiError)
+	b.WriteString("<request")
+	if oe == nil || (oe.code != "badVerb" && oe.code != "badArgument") {
+		if verb != "" {
+			fmt.Fprintf(&b, ` verb="%s"`, xmlText(verb))
+		}
+		names := make([]string, 0, len(args))
+		for name := range args {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, ` %s="%s"`, name, xmlText(args[name]))
+		}
+	}
+	fmt.Fprintf(&b, ">%s</request>\n", xmlText(repo.baseURL))
+
+	if oe != nil {
+		fmt.Fprintf(&b, "<error code=\"%s\">%s</error>\n", oe.code, xmlText(oe.message))
+	} else {
+		b.WriteString(body)
+	}
+	b.WriteString("</OAI-PMH>\n")
+	c.Data(http.StatusOK, "text/xml; charset=utf-8", []byte(b.String()))
+}
+
+func oaiIdentify(db *sql.DB, repo oaiRepository) (string, error) {
+	var earliest sql.NullTime
+	if err := db.QueryRow("SELECT MIN(Updated_at) FROM Book").Scan(&earliest); err != nil {
+		return "", fmt.Errorf("load earliest datestamp: %w", err)
+	}
+	if !earliest.Valid {
+		earliest.Time = time.Now()
+	}
+
+	var b strings.Builder
+	b.WriteString("<Identify>\n")
+	fmt.Fprintf(&b, "<repositoryName>%s</repositoryName>\n", xmlText(repo.name))
+	fmt.Fprintf(&b, "<baseURL>%s</baseURL>\n", xmlText(repo.baseURL))
+	b.WriteString("<protocolVersion>2.0</protocolVersion>\n")
+	fmt.Fprintf(&b, "<adminEmail>%s</adminEmail>\n", xmlText(repo.adminEmail))
+	fmt.Fprintf(&b, "<earliestDatestamp>%s</earliestDatestamp>\n", earliest.Time.UTC().Format(oaiSecondsForm))
+	// Books are never deleted, only their copies withdrawn
+	b.WriteString("<deletedRecord>no</deletedRecord>\n")
+	fmt.Fprintf(&b, "<granularity>%s</granularity>\n", oaiGranularity)
+	b.WriteString("<description>\n")
+	fmt.Fprintf(&b, `<oai-identifier xmlns="http://www.openarchives.org/OAI/2.0/oai-identifier" xmlns:xsi="%s" `+
+		`xsi:schemaLocation="http://www.openarchives.org/OAI/2.0/oai-identifier http://www.openarchives.org/OAI/2.0/oai-identifier.xsd">`,
+		xsiNamespace)
+	fmt.Fprintf(&b, "<scheme>oai</scheme><repositoryIdentifier>%s</repositoryIdentifier>", xmlText(repo.id))
+	fmt.Fprintf(&b, "<delimiter>:</delimiter><sampleIdentifier>%s</sampleIdentifier>", xmlText(repo.identifier(1)))
+	b.WriteString("</oai-identifier>\n</description>\n</Identify>\n")
+	return b.String(), nil
+}
+
+func oaiListMetadataFormats(db *sql.DB, repo oaiRepository, args map[string]string) (string, error) {
+	if identifier, ok := args["identifier"]; ok {
+		if _, err := oaiLoadBook(db, repo, identifier); err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	b.WriteString("<ListMetadataFormats>\n")
+	for _, f := range oaiFormats {
+		fmt.Fprintf(&b, "<metadataFormat><metadataPrefix>%s</metadataPrefix><schema>%s</schema><metadataNamespace>%s</metadataNamespace></metadataFormat>\n",
+			f.prefix, f.schema, f.namespace)
+	}
+	b.WriteString("</ListMetadataFormats>\n")
+	return b.String(), nil
+}
+
+// oaiSetSpecs maps every category to its setSpec, the slug path from the root.
+func oaiSetSpecs(byID map[int]*Category) map[int]string {
+	specs := map[int]string{}
+	var spec func(id int) string
+	spec = func(id int) string {
+		if s, ok := specs[id]; ok {
+			return s
+		}
+		cat := byID[id]
+		s := cat.Slug
+		if cat.ParentID != nil {
+			if _, ok := byID[*cat.ParentID]; ok {
+				s = spec(*cat.ParentID) + ":" + s
+			}
+		}
+		specs[id] = s
+		return s
+	}
+	for id := range byID {
+		spec(id)
+	}
+	return specs
+}
+
+func oaiListSets(db *sql.DB, args map[string]string) (string, error) {
+	if _, ok := args["resumptionToken"]; ok {
+		// Every set fits in one response, so no token is ever issued
+		return "", newOAIError("badResumptionToken", "the resumptionToken is invalid")
+	}
+	byID, _, err := loadCategories(db)
+	if err != nil {
+		return "", err
+	}
+	specs := oaiSetSpecs(byID)
+	ids := make([]int, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return specs[ids[i]] < specs[ids[j]] })
+
+	var b strings.Builder
+	b.WriteString("<ListSets>\n")
+	for _, id := range ids {
+		fmt.Fprintf(&b, "<set><setSpec>%s</setSpec><setName>%s</setName></set>\n", xmlText(specs[id]), xmlText(byID[id].Path))
+	}
+	b.WriteString("</ListSets>\n")
+	return b.String(), nil
+}
+
+// oaiLoadBook loads the book an identifier names, with its credits and categories.
+func oaiLoadBook(db *sql.DB, repo oaiRepository, identifier string) (*Book, error) {
+	id, ok := repo.bookID(identifier)
+	if !ok {
+		return nil, newOAIError("idDoesNotExist", "%s is not an identifier of this repository", identifier)
+	}
+	var book Book
+	err := scanBook(db.QueryRow("SELECT "+bookColumns+" FROM Book WHERE BookID = ?", id), &book)
+	if err == sql.ErrNoRows {
+		return nil, newOAIError("idDoesNotExist", "%s does not exist", identifier)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load book %d: %w", id, err)
+	}
+	if err := attachBookDetail(db, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func checkOAIPrefix(prefix string) error {
+	for _, f := range oaiFormats {
+		if f.prefix == prefix {
+			return nil
+		}
+	}
+	return newOAIError("cannotDisseminateFormat", "metadata format %s is not supported", prefix)
+}
+
+func oaiGetRecord(db *sql.DB, repo oaiRepository, args map[string]string) (string, error) {
+	if err := checkOAIPrefix(args["metadataPrefix"]); err != nil {
+		return "", err
+	}
+	book, err := oaiLoadBook(db, repo, args["identifier"])
+	if err != nil {
+		return "", err
+	}
+	byID, _, err := loadCategories(db)
+	if err != nil {
+		return "", err
+	}
+	record, err := oaiRecord(repo, book, args["metadataPrefix"], oaiSetSpecs(byID), true)
+	if err != nil {
+		return "", err
+	}
+	return "<GetRecord>\n" + record + "</GetRecord>\n", nil
+}
+
+// oaiList serves ListIdentifiers and ListRecords, a page at a time in BookID
+// order. The first request fixes until (defaulting to now) so later pages see
+// the same list even while books keep changing.
+func oaiList(db *sql.DB, repo oaiRepository, verb string, args map[string]string) (string, error) {
+	var token oaiToken
+	if raw, ok := args["resumptionToken"]; ok {
+		var err error
+		if token, err = decodeOAIToken(raw, verb); err != nil {
+			return "", err
+		}
+	} else {
+		token = oaiToken{Verb: verb, Prefix: args["metadataPrefix"], Set: args["set"], From: args["from"], Until: args["until"]}
+		if err := checkOAIPrefix(token.Prefix); err != nil {
+			return "", err
+		}
+		if token.From != "" && token.Until != "" {
+			_, fromDay, _ := parseOAIDate(token.From)
+			_, untilDay, _ := parseOAIDate(token.Until)
+			if fromDay != untilDay {
+				return "", newOAIError("badArgument", "from and until must have the same granularity")
+			}
+		}
+		if token.Until == "" {
+			token.Until = time.Now().UTC().Format(oaiSecondsForm)
+		}
+	}
+
+	filter, err := oaiFilter(db, token)
+	if err != nil {
+		return "", err
+	}
+	if token.Total == 0 && token.After == 0 {
+		if err := db.QueryRow("SELECT COUNT(*) FROM Book"+filter.where(), filter.args...).Scan(&token.Total); err != nil {
+			return "", fmt.Errorf("count records: %w", err)
+		}
+		if token.Total == 0 {
+			return "", newOAIError("noRecordsMatch", "no records match the request")
+		}
+	}
+
+	filter.add("BookID > ?", token.After)
+	query := fmt.Sprintf("SELECT TOP (%d) %s FROM Book%s ORDER BY BookID", oaiPageSize+1, bookColumns, filter.where())
+	rows, err := db.Query(query, filter.args...)
+	if err != nil {
+		return "", fmt.Errorf("list records: %w", err)
+	}
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := scanBook(rows, &book); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("scan record: %w", err)
+		}
+		books = append(books, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("list records: %w", err)
+	}
+	more := len(books) > oaiPageSize
+	if more {
+		books = books[:oaiPageSize]
+	}
+	if err := attachBookDetails(db, books); err != nil {
+		return "", err
+	}
+	byID, _, err := loadCategories(db)
+	if err != nil {
+		return "", err
+	}
+	specs := oaiSetSpecs(byID)
+
+	var b strings.Builder
+	b.WriteString("<" + verb + ">\n")
+	for i := range books {
+		record, err := oaiRecord(repo, &books[i], token.Prefix, specs, verb == "ListRecords")
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(record)
+	}
+
+	// The last page carries an empty token, as the protocol requires
+	cursor := token.Cursor
+	if more {
+		next := token
+		next.After = books[len(books)-1].BookID
+		next.Cursor = cursor + len(books)
+		fmt.Fprintf(&b, `<resumptionToken completeListSize="%d" cursor="%d">%s</resumptionToken>`+"\n", token.Total, cursor, next.encode())
+	} else if cursor > 0 {
+		fmt.Fprintf(&b, `<resumptionToken completeListSize="%d" cursor="%d"/>`+"\n", token.Total, cursor)
+	}
+	b.WriteString("</" + verb + ">\n")
+	return b.String(), nil
+}
+
+// oaiFilter turns from, until and set into conditions on Book.
+func oaiFilter(db *sql.DB, token oaiToken) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	var from, until time.Time
+	var untilDay bool
+	var err error
+	if token.From != "" {
+		if from, _, err = parseOAIDate(token.From); err != nil {
+			return nil, newOAIError("badArgument", "from is not a valid datestamp")
+		}
+		f.add("Updated_at >= ?", from)
+	}
+	if until, untilDay, err = parseOAIDate(token.Until); err != nil {
+		return nil, newOAIError("badArgument", "until is not a valid datestamp")
+	}
+	if token.From != "" && from.After(until) {
+		return nil, newOAIError("badArgument", "from is later than until")
+	}
+	// until is inclusive at its own granularity
+	if untilDay {
+		until = until.AddDate(0, 0, 1)
+	} else {
+		until = until.Add(time.Second)
+	}
+	f.add("Updated_at < ?", until)
+
+	if token.Set != "" {
+		byID, _, err := loadCategories(db)
+		if err != nil {
+			return nil, err
+		}
+		setID := 0
+		for id, spec := range oaiSetSpecs(byID) {
+			if spec == token.Set {
+				setID = id
+			}
+		}
+		if setID == 0 {
+			return nil, newOAIError("noRecordsMatch", "set %s does not exist", token.Set)
+		}
+		ids, err := categorySubtreeIDs(db, setID)
+		if err != nil {
+			return nil, err
+		}
+		placeholders := make([]string, len(ids))
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		f.add("BookID IN (SELECT BookID FROM BookCategory WHERE CategoryID IN ("+strings.Join(placeholders, ", ")+"))", args...)
+	}
+	return f, nil
+}
+
+// oaiRecord renders a record's header and, when withMetadata is set, its
+// metadata in the requested format.
+func oaiRecord(repo oaiRepository, book *Book, prefix string, specs map[int]string, withMetadata bool) (string, error) {
+	var b strings.Builder
+	b.WriteString("<header>")
+	fmt.Fprintf(&b, "<identifier>%s</identifier>", xmlText(repo.identifier(book.BookID)))
+	fmt.Fprintf(&b, "<datestamp>%s</datestamp>", book.UpdatedAt.UTC().Format(oaiSecondsForm))
+	for _, cat := range book.Categories {
+		if spec, ok := specs[cat.CategoryID]; ok {
+			fmt.Fprintf(&b, "<setSpec>%s</setSpec>", xmlText(spec))
+		}
+	}
+	b.WriteString("</header>")
+	if !withMetadata {
+		return b.String() + "\n", nil
+	}
+
+	var metadata string
+	switch prefix {
+	case "oai_dc":
+		metadata = bookDublinCore(book)
+	case "marcxml":
+		data, err := helper.MarshalMARCXML(bookToMARC(book))
+		if err != nil {
+			return "", fmt.Errorf("encode MARCXML for book %d: %w", book.BookID, err)
+		}
+		metadata = string(data)
+	}
+	return "<record>" + b.String() + "<metadata>" + metadata + "</metadata></record>\n", nil
+}
+
+// bookDublinCore renders a book as an oai_dc record.
+func bookDublinCore(book *Book) string {
+	return fmt.Sprintf(`<oai_dc:dc xmlns:oai_dc="%s" xmlns:dc="%s" xmlns:xsi="%s" xsi:schemaLocation="%s http://www.openarchives.org/OAI/2.0/oai_dc.xsd">`,
+		oaiDCNamespace, dcNamespace, xsiNamespace, oaiDCNamespace) + dublinCoreElements(book) + "</oai_dc:dc>"
+}
+
+// dublinCoreElements renders a book as simple Dublin Core elements: title,
+// creators (Author credits), contributors (other roles), subjects
+// (categories), type and the ISBN as a URN. The dc prefix must be bound by
+// the enclosing element.
+func dublinCoreElements(book *Book) string {
+	var b strings.Builder
+	element := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "<dc:%s>%s</dc:%s>", name, xmlText(value), name)
+		}
+	}
+	element("title", book.BookName)
+	for _, bc := range book.Authors {
+		if bc.Role == RoleAuthor {
+			element("creator", bc.Name)
+		} else {
+			element("contributor", bc.Name)
+		}
+	}
+	for _, cat := range book.Categories {
+		element("subject", cat.Name)
+	}
+	element("type", "Text")
+	if book.ISBN13 != nil {
+		element("identifier", "urn:isbn:"+*book.ISBN13)
+	}
+	return b.String()
+}
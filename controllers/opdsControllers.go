@@ -0,0 +1,647 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// opdsPageSize is how many entries an OPDS feed page carries.
+const opdsPageSize = 50
+
+const (
+	atomNamespace       = "http://www.w3.org/2005/Atom"
+	opdsNamespace       = "http://opds-spec.org/2010/catalog"
+	openSearchNamespace = "http://a9.com/-/spec/opensearch/1.1/"
+	dcTermsNamespace    = "http://purl.org/dc/terms/"
+
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsEntryType       = "application/atom+xml;type=entry;profile=opds-catalog"
+	openSearchType      = "application/opensearchdescription+xml"
+
+	opdsRelBorrow    = "http://opds-spec.org/acquisition/borrow"
+	opdsRelNew       = "http://opds-spec.org/sort/new"
+	opdsRelImage     = "http://opds-spec.org/image"
+	opdsRelThumbnail = "http://opds-spec.org/image/thumbnail"
+)
+
+type atomLink struct {
+	Rel          string            `xml:"rel,attr,omitempty"`
+	Href         string            `xml:"href,attr"`
+	Type         string            `xml:"type,attr,omitempty"`
+	Title        string            `xml:"title,attr,omitempty"`
+	Availability *opdsAvailability `xml:"opds:availability,omitempty"`
+	Copies       *opdsCopies       `xml:"opds:copies,omitempty"`
+}
+
+// opdsAvailability and opdsCopies describe whether a borrow link can be
+// followed right now, as in the Library Simplified OPDS extensions.
+type opdsAvailability struct {
+	Status string `xml:"status,attr"` // available or unavailable
+}
+
+type opdsCopies struct {
+	Total     int `xml:"total,attr"`
+	Available int `xml:"available,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Scheme string `xml:"scheme,attr,omitempty"`
+	Term   string `xml:"term,attr"`
+	Label  string `xml:"label,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	XMLName xml.Name `xml:"entry"`
+
+	// Namespaces are only declared on a standalone entry document
+	Xmlns     string `xml:"xmlns,attr,omitempty"`
+	XmlnsDC   string `xml:"xmlns:dc,attr,omitempty"`
+	XmlnsOPDS string `xml:"xmlns:opds,attr,omitempty"`
+
+	Title        string         `xml:"title"`
+	ID           string         `xml:"id"`
+	Updated      string         `xml:"updated"`
+	Authors      []atomPerson   `xml:"author"`
+	Contributors []atomPerson   `xml:"contributor"`
+	Identifier   string         `xml:"dc:identifier,omitempty"`
+	Published    string         `xml:"published,omitempty"`
+	Categories   []atomCategory `xml:"category"`
+	Content      *atomText      `xml:"content,omitempty"`
+	Links        []atomLink     `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName   xml.Name `xml:"feed"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	XmlnsDC   string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string   `xml:"xmlns:opds,attr"`
+	XmlnsOS   string   `xml:"xmlns:opensearch,attr"`
+
+	ID           string      `xml:"id"`
+	Title        string      `xml:"title"`
+	Updated      string      `xml:"updated"`
+	Author       atomPerson  `xml:"author"`
+	TotalResults *int        `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage *int        `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   *int        `xml:"opensearch:startIndex,omitempty"`
+	Links        []atomLink  `xml:"link"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newOPDSFeed starts a feed whose id and self link are the request URL. Every
+// feed links back to the root and to the OpenSearch description.
+func newOPDSFeed(c *gin.Context, title, kind string) *atomFeed {
+	base := requestBaseURL(c)
+	self := base + c.Request.URL.RequestURI()
+	return &atomFeed{
+		Xmlns:     atomNamespace,
+		XmlnsDC:   dcTermsNamespace,
+		XmlnsOPDS: opdsNamespace,
+		XmlnsOS:   openSearchNamespace,
+		ID:        self,
+		Title:     title,
+		Updated:   atomTime(time.Now()),
+		Author:    atomPerson{Name: loadOAIRepository(c).name, URI: base + "/opds"},
+		Links: []atomLink{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: base + "/opds", Type: opdsNavigationType, Title: "Catalogue"},
+			{Rel: "search", Href: base + "/opds/opensearch.xml", Type: openSearchType, Title: "Search the catalogue"},
+		},
+	}
+}
+
+func writeOPDS(c *gin.Context, contentType string, v interface{}) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Printf("encode OPDS feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode feed"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, contentType+";charset=utf-8", append([]byte(xml.Header), data...))
+}
+
+// navigationEntry is an entry of a navigation feed pointing at another feed.
+func navigationEntry(c *gin.Context, title, path, kind, summary string) atomEntry {
+	href := requestBaseURL(c) + path
+	entry := atomEntry{
+		Title:   title,
+		ID:      href,
+		Updated: atomTime(time.Now()),
+		Links:   []atomLink{{Rel: "subsection", Href: href, Type: kind}},
+	}
+	if summary != "" {
+		entry.Content = &atomText{Type: "text", Text: summary}
+	}
+	return entry
+}
+
+// opdsPage reads the 1-based ?page= parameter.
+func opdsPage(c *gin.Context) (int, bool) {
+	v := c.Query("page")
+	if v == "" {
+		return 1, true
+	}
+	page, err := strconv.Atoi(v)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return 0, false
+	}
+	return page, true
+}
+
+// pageLinks adds the OpenSearch counters and first/previous/next/last links
+// for a paged feed.
+func (f *atomFeed) pageLinks(c *gin.Context, kind string, page, total int) {
+	perPage, start := opdsPageSize, (page-1)*opdsPageSize+1
+	f.TotalResults, f.ItemsPerPage, f.StartIndex = &total, &perPage, &start
+
+	last := max(1, (total+opdsPageSize-1)/opdsPageSize)
+	link := func(rel string, p int) {
+		u := *c.Request.URL
+		q := u.Query()
+		q.Set("page", strconv.Itoa(p))
+		u.RawQuery = q.Encode()
+		f.Links = append(f.Links, atomLink{Rel: rel, Href: requestBaseURL(c) + u.RequestURI(), Type: kind})
+	}
+	link("first", 1)
+	if page > 1 {
+		link("previous", page-1)
+	}
+	if page < last {
+		link("next", page+1)
+	}
+	link("last", last)
+}
+
+// OPDSRoot is the OPDS start feed: new arrivals, browsing by category and by
+// author, and search.
+func OPDSRoot() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		feed := newOPDSFeed(c, "Library catalogue", opdsNavigationType)
+		feed.Links = append(feed.Links, atomLink{Rel: opdsRelNew, Href: requestBaseURL(c) + "/opds/new", Type: opdsAcquisitionType, Title: "New arrivals"})
+		feed.Entries = []atomEntry{
+			navigationEntry(c, "New arrivals", "/opds/new", opdsAcquisitionType, "Books most recently added to the catalogue"),
+			navigationEntry(c, "By category", "/opds/categories", opdsNavigationType, "Browse the subject tree"),
+			navigationEntry(c, "By author", "/opds/authors", opdsNavigationType, "Browse authors A to Z"),
+			navigationEntry(c, "All books", "/opds/books", opdsAcquisitionType, "The whole catalogue by title"),
+		}
+		writeOPDS(c, opdsNavigationType, feed)
+	}
+}
+
+// OPDSAllBooks is an acquisition feed of the whole catalogue by title.
+func OPDSAllBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opdsBookFeed(c, "All books", &sqlFilter{}, "bookName, BookID")
+	}
+}
+
+// OPDSNewArrivals lists books newest first.
+func OPDSNewArrivals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opdsBookFeed(c, "New arrivals", &sqlFilter{}, "Created_at DESC, BookID DESC")
+	}
+}
+
+// OPDSSearch is the target of the OpenSearch template. Every word of q must
+// match the title, an author or a subject, as with cql.serverChoice in SRU.
+func OPDSSearch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		words := strings.Fields(c.Query("q"))
+		if len(words) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+			return
+		}
+		t := &cqlTranslator{q: db}
+		filter := &sqlFilter{}
+		for _, word := range words {
+			// Masking characters in free text are taken literally
+			word = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "^", `\^`).Replace(word)
+			cond, args, err := t.clause(&helper.CQLClause{Index: "cql.serverChoice", Relation: "=", Term: word})
+			if err != nil {
+				log.Printf("OPDS search %q: %v", c.Query("q"), err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search"})
+				return
+			}
+			filter.add(cond, args...)
+		}
+		opdsBookFeed(c, fmt.Sprintf("Search results for %q", c.Query("q")), filter, "bookName, BookID")
+	}
+}
+
+// OPDSCategories is a navigation feed of the top-level categories.
+func OPDSCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		_, roots, err := loadCategories(db)
+		if err != nil {
+			log.Printf("OPDS categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load categories"})
+			return
+		}
+		feed := newOPDSFeed(c, "By category", opdsNavigationType)
+		feed.Links = append(feed.Links, atomLink{Rel: "up", Href: requestBaseURL(c) + "/opds", Type: opdsNavigationType})
+		for _, cat := range roots {
+			feed.Entries = append(feed.Entries, categoryNavigationEntry(c, cat))
+		}
+		writeOPDS(c, opdsNavigationType, feed)
+	}
+}
+
+// categoryNavigationEntry leads to a category's subcategories, or straight
+// to its books when it has none.
+func categoryNavigationEntry(c *gin.Context, cat *Category) atomEntry {
+	if len(cat.Children) == 0 {
+		return navigationEntry(c, cat.Name, fmt.Sprintf("/opds/categories/%d/books", cat.CategoryID), opdsAcquisitionType, cat.Path)
+	}
+	return navigationEntry(c, cat.Name, fmt.Sprintf("/opds/categories/%d", cat.CategoryID), opdsNavigationType, cat.Path)
+}
+
+// OPDSCategory is a navigation feed of one category: all of its books, then
+// each subcategory.
+func OPDSCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		cat, ok := opdsCategoryParam(c, db)
+		if !ok {
+			return
+		}
+		feed := newOPDSFeed(c, cat.Path, opdsNavigationType)
+		up := "/opds/categories"
+		if cat.ParentID != nil {
+			up = fmt.Sprintf("/opds/categories/%d", *cat.ParentID)
+		}
+		feed.Links = append(feed.Links, atomLink{Rel: "up", Href: requestBaseURL(c) + up, Type: opdsNavigationType})
+		feed.Entries = append(feed.Entries, navigationEntry(c, "All "+cat.Name, fmt.Sprintf("/opds/categories/%d/books", cat.CategoryID),
+			opdsAcquisitionType, "Every book in "+cat.Path+" and below"))
+		for _, child := range cat.Children {
+			feed.Entries = append(feed.Entries, categoryNavigationEntry(c, child))
+		}
+		writeOPDS(c, opdsNavigationType, feed)
+	}
+}
+
+// OPDSCategoryBooks lists the books of a category and its subcategories.
+func OPDSCategoryBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		cat, ok := opdsCategoryParam(c, db)
+		if !ok {
+			return
+		}
+		ids, err := categorySubtreeIDs(db, cat.CategoryID)
+		if err != nil {
+			log.Printf("OPDS category %d: %v", cat.CategoryID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load category"})
+			return
+		}
+		placeholders := make([]string, len(ids))
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		filter := &sqlFilter{}
+		filter.add("BookID IN (SELECT BookID FROM BookCategory WHERE CategoryID IN ("+strings.Join(placeholders, ", ")+"))", args...)
+		opdsBookFeed(c, cat.Path, filter, "bookName, BookID")
+	}
+}
+
+func opdsCategoryParam(c *gin.Context, db *sql.DB) (*Category, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category ID"})
+		return nil, false
+	}
+	byID, _, err := loadCategories(db)
+	if err != nil {
+		log.Printf("OPDS category %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load categories"})
+		return nil, false
+	}
+	cat, ok := byID[id]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+		return nil, false
+	}
+	return cat, true
+}
+
+// OPDSAuthors is a paged navigation feed of every credited author, by sort name.
+func OPDSAuthors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		page, ok := opdsPage(c)
+		if !ok {
+			return
+		}
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(DISTINCT AuthorID) FROM BookAuthor").Scan(&total); err != nil {
+			log.Printf("OPDS authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load authors"})
+			return
+		}
+		rows, err := db.Query(`
+			SELECT a.AuthorID, a.Name, COUNT(DISTINCT ba.BookID)
+			FROM Author a JOIN BookAuthor ba ON ba.AuthorID = a.AuthorID
+			GROUP BY a.AuthorID, a.Name, a.SortName
+			ORDER BY COALESCE(a.SortName, a.Name), a.AuthorID
+			OFFSET ? ROWS FETCH NEXT ? ROWS ONLY`, (page-1)*opdsPageSize, opdsPageSize)
+		if err != nil {
+			log.Printf("OPDS authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load authors"})
+			return
+		}
+		defer rows.Close()
+
+		feed := newOPDSFeed(c, "By author", opdsNavigationType)
+		feed.Links = append(feed.Links, atomLink{Rel: "up", Href: requestBaseURL(c) + "/opds", Type: opdsNavigationType})
+		for rows.Next() {
+			var id, count int
+			var name string
+			if err := rows.Scan(&id, &name, &count); err != nil {
+				log.Printf("scan OPDS author: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load authors"})
+				return
+			}
+			summary := fmt.Sprintf("%d books", count)
+			if count == 1 {
+				summary = "1 book"
+			}
+			feed.Entries = append(feed.Entries, navigationEntry(c, name, fmt.Sprintf("/opds/authors/%d", id), opdsAcquisitionType, summary))
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("OPDS authors: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load authors"})
+			return
+		}
+		feed.pageLinks(c, opdsNavigationType, page, total)
+		writeOPDS(c, opdsNavigationType, feed)
+	}
+}
+
+// OPDSAuthorBooks lists the books an author is credited on, in any role.
+func OPDSAuthorBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author ID"})
+			return
+		}
+		var name string
+		err = db.QueryRow("SELECT Name FROM Author WHERE AuthorID = ?", id).Scan(&name)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "author not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("OPDS author %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load author"})
+			return
+		}
+		filter := &sqlFilter{}
+		filter.add("BookID IN (SELECT BookID FROM BookAuthor WHERE AuthorID = ?)", id)
+		opdsBookFeed(c, name, filter, "bookName, BookID")
+	}
+}
+
+// OPDSBook is the complete entry of one book.
+func OPDSBook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book ID"})
+			return
+		}
+		var book Book
+		err = scanBook(db.QueryRow("SELECT "+bookColumns+" FROM Book WHERE BookID = ?", id), &book)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+			return
+		}
+		books := []Book{book}
+		var copies map[int]int
+		if err == nil {
+			err = attachBookDetails(db, books)
+		}
+		if err == nil {
+			copies, err = countShelvedCopies(db, books)
+		}
+		if err != nil {
+			log.Printf("OPDS book %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load book"})
+			return
+		}
+		entry := bookEntry(c, &books[0], copies[id])
+		entry.Xmlns, entry.XmlnsDC, entry.XmlnsOPDS = atomNamespace, dcTermsNamespace, opdsNamespace
+		writeOPDS(c, opdsEntryType, entry)
+	}
+}
+
+// opdsBookFeed writes a paged acquisition feed of the books filter selects.
+func opdsBookFeed(c *gin.Context, title string, filter *sqlFilter, orderBy string) {
+	db := database.Database()
+	page, ok := opdsPage(c)
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Book"+filter.where(), filter.args...).Scan(&total); err != nil {
+		log.Printf("OPDS feed %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load books"})
+		return
+	}
+	query := "SELECT " + bookColumns + " FROM Book" + filter.where() + " ORDER BY " + orderBy + " OFFSET ? ROWS FETCH NEXT ? ROWS ONLY"
+	rows, err := db.Query(query, append(filter.args, (page-1)*opdsPageSize, opdsPageSize)...)
+	if err != nil {
+		log.Printf("OPDS feed %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load books"})
+		return
+	}
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := scanBook(rows, &book); err != nil {
+			rows.Close()
+			log.Printf("scan OPDS book: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load books"})
+			return
+		}
+		books = append(books, book)
+	}
+	rows.Close()
+	var copies map[int]int
+	if err = rows.Err(); err == nil {
+		err = attachBookDetails(db, books)
+	}
+	if err == nil {
+		copies, err = countShelvedCopies(db, books)
+	}
+	if err != nil {
+		log.Printf("OPDS feed %s: %v", c.Request.URL.Path, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load books"})
+		return
+	}
+
+	feed := newOPDSFeed(c, title, opdsAcquisitionType)
+	feed.Links = append(feed.Links, atomLink{Rel: "up", Href: requestBaseURL(c) + "/opds", Type: opdsNavigationType})
+	var updated time.Time
+	for i := range books {
+		feed.Entries = append(feed.Entries, bookEntry(c, &books[i], copies[books[i].BookID]))
+		if books[i].UpdatedAt.After(updated) {
+			updated = books[i].UpdatedAt
+		}
+	}
+	if !updated.IsZero() {
+		feed.Updated = atomTime(updated)
+	}
+	feed.pageLinks(c, opdsAcquisitionType, page, total)
+	writeOPDS(c, opdsAcquisitionType, feed)
+}
+
+// countShelvedCopies counts the copies of each book that are in circulation,
+// i.e. not lost or withdrawn.
+func countShelvedCopies(q queryer, books []Book) (map[int]int, error) {
+	counts := map[int]int{}
+	if len(books) == 0 {
+		return counts, nil
+	}
+	err := queryBookBatches(q, books, "SELECT BookID, COUNT(*) FROM BookCopy WHERE Status NOT IN (?, ?) AND BookID IN (%s) GROUP BY BookID",
+		[]interface{}{CopyLost, CopyWithdrawn}, func(rows *sql.Rows) error {
+			var id, n int
+			if err := rows.Scan(&id, &n); err != nil {
+				return err
+			}
+			counts[id] = n
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("count copies: %w", err)
+	}
+	return counts, nil
+}
+
+// bookEntry renders a book as an OPDS entry. The borrow link points at the
+// book's copies and says how many are on the shelf now.
+func bookEntry(c *gin.Context, book *Book, totalCopies int) atomEntry {
+	base := requestBaseURL(c)
+	href := fmt.Sprintf("%s/opds/books/%d", base, book.BookID)
+	entry := atomEntry{
+		Title:     book.BookName,
+		ID:        href,
+		Updated:   atomTime(book.UpdatedAt),
+		Published: atomTime(book.CreatedAt),
+	}
+	for _, bc := range book.Authors {
+		person := atomPerson{Name: bc.Name, URI: fmt.Sprintf("%s/opds/authors/%d", base, bc.AuthorID)}
+		if bc.Role == RoleAuthor {
+			entry.Authors = append(entry.Authors, person)
+		} else {
+			entry.Contributors = append(entry.Contributors, person)
+		}
+	}
+	if book.ISBN13 != nil {
+		entry.Identifier = "urn:isbn:" + *book.ISBN13
+	}
+	for _, cat := range book.Categories {
+		entry.Categories = append(entry.Categories, atomCategory{
+			Scheme: base + "/opds/categories",
+			Term:   strconv.Itoa(cat.CategoryID),
+			Label:  cat.Name,
+		})
+	}
+
+	status := "unavailable"
+	if book.IsAvailable {
+		status = "available"
+	}
+	entry.Links = []atomLink{
+		{Rel: "alternate", Href: href, Type: opdsEntryType, Title: "Full entry"},
+		{
+			Rel:          opdsRelBorrow,
+			Href:         fmt.Sprintf("%s/book/%d/copies", base, book.BookID),
+			Type:         "application/json",
+			Title:        "Availability",
+			Availability: &opdsAvailability{Status: status},
+			Copies:       &opdsCopies{Total: totalCopies, Available: book.BookQuantity},
+		},
+		{Rel: "alternate", Href: fmt.Sprintf("%s/book/%d/marc?format=%s", base, book.BookID, MARCXML), Type: "application/marcxml+xml", Title: "MARCXML"},
+	}
+	if book.Cover != nil {
+		absolute := func(u string) string {
+			if strings.HasPrefix(u, "/") {
+				return base + u
+			}
+			return u
+		}
+		entry.Links = append(entry.Links,
+			atomLink{Rel: opdsRelImage, Href: absolute(book.Cover.URL), Type: book.Cover.ContentType},
+			atomLink{Rel: opdsRelThumbnail, Href: absolute(book.Cover.Thumbnails["medium"]), Type: "image/jpeg"})
+	}
+	return entry
+}
+
+// openSearchDescription is the OpenSearch 1.1 document clients read to learn
+// how to search the catalogue.
+type openSearchDescription struct {
+	XMLName        xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	URLs           []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// OpenSearch serves the OpenSearch description that OPDS feeds link to.
+func OpenSearch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		desc := openSearchDescription{
+			Xmlns:          openSearchNamespace,
+			ShortName:      "Catalogue",
+			Description:    "Search " + loadOAIRepository(c).name + " by title, author or subject",
+			InputEncoding:  "UTF-8",
+			OutputEncoding: "UTF-8",
+			URLs: []openSearchURL{
+				{Type: opdsAcquisitionType, Template: requestBaseURL(c) + "/opds/search?q={searchTerms}&page={startPage?}"},
+			},
+		}
+		writeOPDS(c, openSearchType, desc)
+	}
+}
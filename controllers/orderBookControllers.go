@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"database/sql"
+	"fmt"
 	database "go-crud-api/config"
 	"log"
 	"net/http"
@@ -17,10 +18,191 @@ type OrderBook struct {
 	OrderID          int     `json:"OrderID"`
 	PersonID         int     `json:"PersonID"`
 	BookID           int     `json:"BookID"`
+	CopyID           *int    `json:"CopyID"`           // Copy lent out; picked automatically if omitted
 	BorrowDate       string  `json:"BorrowDate"`       // String in YYYY-MM-DD format
-	ReturnDate       *string `json:"ReturnDate"`       // Nullable
+	ReturnDate       *string `json:"ReturnDate"`       // Due date; set from the circulation policy for new loans
 	ActualReturnDate *string `json:"ActualReturnDate"` // Nullable
 	Status           string  `json:"Status"`
+	RenewalCount     int     `json:"RenewalCount"` // Set by POST /orderbook/:id/renew
+
+	// Override lends despite a fines block; it is recorded, not stored on the order
+	Override *BlockOverride `json:"Override,omitempty"`
+
+	// Condition may accompany a return; the copy's assessment comes back
+	// as Assessment
+	Condition  *ConditionReport     `json:"Condition,omitempty"`
+	Assessment *ConditionAssessment `json:"Assessment,omitempty"`
+}
+
+const orderColumns = "OrderID, PersonID, BookID, CopyID, BorrowDate, ReturnDate, ActualReturnDate, Status, RenewalCount"
+
+// scanOrder reads one row selected with orderColumns into an OrderBook.
+func scanOrder(row rowScanner, order *OrderBook) error {
+	var borrowDate time.Time
+	var returnDate, actualReturnDate sql.NullTime
+	var copyID sql.NullInt64
+	err := row.Scan(
+		&order.OrderID,
+		&order.PersonID,
+		&order.BookID,
+		&copyID,
+		&borrowDate,
+		&returnDate,
+		&actualReturnDate,
+		&order.Status,
+		&order.RenewalCount,
+	)
+	if err != nil {
+		return err
+	}
+
+	order.BorrowDate = borrowDate.Format("2006-01-02")
+	order.CopyID, order.ReturnDate, order.ActualReturnDate = nil, nil, nil
+	if copyID.Valid {
+		id := int(copyID.Int64)
+		order.CopyID = &id
+	}
+	if returnDate.Valid {
+		dateStr := returnDate.Time.Format("2006-01-02")
+		order.ReturnDate = &dateStr
+	}
+	if actualReturnDate.Valid {
+		dateStr := actualReturnDate.Time.Format("2006-01-02")
+		order.ActualReturnDate = &dateStr
+	}
+	return nil
+}
+
+// insertOrder stores a new order, setting its OrderID.
+func insertOrder(q queryer, order *OrderBook, borrowed time.Time) error {
+	insertQuery := `
+        INSERT INTO OrderBook (PersonID, BookID, CopyID, BorrowDate, ReturnDate, ActualReturnDate, Status)
+        VALUES (?, ?, ?, ?, ?, ?, ?);
+        SELECT SCOPE_IDENTITY() AS OrderID;`
+	err := q.QueryRow(insertQuery,
+		order.PersonID,
+		order.BookID,
+		order.CopyID,
+		borrowed,
+		order.ReturnDate,
+		order.ActualReturnDate,
+		order.Status,
+	).Scan(&order.OrderID)
+	if err != nil {
+		return fmt.Errorf("insert order: %w", err)
+	}
+	return nil
+}
+
+// checkCopyOfBook makes sure copyID exists and is a copy of bookID.
+func checkCopyOfBook(q queryer, copyID, bookID int) error {
+	var copyBookID int
+	err := q.QueryRow("SELECT BookID FROM BookCopy WHERE CopyID = ?", copyID).Scan(&copyBookID)
+	if err == sql.ErrNoRows {
+		return newRequestError(http.StatusNotFound, "COPY_NOT_FOUND", "copy %d not found", copyID)
+	}
+	if err != nil {
+		return fmt.Errorf("load copy %d: %w", copyID, err)
+	}
+	if copyBookID != bookID {
+		return newRequestError(http.StatusConflict, "COPY_BOOK_MISMATCH", "copy %d does not belong to book %d", copyID, bookID)
+	}
+	return nil
+}
+
+// lendBook creates an open loan. The patron must not owe too much in fines,
+// unless the order carries a librarian's override, and the loan must fit
+// their circulation policy, which also decides when it is due. It takes a
+// specific copy off the shelf, or the one set aside for the patron's hold.
+func lendBook(q queryer, order *OrderBook, borrowed time.Time) error {
+	block, err := fineBlock(q, order.PersonID)
+	if err != nil {
+		return err
+	}
+	if block != nil && order.Override == nil {
+		return block
+	}
+
+	policy, err := policyFor(q, order.PersonID, order.BookID)
+	if err != nil {
+		return err
+	}
+	if err := policy.checkLoanLimit(q, order.PersonID); err != nil {
+		return err
+	}
+	dueDate, err := policy.dueDate(q, borrowed)
+	if err != nil {
+		return err
+	}
+	due := dueDate.Format("2006-01-02")
+	order.ReturnDate = &due
+
+	copyID, holdID, err := checkoutCopy(q, order.PersonID, order.BookID, order.CopyID)
+	if err != nil {
+		return err
+	}
+	order.CopyID = &copyID
+
+	if err := insertOrder(q, order, borrowed); err != nil {
+		return err
+	}
+	if holdID != 0 {
+		if err := fulfillHold(q, holdID, order.OrderID); err != nil {
+			return err
+		}
+	}
+	if block != nil {
+		return recordOverride(q, order.PersonID, order.OrderID, "checkout", block, order.Override)
+	}
+	return nil
+}
+
+// settleReturn does what follows an open loan being marked Returned: the
+// copy's condition is recorded when a report is given, the copy goes back
+// on the shelf or to the next hold, and a late return settles the overdue
+// fine as of today.
+func settleReturn(q queryer, orderID int, copyID sql.NullInt64, today time.Time, report *ConditionReport) (*ConditionAssessment, error) {
+	var assessment *ConditionAssessment
+	if report != nil {
+		if !copyID.Valid {
+			return nil, newRequestError(http.StatusConflict, "NO_COPY", "order %d has no copy to assess", orderID)
+		}
+		a, err := assessCondition(q, int(copyID.Int64), &orderID, *report)
+		if err != nil {
+			return nil, err
+		}
+		assessment = &a
+	}
+	if copyID.Valid {
+		if _, err := releaseCopy(q, int(copyID.Int64)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := assessOverdueFine(q, orderID, today); err != nil {
+		return nil, err
+	}
+	return assessment, nil
+}
+
+// returnLoan closes an open loan as returned on the given day, with the
+// copy's condition when a report is given.
+func returnLoan(q queryer, orderID int, today time.Time, report *ConditionReport) (*ConditionAssessment, error) {
+	var status string
+	var copyID sql.NullInt64
+	err := q.QueryRow("SELECT Status, CopyID FROM OrderBook WITH (UPDLOCK) WHERE OrderID = ?", orderID).Scan(&status, &copyID)
+	if err == sql.ErrNoRows {
+		return nil, newRequestError(http.StatusNotFound, "ORDER_NOT_FOUND", "order %d not found", orderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load order %d: %w", orderID, err)
+	}
+	if !isOpenLoanStatus(status) {
+		return nil, newRequestError(http.StatusConflict, "LOAN_CLOSED", "order %d is already %s", orderID, status)
+	}
+	if _, err := q.Exec("UPDATE OrderBook SET Status = 'Returned', ActualReturnDate = ? WHERE OrderID = ?", today, orderID); err != nil {
+		return nil, fmt.Errorf("return order %d: %w", orderID, err)
+	}
+	return settleReturn(q, orderID, copyID, today, report)
 }
 
 // CreateOrderBook handles the creation of a new order
@@ -71,29 +253,47 @@ func CreateOrderBook() gin.HandlerFunc {
 			}
 		}
 		newOrder.Status = strings.TrimSpace(newOrder.Status)
+		newOrder.RenewalCount = 0
 		if newOrder.Status == "" {
 			newOrder.Status = "Borrowed" // Default status
 		}
-		if !isValidStatus(newOrder.Status) {
+		if !isValidStatus(newOrder.Status) || isLossStatus(newOrder.Status) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of: Borrowed, Returned, Overdue"})
 			return
 		}
+		if err := checkOverride(c, newOrder.Override); err != nil {
+			respondRequestError(c, err, "failed to create order")
+			return
+		}
 
-		// Insert into database
-		insertQuery := `
-            INSERT INTO OrderBook (PersonID, BookID, BorrowDate, ReturnDate, ActualReturnDate, Status)
-            VALUES (?, ?, ?, ?, ?, ?);
-            SELECT SCOPE_IDENTITY() AS OrderID;`
-		err = db.QueryRow(insertQuery,
-			newOrder.PersonID,
-			newOrder.BookID,
-			borrowDate,
-			newOrder.ReturnDate,
-			newOrder.ActualReturnDate,
-			newOrder.Status,
-		).Scan(&newOrder.OrderID)
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
+			return
+		}
+		defer tx.Rollback()
+
+		if isOpenLoanStatus(newOrder.Status) {
+			err = lendBook(tx, &newOrder, borrowDate)
+		} else {
+			// A closed order only records history, but its copy must still
+			// be a copy of its book
+			if newOrder.CopyID != nil {
+				err = checkCopyOfBook(tx, *newOrder.CopyID, newOrder.BookID)
+			}
+			if err == nil {
+				err = insertOrder(tx, &newOrder, borrowDate)
+			}
+		}
 		if err != nil {
 			log.Printf("insert order: %v", err)
+			respondRequestError(c, err, "failed to create order")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit order: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
 			return
 		}
@@ -177,7 +377,7 @@ func UpdateOrderBook() gin.HandlerFunc {
 		if updateOrder.Status != "" {
 			updateOrder.Status = strings.TrimSpace(updateOrder.Status)
 			if !isValidStatus(updateOrder.Status) {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of: Borrowed, Returned, Overdue"})
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of: Borrowed, Returned, Overdue, Lost, Damaged"})
 				return
 			}
 			setClauses = append(setClauses, "Status = ?")
@@ -189,27 +389,78 @@ func UpdateOrderBook() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "no valid fields provided for update"})
 			return
 		}
+		if err := checkConditionReport(c, updateOrder.Condition); err != nil {
+			respondRequestError(c, err, "failed to update order")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Load the current status and copy so a return can put the copy back
+		var currentStatus string
+		var currentCopyID sql.NullInt64
+		err = tx.QueryRow("SELECT Status, CopyID FROM OrderBook WITH (UPDLOCK) WHERE OrderID = ?", orderID).
+			Scan(&currentStatus, &currentCopyID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("query order %d: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
+			return
+		}
+		if updateOrder.Status != "" && isOpenLoanStatus(updateOrder.Status) && !isOpenLoanStatus(currentStatus) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a closed order cannot be reopened; create a new order instead"})
+			return
+		}
+		// The order's copy pins it to that copy's book
+		if updateOrder.BookID > 0 && currentCopyID.Valid {
+			if err := checkCopyOfBook(tx, int(currentCopyID.Int64), updateOrder.BookID); err != nil {
+				log.Printf("update order %d: %v", orderID, err)
+				respondRequestError(c, err, "failed to update order")
+				return
+			}
+		}
+		// Losses charge and credit fines, so they have their own actions
+		if updateOrder.Status != "" && updateOrder.Status != currentStatus &&
+			(isLossStatus(updateOrder.Status) || isLossStatus(currentStatus)) {
+			c.JSON(http.StatusConflict, gin.H{"error": "use POST /orderbook/:id/lost, /damaged or /found to change a loss"})
+			return
+		}
 
 		// Construct the query
 		updateQuery := "UPDATE OrderBook SET " + strings.Join(setClauses, ", ") + " WHERE OrderID = ?"
 		args = append(args, orderID)
 
 		// Execute the query
-		result, err := db.Exec(updateQuery, args...)
-		if err != nil {
+		if _, err := tx.Exec(updateQuery, args...); err != nil {
 			log.Printf("update order %d: %v", orderID, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
 			return
 		}
 
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
-			log.Printf("check rows affected: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify update"})
+		if isOpenLoanStatus(currentStatus) && updateOrder.Status == "Returned" {
+			updateOrder.Assessment, err = settleReturn(tx, orderID, currentCopyID, time.Now().UTC().Truncate(24*time.Hour), updateOrder.Condition)
+			if err != nil {
+				log.Printf("return order %d: %v", orderID, err)
+				respondRequestError(c, err, "failed to update order")
+				return
+			}
+		} else if updateOrder.Condition != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Condition is only recorded when an open loan is returned"})
 			return
 		}
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit order %d: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
 			return
 		}
 
@@ -228,10 +479,13 @@ func GetAllOrderBooks() gin.HandlerFunc {
 			return
 		}
 
-		query := `
-			SELECT OrderID, PersonID, BookID, BorrowDate, ReturnDate, ActualReturnDate, Status
-			FROM OrderBook`
-		rows, err := db.Query(query)
+		filter, err := orderFilter(c.Request.URL.Query())
+		if err != nil {
+			respondRequestError(c, err, "failed to get orders")
+			return
+		}
+
+		rows, err := db.Query("SELECT "+orderColumns+" FROM OrderBook"+filter.where(), filter.args...)
 		if err != nil {
 			log.Printf("get all orders: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get orders"})
@@ -242,34 +496,11 @@ func GetAllOrderBooks() gin.HandlerFunc {
 		var orders []OrderBook
 		for rows.Next() {
 			var order OrderBook
-			var borrowDate time.Time
-			var returnDate, actualReturnDate sql.NullTime
-
-			err := rows.Scan(
-				&order.OrderID,
-				&order.PersonID,
-				&order.BookID,
-				&borrowDate,
-				&returnDate,
-				&actualReturnDate,
-				&order.Status,
-			)
-			if err != nil {
+			if err := scanOrder(rows, &order); err != nil {
 				log.Printf("scan order: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to scan order"})
 				return
 			}
-
-			order.BorrowDate = borrowDate.Format("2006-01-02")
-			if returnDate.Valid {
-				formattedReturnDate := returnDate.Time.Format("2006-01-02")
-				order.ReturnDate = &formattedReturnDate
-			}
-			if actualReturnDate.Valid {
-				formattedActualReturnDate := actualReturnDate.Time.Format("2006-01-02")
-				order.ActualReturnDate = &formattedActualReturnDate
-			}
-
 			orders = append(orders, order)
 		}
 
@@ -299,23 +530,8 @@ func GetOrderBookByID() gin.HandlerFunc {
 			return
 		}
 
-		query := `
-            SELECT OrderID, PersonID, BookID, BorrowDate, ReturnDate, ActualReturnDate, Status
-            FROM OrderBook
-            WHERE OrderID = ?`
 		var order OrderBook
-		var borrowDate time.Time
-		var returnDate, actualReturnDate sql.NullTime
-
-		err = db.QueryRow(query, orderID).Scan(
-			&order.OrderID,
-			&order.PersonID,
-			&order.BookID,
-			&borrowDate,
-			&returnDate,
-			&actualReturnDate,
-			&order.Status,
-		)
+		err = scanOrder(db.QueryRow("SELECT "+orderColumns+" FROM OrderBook WHERE OrderID = ?", orderID), &order)
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
 			return
@@ -326,16 +542,6 @@ func GetOrderBookByID() gin.HandlerFunc {
 			return
 		}
 
-		order.BorrowDate = borrowDate.Format("2006-01-02")
-		if returnDate.Valid {
-			dateStr := returnDate.Time.Format("2006-01-02")
-			order.ReturnDate = &dateStr
-		}
-		if actualReturnDate.Valid {
-			dateStr := actualReturnDate.Time.Format("2006-01-02")
-			order.ActualReturnDate = &dateStr
-		}
-
 		c.JSON(http.StatusOK, order)
 	}
 }
@@ -343,9 +549,16 @@ func GetOrderBookByID() gin.HandlerFunc {
 // isValidStatus checks if the status is valid
 func isValidStatus(status string) bool {
 	validStatuses := map[string]bool{
-		"Borrowed": true,
-		"Returned": true,
-		"Overdue":  true,
+		"Borrowed":   true,
+		"Returned":   true,
+		"Overdue":    true,
+		OrderLost:    true,
+		OrderDamaged: true,
 	}
 	return validStatuses[status]
 }
+
+// isOpenLoanStatus reports whether an order with this status still holds its copy
+func isOpenLoanStatus(status string) bool {
+	return status == "Borrowed" || status == "Overdue"
+}
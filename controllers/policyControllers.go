@@ -0,0 +1,513 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPatronCategory is the category new patrons get.
+const defaultPatronCategory = "standard"
+
+// patronCategoryPattern is what a patron category code may look like.
+var patronCategoryPattern = regexp.MustCompile(`^[a-z0-9_-]{1,30}$`)
+
+// CirculationPolicy is one cell of the circulation matrix: the rules for
+// loans of books in CategoryID (or a subcategory) to patrons in
+// PatronCategory. A null key matches everything.
+type CirculationPolicy struct {
+	PolicyID       int     `json:"PolicyID"`
+	PatronCategory *string `json:"PatronCategory"`
+	CategoryID     *int    `json:"CategoryID"`
+	LoanPeriodDays int     `json:"LoanPeriodDays"`
+	MaxItems       *int    `json:"MaxItems"` // open loans a patron may have; null for no limit
+	MaxRenewals    int     `json:"MaxRenewals"`
+	FineTypeID     *int    `json:"FineTypeID"` // charged per day overdue; null for no overdue fines
+	FineGraceDays  int     `json:"FineGraceDays"`
+	MaxFineDays    *int    `json:"MaxFineDays"` // null for no cap
+	HoldsAllowed   bool    `json:"HoldsAllowed"`
+}
+
+// defaultPolicy applies when no row matches, which only happens if the
+// catch-all policy has been deleted.
+var defaultPolicy = CirculationPolicy{LoanPeriodDays: 14, MaxRenewals: 2, HoldsAllowed: true}
+
+const policyColumns = "p.PolicyID, p.PatronCategory, p.CategoryID, p.LoanPeriodDays, p.MaxItems, p.MaxRenewals, " +
+	"p.FineTypeID, p.FineGraceDays, p.MaxFineDays, p.HoldsAllowed"
+
+func scanPolicy(row rowScanner, p *CirculationPolicy) error {
+	var patron sql.NullString
+	var categoryID, maxItems, fineTypeID, maxFineDays sql.NullInt64
+	if err := row.Scan(&p.PolicyID, &patron, &categoryID, &p.LoanPeriodDays, &maxItems, &p.MaxRenewals,
+		&fineTypeID, &p.FineGraceDays, &maxFineDays, &p.HoldsAllowed); err != nil {
+		return err
+	}
+	p.PatronCategory = nil
+	if patron.Valid {
+		p.PatronCategory = &patron.String
+	}
+	p.CategoryID, p.MaxItems = nullIntPtr(categoryID), nullIntPtr(maxItems)
+	p.FineTypeID, p.MaxFineDays = nullIntPtr(fineTypeID), nullIntPtr(maxFineDays)
+	return nil
+}
+
+// policyFor finds the policy governing a loan of bookID to personID: a rule
+// for the patron's category beats a catch-all, then a rule for the book's
+// primary category beats one for an ancestor, which beats a catch-all.
+func policyFor(q queryer, personID, bookID int) (CirculationPolicy, error) {
+	p := defaultPolicy
+	err := scanPolicy(q.QueryRow(`
+		WITH Ancestors AS (
+			SELECT c.CategoryID, c.ParentID, 0 AS Depth
+			FROM BookCategory bc JOIN Category c ON c.CategoryID = bc.CategoryID
+			WHERE bc.BookID = ?
+			  AND bc.Position = (SELECT MIN(Position) FROM BookCategory WHERE BookID = ?)
+			UNION ALL
+			SELECT c.CategoryID, c.ParentID, a.Depth + 1
+			FROM Ancestors a JOIN Category c ON c.CategoryID = a.ParentID
+		)
+		SELECT TOP (1) `+policyColumns+`
+		FROM CirculationPolicy p
+		LEFT JOIN Ancestors a ON a.CategoryID = p.CategoryID
+		WHERE (p.PatronCategory IS NULL OR p.PatronCategory = (SELECT PatronCategory FROM Person WHERE ID = ?))
+		  AND (p.CategoryID IS NULL OR a.CategoryID IS NOT NULL)
+		ORDER BY CASE WHEN p.PatronCategory IS NULL THEN 1 ELSE 0 END,
+		         CASE WHEN p.CategoryID IS NULL THEN 1 ELSE 0 END, a.Depth`,
+		bookID, bookID, personID), &p)
+	if err == sql.ErrNoRows {
+		return defaultPolicy, nil
+	}
+	if err != nil {
+		return CirculationPolicy{}, fmt.Errorf("load policy for person %d and book %d: %w", personID, bookID, err)
+	}
+	return p, nil
+}
+
+// dueDate is when a loan made on borrowed is due back: after the loan
+// period, moved to the next day the library is open.
+func (p CirculationPolicy) dueDate(q queryer, borrowed time.Time) (time.Time, error) {
+	return nextOpenDay(q, borrowed.AddDate(0, 0, p.LoanPeriodDays))
+}
+
+// checkLoanLimit refuses a new loan when the patron already has MaxItems
+// books out. It locks the patron's row until the transaction ends, so that
+// concurrent checkouts for one patron are counted one after the other.
+func (p CirculationPolicy) checkLoanLimit(q queryer, personID int) error {
+	if p.MaxItems == nil {
+		return nil
+	}
+	var id int
+	err := q.QueryRow("SELECT ID FROM Person WITH (UPDLOCK, HOLDLOCK) WHERE ID = ?", personID).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("lock person %d: %w", personID, err)
+	}
+	var open int
+	if err := q.QueryRow("SELECT COUNT(*) FROM OrderBook WHERE PersonID = ? AND Status IN ('Borrowed', 'Overdue')", personID).Scan(&open); err != nil {
+		return fmt.Errorf("count loans of person %d: %w", personID, err)
+	}
+	if open >= *p.MaxItems {
+		e := newRequestError(http.StatusConflict, "LOAN_LIMIT", "person %d already has %d book(s) on loan", personID, open)
+		e.Details = gin.H{"limit": *p.MaxItems}
+		return e
+	}
+	return nil
+}
+
+// overdueFineDays is how many days of lateness are charged: none within the
+// grace period, otherwise every day late up to MaxFineDays.
+func (p CirculationPolicy) overdueFineDays(daysLate int) int {
+	if daysLate <= p.FineGraceDays {
+		return 0
+	}
+	if p.MaxFineDays != nil && daysLate > *p.MaxFineDays {
+		return *p.MaxFineDays
+	}
+	return daysLate
+}
+
+// assessOverdueFine brings an order's overdue fine up to date: an open loan
+// is charged up to today and a returned one up to its return date, for the
+// days the library was open, as one FineBookTable entry of the policy's fine
+// type per order. It also marks late open loans Overdue, and reports whether
+// anything changed.
+func assessOverdueFine(q queryer, orderID int, today time.Time) (bool, error) {
+	var personID, bookID int
+	var status string
+	var due, returned sql.NullTime
+	err := q.QueryRow("SELECT PersonID, BookID, Status, ReturnDate, ActualReturnDate FROM OrderBook WITH (UPDLOCK) WHERE OrderID = ?", orderID).
+		Scan(&personID, &bookID, &status, &due, &returned)
+	if err != nil {
+		return false, fmt.Errorf("load order %d: %w", orderID, err)
+	}
+	if !due.Valid {
+		return false, nil
+	}
+	end := today
+	if !isOpenLoanStatus(status) && returned.Valid {
+		end = returned.Time.UTC().Truncate(24 * time.Hour)
+	}
+	dueDay := due.Time.UTC().Truncate(24 * time.Hour)
+	daysLate := int(end.Sub(dueDay).Hours() / 24)
+	if daysLate <= 0 {
+		return false, nil
+	}
+
+	changed := false
+	if status == "Borrowed" {
+		if _, err := q.Exec("UPDATE OrderBook SET Status = 'Overdue' WHERE OrderID = ?", orderID); err != nil {
+			return false, fmt.Errorf("mark order %d overdue: %w", orderID, err)
+		}
+		changed = true
+	}
+
+	policy, err := policyFor(q, personID, bookID)
+	if err != nil {
+		return false, err
+	}
+	if policy.FineTypeID == nil {
+		return changed, nil
+	}
+	closed, err := closedDaysBetween(q, dueDay, end)
+	if err != nil {
+		return false, err
+	}
+	days := policy.overdueFineDays(daysLate - closed)
+	if days == 0 {
+		return changed, nil
+	}
+	var rate helper.Money
+	err = q.QueryRow("SELECT FineAmount, Currency FROM FineTable WHERE FineID = ?", *policy.FineTypeID).Scan(&rate, rate.CurrencyScanner())
+	if err != nil {
+		return false, fmt.Errorf("load fine type %d: %w", *policy.FineTypeID, err)
+	}
+	amount, err := rate.Mul(int64(days))
+	if err != nil {
+		return false, err
+	}
+	amount = amount.Round()
+
+	// A waived fine stays waived; a paid one is owed again if it grew
+	res, err := q.Exec(`
+		UPDATE FineBookTable
+		SET FineAmount = ?, Currency = ?,
+		    Status = CASE WHEN Status = 'Paid' AND AmountPaid < ? THEN 'Open' ELSE Status END,
+		    Closed_at = CASE WHEN Status = 'Paid' AND AmountPaid < ? THEN NULL ELSE Closed_at END
+		WHERE OrderID = ? AND FineTypeID = ? AND FineAmount <> ? AND Status <> 'Waived'`,
+		amount, amount.Currency(), amount, amount, orderID, *policy.FineTypeID, amount)
+	if err != nil {
+		return false, fmt.Errorf("update overdue fine of order %d: %w", orderID, err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	res, err = q.Exec(`
+		INSERT INTO FineBookTable (PersonID, OrderID, FineTypeID, FineAmount, Currency)
+		SELECT ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM FineBookTable WHERE OrderID = ? AND FineTypeID = ?)`,
+		personID, orderID, *policy.FineTypeID, amount, amount.Currency(), orderID, *policy.FineTypeID)
+	if err != nil {
+		return false, fmt.Errorf("insert overdue fine of order %d: %w", orderID, err)
+	}
+	n, _ := res.RowsAffected()
+	return changed || n > 0, nil
+}
+
+// AssessOverdueFines updates the overdue fines of every open loan past its
+// due date and returns the number of orders changed.
+func AssessOverdueFines(db *sql.DB) (int64, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	rows, err := db.Query("SELECT OrderID FROM OrderBook WHERE Status IN ('Borrowed', 'Overdue') AND ReturnDate < ?", today)
+	if err != nil {
+		return 0, fmt.Errorf("find overdue loans: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan overdue loan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("find overdue loans: %w", err)
+	}
+
+	var changed int64
+	for _, id := range ids {
+		tx, err := db.Begin()
+		if err != nil {
+			return changed, fmt.Errorf("begin fine assessment: %w", err)
+		}
+		ok, err := assessOverdueFine(tx, id, today)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return changed, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// normalizePatronCategory lower-cases a patron category code and checks its
+// shape.
+func normalizePatronCategory(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if !patronCategoryPattern.MatchString(v) {
+		return "", fmt.Errorf("patron category must be 1-30 letters, digits, '-' or '_'")
+	}
+	return v, nil
+}
+
+// validatePolicy normalizes a policy from a request body and checks the
+// categories and fine type it refers to exist.
+func validatePolicy(q queryer, p *CirculationPolicy) error {
+	if p.PatronCategory != nil {
+		v, err := normalizePatronCategory(*p.PatronCategory)
+		if err != nil {
+			return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "%v", err)
+		}
+		p.PatronCategory = &v
+	}
+	switch {
+	case p.LoanPeriodDays <= 0:
+		return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "LoanPeriodDays must be positive")
+	case p.MaxItems != nil && *p.MaxItems < 0:
+		return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "MaxItems must not be negative")
+	case p.MaxRenewals < 0:
+		return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "MaxRenewals must not be negative")
+	case p.FineGraceDays < 0:
+		return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "FineGraceDays must not be negative")
+	case p.MaxFineDays != nil && *p.MaxFineDays <= 0:
+		return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "MaxFineDays must be positive")
+	}
+	if p.CategoryID != nil {
+		var exists int
+		if err := q.QueryRow("SELECT COUNT(1) FROM Category WHERE CategoryID = ?", *p.CategoryID).Scan(&exists); err != nil {
+			return fmt.Errorf("check category %d: %w", *p.CategoryID, err)
+		}
+		if exists == 0 {
+			return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "category %d does not exist", *p.CategoryID)
+		}
+	}
+	if p.FineTypeID != nil {
+		var exists int
+		if err := q.QueryRow("SELECT COUNT(1) FROM FineTable WHERE FineID = ?", *p.FineTypeID).Scan(&exists); err != nil {
+			return fmt.Errorf("check fine type %d: %w", *p.FineTypeID, err)
+		}
+		if exists == 0 {
+			return newRequestError(http.StatusBadRequest, "INVALID_POLICY", "fine type %d does not exist", *p.FineTypeID)
+		}
+	}
+	return nil
+}
+
+// duplicatePolicy reports a write that hit UX_CirculationPolicy_Key.
+func duplicatePolicy(err error) bool {
+	return strings.Contains(err.Error(), "UX_CirculationPolicy_Key")
+}
+
+func loadPolicy(q queryer, policyID int) (CirculationPolicy, error) {
+	var p CirculationPolicy
+	err := scanPolicy(q.QueryRow("SELECT "+policyColumns+" FROM CirculationPolicy p WHERE p.PolicyID = ?", policyID), &p)
+	return p, err
+}
+
+// CreatePolicy adds a cell to the circulation matrix.
+func CreatePolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		p := CirculationPolicy{MaxRenewals: defaultPolicy.MaxRenewals, HoldsAllowed: true}
+		if err := c.ShouldBindJSON(&p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if err := validatePolicy(db, &p); err != nil {
+			log.Printf("create policy: %v", err)
+			respondRequestError(c, err, "failed to create policy")
+			return
+		}
+
+		err := db.QueryRow(`
+			INSERT INTO CirculationPolicy (PatronCategory, CategoryID, LoanPeriodDays, MaxItems, MaxRenewals,
+			                               FineTypeID, FineGraceDays, MaxFineDays, HoldsAllowed)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+			SELECT SCOPE_IDENTITY();`,
+			p.PatronCategory, p.CategoryID, p.LoanPeriodDays, p.MaxItems, p.MaxRenewals,
+			p.FineTypeID, p.FineGraceDays, p.MaxFineDays, p.HoldsAllowed).Scan(&p.PolicyID)
+		if err != nil {
+			log.Printf("insert policy: %v", err)
+			if duplicatePolicy(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "a policy for this patron category and book category already exists"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create policy"})
+			}
+			return
+		}
+		c.JSON(http.StatusCreated, p)
+	}
+}
+
+// GetPolicies lists the circulation matrix, catch-alls last.
+func GetPolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		rows, err := db.Query(`SELECT ` + policyColumns + ` FROM CirculationPolicy p
+			ORDER BY CASE WHEN p.PatronCategory IS NULL THEN 1 ELSE 0 END, p.PatronCategory,
+			         CASE WHEN p.CategoryID IS NULL THEN 1 ELSE 0 END, p.CategoryID`)
+		if err != nil {
+			log.Printf("list policies: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get policies"})
+			return
+		}
+		defer rows.Close()
+		policies := []CirculationPolicy{}
+		for rows.Next() {
+			var p CirculationPolicy
+			if err := scanPolicy(rows, &p); err != nil {
+				log.Printf("scan policy: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get policies"})
+				return
+			}
+			policies = append(policies, p)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("list policies: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get policies"})
+			return
+		}
+		c.JSON(http.StatusOK, policies)
+	}
+}
+
+// GetPolicyByID returns one policy.
+func GetPolicyByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		policyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || policyID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid policy_id"})
+			return
+		}
+		p, err := loadPolicy(db, policyID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("get policy %d: %v", policyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve policy"})
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// ResolvePolicy answers GET /policy/resolve?personid=&bookid= with the
+// policy a loan of that book to that patron would use.
+func ResolvePolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		personID, err := strconv.Atoi(c.Query("personid"))
+		if err != nil || personID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "personid must be a positive integer"})
+			return
+		}
+		bookID, err := strconv.Atoi(c.Query("bookid"))
+		if err != nil || bookID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bookid must be a positive integer"})
+			return
+		}
+		p, err := policyFor(db, personID, bookID)
+		if err != nil {
+			log.Printf("resolve policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve policy"})
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// UpdatePolicy replaces a policy's keys and rules.
+func UpdatePolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		policyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || policyID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid policy_id"})
+			return
+		}
+		var p CirculationPolicy
+		if err := c.ShouldBindJSON(&p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if err := validatePolicy(db, &p); err != nil {
+			log.Printf("update policy %d: %v", policyID, err)
+			respondRequestError(c, err, "failed to update policy")
+			return
+		}
+
+		res, err := db.Exec(`
+			UPDATE CirculationPolicy
+			SET PatronCategory = ?, CategoryID = ?, LoanPeriodDays = ?, MaxItems = ?, MaxRenewals = ?,
+			    FineTypeID = ?, FineGraceDays = ?, MaxFineDays = ?, HoldsAllowed = ?, Updated_at = SYSUTCDATETIME()
+			WHERE PolicyID = ?`,
+			p.PatronCategory, p.CategoryID, p.LoanPeriodDays, p.MaxItems, p.MaxRenewals,
+			p.FineTypeID, p.FineGraceDays, p.MaxFineDays, p.HoldsAllowed, policyID)
+		if err != nil {
+			log.Printf("update policy %d: %v", policyID, err)
+			if duplicatePolicy(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "a policy for this patron category and book category already exists"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update policy"})
+			}
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+			return
+		}
+		p.PolicyID = policyID
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// DeletePolicy removes a policy; loans it covered fall back to the next
+// most specific one.
+func DeletePolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		policyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || policyID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid policy_id"})
+			return
+		}
+		res, err := db.Exec("DELETE FROM CirculationPolicy WHERE PolicyID = ?", policyID)
+		if err != nil {
+			log.Printf("delete policy %d: %v", policyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete policy"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "policy deleted"})
+	}
+}
@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BookPrice is one entry of a book's price history. Status is "past",
+// "current" or "scheduled" relative to the time of the request.
+type BookPrice struct {
+	PriceID       int          `json:"priceid"`
+	BookID        int          `json:"bookid"`
+	Price         helper.Money `json:"price"`
+	EffectiveFrom time.Time    `json:"effective_from"`
+	Note          *string      `json:"note"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Status        string       `json:"status"`
+}
+
+// Price entry statuses
+const (
+	PricePast      = "past"
+	PriceCurrent   = "current"
+	PriceScheduled = "scheduled"
+)
+
+// BookPriceInput is the body of POST /book/:id/prices. effective_from is an
+// RFC 3339 time or a date (midnight UTC); it defaults to now.
+type BookPriceInput struct {
+	Price         *helper.Money `json:"price"`
+	EffectiveFrom string        `json:"effective_from"`
+	Note          string        `json:"note"`
+}
+
+const bookPriceColumns = "PriceID, BookID, Price, Currency, EffectiveFrom, Note, Created_at"
+
+func scanBookPrice(row rowScanner, p *BookPrice) error {
+	var note sql.NullString
+	if err := row.Scan(&p.PriceID, &p.BookID, &p.Price, p.Price.CurrencyScanner(),
+		&p.EffectiveFrom, &note, &p.CreatedAt); err != nil {
+		return err
+	}
+	p.Note = nil
+	if note.Valid {
+		p.Note = &note.String
+	}
+	return nil
+}
+
+// parsePriceTime reads an RFC 3339 time or a YYYY-MM-DD date. endOfDay
+// moves a bare date to its last instant, so "as of 2024-03-01" includes
+// changes made during that day.
+func parsePriceTime(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date (YYYY-MM-DD) or RFC 3339 time", s)
+	}
+	if endOfDay {
+		d = d.AddDate(0, 0, 1).Add(-time.Microsecond)
+	}
+	return d, nil
+}
+
+// recordBookPrice adds a price history entry and, when it is already in
+// effect, makes it the book's current price. An entry at the same instant
+// as an existing one replaces it.
+func recordBookPrice(q queryer, bookID int, price helper.Money, from time.Time, note string) (int, error) {
+	price, err := normalizePrice(price)
+	if err != nil {
+		return 0, newRequestError(http.StatusBadRequest, "INVALID_PRICE", "%s", err.Error())
+	}
+	if _, err := q.Exec("DELETE FROM BookPriceHistory WHERE BookID = ? AND EffectiveFrom = ?", bookID, from); err != nil {
+		return 0, fmt.Errorf("replace price of book %d: %w", bookID, err)
+	}
+	var noteArg interface{}
+	if note != "" {
+		noteArg = note
+	}
+	var priceID int
+	err = q.QueryRow(`
+		INSERT INTO BookPriceHistory (BookID, Price, Currency, EffectiveFrom, Note)
+		VALUES (?, ?, ?, ?, ?);
+		SELECT SCOPE_IDENTITY();`, bookID, price, price.Currency(), from, noteArg).Scan(&priceID)
+	if err != nil {
+		return 0, fmt.Errorf("record price of book %d: %w", bookID, err)
+	}
+	if _, err := applyBookPrices(q, &bookID); err != nil {
+		return 0, err
+	}
+	return priceID, nil
+}
+
+// applyBookPrices copies the latest effective history entry into
+// Book.bookPrice wherever they differ, for one book or (bookID nil) all of
+// them. It returns the number of books whose price changed.
+func applyBookPrices(q queryer, bookID *int) (int64, error) {
+	query := `
+		UPDATE b SET bookPrice = h.Price, bookPriceCurrency = h.Currency
+		FROM Book b
+		CROSS APPLY (
+			SELECT TOP (1) Price, Currency FROM BookPriceHistory
+			WHERE BookID = b.BookID AND EffectiveFrom <= SYSUTCDATETIME()
+			ORDER BY EffectiveFrom DESC) h
+		WHERE (b.bookPrice <> h.Price OR b.bookPriceCurrency <> h.Currency)`
+	var args []interface{}
+	if bookID != nil {
+		query += " AND b.BookID = ?"
+		args = append(args, *bookID)
+	}
+	res, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("apply scheduled prices: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ApplyScheduledPrices brings every book's current price up to date with
+// its history, so future-dated changes take effect once their time passes.
+func ApplyScheduledPrices(db *sql.DB) (int64, error) {
+	return applyBookPrices(db, nil)
+}
+
+// bookPriceAsOf returns the price a book had at a given time, or
+// sql.ErrNoRows when its history starts later.
+func bookPriceAsOf(q queryer, bookID int, at time.Time) (BookPrice, error) {
+	var p BookPrice
+	err := scanBookPrice(q.QueryRow(`
+		SELECT TOP (1) `+bookPriceColumns+` FROM BookPriceHistory
+		WHERE BookID = ? AND EffectiveFrom <= ?
+		ORDER BY EffectiveFrom DESC`, bookID, at), &p)
+	return p, err
+}
+
+// bookIDParam reads the :id of a /book/:id route, answering 400 or 404 and
+// returning false when it does not name a book.
+func bookIDParam(c *gin.Context, q queryer) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return 0, false
+	}
+	var exists int
+	if err := q.QueryRow("SELECT COUNT(1) FROM Book WHERE BookID = ?", id).Scan(&exists); err != nil {
+		log.Printf("check book %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch book data. Please try again later."})
+		return 0, false
+	}
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+// GetBookPrices lists a book's price history, oldest first, including
+// scheduled changes.
+func GetBookPrices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query("SELECT "+bookPriceColumns+" FROM BookPriceHistory WHERE BookID = ? ORDER BY EffectiveFrom", bookID)
+		if err != nil {
+			log.Printf("get prices of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch prices. Please try again later."})
+			return
+		}
+		defer rows.Close()
+
+		prices := []BookPrice{}
+		now := time.Now()
+		for rows.Next() {
+			var p BookPrice
+			if err := scanBookPrice(rows, &p); err != nil {
+				log.Printf("scan price: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch prices. Please try again later."})
+				return
+			}
+			p.Status = PricePast
+			if p.EffectiveFrom.After(now) {
+				p.Status = PriceScheduled
+			}
+			prices = append(prices, p)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("get prices of book %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch prices. Please try again later."})
+			return
+		}
+		for i := len(prices) - 1; i >= 0; i-- {
+			if prices[i].Status == PricePast {
+				prices[i].Status = PriceCurrent
+				break
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": prices})
+	}
+}
+
+// GetBookPrice answers GET /book/:id/price?asof=2024-03-01 with the price in
+// effect at that time (a bare date means the end of that day); without
+// asof it is the current price.
+func GetBookPrice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		at := time.Now().UTC()
+		if v := c.Query("asof"); v != "" {
+			t, err := parsePriceTime(v, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "asof: " + err.Error()})
+				return
+			}
+			at = t
+		}
+
+		p, err := bookPriceAsOf(db, bookID, at)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "The book had no recorded price at that time"})
+			return
+		}
+		if err != nil {
+			log.Printf("get price of book %d as of %s: %v", bookID, at, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch prices. Please try again later."})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{
+			"bookid":         bookID,
+			"asof":           at,
+			"price":          p.Price,
+			"priceid":        p.PriceID,
+			"effective_from": p.EffectiveFrom,
+		}})
+	}
+}
+
+// AddBookPrice records a price change. A change effective now or earlier
+// updates the book's price at once; a future one waits for the scheduler.
+func AddBookPrice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		var input BookPriceInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if input.Price == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "price is required"})
+			return
+		}
+		input.Note = strings.TrimSpace(input.Note)
+		if len(input.Note) > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "note is too long (max 200 characters)"})
+			return
+		}
+		from := time.Now().UTC()
+		if input.EffectiveFrom != "" {
+			t, err := parsePriceTime(input.EffectiveFrom, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "effective_from: " + err.Error()})
+				return
+			}
+			from = t
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin price change: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record price"})
+			return
+		}
+		defer tx.Rollback()
+
+		priceID, err := recordBookPrice(tx, bookID, *input.Price, from, input.Note)
+		if err != nil {
+			log.Printf("record price of book %d: %v", bookID, err)
+			respondRequestError(c, err, "Failed to record price")
+			return
+		}
+		var p BookPrice
+		if err := scanBookPrice(tx.QueryRow("SELECT "+bookPriceColumns+" FROM BookPriceHistory WHERE PriceID = ?", priceID), &p); err != nil {
+			log.Printf("reload price %d: %v", priceID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record price"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit price change: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record price"})
+			return
+		}
+
+		p.Status = PriceCurrent
+		if p.EffectiveFrom.After(time.Now()) {
+			p.Status = PriceScheduled
+		} else if current, err := bookPriceAsOf(db, bookID, time.Now().UTC()); err == nil && current.PriceID != p.PriceID {
+			// back-dated behind a later change
+			p.Status = PricePast
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": p})
+	}
+}
+
+// DeleteBookPrice cancels a scheduled price change. Entries already in
+// effect are history and cannot be removed.
+func DeleteBookPrice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+		priceID, err := strconv.Atoi(c.Param("priceid"))
+		if err != nil || priceID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price ID"})
+			return
+		}
+
+		var exists int
+		err = db.QueryRow("SELECT COUNT(1) FROM BookPriceHistory WHERE PriceID = ? AND BookID = ?", priceID, bookID).Scan(&exists)
+		if err != nil {
+			log.Printf("get price %d: %v", priceID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price"})
+			return
+		}
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM BookPriceHistory WHERE PriceID = ? AND EffectiveFrom > SYSUTCDATETIME()", priceID)
+		if err != nil {
+			log.Printf("delete price %d: %v", priceID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only scheduled price changes can be deleted", "code": "PRICE_IN_EFFECT"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
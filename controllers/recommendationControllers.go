@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recommendation tuning. A pair needs recommendationMinCoBorrowers shared
+// borrowers before it counts, so one patron's reading list does not link
+// every book in it; each book keeps its recommendationTopN best matches.
+const (
+	recommendationMinCoBorrowers = 2
+	recommendationTopN           = 50
+	defaultRecommendationLimit   = 10
+	maxRecommendationLimit       = 50
+)
+
+// Recommendation is a suggested book. Score is the cosine similarity for
+// /book/:id/similar and the sum over the patron's history for
+// /user/:user_id/recommendations; Reason is "similar", "borrowed-together"
+// or "popular" (the fallback for patrons without usable history).
+type Recommendation struct {
+	Book        Book    `json:"book"`
+	Score       float64 `json:"score"`
+	CoBorrowers int     `json:"coborrowers"`
+	Reason      string  `json:"reason"`
+}
+
+// recommendationMin reads RECOMMENDATION_MIN_COBORROWERS.
+func recommendationMin() int {
+	if v := os.Getenv("RECOMMENDATION_MIN_COBORROWERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("ignoring invalid RECOMMENDATION_MIN_COBORROWERS %q", v)
+	}
+	return recommendationMinCoBorrowers
+}
+
+// RebuildRecommendations recomputes BookSimilarity from every loan ever
+// made and returns the number of pairs stored. Readers see the old or the
+// new table, never a half-built one.
+func RebuildRecommendations(db *sql.DB) (int64, error) {
+	started := time.Now().UTC()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin rebuild: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM BookSimilarity"); err != nil {
+		return 0, fmt.Errorf("clear similarities: %w", err)
+	}
+	res, err := tx.Exec(`
+		WITH Borrows AS (
+			SELECT DISTINCT o.PersonID, o.BookID
+			FROM OrderBook o JOIN Book b ON b.BookID = o.BookID
+		), Borrowers AS (
+			SELECT BookID, COUNT(*) AS N FROM Borrows GROUP BY BookID
+		), Pairs AS (
+			SELECT a.BookID, b.BookID AS SimilarBookID, COUNT(*) AS CoBorrowers
+			FROM Borrows a JOIN Borrows b ON b.PersonID = a.PersonID AND b.BookID <> a.BookID
+			GROUP BY a.BookID, b.BookID
+			HAVING COUNT(*) >= ?
+		), Ranked AS (
+			SELECT p.BookID, p.SimilarBookID, p.CoBorrowers,
+			       p.CoBorrowers / SQRT(CAST(na.N AS FLOAT) * nb.N) AS Score,
+			       ROW_NUMBER() OVER (PARTITION BY p.BookID
+			                          ORDER BY p.CoBorrowers / SQRT(CAST(na.N AS FLOAT) * nb.N) DESC, p.SimilarBookID) AS Seq
+			FROM Pairs p
+			JOIN Borrowers na ON na.BookID = p.BookID
+			JOIN Borrowers nb ON nb.BookID = p.SimilarBookID
+		)
+		INSERT INTO BookSimilarity (BookID, SimilarBookID, Score, CoBorrowers)
+		SELECT BookID, SimilarBookID, Score, CoBorrowers FROM Ranked WHERE Seq <= ?`,
+		recommendationMin(), recommendationTopN)
+	if err != nil {
+		return 0, fmt.Errorf("compute similarities: %w", err)
+	}
+	pairs, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO RecommendationBuild (Started_at, Pairs) VALUES (?, ?)", started, pairs); err != nil {
+		return 0, fmt.Errorf("record rebuild: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit rebuild: %w", err)
+	}
+	return pairs, nil
+}
+
+// recommendationParams reads ?limit= and ?includeunavailable=. By default
+// only books with a copy on the shelf are suggested.
+func recommendationParams(c *gin.Context) (int, bool, error) {
+	limit := defaultRecommendationLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxRecommendationLimit {
+			return 0, false, newRequestError(http.StatusBadRequest, "INVALID_FILTER", "limit must be between 1 and %d", maxRecommendationLimit)
+		}
+		limit = n
+	}
+	includeUnavailable := false
+	if v := c.Query("includeunavailable"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return 0, false, invalidFilter("includeunavailable", v)
+		}
+		includeUnavailable = b
+	}
+	return limit, includeUnavailable, nil
+}
+
+// respondRecommendations answers with the suggestions and built_at, when
+// the similarities were last rebuilt (null before the first build).
+func respondRecommendations(c *gin.Context, q queryer, recs []Recommendation) {
+	var built sql.NullTime
+	if err := q.QueryRow("SELECT MAX(Finished_at) FROM RecommendationBuild").Scan(&built); err != nil {
+		log.Printf("load last recommendation build: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch recommendations. Please try again later."})
+		return
+	}
+	var builtAt *time.Time
+	if built.Valid {
+		builtAt = &built.Time
+	}
+	c.JSON(http.StatusOK, gin.H{"data": recs, "built_at": builtAt})
+}
+
+// loadRecommendations runs a query returning (BookID, Score, CoBorrowers)
+// rows and loads the books in that order.
+func loadRecommendations(q queryer, reason, query string, args ...interface{}) ([]Recommendation, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load recommendations: %w", err)
+	}
+	var recs []Recommendation
+	for rows.Next() {
+		var r Recommendation
+		if err := rows.Scan(&r.Book.BookID, &r.Score, &r.CoBorrowers); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan recommendation: %w", err)
+		}
+		r.Reason = reason
+		recs = append(recs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load recommendations: %w", err)
+	}
+	if len(recs) == 0 {
+		return []Recommendation{}, nil
+	}
+
+	placeholders := make([]string, len(recs))
+	ids := make([]interface{}, len(recs))
+	index := make(map[int]int, len(recs))
+	for i, r := range recs {
+		placeholders[i] = "?"
+		ids[i] = r.Book.BookID
+		index[r.Book.BookID] = i
+	}
+	bookRows, err := q.Query("SELECT "+bookColumns+" FROM Book WHERE BookID IN ("+strings.Join(placeholders, ", ")+")", ids...)
+	if err != nil {
+		return nil, fmt.Errorf("load recommended books: %w", err)
+	}
+	defer bookRows.Close()
+	for bookRows.Next() {
+		var book Book
+		if err := scanBook(bookRows, &book); err != nil {
+			return nil, fmt.Errorf("scan recommended book: %w", err)
+		}
+		recs[index[book.BookID]].Book = book
+	}
+	if err := bookRows.Err(); err != nil {
+		return nil, err
+	}
+
+	books := make([]Book, len(recs))
+	for i := range recs {
+		books[i] = recs[i].Book
+	}
+	if err := attachBookDetails(q, books); err != nil {
+		return nil, err
+	}
+	for i := range recs {
+		recs[i].Book = books[i]
+	}
+	return recs, nil
+}
+
+// availabilityCondition limits suggestions to books on the shelf unless
+// unavailable ones were asked for.
+func availabilityCondition(includeUnavailable bool, alias string) string {
+	if includeUnavailable {
+		return ""
+	}
+	return " AND " + alias + ".isAvailable = 1"
+}
+
+// GetSimilarBooks answers GET /book/:id/similar: the books most often
+// borrowed by the same patrons, best match first.
+func GetSimilarBooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+		limit, includeUnavailable, err := recommendationParams(c)
+		if err != nil {
+			respondRequestError(c, err, "Unable to fetch recommendations. Please try again later.")
+			return
+		}
+
+		recs, err := loadRecommendations(db, "similar", `
+			SELECT TOP (?) s.SimilarBookID, s.Score, s.CoBorrowers
+			FROM BookSimilarity s JOIN Book b ON b.BookID = s.SimilarBookID
+			WHERE s.BookID = ?`+availabilityCondition(includeUnavailable, "b")+`
+			ORDER BY s.Score DESC, s.CoBorrowers DESC, s.SimilarBookID`, limit, bookID)
+		if err != nil {
+			log.Printf("similar books for %d: %v", bookID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch recommendations. Please try again later."})
+			return
+		}
+		respondRecommendations(c, db, recs)
+	}
+}
+
+// GetUserRecommendations answers GET /user/:user_id/recommendations. Every
+// book the patron has borrowed votes for its similar books with its score;
+// books they have already borrowed are left out. Patrons with no usable
+// history get the most borrowed books instead.
+func GetUserRecommendations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		uid := c.Param("user_id")
+
+		var personID int
+		err := db.QueryRow("SELECT ID FROM Person WHERE User_id = ?", uid).Scan(&personID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("get user %s: %v", uid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user"})
+			return
+		}
+		limit, includeUnavailable, err := recommendationParams(c)
+		if err != nil {
+			respondRequestError(c, err, "Unable to fetch recommendations. Please try again later.")
+			return
+		}
+
+		recs, err := loadRecommendations(db, "borrowed-together", `
+			SELECT TOP (?) s.SimilarBookID, SUM(s.Score), SUM(s.CoBorrowers)
+			FROM BookSimilarity s JOIN Book b ON b.BookID = s.SimilarBookID
+			WHERE s.BookID IN (SELECT BookID FROM OrderBook WHERE PersonID = ?)
+			  AND s.SimilarBookID NOT IN (SELECT BookID FROM OrderBook WHERE PersonID = ?)`+
+			availabilityCondition(includeUnavailable, "b")+`
+			GROUP BY s.SimilarBookID
+			ORDER BY SUM(s.Score) DESC, SUM(s.CoBorrowers) DESC, s.SimilarBookID`,
+			limit, personID, personID)
+		if err == nil && len(recs) == 0 {
+			recs, err = loadRecommendations(db, "popular", `
+				SELECT TOP (?) o.BookID, CAST(COUNT(DISTINCT o.PersonID) AS FLOAT), 0
+				FROM OrderBook o JOIN Book b ON b.BookID = o.BookID
+				WHERE o.BookID NOT IN (SELECT BookID FROM OrderBook WHERE PersonID = ?)`+
+				availabilityCondition(includeUnavailable, "b")+`
+				GROUP BY o.BookID
+				ORDER BY COUNT(DISTINCT o.PersonID) DESC, o.BookID`, limit, personID)
+		}
+		if err != nil {
+			log.Printf("recommendations for %s: %v", uid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to fetch recommendations. Please try again later."})
+			return
+		}
+		respondRecommendations(c, db, recs)
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRenewalOverdueDays is how late a loan may be and still be renewed.
+const defaultRenewalOverdueDays = 0
+
+// renewalOverdueDays reads RENEWAL_MAX_OVERDUE_DAYS.
+func renewalOverdueDays() int {
+	if v := os.Getenv("RENEWAL_MAX_OVERDUE_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("ignoring invalid RENEWAL_MAX_OVERDUE_DAYS %q", v)
+	}
+	return defaultRenewalOverdueDays
+}
+
+// RenewalInput is the optional body of POST /orderbook/:id/renew.
+type RenewalInput struct {
+	Override *BlockOverride `json:"Override"`
+}
+
+// renewOrder extends an open loan by its policy's loan period, counted from
+// its due date or from today if that is later. It refuses while patrons are
+// waiting for the book, once the policy's renewal limit is reached, when the
+// loan is too far overdue, and while the patron owes too much in fines
+// unless override is given.
+func renewOrder(q queryer, orderID int, today time.Time, override *BlockOverride) error {
+	var personID, bookID, renewals int
+	var status string
+	var due sql.NullTime
+	err := q.QueryRow("SELECT PersonID, BookID, Status, ReturnDate, RenewalCount FROM OrderBook WITH (UPDLOCK) WHERE OrderID = ?", orderID).
+		Scan(&personID, &bookID, &status, &due, &renewals)
+	if err == sql.ErrNoRows {
+		return newRequestError(http.StatusNotFound, "ORDER_NOT_FOUND", "order not found")
+	}
+	if err != nil {
+		return fmt.Errorf("load order %d: %w", orderID, err)
+	}
+	if !isOpenLoanStatus(status) {
+		return newRequestError(http.StatusConflict, "LOAN_CLOSED", "order %d is %s and cannot be renewed", orderID, status)
+	}
+
+	var waiting int
+	if err := q.QueryRow("SELECT COUNT(*) FROM BookHold WHERE BookID = ? AND Status = ?", bookID, HoldWaiting).Scan(&waiting); err != nil {
+		return fmt.Errorf("count holds on book %d: %w", bookID, err)
+	}
+	if waiting > 0 {
+		e := newRequestError(http.StatusConflict, "HOLDS_WAITING", "%d patron(s) are waiting for book %d", waiting, bookID)
+		e.Details = gin.H{"waiting": waiting}
+		return e
+	}
+
+	block, err := fineBlock(q, personID)
+	if err != nil {
+		return err
+	}
+	if block != nil {
+		if override == nil {
+			return block
+		}
+		if err := recordOverride(q, personID, orderID, "renewal", block, override); err != nil {
+			return err
+		}
+	}
+
+	policy, err := policyFor(q, personID, bookID)
+	if err != nil {
+		return err
+	}
+	if renewals >= policy.MaxRenewals {
+		e := newRequestError(http.StatusConflict, "RENEWAL_LIMIT", "order %d has already been renewed %d time(s)", orderID, renewals)
+		e.Details = gin.H{"limit": policy.MaxRenewals}
+		return e
+	}
+
+	from := today
+	if due.Valid {
+		dueDate := due.Time.UTC().Truncate(24 * time.Hour)
+		late := int(today.Sub(dueDate).Hours() / 24)
+		if limit := renewalOverdueDays(); late > limit {
+			e := newRequestError(http.StatusConflict, "TOO_OVERDUE", "order %d is %d day(s) overdue; return it instead", orderID, late)
+			e.Details = gin.H{"limit": limit}
+			return e
+		}
+		if dueDate.After(today) {
+			from = dueDate
+		}
+	}
+
+	newDue, err := policy.dueDate(q, from)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec("UPDATE OrderBook SET ReturnDate = ?, Status = 'Borrowed', RenewalCount = RenewalCount + 1 WHERE OrderID = ?",
+		newDue, orderID)
+	if err != nil {
+		return fmt.Errorf("renew order %d: %w", orderID, err)
+	}
+	return nil
+}
+
+// RenewOrderBook answers POST /orderbook/:id/renew, returning the order with
+// its new due date. The body is optional and only needed to override a
+// fines block.
+func RenewOrderBook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		if db == nil {
+			log.Println("database connection is nil")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection failed"})
+			return
+		}
+
+		orderID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || orderID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order_id"})
+			return
+		}
+
+		var input RenewalInput
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+				return
+			}
+		}
+		if err := checkOverride(c, input.Override); err != nil {
+			respondRequestError(c, err, "failed to renew order")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to renew order"})
+			return
+		}
+		defer tx.Rollback()
+
+		if err := renewOrder(tx, orderID, time.Now().UTC().Truncate(24*time.Hour), input.Override); err != nil {
+			log.Printf("renew order %d: %v", orderID, err)
+			respondRequestError(c, err, "failed to renew order")
+			return
+		}
+		var order OrderBook
+		if err := scanOrder(tx.QueryRow("SELECT "+orderColumns+" FROM OrderBook WHERE OrderID = ?", orderID), &order); err != nil {
+			log.Printf("reload order %d: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to renew order"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit renewal of order %d: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to renew order"})
+			return
+		}
+
+		c.JSON(http.StatusOK, order)
+	}
+}
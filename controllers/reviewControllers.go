@@ -0,0 +1,459 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Review moderation states
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewHidden   = "hidden"
+)
+
+// Review is a member's rating of a book, with an optional written review.
+type Review struct {
+	ReviewID       int        `json:"ReviewID"`
+	BookID         int        `json:"BookID"`
+	PersonID       int        `json:"PersonID"`
+	Rating         int        `json:"Rating"` // 1 to 5 stars
+	Title          *string    `json:"Title"`
+	Body           *string    `json:"Body"`
+	Status         string     `json:"Status"`
+	ModerationNote *string    `json:"ModerationNote"`
+	ModeratedAt    *time.Time `json:"ModeratedAt"`
+	CreatedAt      time.Time  `json:"CreatedAt"`
+	UpdatedAt      time.Time  `json:"UpdatedAt"`
+}
+
+// ReviewInput is the body of POST /book/:id/reviews and PUT /review/:id.
+// PersonID is only read on create.
+type ReviewInput struct {
+	PersonID int    `json:"PersonID"`
+	Rating   int    `json:"Rating"`
+	Title    string `json:"Title"`
+	Body     string `json:"Body"`
+}
+
+// ReviewModeration is the body of PUT /review/:id/status.
+type ReviewModeration struct {
+	Status string `json:"Status"`
+	Note   string `json:"Note"`
+}
+
+// BookRating summarizes a book's approved reviews. Average is null until
+// the book has one.
+type BookRating struct {
+	Average *float64 `json:"average"`
+	Count   int      `json:"count"`
+}
+
+const reviewColumns = "ReviewID, BookID, PersonID, Rating, Title, Body, Status, ModerationNote, Moderated_at, Created_at, Updated_at"
+
+func scanReview(row rowScanner, r *Review) error {
+	var title, body, note sql.NullString
+	var moderatedAt sql.NullTime
+	if err := row.Scan(&r.ReviewID, &r.BookID, &r.PersonID, &r.Rating, &title, &body,
+		&r.Status, &note, &moderatedAt, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return err
+	}
+	r.Title, r.Body, r.ModerationNote, r.ModeratedAt = nil, nil, nil, nil
+	if title.Valid {
+		r.Title = &title.String
+	}
+	if body.Valid {
+		r.Body = &body.String
+	}
+	if note.Valid {
+		r.ModerationNote = &note.String
+	}
+	if moderatedAt.Valid {
+		r.ModeratedAt = &moderatedAt.Time
+	}
+	return nil
+}
+
+// reviewsRequireReturn reports whether only members who have returned a
+// loan of the book may review it (REVIEWS_REQUIRE_RETURN=true).
+func reviewsRequireReturn() bool {
+	v, _ := strconv.ParseBool(os.Getenv("REVIEWS_REQUIRE_RETURN"))
+	return v
+}
+
+// validateReviewText trims the input and checks the rating and lengths.
+func validateReviewText(input *ReviewInput) error {
+	input.Title = strings.TrimSpace(input.Title)
+	input.Body = strings.TrimSpace(input.Body)
+	if input.Rating < 1 || input.Rating > 5 {
+		return newRequestError(http.StatusBadRequest, "INVALID_REVIEW", "Rating must be between 1 and 5")
+	}
+	if len(input.Title) > 200 {
+		return newRequestError(http.StatusBadRequest, "INVALID_REVIEW", "Title is too long (max 200 characters)")
+	}
+	if len(input.Body) > 4000 {
+		return newRequestError(http.StatusBadRequest, "INVALID_REVIEW", "Body is too long (max 4000 characters)")
+	}
+	return nil
+}
+
+// nullableText maps an empty string to NULL.
+func nullableText(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// checkReviewer makes sure the person exists and, when required, has
+// returned a loan of the book.
+func checkReviewer(q queryer, personID, bookID int) error {
+	var exists int
+	if err := q.QueryRow("SELECT COUNT(1) FROM Person WHERE ID = ?", personID).Scan(&exists); err != nil {
+		return fmt.Errorf("check person %d: %w", personID, err)
+	}
+	if exists == 0 {
+		return newRequestError(http.StatusBadRequest, "PERSON_NOT_FOUND", "Person %d does not exist", personID)
+	}
+	if !reviewsRequireReturn() {
+		return nil
+	}
+	var returned int
+	err := q.QueryRow("SELECT COUNT(1) FROM OrderBook WHERE PersonID = ? AND BookID = ? AND Status = 'Returned'",
+		personID, bookID).Scan(&returned)
+	if err != nil {
+		return fmt.Errorf("check loans of person %d: %w", personID, err)
+	}
+	if returned == 0 {
+		return newRequestError(http.StatusForbidden, "NOT_BORROWED", "Only members who have borrowed and returned this book can review it")
+	}
+	return nil
+}
+
+// attachRatings fills in the rating summary of every book.
+func attachRatings(q queryer, books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(books))
+	for i := range books {
+		index[books[i].BookID] = i
+		books[i].Rating = BookRating{}
+	}
+
+	err := queryBookBatches(q, books, `
+		SELECT BookID, AVG(CAST(Rating AS DECIMAL(9,4))), COUNT(*)
+		FROM BookReview
+		WHERE Status = 'approved' AND BookID IN (%s)
+		GROUP BY BookID`, nil, func(rows *sql.Rows) error {
+		var bookID, count int
+		var average float64
+		if err := rows.Scan(&bookID, &average, &count); err != nil {
+			return err
+		}
+		if i, ok := index[bookID]; ok {
+			average = math.Round(average*100) / 100
+			books[i].Rating = BookRating{Average: &average, Count: count}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("load book ratings: %w", err)
+	}
+	return nil
+}
+
+// bookRatingApply is the OUTER APPLY that exposes a book's approved rating
+// as rt.AvgRating and rt.RatingCount, for sorting the catalog.
+const bookRatingApply = `
+	OUTER APPLY (
+		SELECT AVG(CAST(r.Rating AS DECIMAL(9,4))) AS AvgRating, COUNT(*) AS RatingCount
+		FROM BookReview r
+		WHERE r.BookID = Book.BookID AND r.Status = 'approved') rt`
+
+// bookRatingOrder sorts the best rated first, ties broken by the number of
+// ratings; unrated books come last.
+const bookRatingOrder = "CASE WHEN rt.RatingCount = 0 THEN 1 ELSE 0 END, rt.AvgRating DESC, rt.RatingCount DESC, BookID"
+
+// reviewFilter reads the review list filters: bookid, personid and status
+// (pending, approved, hidden or all; approved unless given).
+func reviewFilter(params url.Values) (*sqlFilter, error) {
+	f := &sqlFilter{}
+	for _, col := range [][2]string{{"bookid", "BookID"}, {"personid", "PersonID"}} {
+		if err := intFilter(f, params, col[0], col[1]); err != nil {
+			return nil, err
+		}
+	}
+	switch status := strings.ToLower(strings.TrimSpace(params.Get("status"))); status {
+	case "":
+		f.add("Status = ?", ReviewApproved)
+	case "all":
+	case ReviewPending, ReviewApproved, ReviewHidden:
+		f.add("Status = ?", status)
+	default:
+		return nil, invalidFilter("status", status)
+	}
+	return f, nil
+}
+
+func listReviews(c *gin.Context, q queryer, filter *sqlFilter) {
+	rows, err := q.Query("SELECT TOP (1000) "+reviewColumns+" FROM BookReview"+filter.where()+" ORDER BY Created_at DESC, ReviewID DESC", filter.args...)
+	if err != nil {
+		log.Printf("list reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reviews"})
+		return
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		var r Review
+		if err := scanReview(rows, &r); err != nil {
+			log.Printf("scan review: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reviews"})
+			return
+		}
+		reviews = append(reviews, r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("list reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reviews"})
+		return
+	}
+	c.JSON(http.StatusOK, reviews)
+}
+
+// CreateReview adds a member's review of a book. It starts out pending
+// until a moderator approves it.
+func CreateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		var input ReviewInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if input.PersonID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "PersonID must be positive"})
+			return
+		}
+		if err := validateReviewText(&input); err != nil {
+			respondRequestError(c, err, "failed to create review")
+			return
+		}
+		if err := checkReviewer(db, input.PersonID, bookID); err != nil {
+			log.Printf("check reviewer: %v", err)
+			respondRequestError(c, err, "failed to create review")
+			return
+		}
+
+		var existingID int
+		err := db.QueryRow("SELECT ReviewID FROM BookReview WHERE BookID = ? AND PersonID = ?", bookID, input.PersonID).Scan(&existingID)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      "this member has already reviewed the book; edit that review instead",
+				"code":       "DUPLICATE_REVIEW",
+				"existingID": existingID,
+			})
+			return
+		} else if err != sql.ErrNoRows {
+			log.Printf("check existing review: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create review"})
+			return
+		}
+
+		var review Review
+		err = scanReview(db.QueryRow(`
+			INSERT INTO BookReview (BookID, PersonID, Rating, Title, Body)
+			OUTPUT inserted.`+strings.ReplaceAll(reviewColumns, ", ", ", inserted.")+`
+			VALUES (?, ?, ?, ?, ?)`,
+			bookID, input.PersonID, input.Rating, nullableText(input.Title), nullableText(input.Body)), &review)
+		if err != nil {
+			log.Printf("insert review: %v", err)
+			if strings.Contains(strings.ToLower(err.Error()), "unique") {
+				c.JSON(http.StatusConflict, gin.H{"error": "this member has already reviewed the book", "code": "DUPLICATE_REVIEW"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create review"})
+			return
+		}
+		c.JSON(http.StatusCreated, review)
+	}
+}
+
+// GetBookReviews lists a book's approved reviews, newest first, with the
+// book's rating. ?status= shows other moderation states.
+func GetBookReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		bookID, ok := bookIDParam(c, db)
+		if !ok {
+			return
+		}
+		params := c.Request.URL.Query()
+		params.Set("bookid", strconv.Itoa(bookID))
+		filter, err := reviewFilter(params)
+		if err != nil {
+			respondRequestError(c, err, "failed to get reviews")
+			return
+		}
+		listReviews(c, db, filter)
+	}
+}
+
+// GetReviews lists reviews across books, filtered by bookid, personid and
+// status. GET /review?status=pending is the moderation queue.
+func GetReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		filter, err := reviewFilter(c.Request.URL.Query())
+		if err != nil {
+			respondRequestError(c, err, "failed to get reviews")
+			return
+		}
+		listReviews(c, db, filter)
+	}
+}
+
+// reviewIDParam reads :id and loads that review, answering 400 or 404 and
+// returning false when there is none.
+func reviewIDParam(c *gin.Context, q queryer) (Review, bool) {
+	var review Review
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid review ID"})
+		return review, false
+	}
+	err = scanReview(q.QueryRow("SELECT "+reviewColumns+" FROM BookReview WHERE ReviewID = ?", id), &review)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return review, false
+	}
+	if err != nil {
+		log.Printf("get review %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get review"})
+		return review, false
+	}
+	return review, true
+}
+
+// GetReviewByID returns one review in any moderation state.
+func GetReviewByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if review, ok := reviewIDParam(c, database.Database()); ok {
+			c.JSON(http.StatusOK, review)
+		}
+	}
+}
+
+// UpdateReview changes a review's rating and text. The edited review goes
+// back to pending, since a moderator has not seen the new text.
+func UpdateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		review, ok := reviewIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		var input ReviewInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if err := validateReviewText(&input); err != nil {
+			respondRequestError(c, err, "failed to update review")
+			return
+		}
+
+		err := scanReview(db.QueryRow(`
+			UPDATE BookReview
+			SET Rating = ?, Title = ?, Body = ?, Status = 'pending',
+			    ModerationNote = NULL, Moderated_at = NULL, Updated_at = SYSUTCDATETIME()
+			OUTPUT inserted.`+strings.ReplaceAll(reviewColumns, ", ", ", inserted.")+`
+			WHERE ReviewID = ?`,
+			input.Rating, nullableText(input.Title), nullableText(input.Body), review.ReviewID), &review)
+		if err != nil {
+			log.Printf("update review %d: %v", review.ReviewID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update review"})
+			return
+		}
+		c.JSON(http.StatusOK, review)
+	}
+}
+
+// ModerateReview sets a review's moderation state, with an optional note
+// for the member.
+func ModerateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		review, ok := reviewIDParam(c, db)
+		if !ok {
+			return
+		}
+
+		var input ReviewModeration
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		input.Status = strings.ToLower(strings.TrimSpace(input.Status))
+		switch input.Status {
+		case ReviewPending, ReviewApproved, ReviewHidden:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be one of: pending, approved, hidden"})
+			return
+		}
+		input.Note = strings.TrimSpace(input.Note)
+		if len(input.Note) > 400 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Note is too long (max 400 characters)"})
+			return
+		}
+
+		err := scanReview(db.QueryRow(`
+			UPDATE BookReview
+			SET Status = ?, ModerationNote = ?, Moderated_at = SYSUTCDATETIME()
+			OUTPUT inserted.`+strings.ReplaceAll(reviewColumns, ", ", ", inserted.")+`
+			WHERE ReviewID = ?`,
+			input.Status, nullableText(input.Note), review.ReviewID), &review)
+		if err != nil {
+			log.Printf("moderate review %d: %v", review.ReviewID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to moderate review"})
+			return
+		}
+		c.JSON(http.StatusOK, review)
+	}
+}
+
+// DeleteReview removes a review.
+func DeleteReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+		review, ok := reviewIDParam(c, db)
+		if !ok {
+			return
+		}
+		if _, err := db.Exec("DELETE FROM BookReview WHERE ReviewID = ?", review.ReviewID); err != nil {
+			log.Printf("delete review %d: %v", review.ReviewID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete review"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
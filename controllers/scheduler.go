@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"database/sql"
+	database "go-crud-api/config"
+	"log"
+	"time"
+)
+
+// RunScheduled runs job every interval until the process exits, logging
+// failures and how many rows each successful run changed.
+func RunScheduled(name string, interval time.Duration, job func(db *sql.DB) (int64, error)) {
+	for {
+		if db := database.Database(); db != nil {
+			if n, err := job(db); err != nil {
+				log.Printf("%s: %v", name, err)
+			} else if n > 0 {
+				log.Printf("%s: %d rows changed", name, n)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
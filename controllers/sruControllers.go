@@ -0,0 +1,702 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	database "go-crud-api/config"
+	"go-crud-api/helper"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sruNamespace        = "http://docs.oasis-open.org/ns/search-ws/sruResponse"
+	sruDiagNamespace    = "http://docs.oasis-open.org/ns/search-ws/diagnostic"
+	sruDCSchema         = "info:srw/schema/1/dc-v1.1"
+	sruMARCXMLSchema    = "info:srw/schema/1/marcxml-v1.1"
+	sruDefaultRecords   = 10
+	sruMaxRecords       = 100
+	sruExactResultCount = "info:srw/vocabulary/resultCountPrecision/1/exact"
+)
+
+// sruSchemas maps the recordSchema values clients send to the schema URI.
+var sruSchemas = map[string]string{
+	"dc":             sruDCSchema,
+	"oai_dc":         sruDCSchema,
+	sruDCSchema:      sruDCSchema,
+	"marcxml":        sruMARCXMLSchema,
+	"marc21":         sruMARCXMLSchema,
+	sruMARCXMLSchema: sruMARCXMLSchema,
+}
+
+// sruMessages are the standard texts of the SRU diagnostics this endpoint
+// reports (info:srw/diagnostic/1/<code>).
+var sruMessages = map[int]string{
+	1:  "General system error",
+	4:  "Unsupported operation",
+	5:  "Unsupported version",
+	6:  "Unsupported parameter value",
+	7:  "Mandatory parameter not supplied",
+	10: "Query syntax error",
+	16: "Unsupported index",
+	19: "Unsupported relation",
+	20: "Unsupported relation modifier",
+	27: "Empty term unsupported",
+	37: "Unsupported boolean operator",
+	46: "Unsupported boolean modifier",
+	61: "First record position out of range",
+	66: "Unknown schema for retrieval",
+	80: "Sort not supported",
+}
+
+// sruDiagnostic is an SRU diagnostic; every one this endpoint raises is fatal.
+type sruDiagnostic struct {
+	code    int
+	details string
+}
+
+func (d *sruDiagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", sruMessages[d.code], d.details)
+}
+
+func newSRUDiagnostic(code int, details string) *sruDiagnostic {
+	return &sruDiagnostic{code: code, details: details}
+}
+
+// sruRequest is a validated searchRetrieve request.
+type sruRequest struct {
+	query          *helper.CQLQuery
+	startRecord    int
+	maximumRecords int
+	schema         string
+	escapeRecords  bool // recordXMLEscaping=string
+}
+
+type sruResult struct {
+	total       int
+	records     []string
+	start       int
+	schema      string
+	escaping    string
+	diagnostics []*sruDiagnostic
+}
+
+// parseSRURequest reads the SRU 2.0 searchRetrieve parameters. The SRU 1.2
+// recordPacking values xml and string are accepted for recordXMLEscaping.
+func parseSRURequest(form url.Values) (*sruRequest, *sruDiagnostic) {
+	if op := form.Get("operation"); op != "" && op != "searchRetrieve" {
+		return nil, newSRUDiagnostic(4, op)
+	}
+	if v := form.Get("version"); v != "" && v != "1.2" && v != "2.0" {
+		return nil, newSRUDiagnostic(5, v)
+	}
+	if qt := form.Get("queryType"); qt != "" && qt != "cql" {
+		return nil, newSRUDiagnostic(6, "queryType")
+	}
+	raw := form.Get("query")
+	if strings.TrimSpace(raw) == "" {
+		return nil, newSRUDiagnostic(7, "query")
+	}
+	query, err := helper.ParseCQL(raw)
+	if err != nil {
+		return nil, newSRUDiagnostic(10, err.Error())
+	}
+
+	req := &sruRequest{query: query, startRecord: 1, maximumRecords: sruDefaultRecords, schema: sruDCSchema}
+	if v := form.Get("startRecord"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, newSRUDiagnostic(6, "startRecord")
+		}
+		req.startRecord = n
+	}
+	if v := form.Get("maximumRecords"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, newSRUDiagnostic(6, "maximumRecords")
+		}
+		req.maximumRecords = min(n, sruMaxRecords)
+	}
+	if v := form.Get("recordSchema"); v != "" {
+		schema, ok := sruSchemas[strings.ToLower(v)]
+		if !ok {
+			return nil, newSRUDiagnostic(66, v)
+		}
+		req.schema = schema
+	}
+	escaping := form.Get("recordXMLEscaping")
+	switch p := form.Get("recordPacking"); p {
+	case "", "packed":
+	case "xml", "string":
+		if escaping == "" {
+			escaping = p
+		}
+	default:
+		return nil, newSRUDiagnostic(6, "recordPacking")
+	}
+	switch escaping {
+	case "", "xml":
+	case "string":
+		req.escapeRecords = true
+	default:
+		return nil, newSRUDiagnostic(6, "recordXMLEscaping")
+	}
+	return req, nil
+}
+
+// SRUSearchRetrieve answers SRU 2.0 searchRetrieve requests with CQL queries
+// over the catalogue. The indexes are title, author, subject and isbn (with
+// or without a dc. prefix) plus cql.serverChoice for bare terms; records come
+// back as Dublin Core or MARCXML.
+func SRUSearchRetrieve() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := database.Database()
+
+		if err := c.Request.ParseForm(); err != nil {
+			writeSRU(c, http.StatusOK, &sruResult{diagnostics: []*sruDiagnostic{newSRUDiagnostic(6, err.Error())}})
+			return
+		}
+		req, diag := parseSRURequest(c.Request.Form)
+		if diag != nil {
+			writeSRU(c, http.StatusOK, &sruResult{diagnostics: []*sruDiagnostic{diag}})
+			return
+		}
+
+		result, err := searchRetrieve(db, req)
+		if diag, ok := err.(*sruDiagnostic); ok {
+			writeSRU(c, http.StatusOK, &sruResult{diagnostics: []*sruDiagnostic{diag}})
+			return
+		}
+		if err != nil {
+			log.Printf("SRU searchRetrieve: %v", err)
+			writeSRU(c, http.StatusInternalServerError, &sruResult{diagnostics: []*sruDiagnostic{newSRUDiagnostic(1, "")}})
+			return
+		}
+		writeSRU(c, http.StatusOK, result)
+	}
+}
+
+func searchRetrieve(db *sql.DB, req *sruRequest) (*sruResult, error) {
+	t := &cqlTranslator{q: db}
+	where, args, err := t.node(req.query.Root)
+	if err != nil {
+		return nil, err
+	}
+	orderBy, err := cqlOrderBy(req.query.SortKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &sruResult{start: req.startRecord, schema: req.schema, escaping: "xml"}
+	if req.escapeRecords {
+		result.escaping = "string"
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM Book WHERE "+where, args...).Scan(&result.total); err != nil {
+		return nil, fmt.Errorf("count matches: %w", err)
+	}
+	if result.total > 0 && req.startRecord > result.total {
+		result.diagnostics = append(result.diagnostics, newSRUDiagnostic(61, strconv.Itoa(req.startRecord)))
+		return result, nil
+	}
+	if req.maximumRecords == 0 || result.total == 0 {
+		return result, nil
+	}
+
+	query := "SELECT " + bookColumns + " FROM Book WHERE " + where + " ORDER BY " + orderBy + " OFFSET ? ROWS FETCH NEXT ? ROWS ONLY"
+	rows, err := db.Query(query, append(args, req.startRecord-1, req.maximumRecords)...)
+	if err != nil {
+		return nil, fmt.Errorf("search books: %w", err)
+	}
+	var books []Book
+	for rows.Next() {
+		var book Book
+		if err := scanBook(rows, &book); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan book: %w", err)
+		}
+		books = append(books, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search books: %w", err)
+	}
+	if err := attachBookDetails(db, books); err != nil {
+		return nil, err
+	}
+
+	for i := range books {
+		record, err := sruRecord(&books[i], req.schema)
+		if err != nil {
+			return nil, err
+		}
+		if req.escapeRecords {
+			record = xmlText(record)
+		}
+		result.records = append(result.records, record)
+	}
+	return result, nil
+}
+
+func sruRecord(book *Book, schema string) (string, error) {
+	if schema == sruMARCXMLSchema {
+		data, err := helper.MarshalMARCXML(bookToMARC(book))
+		if err != nil {
+			return "", fmt.Errorf("encode MARCXML for book %d: %w", book.BookID, err)
+		}
+		return string(data), nil
+	}
+	return fmt.Sprintf(`<srw_dc:dc xmlns:srw_dc="info:srw/schema/1/dc-schema" xmlns:dc="%s">`, dcNamespace) +
+		dublinCoreElements(book) + "</srw_dc:dc>", nil
+}
+
+func writeSRU(c *gin.Context, status int, r *sruResult) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<searchRetrieveResponse xmlns="%s">`+"\n", sruNamespace)
+	b.WriteString("<version>2.0</version>\n")
+	fmt.Fprintf(&b, "<numberOfRecords>%d</numberOfRecords>\n", r.total)
+	if len(r.records) > 0 {
+		b.WriteString("<records>\n")
+		for i, record := range r.records {
+			fmt.Fprintf(&b, "<record><recordSchema>%s</recordSchema><recordXMLEscaping>%s</recordXMLEscaping><recordData>%s</recordData><recordPosition>%d</recordPosition></record>\n",
+				r.schema, r.escaping, record, r.start+i)
+		}
+		b.WriteString("</records>\n")
+		if next := r.start + len(r.records); next <= r.total {
+			fmt.Fprintf(&b, "<nextRecordPosition>%d</nextRecordPosition>\n", next)
+		}
+	}
+	if len(r.diagnostics) > 0 {
+		b.WriteString("<diagnostics>\n")
+		for _, d := range r.diagnostics {
+			fmt.Fprintf(&b, `<diag:diagnostic xmlns:diag="%s"><diag:uri>info:srw/diagnostic/1/%d</diag:uri>`, sruDiagNamespace, d.code)
+			if d.details != "" {
+				fmt.Fprintf(&b, "<diag:details>%s</diag:details>", xmlText(d.details))
+			}
+			fmt.Fprintf(&b, "<diag:message>%s</diag:message></diag:diagnostic>\n", xmlText(sruMessages[d.code]))
+		}
+		b.WriteString("</diagnostics>\n")
+	}
+	fmt.Fprintf(&b, "<resultCountPrecision>%s</resultCountPrecision>\n", sruExactResultCount)
+	b.WriteString("</searchRetrieveResponse>\n")
+
+	contentType := "application/sru+xml; charset=utf-8"
+	if accept := c.Request.Form.Get("httpAccept"); accept == "application/xml" || accept == "text/xml" {
+		contentType = accept + "; charset=utf-8"
+	}
+	c.Data(status, contentType, []byte(b.String()))
+}
+
+// cqlTranslator turns a parsed CQL query into a WHERE condition on Book.
+// Subjects are matched against the category tree in Go, so the tree and its
+// aliases are loaded once, on the first subject clause.
+type cqlTranslator struct {
+	q          queryer
+	categories map[int]*Category
+	aliases    map[string]int
+}
+
+func (t *cqlTranslator) node(n helper.CQLNode) (string, []interface{}, error) {
+	switch n := n.(type) {
+	case *helper.CQLBoolean:
+		if len(n.Modifiers) > 0 {
+			return "", nil, newSRUDiagnostic(46, n.Modifiers[0].Name)
+		}
+		if n.Op == "prox" {
+			return "", nil, newSRUDiagnostic(37, n.Op)
+		}
+		left, largs, err := t.node(n.Left)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rargs, err := t.node(n.Right)
+		if err != nil {
+			return "", nil, err
+		}
+		args := append(largs, rargs...)
+		switch n.Op {
+		case "and":
+			return "(" + left + " AND " + right + ")", args, nil
+		case "or":
+			return "(" + left + " OR " + right + ")", args, nil
+		default:
+			return "(" + left + " AND NOT " + right + ")", args, nil
+		}
+	case *helper.CQLClause:
+		return t.clause(n)
+	}
+	return "", nil, fmt.Errorf("unexpected CQL node %T", n)
+}
+
+// cqlIndexes maps index names, without their context set prefix, to the
+// catalogue field they search.
+var cqlIndexes = map[string]string{
+	"title":        "title",
+	"author":       "author",
+	"creator":      "author",
+	"contributor":  "author",
+	"name":         "author",
+	"subject":      "subject",
+	"isbn":         "isbn",
+	"identifier":   "isbn",
+	"serverchoice": "any",
+	"anywhere":     "any",
+	"keywords":     "any",
+	"allrecords":   "all",
+}
+
+func cqlName(s string) string {
+	s = strings.ToLower(s)
+	if i := strings.LastIndexByte(s, '.'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+func (t *cqlTranslator) clause(cl *helper.CQLClause) (string, []interface{}, error) {
+	field, ok := cqlIndexes[cqlName(cl.Index)]
+	if !ok {
+		return "", nil, newSRUDiagnostic(16, cl.Index)
+	}
+	relation := cqlName(cl.Relation)
+	switch relation {
+	case "=", "==", "<>", "adj", "any", "all", "exact":
+	default:
+		return "", nil, newSRUDiagnostic(19, cl.Relation)
+	}
+	for _, mod := range cl.Modifiers {
+		// Matching is always case-insensitive and masking always on
+		if name := cqlName(mod.Name); name != "ignorecase" && name != "masked" {
+			return "", nil, newSRUDiagnostic(20, mod.Name)
+		}
+	}
+	if field == "all" {
+		return "1 = 1", nil, nil
+	}
+	if strings.TrimSpace(cl.Term) == "" {
+		return "", nil, newSRUDiagnostic(27, cl.Index)
+	}
+
+	// <> is the negation of an exact match
+	negate := relation == "<>"
+	if negate {
+		relation = "=="
+	}
+	var cond string
+	var args []interface{}
+	var err error
+	switch field {
+	case "title":
+		cond, args = cqlTextMatch("LOWER(bookName) LIKE LOWER(?)", cl.Term, relation, nil)
+	case "author":
+		cond, args = cqlAuthorMatch(cl.Term, relation)
+	case "subject":
+		cond, args, err = t.subjectMatch(cl.Term, relation)
+	case "isbn":
+		cond, args = cqlISBNMatch(cl.Term, relation)
+	case "any":
+		titleCond, titleArgs := cqlTextMatch("LOWER(bookName) LIKE LOWER(?)", cl.Term, relation, nil)
+		authorCond, authorArgs := cqlAuthorMatch(cl.Term, relation)
+		subjectCond, subjectArgs, serr := t.subjectMatch(cl.Term, relation)
+		err = serr
+		cond = "(" + titleCond + " OR " + authorCond + " OR " + subjectCond + ")"
+		args = append(append(titleArgs, authorArgs...), subjectArgs...)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if negate {
+		cond = "NOT " + cond
+	}
+	return cond, args, nil
+}
+
+// cqlWords splits a term for its relation: any and all match word by word,
+// the other relations treat the term as one phrase.
+func cqlWords(term, relation string) []string {
+	if relation == "any" || relation == "all" {
+		if words := strings.Fields(term); len(words) > 0 {
+			return words
+		}
+	}
+	return []string{term}
+}
+
+// cqlJoin combines per-word conditions: any needs one of them, every other
+// relation all of them.
+func cqlJoin(conds []string, relation string) string {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	op := " AND "
+	if relation == "any" {
+		op = " OR "
+	}
+	return "(" + strings.Join(conds, op) + ")"
+}
+
+// cqlTextMatch builds cond (a LIKE test with one placeholder) for every word
+// of term. prepare, when set, rewrites each word before it becomes a pattern.
+func cqlTextMatch(cond, term, relation string, prepare func(string) string) (string, []interface{}) {
+	exact := relation == "==" || relation == "exact"
+	var conds []string
+	var args []interface{}
+	for _, word := range cqlWords(term, relation) {
+		if prepare != nil {
+			word = prepare(word)
+		}
+		conds = append(conds, cond)
+		args = append(args, cqlLikePattern(word, exact))
+	}
+	return cqlJoin(conds, relation), args
+}
+
+// cqlAuthorMatch matches contributors by normalized name, so punctuation and
+// spacing in the query do not matter. Every word has to match the same person.
+func cqlAuthorMatch(term, relation string) (string, []interface{}) {
+	cond, args := cqlTextMatch("a.NormalizedName LIKE ?", term, relation, normalizeAuthorName)
+	return `BookID IN (
+		SELECT ba.BookID FROM BookAuthor ba JOIN Author a ON a.AuthorID = ba.AuthorID
+		WHERE ` + cond + `)`, args
+}
+
+// cqlISBNMatch matches ISBN-10 or ISBN-13 in any notation. A masked term is
+// compared digit by digit against both columns; a term that is not an ISBN
+// matches nothing.
+func cqlISBNMatch(term, relation string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for _, word := range cqlWords(term, relation) {
+		if parsed, err := helper.ParseISBN(word); err == nil {
+			conds = append(conds, "isbn13 = ?")
+			args = append(args, parsed.ISBN13)
+			continue
+		}
+		if strings.ContainsAny(word, "*?") {
+			pattern := cqlLikePattern(strings.ReplaceAll(word, "-", ""), true)
+			conds = append(conds, "(isbn13 LIKE ? OR isbn10 LIKE ?)")
+			args = append(args, pattern, pattern)
+			continue
+		}
+		conds = append(conds, "1 = 0")
+	}
+	return cqlJoin(conds, relation), args
+}
+
+// subjectMatch finds the categories a term names, by name or alias, and
+// matches books filed under them or anything below them.
+func (t *cqlTranslator) subjectMatch(term, relation string) (string, []interface{}, error) {
+	if err := t.loadCategories(); err != nil {
+		return "", nil, err
+	}
+	exact := relation == "==" || relation == "exact"
+	var conds []string
+	var args []interface{}
+	for _, word := range cqlWords(term, relation) {
+		re := cqlRegexp(word, exact)
+		matched := map[int]bool{}
+		var collect func(cat *Category)
+		collect = func(cat *Category) {
+			matched[cat.CategoryID] = true
+			for _, child := range cat.Children {
+				collect(child)
+			}
+		}
+		for _, cat := range t.categories {
+			if re.MatchString(cat.Name) {
+				collect(cat)
+			}
+		}
+		if !strings.ContainsAny(word, `*?^\`) {
+			if id, ok := t.aliases[categoryKey(word)]; ok {
+				collect(t.categories[id])
+			}
+		}
+		if len(matched) == 0 {
+			conds = append(conds, "1 = 0")
+			continue
+		}
+		ids := make([]int, 0, len(matched))
+		for id := range matched {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		placeholders := make([]string, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		conds = append(conds, "BookID IN (SELECT BookID FROM BookCategory WHERE CategoryID IN ("+strings.Join(placeholders, ", ")+"))")
+	}
+	return cqlJoin(conds, relation), args, nil
+}
+
+func (t *cqlTranslator) loadCategories() error {
+	if t.categories != nil {
+		return nil
+	}
+	byID, _, err := loadCategories(t.q)
+	if err != nil {
+		return err
+	}
+	rows, err := t.q.Query("SELECT AliasKey, CategoryID FROM CategoryAlias")
+	if err != nil {
+		return fmt.Errorf("load category aliases: %w", err)
+	}
+	defer rows.Close()
+	aliases := map[string]int{}
+	for rows.Next() {
+		var key string
+		var id int
+		if err := rows.Scan(&key, &id); err != nil {
+			return fmt.Errorf("scan category alias: %w", err)
+		}
+		if _, ok := byID[id]; ok {
+			aliases[key] = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load category aliases: %w", err)
+	}
+	t.categories, t.aliases = byID, aliases
+	return nil
+}
+
+// cqlTermPart is a literal run of a term or one masking character.
+type cqlTermPart struct {
+	literal string
+	mask    byte // '*' or '?', 0 for a literal
+}
+
+// splitCQLTerm breaks a term into literals and masks. An unescaped ^ at
+// either end anchors the match there; a backslash makes the next character
+// literal.
+func splitCQLTerm(term string) (parts []cqlTermPart, anchorStart, anchorEnd bool) {
+	if strings.HasPrefix(term, "^") {
+		anchorStart = true
+		term = term[1:]
+	}
+	if strings.HasSuffix(term, "^") && !strings.HasSuffix(term, `\^`) {
+		anchorEnd = true
+		term = term[:len(term)-1]
+	}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, cqlTermPart{literal: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(term); i++ {
+		switch c := term[i]; {
+		case c == '\\' && i+1 < len(term):
+			i++
+			lit.WriteByte(term[i])
+		case c == '*' || c == '?':
+			flush()
+			parts = append(parts, cqlTermPart{mask: c})
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return parts, anchorStart, anchorEnd
+}
+
+// cqlLikePattern turns a term into a T-SQL LIKE pattern: * and ? become % and
+// _, LIKE's own wildcards are bracketed, and unless exact or anchored the
+// pattern may match anywhere in the value.
+func cqlLikePattern(term string, exact bool) string {
+	parts, anchorStart, anchorEnd := splitCQLTerm(term)
+	var b strings.Builder
+	if !exact && !anchorStart {
+		b.WriteByte('%')
+	}
+	for _, p := range parts {
+		switch p.mask {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		default:
+			for _, r := range p.literal {
+				if r == '%' || r == '_' || r == '[' {
+					b.WriteString("[" + string(r) + "]")
+				} else {
+					b.WriteRune(r)
+				}
+			}
+		}
+	}
+	if !exact && !anchorEnd {
+		b.WriteByte('%')
+	}
+	return b.String()
+}
+
+// cqlRegexp is the case-insensitive regular expression equivalent of
+// cqlLikePattern, for matching in Go.
+func cqlRegexp(term string, exact bool) *regexp.Regexp {
+	parts, anchorStart, anchorEnd := splitCQLTerm(term)
+	var b strings.Builder
+	b.WriteString("(?i)")
+	if exact || anchorStart {
+		b.WriteByte('^')
+	}
+	for _, p := range parts {
+		switch p.mask {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(p.literal))
+		}
+	}
+	if exact || anchorEnd {
+		b.WriteByte('$')
+	}
+	return regexp.MustCompile(b.String())
+}
+
+// cqlOrderBy translates sortby keys (title, author, isbn, with
+// sort.ascending or sort.descending) into an ORDER BY list. BookID always
+// comes last so paging is stable.
+func cqlOrderBy(keys []helper.CQLSortKey) (string, error) {
+	var terms []string
+	for _, key := range keys {
+		var expr string
+		switch cqlIndexes[cqlName(key.Index)] {
+		case "title":
+			expr = "bookName"
+		case "isbn":
+			expr = "isbn13"
+		case "author":
+			expr = `(SELECT TOP (1) COALESCE(a.SortName, a.Name) FROM BookAuthor ba
+				JOIN Author a ON a.AuthorID = ba.AuthorID
+				WHERE ba.BookID = Book.BookID ORDER BY CASE WHEN ba.Role = 'Author' THEN 0 ELSE 1 END, ba.Position)`
+		default:
+			return "", newSRUDiagnostic(80, key.Index)
+		}
+		dir := " ASC"
+		for _, mod := range key.Modifiers {
+			switch cqlName(mod.Name) {
+			case "ascending":
+			case "descending":
+				dir = " DESC"
+			case "ignorecase":
+			default:
+				return "", newSRUDiagnostic(80, key.Index+"/"+mod.Name)
+			}
+		}
+		terms = append(terms, expr+dir)
+	}
+	return strings.Join(append(terms, "BookID"), ", "), nil
+}
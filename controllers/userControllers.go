@@ -25,6 +25,9 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	UserID       string    `json:"user_id"`
+
+	// PatronCategory picks the user's row of the circulation policy matrix
+	PatronCategory string `json:"patron_category"`
 }
 
 func CreateUser() gin.HandlerFunc {
@@ -51,11 +54,20 @@ func CreateUser() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email format"})
 			return
 		}
+		if newUser.PatronCategory == "" {
+			newUser.PatronCategory = defaultPatronCategory
+		}
+		category, err := normalizePatronCategory(newUser.PatronCategory)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		newUser.PatronCategory = category
 
 		// check duplicates
 		const dupChk = `SELECT 1 FROM Person WHERE Username = ? OR Email = ?`
 		var dummy int
-		err := db.QueryRow(dupChk,
+		err = db.QueryRow(dupChk,
 			newUser.Username,
 			newUser.Email,
 		).Scan(&dummy)
@@ -98,8 +110,8 @@ func CreateUser() gin.HandlerFunc {
 		const ins = `
 			INSERT INTO Person
         (Username, Email, First_name, Last_name, Password, PhoneNumber,
-         Created_at, Updated_at, User_id, Token, Refresh_Token)
-        VALUES (?,?,?,?,?,?,?,?,?,?,?);
+         Created_at, Updated_at, User_id, Token, Refresh_Token, PatronCategory)
+        VALUES (?,?,?,?,?,?,?,?,?,?,?,?);
     SELECT SCOPE_IDENTITY() AS ID;
 		`
 
@@ -115,6 +127,7 @@ func CreateUser() gin.HandlerFunc {
 			newUser.UserID,
 			newUser.Token,
 			newUser.RefreshToken,
+			newUser.PatronCategory,
 		).Scan(&newUser.ID)
 		if err != nil {
 			log.Printf("insert user: %v", err)
@@ -131,11 +144,17 @@ func GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		db := database.Database()
 
-		const q = `
+		filter, err := userFilter(c.Request.URL.Query())
+		if err != nil {
+			respondRequestError(c, err, "failed to retrieve users")
+			return
+		}
+
+		q := `
             SELECT ID, Username, Email, PhoneNumber, First_name, Last_name,
-                   Created_at, Updated_at, User_id
-            FROM Person`
-		rows, err := db.Query(q)
+                   Created_at, Updated_at, User_id, PatronCategory
+            FROM Person` + filter.where()
+		rows, err := db.Query(q, filter.args...)
 		if err != nil {
 			log.Printf("list users: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve users"})
@@ -158,6 +177,7 @@ func GetUsers() gin.HandlerFunc {
 				&u.CreatedAt,
 				&u.UpdatedAt,
 				&u.UserID,
+				&u.PatronCategory,
 			); err != nil {
 				log.Printf("scan users: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process users"})
@@ -198,7 +218,7 @@ func GetUserById() gin.HandlerFunc {
 
 		const q = `
             SELECT ID, Username, Email, PhoneNumber, First_name, Last_name,
-                   Created_at, Updated_at, User_id
+                   Created_at, Updated_at, User_id, PatronCategory
             FROM Person
             WHERE User_id = ?`
 		row := db.QueryRow(q, uid)
@@ -215,6 +235,7 @@ func GetUserById() gin.HandlerFunc {
 			&u.CreatedAt,
 			&u.UpdatedAt,
 			&u.UserID,
+			&u.PatronCategory,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
@@ -296,11 +317,12 @@ func UpdateUserById() gin.HandlerFunc {
 
 		// Fields allowed to be updated
 		allowedFields := map[string]string{
-			"username":     "Username",
-			"email":        "Email",
-			"phone_number": "PhoneNumber",
-			"first_name":   "First_name",
-			"last_name":    "Last_name",
+			"username":        "Username",
+			"email":           "Email",
+			"phone_number":    "PhoneNumber",
+			"first_name":      "First_name",
+			"last_name":       "Last_name",
+			"patron_category": "PatronCategory",
 		}
 
 		// Build dynamic SQL query
@@ -318,6 +340,14 @@ func UpdateUserById() gin.HandlerFunc {
 						c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email format"})
 						return
 					}
+					if jsonKey == "patron_category" {
+						category, err := normalizePatronCategory(str)
+						if err != nil {
+							c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+							return
+						}
+						str = category
+					}
 					setClauses = append(setClauses, dbColumn+" = ?")
 					args = append(args, str)
 				}
@@ -361,7 +391,7 @@ func UpdateUserById() gin.HandlerFunc {
 		// Retrieve the updated user
 		const selectQuery = `
             SELECT ID, Username, Email, PhoneNumber, First_name, Last_name,
-                   Created_at, Updated_at, User_id
+                   Created_at, Updated_at, User_id, PatronCategory
             FROM Person
             WHERE User_id = ?`
 		row := db.QueryRow(selectQuery, uid)
@@ -378,6 +408,7 @@ func UpdateUserById() gin.HandlerFunc {
 			&u.CreatedAt,
 			&u.UpdatedAt,
 			&u.UserID,
+			&u.PatronCategory,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
@@ -429,7 +460,7 @@ func LoginUser() gin.HandlerFunc {
 		// Query user by username
 		const query = `
             SELECT ID, Username, Email, PhoneNumber, First_name, Last_name, 
-                   Password, Created_at, Updated_at, User_id
+                   Password, Created_at, Updated_at, User_id, PatronCategory
             FROM Person 
             WHERE Username = ?`
 		row := db.QueryRow(query, input.Username)
@@ -448,6 +479,7 @@ func LoginUser() gin.HandlerFunc {
 			&user.CreatedAt,
 			&user.UpdatedAt,
 			&user.UserID,
+			&user.PatronCategory,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
@@ -0,0 +1,326 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CQLNode is a node of a parsed CQL query: a *CQLClause or a *CQLBoolean.
+type CQLNode interface {
+	cqlNode()
+}
+
+// CQLModifier is a "/name", "/name=value" or "/name<value" modifier on a
+// relation, boolean or sort key. Names are kept as written.
+type CQLModifier struct {
+	Name     string
+	Relation string
+	Value    string
+}
+
+// CQLClause is a search clause. A bare term gets the index "cql.serverChoice"
+// and the relation "=". Term is kept as written, backslash escapes included,
+// so masking characters (*, ?, ^) can still be told from escaped ones.
+type CQLClause struct {
+	Index     string
+	Relation  string
+	Modifiers []CQLModifier
+	Term      string
+}
+
+// CQLBoolean joins two queries with "and", "or", "not" or "prox", always in
+// lower case.
+type CQLBoolean struct {
+	Op        string
+	Modifiers []CQLModifier
+	Left      CQLNode
+	Right     CQLNode
+}
+
+func (*CQLClause) cqlNode()  {}
+func (*CQLBoolean) cqlNode() {}
+
+// CQLSortKey is one key of a "sortby" clause.
+type CQLSortKey struct {
+	Index     string
+	Modifiers []CQLModifier
+}
+
+// CQLQuery is a parsed query. Prefix assignments are accepted and dropped.
+type CQLQuery struct {
+	Root     CQLNode
+	SortKeys []CQLSortKey
+}
+
+// CQLSyntaxError reports where a query stopped making sense.
+type CQLSyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *CQLSyntaxError) Error() string {
+	return fmt.Sprintf("CQL syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+type cqlToken struct {
+	text   string
+	pos    int
+	quoted bool
+	symbol bool // ( ) / or a comparison symbol
+}
+
+// cqlComparisons are the symbolic relations, longest first.
+var cqlComparisons = []string{"==", "<>", "<=", ">=", "=", "<", ">"}
+
+func tokenizeCQL(s string) ([]cqlToken, error) {
+	var tokens []cqlToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '/':
+			tokens = append(tokens, cqlToken{text: string(c), pos: i, symbol: true})
+			i++
+		case c == '=' || c == '<' || c == '>':
+			for _, cmp := range cqlComparisons {
+				if strings.HasPrefix(s[i:], cmp) {
+					tokens = append(tokens, cqlToken{text: cmp, pos: i, symbol: true})
+					i += len(cmp)
+					break
+				}
+			}
+		case c == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, &CQLSyntaxError{start, "unterminated quoted string"}
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					// \" ends up as a plain quote; other escapes are kept for the term
+					if s[i+1] == '"' {
+						b.WriteByte('"')
+					} else {
+						b.WriteString(s[i : i+2])
+					}
+					i += 2
+					continue
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			tokens = append(tokens, cqlToken{text: b.String(), pos: start, quoted: true})
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r()/=<>\"", rune(s[i])) {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				i++
+			}
+			tokens = append(tokens, cqlToken{text: s[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+type cqlParser struct {
+	tokens []cqlToken
+	next   int
+	end    int
+}
+
+// ParseCQL parses a CQL 1.2 query: search clauses with an optional index,
+// relation and relation modifiers, the booleans and, or, not and prox
+// (left-associative, equal precedence), parentheses, prefix assignments and
+// a trailing sortby clause.
+func ParseCQL(query string) (*CQLQuery, error) {
+	tokens, err := tokenizeCQL(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &CQLSyntaxError{0, "empty query"}
+	}
+	p := &cqlParser{tokens: tokens, end: len(query)}
+	root, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	q := &CQLQuery{Root: root}
+	if p.keyword("sortby") {
+		p.next++
+		for p.peek() != nil {
+			key, err := p.sortKey()
+			if err != nil {
+				return nil, err
+			}
+			q.SortKeys = append(q.SortKeys, key)
+		}
+		if len(q.SortKeys) == 0 {
+			return nil, p.fail("sortby needs at least one index")
+		}
+	}
+	if t := p.peek(); t != nil {
+		return nil, &CQLSyntaxError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return q, nil
+}
+
+func (p *cqlParser) peek() *cqlToken {
+	if p.next < len(p.tokens) {
+		return &p.tokens[p.next]
+	}
+	return nil
+}
+
+func (p *cqlParser) fail(msg string) error {
+	if t := p.peek(); t != nil {
+		return &CQLSyntaxError{t.pos, msg}
+	}
+	return &CQLSyntaxError{p.end, msg}
+}
+
+func (p *cqlParser) symbol(s string) bool {
+	t := p.peek()
+	return t != nil && t.symbol && t.text == s
+}
+
+func (p *cqlParser) keyword(word string) bool {
+	t := p.peek()
+	return t != nil && !t.quoted && !t.symbol && strings.EqualFold(t.text, word)
+}
+
+func (p *cqlParser) boolean() string {
+	for _, op := range []string{"and", "or", "not", "prox"} {
+		if p.keyword(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *cqlParser) isComparison() bool {
+	t := p.peek()
+	return t != nil && t.symbol && t.text != "(" && t.text != ")" && t.text != "/"
+}
+
+// word consumes a term, index or modifier value: a bare word or quoted string.
+func (p *cqlParser) word(what string) (string, error) {
+	t := p.peek()
+	if t == nil || t.symbol {
+		return "", p.fail("expected " + what)
+	}
+	p.next++
+	return t.text, nil
+}
+
+func (p *cqlParser) query() (CQLNode, error) {
+	// Prefix assignments: > prefix = "uri" or > "uri"
+	for p.symbol(">") {
+		p.next++
+		if _, err := p.word("prefix or URI"); err != nil {
+			return nil, err
+		}
+		if p.symbol("=") {
+			p.next++
+			if _, err := p.word("URI"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	left, err := p.searchClause()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.boolean()
+		if op == "" {
+			return left, nil
+		}
+		p.next++
+		mods, err := p.modifiers()
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.searchClause()
+		if err != nil {
+			return nil, err
+		}
+		left = &CQLBoolean{Op: op, Modifiers: mods, Left: left, Right: right}
+	}
+}
+
+func (p *cqlParser) searchClause() (CQLNode, error) {
+	if p.symbol("(") {
+		p.next++
+		node, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, p.fail("expected )")
+		}
+		p.next++
+		return node, nil
+	}
+	first, err := p.word("search term")
+	if err != nil {
+		return nil, err
+	}
+
+	// A bare term ends at a boolean, a closing parenthesis, sortby or the end
+	t := p.peek()
+	if t == nil || p.symbol(")") || p.boolean() != "" || p.keyword("sortby") {
+		return &CQLClause{Index: "cql.serverChoice", Relation: "=", Term: first}, nil
+	}
+	if t.symbol && !p.isComparison() {
+		return nil, p.fail("expected a relation")
+	}
+	p.next++
+	clause := &CQLClause{Index: first, Relation: strings.ToLower(t.text)}
+	if clause.Modifiers, err = p.modifiers(); err != nil {
+		return nil, err
+	}
+	if clause.Term, err = p.word("search term"); err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+func (p *cqlParser) modifiers() ([]CQLModifier, error) {
+	var mods []CQLModifier
+	for p.symbol("/") {
+		p.next++
+		name, err := p.word("modifier name")
+		if err != nil {
+			return nil, err
+		}
+		mod := CQLModifier{Name: name}
+		if p.isComparison() {
+			mod.Relation = p.peek().text
+			p.next++
+			if mod.Value, err = p.word("modifier value"); err != nil {
+				return nil, err
+			}
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+func (p *cqlParser) sortKey() (CQLSortKey, error) {
+	index, err := p.word("sort index")
+	if err != nil {
+		return CQLSortKey{}, err
+	}
+	mods, err := p.modifiers()
+	return CQLSortKey{Index: index, Modifiers: mods}, err
+}
@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func cqlModifiers(ms []CQLModifier) string {
+	var b strings.Builder
+	for _, m := range ms {
+		b.WriteString("/" + m.Name + m.Relation + m.Value)
+	}
+	return b.String()
+}
+
+// cqlString prints a node as a compact S-expression for comparisons.
+func cqlString(n CQLNode) string {
+	switch n := n.(type) {
+	case *CQLClause:
+		return fmt.Sprintf("[%s %s%s %s]", n.Index, n.Relation, cqlModifiers(n.Modifiers), n.Term)
+	case *CQLBoolean:
+		return fmt.Sprintf("(%s%s %s %s)", n.Op, cqlModifiers(n.Modifiers), cqlString(n.Left), cqlString(n.Right))
+	}
+	return "?"
+}
+
+func TestParseCQL(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		sort  string
+	}{
+		{"cat", "[cql.serverChoice = cat]", ""},
+		{`"cat in the hat"`, "[cql.serverChoice = cat in the hat]", ""},
+		{"dc.title = cat", "[dc.title = cat]", ""},
+		{"dc.title==cat", "[dc.title == cat]", ""},
+		{"dc.date >= 2001", "[dc.date >= 2001]", ""},
+		{"dc.title ANY \"cat hat\"", "[dc.title any cat hat]", ""},
+		{"dc.title =/relevant/stem cat", "[dc.title =/relevant/stem cat]", ""},
+		{"title adj/distance<3 \"a b\"", "[title adj/distance<3 a b]", ""},
+		{"cat and dog", "(and [cql.serverChoice = cat] [cql.serverChoice = dog])", ""},
+		{"cat OR dog not fish", "(not (or [cql.serverChoice = cat] [cql.serverChoice = dog]) [cql.serverChoice = fish])", ""},
+		{"cat and (dog or fish)", "(and [cql.serverChoice = cat] (or [cql.serverChoice = dog] [cql.serverChoice = fish]))", ""},
+		{"cat prox/unit=word dog", "(prox/unit=word [cql.serverChoice = cat] [cql.serverChoice = dog])", ""},
+		{`> dc = "http://purl.org/dc/elements/1.1/" dc.title = cat`, "[dc.title = cat]", ""},
+		{`"and" and "sortby"`, "(and [cql.serverChoice = and] [cql.serverChoice = sortby])", ""},
+		{`title = "say \"hi\""`, `[title = say "hi"]`, ""},
+		{`title = ca\*t*`, `[title = ca\*t*]`, ""},
+		{`title = "ca\*t"`, `[title = ca\*t]`, ""},
+		{"cat sortby dc.title/descending dc.date", "[cql.serverChoice = cat]", "dc.title/descending dc.date"},
+	}
+	for _, tt := range tests {
+		q, err := ParseCQL(tt.query)
+		if err != nil {
+			t.Errorf("ParseCQL(%q): %v", tt.query, err)
+			continue
+		}
+		if got := cqlString(q.Root); got != tt.want {
+			t.Errorf("ParseCQL(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+		var keys []string
+		for _, k := range q.SortKeys {
+			keys = append(keys, k.Index+cqlModifiers(k.Modifiers))
+		}
+		if got := strings.Join(keys, " "); got != tt.sort {
+			t.Errorf("ParseCQL(%q) sort keys = %q, want %q", tt.query, got, tt.sort)
+		}
+	}
+}
+
+func TestParseCQLErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{`"cat`, 0},
+		{"(cat", 4},
+		{"cat)", 3},
+		{"dc.title =", 10},
+		{"dc.title ( cat", 9},
+		{"cat and", 7},
+		{"cat sortby", 10},
+		{"dc.title =/ cat", 15}, // cat is read as the modifier name
+		{"> dc = ", 7},
+	}
+	for _, tt := range tests {
+		_, err := ParseCQL(tt.query)
+		var se *CQLSyntaxError
+		if !errors.As(err, &se) || se.Pos != tt.pos {
+			t.Errorf("ParseCQL(%q) = %v, want a syntax error at %d", tt.query, err, tt.pos)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidICal is returned when a file is not an iCalendar VCALENDAR.
+var ErrInvalidICal = errors.New("invalid iCalendar file")
+
+// ErrUnsupportedRRule is returned for a recurrence rule that cannot be
+// expanded: only FREQ=YEARLY with BYMONTH, BYMONTHDAY and BYDAY is.
+var ErrUnsupportedRRule = errors.New("unsupported recurrence rule")
+
+// ICalEvent is a VEVENT reduced to the days it covers. Start and End are
+// midnight UTC dates and End is exclusive, as DTEND is for all-day events;
+// an event without DTEND covers its start day only.
+type ICalEvent struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+
+	// RRule is the RRULE of a recurring event and ExDates the dates it
+	// skips; see Occurrences
+	RRule   string
+	ExDates []time.Time
+}
+
+// ParseICal reads the events of an iCalendar (RFC 5545) stream, as published
+// for public holidays. Only the date part of DTSTART and DTEND is kept.
+func ParseICal(r io.Reader) ([]ICalEvent, error) {
+	lines, err := icalLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrInvalidICal
+	}
+
+	var events []ICalEvent
+	var ev *ICalEvent
+	for i, line := range lines {
+		name, params, value := icalProperty(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			ev = &ICalEvent{}
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if ev == nil {
+				return nil, fmt.Errorf("%w: line %d: END:VEVENT without BEGIN", ErrInvalidICal, i+1)
+			}
+			if ev.Start.IsZero() {
+				return nil, fmt.Errorf("%w: event %q has no DTSTART", ErrInvalidICal, ev.Summary)
+			}
+			if !ev.End.After(ev.Start) {
+				ev.End = ev.Start.AddDate(0, 0, 1)
+			}
+			events = append(events, *ev)
+			ev = nil
+		case ev == nil:
+		case name == "UID":
+			ev.UID = value
+		case name == "SUMMARY":
+			ev.Summary = icalText(value)
+		case name == "RRULE":
+			if _, err := parseICalRule(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			ev.RRule = value
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				d, err := icalDate(v)
+				if err != nil {
+					return nil, fmt.Errorf("%w: line %d: EXDATE %q", ErrInvalidICal, i+1, v)
+				}
+				ev.ExDates = append(ev.ExDates, d)
+			}
+		case name == "DTSTART" || name == "DTEND":
+			d, err := icalDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %s %q", ErrInvalidICal, i+1, name, value)
+			}
+			if name == "DTSTART" {
+				ev.Start = d
+			} else {
+				ev.End = d
+				// A timed DTEND is inclusive of the day it falls on
+				if !strings.Contains(strings.ToUpper(params), "VALUE=DATE") && len(value) > 8 {
+					if t := strings.TrimSuffix(value[9:], "Z"); strings.Trim(t, "0") != "" {
+						ev.End = d.AddDate(0, 0, 1)
+					}
+				}
+			}
+		}
+	}
+	if ev != nil {
+		return nil, fmt.Errorf("%w: unterminated VEVENT", ErrInvalidICal)
+	}
+	return events, nil
+}
+
+// icalRule is a parsed FREQ=YEARLY RRULE. A zero count or until means no
+// limit; byDay holds weekdays with their ordinal in the month, 0 for every
+// such weekday.
+type icalRule struct {
+	interval   int
+	count      int
+	until      time.Time
+	byMonth    []time.Month
+	byMonthDay []int
+	byDay      []icalWeekday
+}
+
+type icalWeekday struct {
+	ord int
+	day time.Weekday
+}
+
+var icalWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseICalRule reads an RRULE value such as
+// "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH".
+func parseICalRule(v string) (*icalRule, error) {
+	rule := &icalRule{interval: 1}
+	freq := ""
+	for _, part := range strings.Split(v, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: RRULE %q", ErrInvalidICal, v)
+		}
+		key, val = strings.ToUpper(key), strings.ToUpper(val)
+		var err error
+		switch key {
+		case "FREQ":
+			freq = val
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(val)
+			if err == nil && rule.interval < 1 {
+				err = ErrInvalidICal
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(val)
+			if err == nil && rule.count < 1 {
+				err = ErrInvalidICal
+			}
+		case "UNTIL":
+			rule.until, err = icalDate(val)
+		case "BYMONTH":
+			for _, f := range strings.Split(val, ",") {
+				m, e := strconv.Atoi(f)
+				if e != nil || m < 1 || m > 12 {
+					err = ErrInvalidICal
+					break
+				}
+				rule.byMonth = append(rule.byMonth, time.Month(m))
+			}
+		case "BYMONTHDAY":
+			for _, f := range strings.Split(val, ",") {
+				d, e := strconv.Atoi(f)
+				if e != nil || d == 0 || d < -31 || d > 31 {
+					err = ErrInvalidICal
+					break
+				}
+				rule.byMonthDay = append(rule.byMonthDay, d)
+			}
+		case "BYDAY":
+			for _, f := range strings.Split(val, ",") {
+				if len(f) < 2 {
+					err = ErrInvalidICal
+					break
+				}
+				day, ok := icalWeekdays[f[len(f)-2:]]
+				ord := 0
+				if n := f[:len(f)-2]; n != "" {
+					ord, err = strconv.Atoi(n)
+				}
+				if !ok || err != nil || ord < -5 || ord > 5 {
+					err = ErrInvalidICal
+					break
+				}
+				rule.byDay = append(rule.byDay, icalWeekday{ord: ord, day: day})
+			}
+		case "WKST":
+			// Only matters for weekly rules
+		default:
+			return nil, fmt.Errorf("%w: %s in RRULE %q", ErrUnsupportedRRule, key, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s in RRULE %q", ErrInvalidICal, key, v)
+		}
+	}
+	if freq != "YEARLY" {
+		return nil, fmt.Errorf("%w: FREQ=%s in RRULE %q", ErrUnsupportedRRule, freq, v)
+	}
+	if len(rule.byDay) > 0 && len(rule.byMonth) == 0 {
+		return nil, fmt.Errorf("%w: BYDAY without BYMONTH in RRULE %q", ErrUnsupportedRRule, v)
+	}
+	return rule, nil
+}
+
+// dates returns the days of year y that the rule picks for an event
+// starting on start, in order.
+func (r *icalRule) dates(y int, start time.Time) []time.Time {
+	months := r.byMonth
+	if len(months) == 0 {
+		months = []time.Month{start.Month()}
+	}
+	var out []time.Time
+	for _, m := range months {
+		first := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		last := first.AddDate(0, 1, -1).Day()
+		inMonth := func(d int) bool { return d >= 1 && d <= last }
+
+		var days []int
+		switch {
+		case len(r.byDay) > 0:
+			for _, wd := range r.byDay {
+				firstOfDay := 1 + (int(wd.day)-int(first.Weekday())+7)%7
+				var all []int
+				for d := firstOfDay; d <= last; d += 7 {
+					all = append(all, d)
+				}
+				switch {
+				case wd.ord == 0:
+					days = append(days, all...)
+				case wd.ord > 0 && wd.ord <= len(all):
+					days = append(days, all[wd.ord-1])
+				case wd.ord < 0 && -wd.ord <= len(all):
+					days = append(days, all[len(all)+wd.ord])
+				}
+			}
+			if len(r.byMonthDay) > 0 {
+				// BYMONTHDAY narrows BYDAY down, as in Friday the 13th
+				var kept []int
+				for _, d := range days {
+					for _, md := range r.byMonthDay {
+						if md == d || md < 0 && last+1+md == d {
+							kept = append(kept, d)
+							break
+						}
+					}
+				}
+				days = kept
+			}
+		case len(r.byMonthDay) > 0:
+			for _, md := range r.byMonthDay {
+				if md < 0 {
+					md = last + 1 + md
+				}
+				days = append(days, md)
+			}
+		default:
+			days = []int{start.Day()}
+		}
+		for _, d := range days {
+			// An invalid date, such as February 29 in a common year, is skipped
+			if inMonth(d) {
+				out = append(out, time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+// Occurrences expands a recurring event into one event per occurrence that
+// overlaps from to until, honoring the COUNT, UNTIL and INTERVAL of its
+// RRULE and its EXDATEs. The expansion is bounded by until, however the rule
+// is written. An event without an RRULE is returned as it is.
+func (ev ICalEvent) Occurrences(from, until time.Time) ([]ICalEvent, error) {
+	if ev.RRule == "" {
+		return []ICalEvent{ev}, nil
+	}
+	rule, err := parseICalRule(ev.RRule)
+	if err != nil {
+		return nil, err
+	}
+	length := ev.End.Sub(ev.Start)
+	excluded := make(map[time.Time]bool, len(ev.ExDates))
+	for _, d := range ev.ExDates {
+		excluded[d] = true
+	}
+
+	var out []ICalEvent
+	n := 0
+	add := func(d time.Time) bool {
+		if !d.Before(until) || !rule.until.IsZero() && d.After(rule.until) {
+			return false
+		}
+		n++
+		if !excluded[d] && d.Add(length).After(from) {
+			occ := ev
+			occ.Start, occ.End = d, d.Add(length)
+			occ.RRule, occ.ExDates = "", nil
+			out = append(out, occ)
+		}
+		return rule.count == 0 || n < rule.count
+	}
+	// DTSTART is always the first occurrence, whether or not the rule picks it
+	if !add(ev.Start) {
+		return out, nil
+	}
+	for y := ev.Start.Year(); y <= until.Year(); y += rule.interval {
+		for _, d := range rule.dates(y, ev.Start) {
+			if d.After(ev.Start) && !add(d) {
+				return out, nil
+			}
+		}
+	}
+	return out, nil
+}
+
+// icalLines splits a stream into unfolded content lines.
+func icalLines(r io.Reader) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	var lines []string
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// icalProperty splits "NAME;PARAM=X:value" into its upper-cased name, its
+// parameters and its value.
+func icalProperty(line string) (name, params, value string) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return strings.ToUpper(line), "", ""
+	}
+	name, value = line[:colon], line[colon+1:]
+	if semi := strings.IndexByte(name, ';'); semi >= 0 {
+		name, params = name[:semi], name[semi+1:]
+	}
+	return strings.ToUpper(name), params, value
+}
+
+// icalDate reads the date of a DATE (20261225) or DATE-TIME
+// (20261225T000000Z) value.
+func icalDate(v string) (time.Time, error) {
+	if len(v) < 8 {
+		return time.Time{}, ErrInvalidICal
+	}
+	return time.Parse("20060102", v[:8])
+}
+
+// icalText undoes TEXT escaping.
+func icalText(v string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(v)
+}
@@ -0,0 +1,167 @@
+package helper
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func icalDay(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func icalCalendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func TestParseICal(t *testing.T) {
+	data := icalCalendar(
+		"BEGIN:VEVENT\r\nUID:xmas@example.org\r\nSUMMARY:Christmas\\, Day\r\n"+
+			"DTSTART;VALUE=DATE:20261225\r\nDTEND;VALUE=DATE:20261226\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nSUMMARY:Long\r\n  weekend\r\nDTSTART:20260501T090000Z\r\nDTEND:20260503T170000Z\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nSUMMARY:New Year\r\nDTSTART;VALUE=DATE:20260101\r\nRRULE:FREQ=YEARLY\r\n"+
+			"EXDATE;VALUE=DATE:20270101,20280101\r\nEND:VEVENT\r\n",
+	)
+	events, err := ParseICal(strings.NewReader("\ufeff" + data))
+	if err != nil {
+		t.Fatalf("ParseICal: %v", err)
+	}
+	want := []ICalEvent{
+		{UID: "xmas@example.org", Summary: "Christmas, Day", Start: icalDay("2026-12-25"), End: icalDay("2026-12-26")},
+		{Summary: "Long weekend", Start: icalDay("2026-05-01"), End: icalDay("2026-05-04")},
+		{Summary: "New Year", Start: icalDay("2026-01-01"), End: icalDay("2026-01-02"), RRule: "FREQ=YEARLY",
+			ExDates: []time.Time{icalDay("2027-01-01"), icalDay("2028-01-01")}},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, ev := range events {
+		w := want[i]
+		if ev.UID != w.UID || ev.Summary != w.Summary || !ev.Start.Equal(w.Start) || !ev.End.Equal(w.End) ||
+			ev.RRule != w.RRule || len(ev.ExDates) != len(w.ExDates) {
+			t.Errorf("event %d = %+v, want %+v", i, ev, w)
+		}
+	}
+}
+
+func TestParseICalInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"not a calendar", "BEGIN:VEVENT\r\n", ErrInvalidICal},
+		{"no DTSTART", icalCalendar("BEGIN:VEVENT\r\nSUMMARY:x\r\nEND:VEVENT\r\n"), ErrInvalidICal},
+		{"bad DTSTART", icalCalendar("BEGIN:VEVENT\r\nDTSTART:2026\r\nEND:VEVENT\r\n"), ErrInvalidICal},
+		{"unterminated", icalCalendar("BEGIN:VEVENT\r\nDTSTART:20260101\r\n"), ErrInvalidICal},
+		{"END without BEGIN", icalCalendar("END:VEVENT\r\n"), ErrInvalidICal},
+		{"bad EXDATE", icalCalendar("BEGIN:VEVENT\r\nDTSTART:20260101\r\nEXDATE:x\r\nEND:VEVENT\r\n"), ErrInvalidICal},
+		{"weekly", icalCalendar("BEGIN:VEVENT\r\nDTSTART:20260101\r\nRRULE:FREQ=WEEKLY\r\nEND:VEVENT\r\n"), ErrUnsupportedRRule},
+		{"bad rule", icalCalendar("BEGIN:VEVENT\r\nDTSTART:20260101\r\nRRULE:FREQ=YEARLY;COUNT=0\r\nEND:VEVENT\r\n"), ErrInvalidICal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseICal(strings.NewReader(tt.data)); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseICalRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  error
+	}{
+		{"FREQ=YEARLY", nil},
+		{"freq=yearly;interval=2;count=5", nil},
+		{"FREQ=YEARLY;UNTIL=20301231T000000Z;WKST=MO", nil},
+		{"FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", nil},
+		{"FREQ=YEARLY;BYMONTH=5;BYDAY=-1MO", nil},
+		{"FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=1,-1", nil},
+		{"FREQ=MONTHLY", ErrUnsupportedRRule},
+		{"INTERVAL=1", ErrUnsupportedRRule},
+		{"FREQ=YEARLY;BYDAY=MO", ErrUnsupportedRRule},
+		{"FREQ=YEARLY;BYWEEKNO=20", ErrUnsupportedRRule},
+		{"FREQ=YEARLY;BYSETPOS=1", ErrUnsupportedRRule},
+		{"FREQ=YEARLY;INTERVAL=0", ErrInvalidICal},
+		{"FREQ=YEARLY;COUNT=x", ErrInvalidICal},
+		{"FREQ=YEARLY;UNTIL=2030", ErrInvalidICal},
+		{"FREQ=YEARLY;BYMONTH=13", ErrInvalidICal},
+		{"FREQ=YEARLY;BYMONTH=1;BYMONTHDAY=0", ErrInvalidICal},
+		{"FREQ=YEARLY;BYMONTH=1;BYDAY=6MO", ErrInvalidICal},
+		{"FREQ=YEARLY;BYMONTH=1;BYDAY=1XX", ErrInvalidICal},
+		{"FREQ=YEARLY;BYMONTH", ErrInvalidICal},
+	}
+	for _, tt := range tests {
+		_, err := parseICalRule(tt.rule)
+		if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("parseICalRule(%q) = %v, want %v", tt.rule, err, tt.err)
+		}
+	}
+}
+
+func TestICalOccurrences(t *testing.T) {
+	from, until := icalDay("2026-01-01"), icalDay("2029-01-01")
+	tests := []struct {
+		name    string
+		start   string
+		days    int
+		rule    string
+		exdates []string
+		want    []string
+	}{
+		{"not recurring", "2020-03-01", 1, "", nil, []string{"2020-03-01"}},
+		{"yearly", "2026-12-25", 1, "FREQ=YEARLY", nil, []string{"2026-12-25", "2027-12-25", "2028-12-25"}},
+		{"started in the past", "1990-07-04", 1, "FREQ=YEARLY", nil, []string{"2026-07-04", "2027-07-04", "2028-07-04"}},
+		{"spans the window start", "2025-12-31", 2, "FREQ=YEARLY", nil, []string{"2025-12-31", "2026-12-31", "2027-12-31", "2028-12-31"}},
+		{"count", "2025-05-01", 1, "FREQ=YEARLY;COUNT=2", nil, []string{"2026-05-01"}},
+		{"until", "2026-05-01", 1, "FREQ=YEARLY;UNTIL=20270501", nil, []string{"2026-05-01", "2027-05-01"}},
+		{"interval", "2026-06-01", 1, "FREQ=YEARLY;INTERVAL=2", nil, []string{"2026-06-01", "2028-06-01"}},
+		{"exdate", "2026-01-01", 1, "FREQ=YEARLY", []string{"2027-01-01"}, []string{"2026-01-01", "2028-01-01"}},
+		{"leap day", "2024-02-29", 1, "FREQ=YEARLY", nil, []string{"2028-02-29"}},
+		{"nth weekday", "2026-11-26", 1, "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", nil,
+			[]string{"2026-11-26", "2027-11-25", "2028-11-23"}},
+		{"last weekday", "2026-05-25", 1, "FREQ=YEARLY;BYMONTH=5;BYDAY=-1MO", nil,
+			[]string{"2026-05-25", "2027-05-31", "2028-05-29"}},
+		{"month days", "2026-01-01", 1, "FREQ=YEARLY;BYMONTH=1,12;BYMONTHDAY=1,-1;COUNT=5", nil,
+			[]string{"2026-01-01", "2026-01-31", "2026-12-01", "2026-12-31", "2027-01-01"}},
+		{"friday the 13th", "2026-02-13", 1, "FREQ=YEARLY;BYMONTH=2,3,11;BYDAY=FR;BYMONTHDAY=13;UNTIL=20271231", nil,
+			[]string{"2026-02-13", "2026-03-13", "2026-11-13"}},
+		{"off-rule DTSTART", "2026-01-02", 1, "FREQ=YEARLY;BYMONTH=1;BYMONTHDAY=1;COUNT=2", nil,
+			[]string{"2026-01-02", "2027-01-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := ICalEvent{Summary: tt.name, Start: icalDay(tt.start), RRule: tt.rule}
+			ev.End = ev.Start.AddDate(0, 0, tt.days)
+			for _, d := range tt.exdates {
+				ev.ExDates = append(ev.ExDates, icalDay(d))
+			}
+			occs, err := ev.Occurrences(from, until)
+			if err != nil {
+				t.Fatalf("Occurrences: %v", err)
+			}
+			var got []string
+			for _, o := range occs {
+				got = append(got, o.Start.Format("2006-01-02"))
+				if o.End.Sub(o.Start) != ev.End.Sub(ev.Start) || o.RRule != "" || o.Summary != tt.name {
+					t.Errorf("occurrence %+v does not match the event", o)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("occurrences = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	ev := ICalEvent{Start: icalDay("2026-01-01"), End: icalDay("2026-01-02"), RRule: "FREQ=DAILY"}
+	if _, err := ev.Occurrences(from, until); !errors.Is(err, ErrUnsupportedRRule) {
+		t.Errorf("FREQ=DAILY: err = %v, want ErrUnsupportedRRule", err)
+	}
+}
@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+
+	// Decoders for the formats CoverTypes accepts
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrUnsupportedImage is returned for data that is not one of CoverTypes.
+var ErrUnsupportedImage = errors.New("unsupported image type")
+
+// ErrImageTooLarge is returned for images over MaxImagePixels.
+var ErrImageTooLarge = errors.New("image dimensions too large")
+
+// MaxImagePixels bounds width*height so a small, highly compressed file
+// cannot expand to gigabytes when decoded.
+const MaxImagePixels = 40_000_000
+
+// CoverTypes maps the accepted image content types to file extensions.
+var CoverTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// SniffImage identifies data by its leading bytes, ignoring whatever type the
+// client claimed.
+func SniffImage(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := CoverTypes[contentType]; !ok {
+		return "", ErrUnsupportedImage
+	}
+	return contentType, nil
+}
+
+// ImageInfo is a decoded image with its dimensions.
+type ImageInfo struct {
+	Image  image.Image
+	Width  int
+	Height int
+}
+
+// DecodeImage checks the dimensions in the header before decoding the pixels.
+func DecodeImage(data []byte) (*ImageInfo, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	return &ImageInfo{Image: img, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+// Thumbnail scales img to fit inside maxWidth x maxHeight, keeping the aspect
+// ratio and never enlarging, and encodes it as a JPEG. Transparent areas are
+// filled with white.
+func Thumbnail(img image.Image, maxWidth, maxHeight int) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxWidth {
+		h = max(1, h*maxWidth/w)
+		w = maxWidth
+	}
+	if h > maxHeight {
+		w = max(1, w*maxHeight/h)
+		h = maxHeight
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,122 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffImage(t *testing.T) {
+	var jpg, gf bytes.Buffer
+	if err := jpeg.Encode(&jpg, testImage(4, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gf, testImage(4, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  error
+	}{
+		{"png", encodePNG(t, testImage(4, 4)), "image/png", nil},
+		{"jpeg", jpg.Bytes(), "image/jpeg", nil},
+		{"gif", gf.Bytes(), "image/gif", nil},
+		{"webp", append([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), make([]byte, 16)...), "image/webp", nil},
+		{"text", []byte("not an image at all"), "", ErrUnsupportedImage},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", ErrUnsupportedImage},
+		{"empty", nil, "", ErrUnsupportedImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SniffImage(tt.data)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("SniffImage = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	info, err := DecodeImage(encodePNG(t, testImage(30, 20)))
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if info.Width != 30 || info.Height != 20 || info.Image.Bounds().Dx() != 30 {
+		t.Errorf("DecodeImage = %dx%d", info.Width, info.Height)
+	}
+
+	if _, err := DecodeImage([]byte("\x89PNG\r\n\x1a\ngarbage")); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("corrupt PNG: err = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+// A PNG whose header claims more pixels than MaxImagePixels is refused
+// before its pixels are decoded.
+func TestDecodeImageTooLarge(t *testing.T) {
+	data := encodePNG(t, testImage(2, 2))
+	// The IHDR chunk follows the 8-byte signature: length, type, then width
+	// and height, and its CRC covers the type and data
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := DecodeImage(data); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("err = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		maxW, maxH   int
+		wantW, wantH int
+	}{
+		{"wide", 400, 200, 100, 150, 100, 50},
+		{"tall", 200, 600, 100, 150, 50, 150},
+		{"small is not enlarged", 40, 60, 100, 150, 40, 60},
+		{"thin keeps a pixel", 1000, 2, 100, 150, 100, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Thumbnail(testImage(tt.w, tt.h), tt.maxW, tt.maxH)
+			if err != nil {
+				t.Fatalf("Thumbnail: %v", err)
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode thumbnail: %v", err)
+			}
+			if format != "jpeg" || cfg.Width != tt.wantW || cfg.Height != tt.wantH {
+				t.Errorf("thumbnail = %s %dx%d, want jpeg %dx%d", format, cfg.Width, cfg.Height, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
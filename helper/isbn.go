@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidISBN is returned when a value cannot be read as an ISBN-10, ISBN-13 or EAN-13.
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// ISBN holds both representations of a normalized ISBN. ISBN10 is empty for
+// 979-prefixed numbers, which have no ISBN-10 equivalent.
+type ISBN struct {
+	ISBN10 string
+	ISBN13 string
+}
+
+// ParseISBN accepts an ISBN-10, ISBN-13 or the raw digits sent by a barcode
+// scanner (EAN-13, optionally followed by a 2 or 5 digit price add-on) and
+// returns the normalized forms. Hyphens, spaces and an "ISBN" prefix are ignored.
+func ParseISBN(raw string) (ISBN, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	s = strings.TrimPrefix(s, "ISBN-13")
+	s = strings.TrimPrefix(s, "ISBN-10")
+	s = strings.TrimPrefix(s, "ISBN")
+	s = strings.TrimLeft(s, ": ")
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == 'X':
+			b.WriteRune(r)
+		case r == '-' || r == ' ':
+			// separators are not significant
+		default:
+			return ISBN{}, ErrInvalidISBN
+		}
+	}
+	digits := b.String()
+
+	// Scanners reading the supplemental price barcode append it to the EAN-13.
+	if (len(digits) == 15 || len(digits) == 18) && isBooklandPrefix(digits) {
+		digits = digits[:13]
+	}
+
+	switch len(digits) {
+	case 10:
+		if !ValidISBN10(digits) {
+			return ISBN{}, ErrInvalidISBN
+		}
+		return ISBN{ISBN10: digits, ISBN13: ISBN10To13(digits)}, nil
+	case 13:
+		if !ValidISBN13(digits) {
+			return ISBN{}, ErrInvalidISBN
+		}
+		return ISBN{ISBN10: ISBN13To10(digits), ISBN13: digits}, nil
+	}
+	return ISBN{}, ErrInvalidISBN
+}
+
+// ValidISBN10 reports whether s is ten characters with a correct mod-11 check digit.
+func ValidISBN10(s string) bool {
+	if len(s) != 10 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var v int
+		switch {
+		case s[i] >= '0' && s[i] <= '9':
+			v = int(s[i] - '0')
+		case s[i] == 'X' && i == 9:
+			v = 10
+		default:
+			return false
+		}
+		sum += v * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// ValidISBN13 reports whether s is a Bookland EAN-13 (978/979) with a correct check digit.
+func ValidISBN13(s string) bool {
+	if len(s) != 13 || !isBooklandPrefix(s) {
+		return false
+	}
+	for i := 0; i < 13; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return ean13CheckDigit(s[:12]) == s[12]
+}
+
+// ISBN10To13 converts a valid ISBN-10 into its 978-prefixed ISBN-13.
+func ISBN10To13(s string) string {
+	body := "978" + s[:9]
+	return body + string(ean13CheckDigit(body))
+}
+
+// ISBN13To10 converts a 978-prefixed ISBN-13 to ISBN-10, or returns "" if there is none.
+func ISBN13To10(s string) string {
+	if !strings.HasPrefix(s, "978") {
+		return ""
+	}
+	body := s[3:12]
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X"
+	}
+	return body + string(rune('0'+check))
+}
+
+func ean13CheckDigit(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(first12[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func isBooklandPrefix(s string) bool {
+	return strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")
+}
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseISBN(t *testing.T) {
+	tests := []struct {
+		raw    string
+		isbn10 string
+		isbn13 string
+		err    error
+	}{
+		{"0306406152", "0306406152", "9780306406157", nil},
+		{"0-306-40615-2", "0306406152", "9780306406157", nil},
+		{"ISBN 978-0-306-40615-7", "0306406152", "9780306406157", nil},
+		{"ISBN-13: 9780306406157", "0306406152", "9780306406157", nil},
+		{"isbn-10: 080442957x", "080442957X", "9780804429573", nil},
+		{"9791090636071", "", "9791090636071", nil},
+		{"978030640615751000", "0306406152", "9780306406157", nil}, // 5 digit price add-on
+		{"978030640615790", "0306406152", "9780306406157", nil},    // 2 digit add-on
+		{"0306406153", "", "", ErrInvalidISBN},
+		{"9780306406158", "", "", ErrInvalidISBN},
+		{"1234567890123", "", "", ErrInvalidISBN}, // not Bookland
+		{"X306406152", "", "", ErrInvalidISBN},
+		{"030640615", "", "", ErrInvalidISBN},
+		{"0306406152.", "", "", ErrInvalidISBN},
+		{"123456789012345", "", "", ErrInvalidISBN},
+		{"", "", "", ErrInvalidISBN},
+	}
+	for _, tt := range tests {
+		got, err := ParseISBN(tt.raw)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseISBN(%q) = %+v, %v; want %v", tt.raw, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got.ISBN10 != tt.isbn10 || got.ISBN13 != tt.isbn13 {
+			t.Errorf("ParseISBN(%q) = %+v, %v; want %s / %s", tt.raw, got, err, tt.isbn10, tt.isbn13)
+		}
+	}
+}
+
+func TestISBNConversions(t *testing.T) {
+	tests := []struct {
+		isbn10, isbn13 string
+	}{
+		{"0306406152", "9780306406157"},
+		{"080442957X", "9780804429573"},
+		{"0000000000", "9780000000002"},
+	}
+	for _, tt := range tests {
+		if !ValidISBN10(tt.isbn10) || !ValidISBN13(tt.isbn13) {
+			t.Errorf("%s / %s not valid", tt.isbn10, tt.isbn13)
+		}
+		if got := ISBN10To13(tt.isbn10); got != tt.isbn13 {
+			t.Errorf("ISBN10To13(%s) = %s, want %s", tt.isbn10, got, tt.isbn13)
+		}
+		if got := ISBN13To10(tt.isbn13); got != tt.isbn10 {
+			t.Errorf("ISBN13To10(%s) = %s, want %s", tt.isbn13, got, tt.isbn10)
+		}
+	}
+	if got := ISBN13To10("9791090636071"); got != "" {
+		t.Errorf("ISBN13To10 of a 979 number = %q", got)
+	}
+	for _, s := range []string{"X306406152", "03064061X2", "030640615", "030640615a"} {
+		if ValidISBN10(s) {
+			t.Errorf("ValidISBN10(%q) = true", s)
+		}
+	}
+}
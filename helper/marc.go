@@ -0,0 +1,436 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrInvalidMARC is returned when a record does not follow ISO 2709 or MARCXML structure.
+var ErrInvalidMARC = errors.New("invalid MARC record")
+
+// MARCXMLNamespace is the namespace of MARC21 slim XML.
+const MARCXMLNamespace = "http://www.loc.gov/MARC21/slim"
+
+const (
+	marcSubfieldDelimiter = 0x1F
+	marcFieldTerminator   = 0x1E
+	marcRecordTerminator  = 0x1D
+	marcLeaderLength      = 24
+	marcDirectoryEntry    = 12
+)
+
+// MARCRecord is one bibliographic record. Fields keep their order from the source.
+type MARCRecord struct {
+	Leader        string
+	ControlFields []MARCControlField
+	DataFields    []MARCDataField
+}
+
+// MARCControlField is a 00X field, which has a value but no indicators or subfields.
+type MARCControlField struct {
+	Tag   string
+	Value string
+}
+
+// MARCDataField is a field with two indicators and a list of subfields.
+type MARCDataField struct {
+	Tag       string
+	Ind1      byte
+	Ind2      byte
+	Subfields []MARCSubfield
+}
+
+// MARCSubfield is one coded value inside a data field.
+type MARCSubfield struct {
+	Code  byte
+	Value string
+}
+
+// Fields returns every data field with the given tag.
+func (r *MARCRecord) Fields(tag string) []MARCDataField {
+	var out []MARCDataField
+	for _, f := range r.DataFields {
+		if f.Tag == tag {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Subfield returns the first value of the subfield with the given code, or "".
+func (f MARCDataField) Subfield(code byte) string {
+	for _, s := range f.Subfields {
+		if s.Code == code {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// NewMARCDataField builds a field from code/value pairs such as "a", "Title".
+func NewMARCDataField(tag string, ind1, ind2 byte, pairs ...string) MARCDataField {
+	f := MARCDataField{Tag: tag, Ind1: ind1, Ind2: ind2}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			f.Subfields = append(f.Subfields, MARCSubfield{Code: pairs[i][0], Value: pairs[i+1]})
+		}
+	}
+	return f
+}
+
+func isControlTag(tag string) bool {
+	return strings.HasPrefix(tag, "00")
+}
+
+// -----------------------------------------------------------------------------
+// ISO 2709
+// -----------------------------------------------------------------------------
+
+// MARCReader reads binary MARC21 (ISO 2709) records one at a time.
+type MARCReader struct {
+	r *bufio.Reader
+}
+
+func NewMARCReader(r io.Reader) *MARCReader {
+	return &MARCReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record, or io.EOF when the input is exhausted.
+func (mr *MARCReader) Read() (*MARCRecord, error) {
+	// Some exports put a line break between records
+	for {
+		b, err := mr.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != '\n' && b[0] != '\r' && b[0] != ' ' {
+			break
+		}
+		mr.r.ReadByte()
+	}
+
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(mr.r, head); err != nil {
+		return nil, fmt.Errorf("%w: truncated record length", ErrInvalidMARC)
+	}
+	length, ok := marcNumber(head)
+	if !ok || length < marcLeaderLength+2 {
+		return nil, fmt.Errorf("%w: bad record length %q", ErrInvalidMARC, head)
+	}
+	data := make([]byte, length)
+	copy(data, head)
+	if _, err := io.ReadFull(mr.r, data[5:]); err != nil {
+		return nil, fmt.Errorf("%w: record shorter than its length %d", ErrInvalidMARC, length)
+	}
+	return ParseMARC(data)
+}
+
+// ParseMARC decodes a single ISO 2709 record. Records that are not UTF-8
+// (leader position 9 other than 'a', i.e. MARC-8) are read byte for byte
+// with invalid sequences replaced.
+func ParseMARC(data []byte) (*MARCRecord, error) {
+	if len(data) < marcLeaderLength+1 {
+		return nil, fmt.Errorf("%w: shorter than a leader", ErrInvalidMARC)
+	}
+	rec := &MARCRecord{Leader: string(data[:marcLeaderLength])}
+	base, ok := marcNumber(data[12:17])
+	if !ok || base <= marcLeaderLength || base > len(data) || data[base-1] != marcFieldTerminator {
+		return nil, fmt.Errorf("%w: bad base address %q", ErrInvalidMARC, data[12:17])
+	}
+
+	dir := data[marcLeaderLength : base-1]
+	if len(dir)%marcDirectoryEntry != 0 {
+		return nil, fmt.Errorf("%w: directory length %d is not a multiple of %d", ErrInvalidMARC, len(dir), marcDirectoryEntry)
+	}
+	for i := 0; i < len(dir); i += marcDirectoryEntry {
+		entry := dir[i : i+marcDirectoryEntry]
+		tag := string(entry[:3])
+		length, ok1 := marcNumber(entry[3:7])
+		start, ok2 := marcNumber(entry[7:12])
+		if !ok1 || !ok2 || length < 1 || base+start+length > len(data) {
+			return nil, fmt.Errorf("%w: bad directory entry %q", ErrInvalidMARC, entry)
+		}
+		field := data[base+start : base+start+length]
+		field = bytes.TrimSuffix(field, []byte{marcFieldTerminator})
+
+		if isControlTag(tag) {
+			rec.ControlFields = append(rec.ControlFields, MARCControlField{Tag: tag, Value: marcString(field)})
+			continue
+		}
+		if len(field) < 2 {
+			return nil, fmt.Errorf("%w: field %s has no indicators", ErrInvalidMARC, tag)
+		}
+		df := MARCDataField{Tag: tag, Ind1: field[0], Ind2: field[1]}
+		for n, chunk := range bytes.Split(field[2:], []byte{marcSubfieldDelimiter}) {
+			// Anything before the first delimiter is not a subfield
+			if n == 0 || len(chunk) == 0 {
+				continue
+			}
+			df.Subfields = append(df.Subfields, MARCSubfield{Code: chunk[0], Value: marcString(chunk[1:])})
+		}
+		rec.DataFields = append(rec.DataFields, df)
+	}
+	return rec, nil
+}
+
+// marcNumber reads a fixed-width numeric field, which must be ASCII digits
+// only: no sign, spaces or other characters strconv would accept.
+func marcNumber(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+func marcString(b []byte) string {
+	return strings.ToValidUTF8(string(b), "�")
+}
+
+// MARCWriter writes records as binary MARC21 (ISO 2709), always UTF-8 encoded.
+type MARCWriter struct {
+	w io.Writer
+}
+
+func NewMARCWriter(w io.Writer) *MARCWriter {
+	return &MARCWriter{w: w}
+}
+
+// Write encodes rec, filling in the record length, base address and the
+// fixed leader positions.
+func (mw *MARCWriter) Write(rec *MARCRecord) error {
+	var dir, body bytes.Buffer
+	addField := func(tag string, content []byte) error {
+		if len(tag) != 3 {
+			return fmt.Errorf("%w: tag %q is not three characters", ErrInvalidMARC, tag)
+		}
+		content = append(content, marcFieldTerminator)
+		if len(content) > 9999 {
+			return fmt.Errorf("%w: field %s is longer than 9999 bytes", ErrInvalidMARC, tag)
+		}
+		fmt.Fprintf(&dir, "%s%04d%05d", tag, len(content), body.Len())
+		body.Write(content)
+		return nil
+	}
+
+	for _, f := range rec.ControlFields {
+		if err := addField(f.Tag, []byte(f.Value)); err != nil {
+			return err
+		}
+	}
+	for _, f := range rec.DataFields {
+		content := []byte{marcIndicator(f.Ind1), marcIndicator(f.Ind2)}
+		for _, s := range f.Subfields {
+			content = append(content, marcSubfieldDelimiter, s.Code)
+			content = append(content, s.Value...)
+		}
+		if err := addField(f.Tag, content); err != nil {
+			return err
+		}
+	}
+	dir.WriteByte(marcFieldTerminator)
+
+	base := marcLeaderLength + dir.Len()
+	length := base + body.Len() + 1
+	if length > 99999 {
+		return fmt.Errorf("%w: record is longer than 99999 bytes", ErrInvalidMARC)
+	}
+	leader := []byte(fmt.Sprintf("%-24s", rec.Leader))[:marcLeaderLength]
+	copy(leader[0:5], fmt.Sprintf("%05d", length))
+	leader[9], leader[10], leader[11] = 'a', '2', '2'
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+
+	out := make([]byte, 0, length)
+	out = append(out, leader...)
+	out = append(out, dir.Bytes()...)
+	out = append(out, body.Bytes()...)
+	out = append(out, marcRecordTerminator)
+	_, err := mw.w.Write(out)
+	return err
+}
+
+// Close is a no-op; it lets MARCWriter and MARCXMLWriter be used interchangeably.
+func (mw *MARCWriter) Close() error { return nil }
+
+func marcIndicator(b byte) byte {
+	if b == 0 {
+		return ' '
+	}
+	return b
+}
+
+// -----------------------------------------------------------------------------
+// MARCXML
+// -----------------------------------------------------------------------------
+
+type marcXMLRecord struct {
+	XMLName       xml.Name           `xml:"record"`
+	Leader        string             `xml:"leader"`
+	ControlFields []marcXMLControl   `xml:"controlfield"`
+	DataFields    []marcXMLDatafield `xml:"datafield"`
+}
+
+type marcXMLControl struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcXMLDatafield struct {
+	Tag       string            `xml:"tag,attr"`
+	Ind1      string            `xml:"ind1,attr"`
+	Ind2      string            `xml:"ind2,attr"`
+	Subfields []marcXMLSubfield `xml:"subfield"`
+}
+
+type marcXMLSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// MARCXMLReader reads the record elements of a MARCXML document, whether
+// the root is a collection or a single record.
+type MARCXMLReader struct {
+	d *xml.Decoder
+}
+
+func NewMARCXMLReader(r io.Reader) *MARCXMLReader {
+	return &MARCXMLReader{d: xml.NewDecoder(r)}
+}
+
+// Read returns the next record, or io.EOF when the document has no more.
+func (mr *MARCXMLReader) Read() (*MARCRecord, error) {
+	for {
+		tok, err := mr.d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMARC, err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+		var x marcXMLRecord
+		if err := mr.d.DecodeElement(&x, &start); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMARC, err)
+		}
+		return x.record()
+	}
+}
+
+func (x *marcXMLRecord) record() (*MARCRecord, error) {
+	rec := &MARCRecord{Leader: x.Leader}
+	for _, cf := range x.ControlFields {
+		rec.ControlFields = append(rec.ControlFields, MARCControlField{Tag: cf.Tag, Value: cf.Value})
+	}
+	for _, df := range x.DataFields {
+		if len(df.Tag) != 3 {
+			return nil, fmt.Errorf("%w: tag %q is not three characters", ErrInvalidMARC, df.Tag)
+		}
+		field := MARCDataField{Tag: df.Tag, Ind1: xmlIndicator(df.Ind1), Ind2: xmlIndicator(df.Ind2)}
+		for _, sf := range df.Subfields {
+			if len(sf.Code) != 1 {
+				return nil, fmt.Errorf("%w: subfield code %q in field %s", ErrInvalidMARC, sf.Code, df.Tag)
+			}
+			field.Subfields = append(field.Subfields, MARCSubfield{Code: sf.Code[0], Value: sf.Value})
+		}
+		rec.DataFields = append(rec.DataFields, field)
+	}
+	return rec, nil
+}
+
+func xmlIndicator(s string) byte {
+	if s == "" {
+		return ' '
+	}
+	return s[0]
+}
+
+// MARCXMLWriter writes records inside a MARCXML collection element. Close
+// must be called to end the document.
+type MARCXMLWriter struct {
+	w       io.Writer
+	enc     *xml.Encoder
+	started bool
+}
+
+func NewMARCXMLWriter(w io.Writer) *MARCXMLWriter {
+	return &MARCXMLWriter{w: w, enc: xml.NewEncoder(w)}
+}
+
+func (mw *MARCXMLWriter) begin() error {
+	if mw.started {
+		return nil
+	}
+	mw.started = true
+	_, err := io.WriteString(mw.w, xml.Header+`<collection xmlns="`+MARCXMLNamespace+`">`+"\n")
+	return err
+}
+
+func (mw *MARCXMLWriter) Write(rec *MARCRecord) error {
+	if err := mw.begin(); err != nil {
+		return err
+	}
+	if err := mw.enc.Encode(marcXMLFrom(rec)); err != nil {
+		return err
+	}
+	if err := mw.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(mw.w, "\n")
+	return err
+}
+
+func (mw *MARCXMLWriter) Close() error {
+	if err := mw.begin(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(mw.w, "</collection>\n")
+	return err
+}
+
+// MarshalMARCXML encodes one record as a standalone record element carrying
+// the MARCXML namespace, for embedding in OAI-PMH and SRU responses.
+func MarshalMARCXML(rec *MARCRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	start := xml.StartElement{
+		Name: xml.Name{Local: "record"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: MARCXMLNamespace}},
+	}
+	if err := enc.EncodeElement(marcXMLFrom(rec), start); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func marcXMLFrom(rec *MARCRecord) *marcXMLRecord {
+	x := &marcXMLRecord{Leader: rec.Leader}
+	for _, cf := range rec.ControlFields {
+		x.ControlFields = append(x.ControlFields, marcXMLControl{Tag: cf.Tag, Value: cf.Value})
+	}
+	for _, df := range rec.DataFields {
+		xf := marcXMLDatafield{Tag: df.Tag, Ind1: string(marcIndicator(df.Ind1)), Ind2: string(marcIndicator(df.Ind2))}
+		for _, sf := range df.Subfields {
+			xf.Subfields = append(xf.Subfields, marcXMLSubfield{Code: string(sf.Code), Value: sf.Value})
+		}
+		x.DataFields = append(x.DataFields, xf)
+	}
+	return x
+}
@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testMARCRecord() *MARCRecord {
+	return &MARCRecord{
+		Leader:        "00000nam a2200000 a 4500",
+		ControlFields: []MARCControlField{{Tag: "001", Value: "42"}},
+		DataFields: []MARCDataField{
+			NewMARCDataField("020", ' ', ' ', "a", "9780306406157"),
+			NewMARCDataField("245", '1', '0', "a", "Café society", "c", "A. Writer"),
+		},
+	}
+}
+
+func encodeMARC(t *testing.T, rec *MARCRecord) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := NewMARCWriter(&buf).Write(rec); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMARCRoundTrip(t *testing.T) {
+	data := encodeMARC(t, testMARCRecord())
+	rec, err := NewMARCReader(bytes.NewReader(data)).Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(rec.ControlFields) != 1 || rec.ControlFields[0].Value != "42" {
+		t.Errorf("control fields = %+v", rec.ControlFields)
+	}
+	title := rec.Fields("245")
+	if len(title) != 1 || title[0].Subfield('a') != "Café society" || title[0].Ind1 != '1' {
+		t.Errorf("245 = %+v", title)
+	}
+	if got := rec.Fields("020")[0].Subfield('a'); got != "9780306406157" {
+		t.Errorf("020$a = %q", got)
+	}
+}
+
+func TestMARCReaderEOF(t *testing.T) {
+	data := encodeMARC(t, testMARCRecord())
+	r := NewMARCReader(bytes.NewReader(append(append(data, '\n'), data...)))
+	for i := 0; i < 2; i++ {
+		if _, err := r.Read(); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("after last record: err = %v, want io.EOF", err)
+	}
+}
+
+func TestParseMARCMalformed(t *testing.T) {
+	valid := encodeMARC(t, testMARCRecord())
+	// The first directory entry starts right after the leader: tag [24:27],
+	// length [27:31], start [31:36]
+	patch := func(at int, s string) []byte {
+		data := append([]byte{}, valid...)
+		copy(data[at:], s)
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"negative start", patch(31, "-9000")},
+		{"signed start", patch(31, "+0000")},
+		{"spaces in start", patch(31, " 0000")},
+		{"negative length", patch(27, "-001")},
+		{"letters in length", patch(27, "00x1")},
+		{"start past end", patch(31, "99999")},
+		{"zero length", patch(27, "0000")},
+		{"bad base address", patch(12, "-0030")},
+		{"base past end", patch(12, "99999")},
+		{"uneven directory", patch(12, "00040")},
+		{"shorter than leader", valid[:10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := ParseMARC(tt.data)
+			if !errors.Is(err, ErrInvalidMARC) {
+				t.Errorf("ParseMARC = %+v, %v; want ErrInvalidMARC", rec, err)
+			}
+		})
+	}
+}
+
+func TestMARCReaderBadLength(t *testing.T) {
+	for _, head := range []string{"-0100", "+0100", "00x10", "00010"} {
+		_, err := NewMARCReader(strings.NewReader(head + strings.Repeat("0", 200))).Read()
+		if !errors.Is(err, ErrInvalidMARC) {
+			t.Errorf("record length %q: err = %v, want ErrInvalidMARC", head, err)
+		}
+	}
+}
+
+func TestMARCXMLRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewMARCXMLWriter(&buf)
+	if err := w.Write(testMARCRecord()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	rec, err := NewMARCXMLReader(&buf).Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got := rec.Fields("245")[0].Subfield('c'); got != "A. Writer" {
+		t.Errorf("245$c = %q", got)
+	}
+	if rec.ControlFields[0].Tag != "001" {
+		t.Errorf("control fields = %+v", rec.ControlFields)
+	}
+}
+
+func TestMARCXMLBadSubfieldCode(t *testing.T) {
+	doc := `<record><datafield tag="245" ind1="1" ind2="0"><subfield code="ab">x</subfield></datafield></record>`
+	if _, err := NewMARCXMLReader(strings.NewReader(doc)).Read(); !errors.Is(err, ErrInvalidMARC) {
+		t.Errorf("err = %v, want ErrInvalidMARC", err)
+	}
+}
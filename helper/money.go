@@ -0,0 +1,487 @@
+package helper
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	database "go-crud-api/config"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidMoney is returned for amounts that cannot be read exactly.
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are
+// added, subtracted or compared.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// moneyScale is how many decimal places Money keeps. It matches the
+// DECIMAL(19,4) columns, so every stored value round-trips exactly.
+const (
+	moneyScale  = 4
+	moneyFactor = 10000
+)
+
+// Currency holds the rounding rule of a currency: amounts round half away
+// from zero to a multiple of Increment minor units, with Digits minor-unit
+// decimals.
+type Currency struct {
+	Code      string
+	Digits    int   // 2 for USD, 0 for JPY, 3 for KWD
+	Increment int64 // in minor units; 5 rounds CHF to 0.05 like cash
+}
+
+// currencies lists the currencies whose rules differ from two decimals in
+// steps of one; other ISO 4217 codes get those defaults.
+var currencies = map[string]Currency{
+	"JPY": {Code: "JPY", Digits: 0, Increment: 1},
+	"KRW": {Code: "KRW", Digits: 0, Increment: 1},
+	"ISK": {Code: "ISK", Digits: 0, Increment: 1},
+	"BHD": {Code: "BHD", Digits: 3, Increment: 1},
+	"KWD": {Code: "KWD", Digits: 3, Increment: 1},
+	"OMR": {Code: "OMR", Digits: 3, Increment: 1},
+	"JOD": {Code: "JOD", Digits: 3, Increment: 1},
+	"TND": {Code: "TND", Digits: 3, Increment: 1},
+	"CHF": {Code: "CHF", Digits: 2, Increment: 5},
+}
+
+// LookupCurrency returns the rounding rule for a three-letter code.
+func LookupCurrency(code string) (Currency, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 || strings.IndexFunc(code, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return Currency{}, fmt.Errorf("%w: unknown currency %q", ErrInvalidMoney, code)
+	}
+	if c, ok := currencies[code]; ok {
+		return c, nil
+	}
+	return Currency{Code: code, Digits: 2, Increment: 1}, nil
+}
+
+// DefaultCurrency is the library's own currency, as the database package
+// reads it for the migrations. Amounts given without a currency are in it.
+var DefaultCurrency = sync.OnceValue(database.DefaultCurrency)
+
+// Money is an exact amount in a currency, kept in ten-thousandths of the
+// main unit. The zero value is zero in no particular currency and combines
+// with any amount.
+type Money struct {
+	units    int64
+	currency string
+}
+
+// NewMoney makes an amount from minor units, e.g. NewMoney(1250, "EUR") is
+// 12.50 EUR. An empty currency means DefaultCurrency.
+func NewMoney(minor int64, currency string) (Money, error) {
+	c, err := moneyCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	scale := pow10(moneyScale - c.Digits)
+	if minor > math.MaxInt64/scale || minor < math.MinInt64/scale {
+		return Money{}, fmt.Errorf("%w: amount out of range", ErrInvalidMoney)
+	}
+	return Money{units: minor * scale, currency: c.Code}, nil
+}
+
+// ZeroMoney is zero in the given currency (DefaultCurrency when empty).
+func ZeroMoney(currency string) Money {
+	c, err := moneyCurrency(currency)
+	if err != nil {
+		return Money{}
+	}
+	return Money{currency: c.Code}
+}
+
+func moneyCurrency(code string) (Currency, error) {
+	if code == "" {
+		code = DefaultCurrency()
+	}
+	return LookupCurrency(code)
+}
+
+// ParseMoney reads "12.50", "-3", "12.50 EUR" or "EUR 12.50" as an amount
+// in currency (DefaultCurrency when empty). s may only name a currency of
+// its own when currency is empty or the same. At most four decimal places
+// are accepted; nothing is rounded.
+func ParseMoney(s, currency string) (Money, error) {
+	fields := strings.Fields(s)
+	var number, named string
+	switch len(fields) {
+	case 1:
+		number = fields[0]
+	case 2:
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			number, named = fields[0], fields[1]
+		} else {
+			named, number = fields[0], fields[1]
+		}
+	default:
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if named != "" {
+		n, err := LookupCurrency(named)
+		if err != nil {
+			return Money{}, err
+		}
+		if currency != "" {
+			want, err := LookupCurrency(currency)
+			if err != nil {
+				return Money{}, err
+			}
+			if want.Code != n.Code {
+				return Money{}, fmt.Errorf("%w: %q is not in %s", ErrCurrencyMismatch, s, want.Code)
+			}
+		}
+		currency = n.Code
+	}
+	c, err := moneyCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	units, err := parseUnits(number, false)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", err, s)
+	}
+	return Money{units: units, currency: c.Code}, nil
+}
+
+// parseUnits reads a plain decimal number into ten-thousandths. With
+// roundExtra, digits beyond the fourth decimal are rounded instead of
+// rejected (for values read back from FLOAT columns).
+func parseUnits(s string, roundExtra bool) (int64, error) {
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg, s = true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, ErrInvalidMoney
+	}
+	for _, part := range []string{whole, frac} {
+		if strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+			return 0, ErrInvalidMoney
+		}
+	}
+	roundUp := false
+	if len(frac) > moneyScale {
+		extra := frac[moneyScale:]
+		if !roundExtra && strings.Trim(extra, "0") != "" {
+			return 0, fmt.Errorf("%w: more than %d decimal places", ErrInvalidMoney, moneyScale)
+		}
+		roundUp = extra[0] >= '5'
+		frac = frac[:moneyScale]
+	}
+	frac += strings.Repeat("0", moneyScale-len(frac))
+
+	var units int64
+	for _, d := range whole + frac {
+		if units > (math.MaxInt64-9)/10 {
+			return 0, fmt.Errorf("%w: amount out of range", ErrInvalidMoney)
+		}
+		units = units*10 + int64(d-'0')
+	}
+	if roundUp {
+		units++
+	}
+	if neg {
+		units = -units
+	}
+	return units, nil
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// Currency is the amount's ISO 4217 code ("" only for the zero value).
+func (m Money) Currency() string { return m.currency }
+
+func (m Money) rule() Currency {
+	c, err := moneyCurrency(m.currency)
+	if err != nil {
+		return Currency{Code: m.currency, Digits: 2, Increment: 1}
+	}
+	return c
+}
+
+// Round rounds to the currency's smallest amount, half away from zero.
+func (m Money) Round() Money {
+	c := m.rule()
+	step := pow10(moneyScale-c.Digits) * c.Increment
+	q, r := m.units/step, m.units%step
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= step {
+		if m.units < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return Money{units: q * step, currency: m.currency}
+}
+
+// Minor is the rounded amount in minor units (cents for USD).
+func (m Money) Minor() int64 {
+	return m.Round().units / pow10(moneyScale-m.rule().Digits)
+}
+
+// Amount formats the number without the currency, with at least the
+// currency's decimals: "12.50", "1000", "0.125" for an unrounded value.
+func (m Money) Amount() string {
+	units := m.units
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	whole, frac := units/moneyFactor, fmt.Sprintf("%04d", units%moneyFactor)
+	digits := m.rule().Digits
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) < digits {
+		frac += strings.Repeat("0", digits-len(frac))
+	}
+	if frac == "" {
+		return sign + strconv.FormatInt(whole, 10)
+	}
+	return sign + strconv.FormatInt(whole, 10) + "." + frac
+}
+
+// String is the amount followed by its currency, e.g. "12.50 USD".
+func (m Money) String() string {
+	if m.currency == "" {
+		return m.Amount()
+	}
+	return m.Amount() + " " + m.currency
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool { return m.units == 0 }
+
+// Sign is -1, 0 or 1.
+func (m Money) Sign() int {
+	switch {
+	case m.units < 0:
+		return -1
+	case m.units > 0:
+		return 1
+	}
+	return 0
+}
+
+// sameCurrency picks the currency of a binary operation; a zero value with
+// no currency takes the other side's.
+func sameCurrency(a, b Money) (string, error) {
+	switch {
+	case a.currency == "":
+		return b.currency, nil
+	case b.currency == "" || a.currency == b.currency:
+		return a.currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.currency, b.currency)
+}
+
+// Add returns m + o. Both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	cur, err := sameCurrency(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.units + o.units
+	if (o.units > 0 && sum < m.units) || (o.units < 0 && sum > m.units) {
+		return Money{}, fmt.Errorf("%w: amount out of range", ErrInvalidMoney)
+	}
+	return Money{units: sum, currency: cur}, nil
+}
+
+// Sub returns m - o. Both must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money { return Money{units: -m.units, currency: m.currency} }
+
+// Mul multiplies by a whole number, e.g. a daily fine by days overdue.
+func (m Money) Mul(n int64) (Money, error) {
+	if n != 0 && (m.units*n)/n != m.units {
+		return Money{}, fmt.Errorf("%w: amount out of range", ErrInvalidMoney)
+	}
+	return Money{units: m.units * n, currency: m.currency}, nil
+}
+
+// Cmp compares m and o: -1 if m < o, 0 if equal, 1 if m > o.
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := sameCurrency(m, o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.units < o.units:
+		return -1, nil
+	case m.units > o.units:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// moneyJSON is the JSON form: the exact amount as a string, the currency,
+// and the rounded amount in minor units for clients that prefer integers.
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+	Minor    *json.Number    `json:"minor,omitempty"`
+}
+
+// MarshalJSON writes {"amount":"12.50","currency":"USD","minor":1250}.
+func (m Money) MarshalJSON() ([]byte, error) {
+	currency := m.currency
+	if currency == "" {
+		currency = DefaultCurrency()
+	}
+	amount, _ := json.Marshal(m.Amount())
+	minor := json.Number(strconv.FormatInt(m.Minor(), 10))
+	return json.Marshal(moneyJSON{Amount: amount, Currency: currency, Minor: &minor})
+}
+
+// UnmarshalJSON accepts a string ("12.50", "12.50 EUR"), an integer number
+// of minor units in DefaultCurrency (1250 is 12.50), or the object written
+// by MarshalJSON with either amount or minor set; an amount naming a
+// currency other than the object's is refused. Fractional numbers are
+// rejected: 12.5 as a JSON number could already have lost precision.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*m = Money{}
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, err := ParseMoney(s, "")
+		if err != nil {
+			return err
+		}
+		*m = v
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var obj moneyJSON
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		if len(obj.Amount) > 0 && !bytes.Equal(obj.Amount, []byte("null")) {
+			var s string
+			if err := json.Unmarshal(obj.Amount, &s); err != nil {
+				return fmt.Errorf("%w: amount must be a string such as \"12.50\"", ErrInvalidMoney)
+			}
+			v, err := ParseMoney(s, obj.Currency)
+			if err != nil {
+				return err
+			}
+			*m = v
+			return nil
+		}
+		if obj.Minor == nil {
+			return fmt.Errorf("%w: amount or minor is required", ErrInvalidMoney)
+		}
+		return m.setMinor(string(*obj.Minor), obj.Currency)
+	}
+	return m.setMinor(string(data), "")
+}
+
+func (m *Money) setMinor(s, currency string) error {
+	minor, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: numbers are minor units and must be whole; send decimals as a string such as \"12.50\"", ErrInvalidMoney)
+	}
+	v, err := NewMoney(minor, currency)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan reads a DECIMAL, MONEY or legacy FLOAT column. The currency is not
+// part of the column: it is DefaultCurrency until CurrencyScanner sets it.
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	units, err := parseUnits(strings.TrimSpace(s), true)
+	if err != nil {
+		return fmt.Errorf("scan money %q: %w", s, err)
+	}
+	if m.currency == "" {
+		m.currency = DefaultCurrency()
+	}
+	m.units = units
+	return nil
+}
+
+// Value writes the amount as a decimal string with four places. The
+// currency goes into its own column: pass m.Currency() alongside.
+func (m Money) Value() (driver.Value, error) {
+	units := m.units
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	return fmt.Sprintf("%s%d.%04d", sign, units/moneyFactor, units%moneyFactor), nil
+}
+
+// CurrencyScanner scans a currency column into m, so a row's amount and
+// currency can be read with rows.Scan(&m, m.CurrencyScanner()). NULL keeps
+// DefaultCurrency.
+func (m *Money) CurrencyScanner() sql.Scanner {
+	return (*moneyCurrencyScanner)(m)
+}
+
+type moneyCurrencyScanner Money
+
+func (s *moneyCurrencyScanner) Scan(src interface{}) error {
+	var code string
+	switch v := src.(type) {
+	case nil:
+		code = DefaultCurrency()
+	case []byte:
+		code = string(v)
+	case string:
+		code = v
+	default:
+		return fmt.Errorf("cannot scan %T into a currency", src)
+	}
+	c, err := LookupCurrency(code)
+	if err != nil {
+		return err
+	}
+	s.currency = c.Code
+	return nil
+}
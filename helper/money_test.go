@@ -0,0 +1,301 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func mustMoney(t *testing.T, s, currency string) Money {
+	t.Helper()
+	m, err := ParseMoney(s, currency)
+	if err != nil {
+		t.Fatalf("ParseMoney(%q, %q): %v", s, currency, err)
+	}
+	return m
+}
+
+func TestLookupCurrency(t *testing.T) {
+	tests := []struct {
+		code   string
+		want   Currency
+		hasErr bool
+	}{
+		{"usd", Currency{Code: "USD", Digits: 2, Increment: 1}, false},
+		{" EUR ", Currency{Code: "EUR", Digits: 2, Increment: 1}, false},
+		{"JPY", Currency{Code: "JPY", Digits: 0, Increment: 1}, false},
+		{"KWD", Currency{Code: "KWD", Digits: 3, Increment: 1}, false},
+		{"CHF", Currency{Code: "CHF", Digits: 2, Increment: 5}, false},
+		{"US", Currency{}, true},
+		{"US1", Currency{}, true},
+		{"", Currency{}, true},
+		{"€", Currency{}, true},
+	}
+	for _, tt := range tests {
+		got, err := LookupCurrency(tt.code)
+		if (err != nil) != tt.hasErr || got != tt.want {
+			t.Errorf("LookupCurrency(%q) = %+v, %v", tt.code, got, err)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	def := DefaultCurrency()
+	tests := []struct {
+		s, currency string
+		want        string // String() of the result
+		err         error
+	}{
+		{"12.50", "EUR", "12.50 EUR", nil},
+		{"12.50", "", "12.50 " + def, nil},
+		{"12.50 EUR", "", "12.50 EUR", nil},
+		{"EUR 12.50", "", "12.50 EUR", nil},
+		{"12.50 eur", "EUR", "12.50 EUR", nil},
+		{"-3", "USD", "-3.00 USD", nil},
+		{"+3", "USD", "3.00 USD", nil},
+		{".5", "USD", "0.50 USD", nil},
+		{"7.", "USD", "7.00 USD", nil},
+		{"0.1234", "USD", "0.1234 USD", nil},
+		{"0.12340", "USD", "0.1234 USD", nil},
+		{"1000", "JPY", "1000 JPY", nil},
+		{"1.5", "KWD", "1.500 KWD", nil},
+		{"12.50 EUR", "USD", "", ErrCurrencyMismatch},
+		{"GBP 1", "usd", "", ErrCurrencyMismatch},
+		{"0.12345", "USD", "", ErrInvalidMoney},
+		{"1,50", "USD", "", ErrInvalidMoney},
+		{"1e3", "USD", "", ErrInvalidMoney},
+		{"", "USD", "", ErrInvalidMoney},
+		{"-", "USD", "", ErrInvalidMoney},
+		{".", "USD", "", ErrInvalidMoney},
+		{"12.50 EUR extra", "", "", ErrInvalidMoney},
+		{"12.50 EURO", "", "", ErrInvalidMoney},
+		{"12.50", "EURO", "", ErrInvalidMoney},
+		{"99999999999999999999", "USD", "", ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.s, tt.currency)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseMoney(%q, %q) = %v, %v; want %v", tt.s, tt.currency, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("ParseMoney(%q, %q) = %v, %v; want %s", tt.s, tt.currency, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewMoney(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{1250, "EUR", "12.50 EUR"},
+		{-5, "USD", "-0.05 USD"},
+		{1250, "JPY", "1250 JPY"},
+		{1250, "KWD", "1.250 KWD"},
+	}
+	for _, tt := range tests {
+		got, err := NewMoney(tt.minor, tt.currency)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("NewMoney(%d, %q) = %v, %v; want %s", tt.minor, tt.currency, got, err, tt.want)
+		}
+	}
+	if _, err := NewMoney(math.MaxInt64/10, "USD"); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("NewMoney overflow: err = %v", err)
+	}
+	if _, err := NewMoney(1, "XX"); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("NewMoney bad currency: err = %v", err)
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		s, currency string
+		want        string
+		minor       int64
+	}{
+		{"12.345", "USD", "12.35 USD", 1235},
+		{"12.3449", "USD", "12.34 USD", 1234},
+		{"-12.345", "USD", "-12.35 USD", -1235},
+		{"0.005", "USD", "0.01 USD", 1},
+		{"-0.005", "USD", "-0.01 USD", -1},
+		{"1234.5", "JPY", "1235 JPY", 1235},
+		{"1.2345", "KWD", "1.235 KWD", 1235},
+		{"1.02", "CHF", "1.00 CHF", 100},
+		{"1.025", "CHF", "1.05 CHF", 105},
+		{"1.074", "CHF", "1.05 CHF", 105},
+		{"1.075", "CHF", "1.10 CHF", 110},
+	}
+	for _, tt := range tests {
+		m := mustMoney(t, tt.s, tt.currency)
+		if got := m.Round().String(); got != tt.want {
+			t.Errorf("Round(%s %s) = %s, want %s", tt.s, tt.currency, got, tt.want)
+		}
+		if got := m.Minor(); got != tt.minor {
+			t.Errorf("Minor(%s %s) = %d, want %d", tt.s, tt.currency, got, tt.minor)
+		}
+	}
+}
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		s, currency string
+		want        string
+	}{
+		{"12.5", "USD", "12.50"},
+		{"0.125", "USD", "0.125"},
+		{"-0.5", "USD", "-0.50"},
+		{"1000", "JPY", "1000"},
+		{"1000.5", "JPY", "1000.5"},
+		{"0", "KWD", "0.000"},
+	}
+	for _, tt := range tests {
+		if got := mustMoney(t, tt.s, tt.currency).Amount(); got != tt.want {
+			t.Errorf("Amount(%s %s) = %s, want %s", tt.s, tt.currency, got, tt.want)
+		}
+	}
+	if got := (Money{}).String(); got != "0.00" {
+		t.Errorf("zero value String() = %q", got)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := mustMoney(t, "12.50", "EUR")
+	b := mustMoney(t, "0.75", "EUR")
+	usd := mustMoney(t, "1", "USD")
+
+	if sum, err := a.Add(b); err != nil || sum.String() != "13.25 EUR" {
+		t.Errorf("Add = %v, %v", sum, err)
+	}
+	if diff, err := b.Sub(a); err != nil || diff.String() != "-11.75 EUR" || diff.Sign() != -1 {
+		t.Errorf("Sub = %v, %v", diff, err)
+	}
+	if prod, err := b.Mul(3); err != nil || prod.String() != "2.25 EUR" {
+		t.Errorf("Mul = %v, %v", prod, err)
+	}
+	if _, err := a.Add(usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies: err = %v", err)
+	}
+	if _, err := a.Cmp(usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp across currencies: err = %v", err)
+	}
+
+	// The zero value takes the other side's currency
+	if sum, err := (Money{}).Add(usd); err != nil || sum.String() != "1.00 USD" {
+		t.Errorf("zero + USD = %v, %v", sum, err)
+	}
+	if c, err := usd.Cmp(Money{}); err != nil || c != 1 {
+		t.Errorf("Cmp with zero value = %d, %v", c, err)
+	}
+
+	for _, tt := range []struct {
+		x, y Money
+		want int
+	}{{a, b, 1}, {b, a, -1}, {a, a, 0}} {
+		if got, err := tt.x.Cmp(tt.y); err != nil || got != tt.want {
+			t.Errorf("Cmp(%v, %v) = %d, %v; want %d", tt.x, tt.y, got, err, tt.want)
+		}
+	}
+
+	big, err := NewMoney(math.MaxInt64/100, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := big.Add(big); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Add overflow: err = %v", err)
+	}
+	if _, err := big.Mul(3); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Mul overflow: err = %v", err)
+	}
+	if !(Money{}).IsZero() || a.IsZero() || a.Neg().Neg() != a {
+		t.Error("IsZero or Neg misbehaves")
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(mustMoney(t, "12.5", "EUR"))
+	if err != nil || string(data) != `{"amount":"12.50","currency":"EUR","minor":1250}` {
+		t.Errorf("MarshalJSON = %s, %v", data, err)
+	}
+
+	def := DefaultCurrency()
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{`"12.50"`, "12.50 " + def, nil},
+		{`"12.50 EUR"`, "12.50 EUR", nil},
+		{`1250`, "12.50 " + def, nil},
+		{`{"amount":"12.50","currency":"EUR"}`, "12.50 EUR", nil},
+		{`{"amount":"12.50 EUR","currency":"EUR"}`, "12.50 EUR", nil},
+		{`{"minor":1250,"currency":"JPY"}`, "1250 JPY", nil},
+		{`{"amount":"1","currency":"EUR","minor":999}`, "1.00 EUR", nil},
+		{`null`, "0.00", nil},
+		{`{"amount":"12.50 EUR","currency":"USD"}`, "", ErrCurrencyMismatch},
+		{`12.5`, "", ErrInvalidMoney},
+		{`{"amount":12.5,"currency":"EUR"}`, "", ErrInvalidMoney},
+		{`{"currency":"EUR"}`, "", ErrInvalidMoney},
+		{`"12.345678"`, "", ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(tt.in), &m)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Unmarshal(%s) = %v, %v; want %v", tt.in, m, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || m.String() != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, %v; want %s", tt.in, m, err, tt.want)
+		}
+	}
+}
+
+func TestMoneySQL(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want string
+	}{
+		{[]byte("12.5000"), "12.50"},
+		{"-3.1000", "-3.10"},
+		{int64(7), "7.00"},
+		{float64(0.1) + float64(0.2), "0.30"}, // legacy FLOAT columns round
+		{nil, "0.00"},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := m.Scan(tt.src); err != nil || m.Amount() != tt.want {
+			t.Errorf("Scan(%v) = %s, %v; want %s", tt.src, m.Amount(), err, tt.want)
+		}
+	}
+	var m Money
+	if err := m.Scan(true); err == nil {
+		t.Error("Scan(bool) succeeded")
+	}
+
+	if err := m.Scan("2.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CurrencyScanner().Scan([]byte("jpy")); err != nil || m.Currency() != "JPY" {
+		t.Errorf("CurrencyScanner = %s, %v", m.Currency(), err)
+	}
+	if err := m.CurrencyScanner().Scan("bad!"); err == nil {
+		t.Error("CurrencyScanner accepted an invalid code")
+	}
+
+	for _, tt := range []struct {
+		s    string
+		want string
+	}{{"12.5", "12.5000"}, {"-0.05", "-0.0500"}, {"0", "0.0000"}} {
+		v, err := mustMoney(t, tt.s, "USD").Value()
+		if err != nil || v != tt.want {
+			t.Errorf("Value(%s) = %v, %v; want %s", tt.s, v, err, tt.want)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	database "go-crud-api/config"
+	"go-crud-api/controllers"
 	routes "go-crud-api/routes"
+	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,15 +17,56 @@ func main() {
 		port = "8080"
 	}
 
+	if err := database.Migrate(controllers.MigrationBackfills); err != nil {
+		log.Fatalf("database migration failed: %v", err)
+	}
+
+	// Future-dated price changes take effect within one interval, as do
+	// missed hold pickups and expired idempotency keys; overdue fines grow
+	// by the day and the borrowed-together recommendations are rebuilt much
+	// less often
+	go controllers.RunScheduled("price scheduler", envInterval("PRICE_SCHEDULE_INTERVAL", time.Minute), controllers.ApplyScheduledPrices)
+	go controllers.RunScheduled("hold expiry", envInterval("HOLD_EXPIRY_INTERVAL", 15*time.Minute), controllers.ExpireHolds)
+	go controllers.RunScheduled("idempotency keys", envInterval("IDEMPOTENCY_PURGE_INTERVAL", time.Hour), controllers.PurgeIdempotencyKeys)
+	go controllers.RunScheduled("overdue fines", envInterval("FINE_ASSESS_INTERVAL", time.Hour), controllers.AssessOverdueFines)
+	go controllers.RunScheduled("recommendations", envInterval("RECOMMENDATION_REBUILD_INTERVAL", 24*time.Hour), controllers.RebuildRecommendations)
+
 	router := gin.New()
 	router.Use(gin.Logger())
+	router.Use(controllers.Idempotent())
 	routes.UserRoutes(router)
+	routes.MeRoutes(router)
 	// router.Use(middleware.Authentication())
 	routes.BookRoutes(router)
+	routes.CoverRoutes(router)
+	routes.CopyRoutes(router)
+	routes.AuthorRoutes(router)
+	routes.CategoryRoutes(router)
 	routes.FineRoutes(router)
 	routes.OrderBookRoutes(router)
 	routes.FineBookRoutes(router)
+	routes.HoldRoutes(router)
+	routes.PolicyRoutes(router)
+	routes.CalendarRoutes(router)
+	routes.ReviewRoutes(router)
+	routes.ExportRoutes(router)
+	routes.OAIRoutes(router)
+	routes.SRURoutes(router)
+	routes.OPDSRoutes(router)
 
 	router.Run(":" + port)
 
 }
+
+// envInterval reads a duration such as "5m" from the environment.
+func envInterval(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q", name, v)
+	}
+	return d
+}
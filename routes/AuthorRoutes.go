@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AuthorRoutes(router *gin.Engine) {
+	authorGroup := router.Group("/author")
+	{
+		authorGroup.POST("", controllers.CreateAuthor())
+		authorGroup.GET("", controllers.GetAuthors())
+		authorGroup.GET("/:id", controllers.GetAuthorByID())
+		authorGroup.GET("/:id/books", controllers.GetAuthorBooks())
+		authorGroup.PUT("/:id", controllers.UpdateAuthor())
+		authorGroup.DELETE("/:id", controllers.DeleteAuthor())
+		authorGroup.POST("/:id/merge", controllers.MergeAuthors())
+	}
+}
@@ -10,12 +10,32 @@ func BookRoutes(router *gin.Engine) {
 	bookGroup := router.Group("/book")
 	{
 		bookGroup.POST("", controllers.CreateBook())
+		bookGroup.POST("/import", controllers.ImportBooks())
+		bookGroup.POST("/marc", controllers.ImportMARC())
+		bookGroup.GET("/marc", controllers.ExportMARC())
 		bookGroup.GET("", controllers.GetBooks())
 		bookGroup.GET("/:id", controllers.GetBookByID())
+		bookGroup.GET("/:id/marc", controllers.GetBookMARC())
 		bookGroup.GET("/name/:name", controllers.GetBookByName())
+		bookGroup.GET("/isbn/:isbn", controllers.GetBookByISBN())
 		bookGroup.GET("/author/:author", controllers.GetBookByAuthor())
 		bookGroup.GET("/type/:type", controllers.GetBookByType())
 		bookGroup.GET("/isAvailable/:isAvailable", controllers.GetBookByAvailability())
 		bookGroup.PUT("/:id", controllers.UpdateBook())
+		bookGroup.POST("/:id/copies", controllers.CreateBookCopy())
+		bookGroup.GET("/:id/copies", controllers.GetBookCopies())
+		bookGroup.PUT("/:id/categories", controllers.SetBookCategories())
+		bookGroup.POST("/:id/cover", controllers.UploadBookCover())
+		bookGroup.GET("/:id/cover", controllers.GetBookCover())
+		bookGroup.DELETE("/:id/cover", controllers.DeleteBookCover())
+		bookGroup.GET("/:id/price", controllers.GetBookPrice())
+		bookGroup.GET("/:id/prices", controllers.GetBookPrices())
+		bookGroup.POST("/:id/prices", controllers.AddBookPrice())
+		bookGroup.DELETE("/:id/prices/:priceid", controllers.DeleteBookPrice())
+		bookGroup.POST("/:id/reviews", controllers.CreateReview())
+		bookGroup.GET("/:id/reviews", controllers.GetBookReviews())
+		bookGroup.GET("/:id/similar", controllers.GetSimilarBooks())
+		bookGroup.POST("/:id/holds", controllers.PlaceHold())
+		bookGroup.GET("/:id/holds", controllers.GetBookHolds())
 	}
 }
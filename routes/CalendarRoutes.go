@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CalendarRoutes(router *gin.Engine) {
+	calendarGroup := router.Group("/calendar")
+	{
+		calendarGroup.GET("/hours", controllers.GetOpeningHours())
+		calendarGroup.PUT("/hours", controllers.SetOpeningHours())
+		calendarGroup.GET("/closures", controllers.GetClosures())
+		calendarGroup.POST("/closures", controllers.CreateClosure())
+		calendarGroup.POST("/closures/ical", controllers.ImportClosures())
+		calendarGroup.DELETE("/closures/:id", controllers.DeleteClosure())
+	}
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CategoryRoutes(router *gin.Engine) {
+	categoryGroup := router.Group("/category")
+	{
+		categoryGroup.POST("", controllers.CreateCategory())
+		categoryGroup.GET("", controllers.GetCategories())
+		categoryGroup.GET("/:id", controllers.GetCategoryByID())
+		categoryGroup.GET("/:id/books", controllers.GetCategoryBooks())
+		categoryGroup.PUT("/:id", controllers.UpdateCategory())
+		categoryGroup.DELETE("/:id", controllers.DeleteCategory())
+		categoryGroup.POST("/:id/aliases", controllers.AddCategoryAlias())
+	}
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CopyRoutes(router *gin.Engine) {
+	copyGroup := router.Group("/copy")
+	{
+		copyGroup.GET("/:id", controllers.GetCopyByID())
+		copyGroup.GET("/barcode/:barcode", controllers.GetCopyByBarcode())
+		copyGroup.PUT("/:id", controllers.UpdateBookCopy())
+		copyGroup.GET("/:id/conditions", controllers.GetCopyConditions())
+		copyGroup.POST("/:id/conditions", controllers.CreateCopyCondition())
+		copyGroup.POST("/:id/conditions/:assessmentid/photos", controllers.UploadConditionPhoto())
+		copyGroup.GET("/:id/conditions/:assessmentid/photos/:photoid", controllers.GetConditionPhoto())
+	}
+}
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CoverRoutes(router *gin.Engine) {
+	router.GET("/covers/*key", controllers.ServeCover())
+}
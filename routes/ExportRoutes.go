@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ExportRoutes(router *gin.Engine) {
+	exportGroup := router.Group("/export")
+	{
+		exportGroup.GET("/:entity", controllers.ExportData())
+	}
+}
@@ -11,7 +11,11 @@ func FineBookRoutes(router *gin.Engine) {
 	{
 		fineBookGroup.POST("", controllers.CreateFineBook())
 		fineBookGroup.GET("", controllers.GetAllFineBooks())
+		fineBookGroup.GET("/summary", controllers.GetFineBookSummary())
+		fineBookGroup.GET("/balance/:personid", controllers.GetFineBalance())
 		fineBookGroup.GET("/:id", controllers.GetFineBookByID())
 		fineBookGroup.PUT("/:id", controllers.UpdateFineBook())
+		fineBookGroup.POST("/:id/payments", controllers.PayFineBook())
+		fineBookGroup.POST("/:id/waive", controllers.WaiveFineBook())
 	}
 }
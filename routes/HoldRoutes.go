@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func HoldRoutes(router *gin.Engine) {
+	holdGroup := router.Group("/hold")
+	{
+		holdGroup.GET("", controllers.GetHolds())
+		holdGroup.GET("/:id", controllers.GetHoldByID())
+		holdGroup.DELETE("/:id", controllers.CancelHold())
+	}
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+	"go-crud-api/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MeRoutes serves the signed-in patron's own records; the patron is the uid
+// claim of the token header.
+func MeRoutes(router *gin.Engine) {
+	meGroup := router.Group("/me", middleware.Authentication())
+	{
+		meGroup.GET("/loans", controllers.GetMyLoans())
+		meGroup.GET("/fines", controllers.GetMyFines())
+		meGroup.GET("/holds", controllers.GetMyHolds())
+	}
+}
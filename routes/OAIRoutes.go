@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func OAIRoutes(router *gin.Engine) {
+	router.GET("/oai", controllers.OAIPMH())
+	router.POST("/oai", controllers.OAIPMH())
+}
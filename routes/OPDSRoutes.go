@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func OPDSRoutes(router *gin.Engine) {
+	opdsGroup := router.Group("/opds")
+	{
+		opdsGroup.GET("", controllers.OPDSRoot())
+		opdsGroup.GET("/opensearch.xml", controllers.OpenSearch())
+		opdsGroup.GET("/search", controllers.OPDSSearch())
+		opdsGroup.GET("/new", controllers.OPDSNewArrivals())
+		opdsGroup.GET("/books", controllers.OPDSAllBooks())
+		opdsGroup.GET("/books/:id", controllers.OPDSBook())
+		opdsGroup.GET("/categories", controllers.OPDSCategories())
+		opdsGroup.GET("/categories/:id", controllers.OPDSCategory())
+		opdsGroup.GET("/categories/:id/books", controllers.OPDSCategoryBooks())
+		opdsGroup.GET("/authors", controllers.OPDSAuthors())
+		opdsGroup.GET("/authors/:id", controllers.OPDSAuthorBooks())
+	}
+}
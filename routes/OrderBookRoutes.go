@@ -2,16 +2,25 @@ package routes
 
 import (
 	"go-crud-api/controllers"
+	"go-crud-api/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
+// OrderBookRoutes serves loans. A token header is optional, but a fines
+// block can only be overridden by a signed-in user.
 func OrderBookRoutes(router *gin.Engine) {
-	orderGroup := router.Group("/orderbook")
+	orderGroup := router.Group("/orderbook", middleware.OptionalAuthentication())
 	{
 		orderGroup.POST("", controllers.CreateOrderBook())
+		orderGroup.POST("/checkout", controllers.CheckoutBooks())
+		orderGroup.POST("/return", controllers.ReturnBooks())
 		orderGroup.GET("", controllers.GetAllOrderBooks())
 		orderGroup.GET("/:id", controllers.GetOrderBookByID())
 		orderGroup.PUT("/:id", controllers.UpdateOrderBook())
+		orderGroup.POST("/:id/renew", controllers.RenewOrderBook())
+		orderGroup.POST("/:id/lost", controllers.DeclareOrderLost())
+		orderGroup.POST("/:id/damaged", controllers.DeclareOrderDamaged())
+		orderGroup.POST("/:id/found", controllers.ReturnFoundOrder())
 	}
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PolicyRoutes(router *gin.Engine) {
+	policyGroup := router.Group("/policy")
+	{
+		policyGroup.POST("", controllers.CreatePolicy())
+		policyGroup.GET("", controllers.GetPolicies())
+		policyGroup.GET("/resolve", controllers.ResolvePolicy())
+		policyGroup.GET("/:id", controllers.GetPolicyByID())
+		policyGroup.PUT("/:id", controllers.UpdatePolicy())
+		policyGroup.DELETE("/:id", controllers.DeletePolicy())
+	}
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ReviewRoutes(router *gin.Engine) {
+	reviewGroup := router.Group("/review")
+	{
+		reviewGroup.GET("", controllers.GetReviews())
+		reviewGroup.GET("/:id", controllers.GetReviewByID())
+		reviewGroup.PUT("/:id", controllers.UpdateReview())
+		reviewGroup.PUT("/:id/status", controllers.ModerateReview())
+		reviewGroup.DELETE("/:id", controllers.DeleteReview())
+	}
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"go-crud-api/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SRURoutes(router *gin.Engine) {
+	router.GET("/sru", controllers.SRUSearchRetrieve())
+	router.POST("/sru", controllers.SRUSearchRetrieve())
+}
@@ -12,7 +12,8 @@ func UserRoutes(router *gin.Engine) {
 		userGroup.POST("", controllers.CreateUser())
 		userGroup.GET("", controllers.GetUsers())
 		userGroup.GET("/:user_id", controllers.GetUserById())
-		userGroup.PUT("/:id", controllers.UpdateUserById())
+		userGroup.GET("/:user_id/recommendations", controllers.GetUserRecommendations())
+		userGroup.PUT("/:user_id", controllers.UpdateUserById())
 		userGroup.POST("/login", controllers.LoginUser())
 	}
 }
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"covers/12/ab34/original.jpg", true},
+		{"a", true},
+		{"", false},
+		{"/covers/1.jpg", false},
+		{"covers/../secret", false},
+		{"covers/./1.jpg", false},
+		{"covers//1.jpg", false},
+		{"covers/1.jpg/", false},
+		{`covers\1.jpg`, false},
+		{"..", false},
+	}
+	for _, tt := range tests {
+		err := CheckKey(tt.key)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrInvalidKey)) {
+			t.Errorf("CheckKey(%q) = %v, want ok=%v", tt.key, err, tt.ok)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "covers/1/abc.png"
+	if err := s.Put(ctx, key, []byte("png bytes"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, obj, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "png bytes" || obj.Size != 9 || obj.ContentType != "image/png" {
+		t.Errorf("Get = %q, %+v", data, obj)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+	if _, _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Put(ctx, "../escape", nil, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put outside the root: err = %v, want ErrInvalidKey", err)
+	}
+}